@@ -2,20 +2,387 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
+	"os"
+	"strings"
+	"time"
 
+	"github.com/nolancon/gitdrops/pkg/gitdrops"
 	"github.com/nolancon/gitdrops/pkg/reconcile"
+
+	"github.com/digitalocean/godo"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	planCmd     = "plan"
+	applyCmd    = "apply"
+	validateCmd = "validate"
+	importCmd   = "import"
+	adoptCmd    = "adopt"
+	versionCmd  = "version"
+
+	textLogFormat = "text"
+	jsonLogFormat = "json"
+
+	digitaloceanToken = "DIGITALOCEAN_TOKEN"
 )
 
+// Exit codes. exitChanges is only returned by plan, so that scripts can tell whether the
+// DO account is in sync with gitdrops.yaml.
+const (
+	exitOK      = 0
+	exitError   = 1
+	exitChanges = 2
+)
+
+const usage = `Usage: gitdrops [command] [flags] [args]
+
+Commands:
+  plan      show the changes required to reconcile the DO account with gitdrops.yaml
+  apply     reconcile the DO account with gitdrops.yaml, or apply a saved plan file
+  validate  check gitdrops.yaml without contacting DO
+  import    print a gitdrops.yaml describing the droplets and volumes on the DO account
+  adopt     tag existing droplets and volumes declared in gitdrops.yaml as managed by gitdrops,
+            optionally only those named in args
+  version   print the gitdrops version
+
+The default command is apply. Run 'gitdrops <command> -h' for the flags of a command.
+
+Exit codes:
+  0  success (plan: no changes)
+  1  error
+  2  plan: changes pending
+`
+
+// version is set at build time with -ldflags "-X main.version=<version>"
+var version = "dev"
+
+type options struct {
+	file      string
+	tokenFile string
+	logFormat string
+	timeout   time.Duration
+	// actionTimeout is the maximum time to wait for a single action, see reconcile.Options
+	actionTimeout time.Duration
+	// shutdownTimeout is the maximum time to wait for a graceful droplet shutdown
+	shutdownTimeout time.Duration
+	// concurrency is the maximum number of operations applied at the same time
+	concurrency int
+	// continueOnError attempts every independent operation and reports all failures at the end
+	continueOnError bool
+	// maxDeletes, maxDeletePercent, allowMassDelete and confirmDelete guard against mass
+	// deletion, see reconcile.Options
+	maxDeletes       int
+	maxDeletePercent int
+	allowMassDelete  bool
+	confirmDelete    string
+	jsonOutput       bool
+	planOut          string
+}
+
 func main() {
-	ctx, cancel := context.WithCancel(context.Background())
+	os.Exit(run(os.Args[1:]))
+}
+
+func run(args []string) int {
+	// apply is the default so that running gitdrops without arguments reconciles the account
+	cmd := applyCmd
+	if len(args) != 0 && !strings.HasPrefix(args[0], "-") {
+		cmd = args[0]
+		args = args[1:]
+	}
+	switch cmd {
+	case planCmd, applyCmd, validateCmd, importCmd, adoptCmd, versionCmd:
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", cmd, usage)
+		return exitError
+	}
+
+	opts := options{}
+	flags := flag.NewFlagSet(cmd, flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage of gitdrops %s:\n", cmd)
+		flags.PrintDefaults()
+	}
+	flags.StringVar(&opts.file, "file", gitdrops.DefaultPath, "path to gitdrops.yaml")
+	flags.StringVar(&opts.tokenFile, "token-file", "", "path to a file containing the DO token (default $"+digitaloceanToken+")")
+	flags.StringVar(&opts.logFormat, "log-format", textLogFormat, "log format, one of "+textLogFormat+" or "+jsonLogFormat)
+	flags.DurationVar(&opts.timeout, "timeout", 30*time.Minute, "maximum duration of the run")
+	switch cmd {
+	case planCmd:
+		flags.BoolVar(&opts.jsonOutput, "json", false, "print the plan as JSON")
+		flags.StringVar(&opts.planOut, "out", "", "save the plan to this file so that it can be applied later with 'apply <file>'")
+	case applyCmd:
+		flags.BoolVar(&opts.jsonOutput, "json", false, "print the plan as JSON")
+		flags.DurationVar(&opts.actionTimeout, "action-timeout", 10*time.Minute, "maximum time to wait for a single droplet or volume action to complete")
+		flags.DurationVar(&opts.shutdownTimeout, "shutdown-timeout", 2*time.Minute, "maximum time to wait for a droplet to shut down gracefully before it is powered off")
+		flags.IntVar(&opts.concurrency, "concurrency", 4, "maximum number of independent operations applied at the same time")
+		flags.BoolVar(&opts.continueOnError, "continue-on-error", false, "keep applying operations that do not depend on a failed operation and report all failures at the end")
+		flags.IntVar(&opts.maxDeletes, "max-deletes", 5, "refuse to apply a plan deleting or replacing more than this many droplets and volumes, 0 is no limit")
+		flags.IntVar(&opts.maxDeletePercent, "max-delete-percent", 50, "refuse to apply a plan deleting or replacing more than this percentage of the managed droplets and volumes, 0 is no limit")
+		flags.BoolVar(&opts.allowMassDelete, "allow-mass-delete", false, "apply a plan exceeding -max-deletes or -max-delete-percent")
+		flags.StringVar(&opts.confirmDelete, "confirm-delete", "", "apply a plan exceeding -max-deletes or -max-delete-percent if this is the token reported when it was refused")
+	}
+	err := flags.Parse(args)
+	if errors.Is(err, flag.ErrHelp) {
+		return exitOK
+	}
+	if err != nil {
+		return exitError
+	}
+	err = setLogFormat(opts.logFormat)
+	if err != nil {
+		log.Println(err)
+		return exitError
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), opts.timeout)
 	defer cancel()
-	reconcileObjects, err := reconcile.NewReconciler(ctx)
+
+	switch cmd {
+	case versionCmd:
+		fmt.Println(version)
+		return exitOK
+	case validateCmd:
+		err = validate(opts)
+		if err != nil {
+			log.Printf("failed to validate %v", err)
+			return exitError
+		}
+		return exitOK
+	case importCmd:
+		err = importObjects(ctx, opts)
+		if err != nil {
+			log.Printf("failed to import %v", err)
+			return exitError
+		}
+		return exitOK
+	case adoptCmd:
+		err = adoptObjects(ctx, opts, flags.Args())
+		if err != nil {
+			log.Printf("failed to adopt %v", err)
+			return exitError
+		}
+		return exitOK
+	}
+	return planAndApply(ctx, cmd, opts, flags.Args())
+}
+
+// planAndApply plans, or loads a saved plan, and applies it if cmd is apply.
+func planAndApply(ctx context.Context, cmd string, opts options, args []string) int {
+	gitDrops, err := gitdrops.ReadGitDrops(opts.file)
+	if err != nil {
+		log.Printf("failed to read %s %v", opts.file, err)
+		return exitError
+	}
+	client, err := newClient(opts)
+	if err != nil {
+		log.Printf("failed to create DO client %v", err)
+		return exitError
+	}
+	reconcileObjects := reconcile.NewReconciler(gitDrops, client, reconcile.Options{
+		ActionTimeout:    opts.actionTimeout,
+		ShutdownTimeout:  opts.shutdownTimeout,
+		Concurrency:      opts.concurrency,
+		ContinueOnError:  opts.continueOnError,
+		MaxDeletes:       opts.maxDeletes,
+		MaxDeletePercent: opts.maxDeletePercent,
+		AllowMassDelete:  opts.allowMassDelete,
+		ConfirmDelete:    opts.confirmDelete,
+	})
+
+	// 'apply <file>' executes a saved plan verbatim, otherwise a new plan is made
+	var plan reconcile.Plan
+	if cmd == applyCmd && len(args) != 0 {
+		plan, err = reconcile.ReadPlan(args[0])
+		if err != nil {
+			log.Printf("failed to read Plan %v", err)
+			return exitError
+		}
+		err = reconcileObjects.LoadPlan(ctx, plan)
+		if err != nil {
+			log.Printf("failed to load Plan %v", err)
+			return exitError
+		}
+	} else {
+		plan, err = reconcileObjects.Plan(ctx)
+		if err != nil {
+			log.Printf("failed to Plan %v", err)
+			return exitError
+		}
+	}
+	err = printPlan(plan, opts.jsonOutput)
+	if err != nil {
+		log.Printf("failed to print Plan %v", err)
+		return exitError
+	}
+
+	if cmd == planCmd {
+		if opts.planOut != "" {
+			err = reconcile.WritePlan(opts.planOut, plan)
+			if err != nil {
+				log.Printf("failed to save Plan %v", err)
+				return exitError
+			}
+		}
+		if plan.HasChanges() {
+			return exitChanges
+		}
+		return exitOK
+	}
+
+	err = reconcileObjects.Apply(ctx)
+	var applyError *reconcile.ApplyError
+	if errors.As(err, &applyError) {
+		printApplyError(applyError, opts.jsonOutput)
+		return exitError
+	}
+	if err != nil {
+		log.Printf("failed to Apply %v", err)
+		return exitError
+	}
+	return exitOK
+}
+
+// validate prints every problem found in the file as '<file>:<line>: <message>'.
+func validate(opts options) error {
+	err := gitdrops.Validate(opts.file)
+	var validationErrors gitdrops.ValidationErrors
+	if errors.As(err, &validationErrors) {
+		for _, validationError := range validationErrors {
+			fmt.Printf("%s:%d: %s\n", opts.file, validationError.Line, validationError.Message)
+		}
+		return fmt.Errorf("%s has %d error(s)", opts.file, len(validationErrors))
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Println(opts.file, "is valid")
+	return nil
+}
+
+func importObjects(ctx context.Context, opts options) error {
+	client, err := newClient(opts)
+	if err != nil {
+		return err
+	}
+	gitDrops, err := reconcile.Import(ctx, client)
+	if err != nil {
+		return err
+	}
+	encoder := yaml.NewEncoder(os.Stdout)
+	encoder.SetIndent(2)
+	err = encoder.Encode(gitDrops)
+	if err != nil {
+		return err
+	}
+	return encoder.Close()
+}
+
+// adoptObjects tags the droplets and volumes declared in gitdrops.yaml that already exist on
+// the DO account as managed by gitdrops, so that they are deleted once removed from it.
+func adoptObjects(ctx context.Context, opts options, names []string) error {
+	gitDrops, err := gitdrops.ReadGitDrops(opts.file)
+	if err != nil {
+		return err
+	}
+	client, err := newClient(opts)
+	if err != nil {
+		return err
+	}
+	adopted, err := reconcile.Adopt(ctx, gitDrops, client, names)
+	if err != nil {
+		return err
+	}
+	for _, change := range adopted {
+		fmt.Println(change)
+	}
+	fmt.Printf("Adopted %d object(s).\n", len(adopted))
+	return nil
+}
+
+// newClient returns a DO client authenticated with the token in opts.tokenFile or, if no token
+// file is given, the DIGITALOCEAN_TOKEN environment variable.
+func newClient(opts options) (*godo.Client, error) {
+	token := os.Getenv(digitaloceanToken)
+	if opts.tokenFile != "" {
+		tokenBytes, err := ioutil.ReadFile(opts.tokenFile)
+		if err != nil {
+			return nil, err
+		}
+		token = strings.TrimSpace(string(tokenBytes))
+	}
+	if token == "" {
+		return nil, fmt.Errorf("no DO token, set %s or use -token-file", digitaloceanToken)
+	}
+	return godo.NewFromToken(token), nil
+}
+
+func printPlan(plan reconcile.Plan, jsonOutput bool) error {
+	if !jsonOutput {
+		fmt.Print(plan)
+		return nil
+	}
+	planJSON, err := plan.JSON()
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(planJSON))
+	return nil
+}
+
+// printApplyError prints the failures of a continue-on-error apply to stderr.
+func printApplyError(applyError *reconcile.ApplyError, jsonOutput bool) {
+	if !jsonOutput {
+		fmt.Fprintln(os.Stderr, applyError)
+		return
+	}
+	applyErrorJSON, err := json.MarshalIndent(applyError, "", "  ")
+	if err != nil {
+		log.Printf("failed to print Apply failures %v", err)
+		return
+	}
+	fmt.Fprintln(os.Stderr, string(applyErrorJSON))
+}
+
+func setLogFormat(logFormat string) error {
+	switch logFormat {
+	case textLogFormat:
+	case jsonLogFormat:
+		log.SetFlags(0)
+		log.SetOutput(&jsonLogWriter{out: os.Stderr})
+	default:
+		return fmt.Errorf("unknown log format %q, expected %q or %q", logFormat, textLogFormat, jsonLogFormat)
+	}
+	return nil
+}
+
+// jsonLogWriter writes each log line as a JSON object.
+type jsonLogWriter struct {
+	out io.Writer
+}
+
+func (w *jsonLogWriter) Write(p []byte) (int, error) {
+	line, err := json.Marshal(struct {
+		Time    string `json:"time"`
+		Message string `json:"msg"`
+	}{
+		Time:    time.Now().UTC().Format(time.RFC3339),
+		Message: strings.TrimSuffix(string(p), "\n"),
+	})
 	if err != nil {
-		log.Fatalf("failed to create new Reconciler %v", err)
+		return 0, err
 	}
-	err = reconcileObjects.Reconcile(ctx)
+	_, err = w.out.Write(append(line, '\n'))
 	if err != nil {
-		log.Fatalf("failed to Reconcile %v", err)
+		return 0, err
 	}
+	return len(p), nil
 }
@@ -2,36 +2,54 @@ package gitdrops
 
 import (
 	"context"
+	"crypto/md5"
+	"encoding/base64"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/digitalocean/godo"
 
-	"gopkg.in/yaml.v2"
+	"gopkg.in/yaml.v3"
 )
 
+// DefaultPath is the path of gitdrops.yaml used when no other path is specified.
+const DefaultPath = "./gitdrops.yaml"
+
 const (
-	gitdropsYamlPath = "./gitdrops.yaml"
-	retries          = 10
-	resize           = "resize"
-	rebuild          = "rebuild"
-	delay            = 3 * time.Second
+	resize         = "resize"
+	rebuild        = "rebuild"
+	enableBackups  = "enableBackups"
+	disableBackups = "disableBackups"
+	enableIPv6     = "enableIPv6"
+	pollInterval   = 5 * time.Second
+	// actionErrored is the status of a failed action. godo only defines constants for the
+	// in-progress and completed statuses.
+	actionErrored = "errored"
+	dropletActive = "active"
+	// dropletErrored and dropletArchive are the statuses of a droplet that failed to be created
+	// or was archived, it never becomes active.
+	dropletErrored = "errored"
+	dropletArchive = "archive"
 )
 
-func timeout() {
-	time.Sleep(delay)
-}
-
-// ReadGitDrops reads and unmarshals from gitdrops.yaml
-func ReadGitDrops() (GitDrops, error) {
+// ReadGitDrops reads and unmarshals from the gitdrops.yaml at path. The file is validated
+// first so that an invalid spec is rejected before any object is reconciled.
+func ReadGitDrops(path string) (GitDrops, error) {
 	gitDrops := GitDrops{}
 
-	gitdropsYaml, err := ioutil.ReadFile(gitdropsYamlPath)
+	gitdropsYaml, err := ioutil.ReadFile(path)
 	if err != nil {
 		return gitDrops, fmt.Errorf("ReadGitDrops: %v", err)
 	}
+	validationErrors := validateGitDrops(gitdropsYaml)
+	if len(validationErrors) != 0 {
+		return gitDrops, fmt.Errorf("ReadGitDrops: %s is invalid:\n%v", path, validationErrors)
+	}
 
 	err = yaml.Unmarshal(gitdropsYaml, &gitDrops)
 	if err != nil {
@@ -47,10 +65,43 @@ func ReadGitDrops() (GitDrops, error) {
 		}
 		gitDrops.Droplets[i].UserData.Data = string(userData)
 	}
-	log.Println("ReadGitDrops: gitdrops.yaml contains", len(gitDrops.Droplets), "droplet(s) and", len(gitDrops.Volumes), "volume(s)")
+	for i, sshKey := range gitDrops.SSHKeys {
+		if sshKey.PublicKeyFile != "" {
+			publicKey, err := ioutil.ReadFile(sshKey.PublicKeyFile)
+			if err != nil {
+				return gitDrops, fmt.Errorf("ReadGitDrops: %v", err)
+			}
+			gitDrops.SSHKeys[i].PublicKey = strings.TrimSpace(string(publicKey))
+		}
+		fingerprint, err := SSHKeyFingerprint(gitDrops.SSHKeys[i].PublicKey)
+		if err != nil {
+			return gitDrops, fmt.Errorf("ReadGitDrops: sshKey %q: %v", sshKey.Name, err)
+		}
+		gitDrops.SSHKeys[i].Fingerprint = fingerprint
+	}
+	log.Println("ReadGitDrops:", path, "contains", len(gitDrops.Droplets), "droplet(s) and", len(gitDrops.Volumes), "volume(s)")
 	return gitDrops, nil
 }
 
+// SSHKeyFingerprint returns the MD5 fingerprint of publicKey, in the authorized_keys format eg
+// "ssh-ed25519 AAAA... user@host", as reported by DO.
+func SSHKeyFingerprint(publicKey string) (string, error) {
+	fields := strings.Fields(publicKey)
+	if len(fields) < 2 {
+		return "", fmt.Errorf("SSHKeyFingerprint: public key is not in authorized_keys format")
+	}
+	key, err := base64.StdEncoding.DecodeString(fields[1])
+	if err != nil {
+		return "", fmt.Errorf("SSHKeyFingerprint: %v", err)
+	}
+	hash := md5.Sum(key)
+	hexBytes := make([]string, 0, len(hash))
+	for _, b := range hash {
+		hexBytes = append(hexBytes, fmt.Sprintf("%02x", b))
+	}
+	return strings.Join(hexBytes, ":"), nil
+}
+
 // ListDroplets lists all active droplets on DO account
 func ListDroplets(ctx context.Context, client *godo.Client) ([]godo.Droplet, error) {
 	// create a list to hold our droplets
@@ -59,20 +110,15 @@ func ListDroplets(ctx context.Context, client *godo.Client) ([]godo.Droplet, err
 	// create options. initially, these will be blank
 	opt := &godo.ListOptions{}
 	for {
-		droplets := []godo.Droplet{}
-		resp := &godo.Response{}
-		for i := 0; i < retries; i++ {
-			dropletsTmp, respTmp, err := client.Droplets.List(ctx, opt)
-			if err != nil {
-				if i == retries-1 {
-					return list, fmt.Errorf("ListDroplets: %v", err)
-				}
-				timeout()
-			} else {
-				droplets = dropletsTmp
-				resp = respTmp
-				break
-			}
+		var droplets []godo.Droplet
+		var resp *godo.Response
+		err := defaultRetryPolicy.retry(ctx, func() (*godo.Response, error) {
+			var err error
+			droplets, resp, err = client.Droplets.List(ctx, opt)
+			return resp, err
+		})
+		if err != nil {
+			return list, fmt.Errorf("ListDroplets: %v", err)
 		}
 		// append the current page's droplets to our list
 		list = append(list, droplets...)
@@ -96,71 +142,250 @@ func ListDroplets(ctx context.Context, client *godo.Client) ([]godo.Droplet, err
 
 // DeleteDroplet attempts to delete droplet from DO by ID
 func DeleteDroplet(ctx context.Context, client *godo.Client, id int) error {
-	for i := 0; i < retries; i++ {
-		response, err := client.Droplets.Delete(ctx, id)
-		if err != nil {
-			if i == retries-1 {
-				return fmt.Errorf("DeleteDroplet: %v", err)
-			}
-			timeout()
-		} else {
-			log.Println("DeleteDroplet: delete request for droplet", id, "returned:", response.StatusCode)
-			break
+	var response *godo.Response
+	err := defaultRetryPolicy.retry(ctx, func() (*godo.Response, error) {
+		var err error
+		response, err = client.Droplets.Delete(ctx, id)
+		return response, err
+	})
+	if err != nil {
+		return fmt.Errorf("DeleteDroplet: %v", err)
+	}
+	log.Println("DeleteDroplet: delete request for droplet", id, "returned:", response.StatusCode)
+	return nil
+}
+
+// CreateDroplet attempts to create droplet on DO by dropletCreateRequest. The returned droplet
+// is not yet active, see WaitForDroplet.
+func CreateDroplet(ctx context.Context, client *godo.Client, dropletCreateRequest *godo.DropletCreateRequest) (*godo.Droplet, error) {
+	var droplet *godo.Droplet
+	var response *godo.Response
+	err := createRetryPolicy.retry(ctx, func() (*godo.Response, error) {
+		var err error
+		droplet, response, err = client.Droplets.Create(ctx, dropletCreateRequest)
+		return response, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("CreateDroplets: %v", err)
+	}
+	log.Println("CreateDroplet: create request for", dropletCreateRequest.Name, "returned", response.Status)
+	return droplet, nil
+}
+
+// UpdateDroplet attempts to perform an action (resize, rebuild, enableBackups, disableBackups or
+// enableIPv6) on an active droplet on DO by ID. value is ignored by the actions without
+// argument. The returned action is nil if action is not a droplet action, otherwise see
+// WaitForAction.
+func UpdateDroplet(ctx context.Context, client *godo.Client, id int, action, value string) (*godo.Action, error) {
+	var dropletAction *godo.Action
+	var response *godo.Response
+	var err error
+	switch action {
+	case resize:
+		err = defaultRetryPolicy.retry(ctx, func() (*godo.Response, error) {
+			var err error
+			dropletAction, response, err = client.DropletActions.Resize(ctx, id, value, true)
+			return response, err
+		})
+	case rebuild:
+		err = defaultRetryPolicy.retry(ctx, func() (*godo.Response, error) {
+			var err error
+			dropletAction, response, err = client.DropletActions.RebuildByImageSlug(ctx, id, value)
+			return response, err
+		})
+	case enableBackups:
+		err = defaultRetryPolicy.retry(ctx, func() (*godo.Response, error) {
+			var err error
+			dropletAction, response, err = client.DropletActions.EnableBackups(ctx, id)
+			return response, err
+		})
+	case disableBackups:
+		err = defaultRetryPolicy.retry(ctx, func() (*godo.Response, error) {
+			var err error
+			dropletAction, response, err = client.DropletActions.DisableBackups(ctx, id)
+			return response, err
+		})
+	case enableIPv6:
+		err = defaultRetryPolicy.retry(ctx, func() (*godo.Response, error) {
+			var err error
+			dropletAction, response, err = client.DropletActions.EnableIPv6(ctx, id)
+			return response, err
+		})
+	default:
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("UpdateDroplets (%s): %v", action, err)
+	}
+	log.Println("UpdateDroplet: droplet action request for", action, id, "returned", response.Status)
+	return dropletAction, nil
+}
+
+// PowerOnDroplet powers on droplet id and waits for the action to complete, see WaitForAction.
+func PowerOnDroplet(ctx context.Context, client *godo.Client, id int, timeout time.Duration) error {
+	var dropletAction *godo.Action
+	var response *godo.Response
+	err := defaultRetryPolicy.retry(ctx, func() (*godo.Response, error) {
+		var err error
+		dropletAction, response, err = client.DropletActions.PowerOn(ctx, id)
+		return response, err
+	})
+	if err != nil {
+		return fmt.Errorf("PowerOnDroplet: %v", err)
+	}
+	log.Println("PowerOnDroplet: droplet action request for power on", id, "returned", response.Status)
+	err = WaitForAction(ctx, client, dropletAction, timeout)
+	if err != nil {
+		return fmt.Errorf("PowerOnDroplet: %v", err)
+	}
+	return nil
+}
+
+// ShutdownDroplet gracefully shuts down droplet id. If the shutdown fails or has not completed
+// after shutdownTimeout, the droplet is powered off, the equivalent of cutting its power, and
+// the power off action is waited for up to timeout.
+func ShutdownDroplet(ctx context.Context, client *godo.Client, id int, shutdownTimeout, timeout time.Duration) error {
+	var dropletAction *godo.Action
+	var response *godo.Response
+	err := defaultRetryPolicy.retry(ctx, func() (*godo.Response, error) {
+		var err error
+		dropletAction, response, err = client.DropletActions.Shutdown(ctx, id)
+		return response, err
+	})
+	if err == nil {
+		log.Println("ShutdownDroplet: droplet action request for shutdown", id, "returned", response.Status)
+		err = WaitForAction(ctx, client, dropletAction, shutdownTimeout)
+		if err == nil {
+			return nil
 		}
 	}
+	if ctx.Err() != nil {
+		return fmt.Errorf("ShutdownDroplet: %v", err)
+	}
+	log.Println("ShutdownDroplet: graceful shutdown of droplet", id, "failed, powering off:", err)
+	err = defaultRetryPolicy.retry(ctx, func() (*godo.Response, error) {
+		var err error
+		dropletAction, response, err = client.DropletActions.PowerOff(ctx, id)
+		return response, err
+	})
+	if err != nil {
+		return fmt.Errorf("ShutdownDroplet: %v", err)
+	}
+	log.Println("ShutdownDroplet: droplet action request for power off", id, "returned", response.Status)
+	err = WaitForAction(ctx, client, dropletAction, timeout)
+	if err != nil {
+		return fmt.Errorf("ShutdownDroplet: %v", err)
+	}
 	return nil
 }
 
-// DeleteDroplet attempts to create droplet on DO by dropletCreateRequest
-func CreateDroplet(ctx context.Context, client *godo.Client, dropletCreateRequest *godo.DropletCreateRequest) error {
-	for i := 0; i < retries; i++ {
-		_, response, err := client.Droplets.Create(ctx, dropletCreateRequest)
+// WaitForDroplet polls droplet id until it is active, or until timeout has elapsed. An error is
+// returned as soon as the droplet is errored or archived. A timeout of 0 waits until ctx is done.
+func WaitForDroplet(ctx context.Context, client *godo.Client, id int, timeout time.Duration) (*godo.Droplet, error) {
+	var droplet *godo.Droplet
+	err := poll(ctx, timeout, func() (bool, error) {
+		dropletTmp, _, err := client.Droplets.Get(ctx, id)
 		if err != nil {
-			if i == retries-1 {
-				return fmt.Errorf("CreateDroplets: %v", err)
-			}
-			timeout()
-		} else {
-			log.Println("CreateDroplet: create request for", dropletCreateRequest.Name, "returned", response.Status)
-			break
+			log.Println("WaitForDroplet: failed to get droplet", id, err)
+			return false, nil
+		}
+		droplet = dropletTmp
+		return isDropletActive(droplet)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("WaitForDroplet: droplet %v: %v", id, err)
+	}
+	log.Println("WaitForDroplet: droplet", id, "is", dropletActive)
+	return droplet, nil
+}
+
+// isDropletActive returns true if droplet is active, and an error if its status is terminal
+// without being active.
+func isDropletActive(droplet *godo.Droplet) (bool, error) {
+	switch droplet.Status {
+	case dropletActive:
+		return true, nil
+	case dropletErrored, dropletArchive:
+		return false, fmt.Errorf("droplet %v is %s", droplet.ID, droplet.Status)
+	}
+	return false, nil
+}
+
+// WaitForDropletDeleted polls droplet id until DO no longer returns it, or until timeout has
+// elapsed. A timeout of 0 waits until ctx is done.
+func WaitForDropletDeleted(ctx context.Context, client *godo.Client, id int, timeout time.Duration) error {
+	err := poll(ctx, timeout, func() (bool, error) {
+		_, response, err := client.Droplets.Get(ctx, id)
+		if response != nil && response.StatusCode == http.StatusNotFound {
+			return true, nil
+		}
+		if err != nil {
+			log.Println("WaitForDropletDeleted: failed to get droplet", id, err)
 		}
+		return false, nil
+	})
+	if err != nil {
+		return fmt.Errorf("WaitForDropletDeleted: droplet %v: %v", id, err)
 	}
+	log.Println("WaitForDropletDeleted: droplet", id, "deleted")
 	return nil
 }
 
-// UpdateDroplet attempts to perform an action (resize or rebuild) on an active droplet on DO by ID
-func UpdateDroplet(ctx context.Context, client *godo.Client, id int, action, value string) error {
-	switch action {
-	case resize:
-		for i := 0; i < retries; i++ {
-			_, response, err := client.DropletActions.Resize(ctx, id, value, true)
-			if err != nil {
-				if i == retries-1 {
-					return fmt.Errorf("UpdateDroplets (resize): %v", err)
-				}
-				timeout()
-			} else {
-				log.Println("UpdateDroplet: droplet action request for resize", id, "returned", response.Status)
-				break
-			}
+// WaitForAction polls action until it has completed, or until timeout has elapsed. An error is
+// returned if the action errored. A timeout of 0 waits until ctx is done.
+func WaitForAction(ctx context.Context, client *godo.Client, action *godo.Action, timeout time.Duration) error {
+	if action == nil {
+		return nil
+	}
+	err := poll(ctx, timeout, func() (bool, error) {
+		if action.Status == godo.ActionCompleted {
+			return true, nil
 		}
-	case rebuild:
-		for i := 0; i < retries; i++ {
-			_, response, err := client.DropletActions.RebuildByImageSlug(ctx, id, value)
-			if err != nil {
-				if i == retries-1 {
-					return fmt.Errorf("UpdateDroplets (rebuild): %v", err)
-				}
-				timeout()
-			} else {
-				log.Println("UpdateDroplet: droplet action request for rebuild", id, "returned", response.Status)
-				break
-			}
+		if action.Status == actionErrored {
+			return false, fmt.Errorf("%s action %v errored", action.Type, action.ID)
+		}
+		actionTmp, _, err := client.Actions.Get(ctx, action.ID)
+		if err != nil {
+			log.Println("WaitForAction: failed to get action", action.ID, err)
+			return false, nil
 		}
+		action = actionTmp
+		return false, nil
+	})
+	if err != nil {
+		return fmt.Errorf("WaitForAction: %v", err)
 	}
+	log.Println("WaitForAction:", action.Type, "action", action.ID, godo.ActionCompleted)
 	return nil
 }
 
+// poll calls condition until it returns true or an error, every pollInterval. Errors listing the
+// polled object are expected to be handled by condition, so that a transient API error does not
+// abandon the wait.
+func poll(ctx context.Context, timeout time.Duration, condition func() (bool, error)) error {
+	if timeout != 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		done, err := condition()
+		if err != nil {
+			return err
+		}
+		if done {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("timed out: %v", ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
 // ListVolumes lists all active volumes on DO account
 func ListVolumes(ctx context.Context, client *godo.Client) ([]godo.Volume, error) {
 	list := []godo.Volume{}
@@ -169,20 +394,15 @@ func ListVolumes(ctx context.Context, client *godo.Client) ([]godo.Volume, error
 	listOpt := &godo.ListOptions{}
 	opt := &godo.ListVolumeParams{ListOptions: listOpt}
 	for {
-		volumes := []godo.Volume{}
-		resp := &godo.Response{}
-		for i := 0; i < retries; i++ {
-			volumesTmp, respTmp, err := client.Storage.ListVolumes(ctx, opt)
-			if err != nil {
-				if i == retries-1 {
-					return list, fmt.Errorf("ListVolumes: %v", err)
-				}
-				timeout()
-			} else {
-				volumes = volumesTmp
-				resp = respTmp
-				break
-			}
+		var volumes []godo.Volume
+		var resp *godo.Response
+		err := defaultRetryPolicy.retry(ctx, func() (*godo.Response, error) {
+			var err error
+			volumes, resp, err = client.Storage.ListVolumes(ctx, opt)
+			return resp, err
+		})
+		if err != nil {
+			return list, fmt.Errorf("ListVolumes: %v", err)
 		}
 		// append the current page's volumes to our list
 		list = append(list, volumes...)
@@ -204,87 +424,723 @@ func ListVolumes(ctx context.Context, client *godo.Client) ([]godo.Volume, error
 	return list, nil
 }
 
+// GetVolume returns the volume with ID id
+func GetVolume(ctx context.Context, client *godo.Client, id string) (*godo.Volume, error) {
+	var volume *godo.Volume
+	err := defaultRetryPolicy.retry(ctx, func() (*godo.Response, error) {
+		var err error
+		var response *godo.Response
+		volume, response, err = client.Storage.GetVolume(ctx, id)
+		return response, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("GetVolume: %v", err)
+	}
+	return volume, nil
+}
+
 // DeleteVolume attempts to delete volume from DO by ID
 func DeleteVolume(ctx context.Context, client *godo.Client, id string) error {
-	for i := 0; i < retries; i++ {
-		response, err := client.Storage.DeleteVolume(ctx, id)
-		if err != nil {
-			if i == retries-1 {
-				return fmt.Errorf("DeleteVolumes: %v", err)
+	var response *godo.Response
+	err := defaultRetryPolicy.retry(ctx, func() (*godo.Response, error) {
+		var err error
+		response, err = client.Storage.DeleteVolume(ctx, id)
+		return response, err
+	})
+	if err != nil {
+		return fmt.Errorf("DeleteVolumes: %v", err)
+	}
+	log.Println("DeleteVolume: delete request for", id, "returned", response.Status)
+	return nil
+}
+
+// CreateVolume attempts to create volume on DO by volumeCreateRequest and returns the new volume
+func CreateVolume(ctx context.Context, client *godo.Client, volumeCreateRequest *godo.VolumeCreateRequest) (*godo.Volume, error) {
+	var volume *godo.Volume
+	var response *godo.Response
+	err := createRetryPolicy.retry(ctx, func() (*godo.Response, error) {
+		var err error
+		volume, response, err = client.Storage.CreateVolume(ctx, volumeCreateRequest)
+		return response, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("CreateVolumes: %v", err)
+	}
+	log.Println("CreateVolume: create request for", volumeCreateRequest.Name, "returned", response.Status)
+	return volume, nil
+}
+
+// AttachVolume attempts to attach a volume to a droplet, see WaitForAction
+func AttachVolume(ctx context.Context, client *godo.Client, volID string, dropletID int) (*godo.Action, error) {
+	var volumeAction *godo.Action
+	var response *godo.Response
+	err := defaultRetryPolicy.retry(ctx, func() (*godo.Response, error) {
+		var err error
+		volumeAction, response, err = client.StorageActions.Attach(ctx, volID, dropletID)
+		return response, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("AttachVolume: %v", err)
+	}
+	log.Println("AttachVolume: volume action request for attachment", volID, "returned", response.Status)
+	return volumeAction, nil
+}
+
+// DetachVolume attempts to detach a volume from a droplet, see WaitForAction
+func DetachVolume(ctx context.Context, client *godo.Client, volID string, dropletID int) (*godo.Action, error) {
+	var volumeAction *godo.Action
+	var response *godo.Response
+	err := defaultRetryPolicy.retry(ctx, func() (*godo.Response, error) {
+		var err error
+		volumeAction, response, err = client.StorageActions.DetachByDropletID(ctx, volID, dropletID)
+		return response, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("DetachVolume: %v", err)
+	}
+	log.Println("DetachVolume: volume action request for detachment", volID, "returned", response.Status)
+	return volumeAction, nil
+}
+
+// ResizeVolume attempts to perform an action (resize) on an active volume on DO by ID, see
+// WaitForAction
+func ResizeVolume(ctx context.Context, client *godo.Client, volID, region string, sizeGigaBytes int64) (*godo.Action, error) {
+	var volumeAction *godo.Action
+	var response *godo.Response
+	err := defaultRetryPolicy.retry(ctx, func() (*godo.Response, error) {
+		var err error
+		volumeAction, response, err = client.StorageActions.Resize(ctx, volID, int(sizeGigaBytes), region)
+		return response, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("ResizeVolume: %v", err)
+	}
+	log.Println("ResizeVolume: volume action request for resize", volID, "returned", response.Status)
+	return volumeAction, nil
+}
+
+// TagResources creates tag if it does not exist and applies it to resources
+func TagResources(ctx context.Context, client *godo.Client, tag string, resources []godo.Resource) error {
+	err := createTag(ctx, client, tag)
+	if err != nil {
+		return fmt.Errorf("TagResources: %v", err)
+	}
+	var response *godo.Response
+	err = defaultRetryPolicy.retry(ctx, func() (*godo.Response, error) {
+		var err error
+		response, err = client.Tags.TagResources(ctx, tag, &godo.TagResourcesRequest{Resources: resources})
+		return response, err
+	})
+	if err != nil {
+		return fmt.Errorf("TagResources: %v", err)
+	}
+	log.Println("TagResources: tag request for", tag, "returned", response.Status)
+	return nil
+}
+
+// createTag creates tag if it does not exist
+func createTag(ctx context.Context, client *godo.Client, tag string) error {
+	return defaultRetryPolicy.retry(ctx, func() (*godo.Response, error) {
+		_, response, err := client.Tags.Create(ctx, &godo.TagCreateRequest{Name: tag})
+		return response, err
+	})
+}
+
+// UntagResources removes tag from resources
+func UntagResources(ctx context.Context, client *godo.Client, tag string, resources []godo.Resource) error {
+	var response *godo.Response
+	err := defaultRetryPolicy.retry(ctx, func() (*godo.Response, error) {
+		var err error
+		response, err = client.Tags.UntagResources(ctx, tag, &godo.UntagResourcesRequest{Resources: resources})
+		return response, err
+	})
+	if err != nil {
+		return fmt.Errorf("UntagResources: %v", err)
+	}
+	log.Println("UntagResources: untag request for", tag, "returned", response.Status)
+	return nil
+}
+
+// SnapshotDroplet takes a snapshot of a droplet named name and waits for it to complete. It
+// returns the ID of the snapshot.
+func SnapshotDroplet(ctx context.Context, client *godo.Client, id int, name string, timeout time.Duration) (string, error) {
+	var dropletAction *godo.Action
+	var response *godo.Response
+	err := defaultRetryPolicy.retry(ctx, func() (*godo.Response, error) {
+		var err error
+		dropletAction, response, err = client.DropletActions.Snapshot(ctx, id, name)
+		return response, err
+	})
+	if err != nil {
+		return "", fmt.Errorf("SnapshotDroplet: %v", err)
+	}
+	log.Println("SnapshotDroplet: droplet action request for snapshot", id, "returned", response.Status)
+	err = WaitForAction(ctx, client, dropletAction, timeout)
+	if err != nil {
+		return "", fmt.Errorf("SnapshotDroplet: %v", err)
+	}
+	snapshots, err := ListSnapshots(ctx, client, godo.DropletResourceType)
+	if err != nil {
+		return "", fmt.Errorf("SnapshotDroplet: %v", err)
+	}
+	for _, snapshot := range snapshots {
+		if snapshot.Name == name && snapshot.ResourceID == strconv.Itoa(id) {
+			return snapshot.ID, nil
+		}
+	}
+	return "", fmt.Errorf("SnapshotDroplet: snapshot %q of droplet %d not found", name, id)
+}
+
+// SnapshotVolume takes a snapshot of a volume named name and returns its ID. DO only returns
+// the snapshot once it is complete.
+func SnapshotVolume(ctx context.Context, client *godo.Client, volID, name string) (string, error) {
+	var snapshot *godo.Snapshot
+	var response *godo.Response
+	err := createRetryPolicy.retry(ctx, func() (*godo.Response, error) {
+		var err error
+		snapshot, response, err = client.Storage.CreateSnapshot(ctx, &godo.SnapshotCreateRequest{VolumeID: volID, Name: name})
+		return response, err
+	})
+	if err != nil {
+		return "", fmt.Errorf("SnapshotVolume: %v", err)
+	}
+	log.Println("SnapshotVolume: snapshot request for", volID, "returned", response.Status)
+	return snapshot.ID, nil
+}
+
+// ListSnapshots lists the snapshots of all droplets or all volumes, depending on resourceType
+// (godo.DropletResourceType or godo.VolumeResourceType).
+func ListSnapshots(ctx context.Context, client *godo.Client, resourceType godo.ResourceType) ([]godo.Snapshot, error) {
+	list := []godo.Snapshot{}
+
+	opt := &godo.ListOptions{}
+	for {
+		var snapshots []godo.Snapshot
+		var resp *godo.Response
+		err := defaultRetryPolicy.retry(ctx, func() (*godo.Response, error) {
+			var err error
+			if resourceType == godo.VolumeResourceType {
+				snapshots, resp, err = client.Snapshots.ListVolume(ctx, opt)
+			} else {
+				snapshots, resp, err = client.Snapshots.ListDroplet(ctx, opt)
 			}
-			timeout()
-		} else {
-			log.Println("DeleteVolume: delete request for", id, "returned", response.Status)
+			return resp, err
+		})
+		if err != nil {
+			return list, fmt.Errorf("ListSnapshots: %v", err)
+		}
+		list = append(list, snapshots...)
+
+		if resp.Links == nil || resp.Links.IsLastPage() {
 			break
 		}
+
+		page, err := resp.Links.CurrentPage()
+		if err != nil {
+			return nil, fmt.Errorf("ListSnapshots: %v", err)
+		}
+		opt.Page = page + 1
 	}
+
+	return list, nil
+}
+
+// DeleteSnapshot attempts to delete a droplet or volume snapshot from DO by ID
+func DeleteSnapshot(ctx context.Context, client *godo.Client, id string) error {
+	var response *godo.Response
+	err := defaultRetryPolicy.retry(ctx, func() (*godo.Response, error) {
+		var err error
+		response, err = client.Snapshots.Delete(ctx, id)
+		return response, err
+	})
+	if err != nil {
+		return fmt.Errorf("DeleteSnapshot: %v", err)
+	}
+	log.Println("DeleteSnapshot: delete request for", id, "returned", response.Status)
 	return nil
 }
 
-// CreateVolume attempts to create droplet on DO by dropletCreateRequest
-func CreateVolume(ctx context.Context, client *godo.Client, volumeCreateRequest *godo.VolumeCreateRequest) error {
-	for i := 0; i < retries; i++ {
-		_, response, err := client.Storage.CreateVolume(ctx, volumeCreateRequest)
+// ListFirewalls lists all firewalls on the DO account
+func ListFirewalls(ctx context.Context, client *godo.Client) ([]godo.Firewall, error) {
+	list := []godo.Firewall{}
+
+	opt := &godo.ListOptions{}
+	for {
+		var firewalls []godo.Firewall
+		var resp *godo.Response
+		err := defaultRetryPolicy.retry(ctx, func() (*godo.Response, error) {
+			var err error
+			firewalls, resp, err = client.Firewalls.List(ctx, opt)
+			return resp, err
+		})
 		if err != nil {
-			if i == retries-1 {
-				return fmt.Errorf("CreateVolumes: %v", err)
-			}
-			timeout()
-		} else {
-			log.Println("CreateVolume: create request for", volumeCreateRequest.Name, "returned", response.Status)
+			return list, fmt.Errorf("ListFirewalls: %v", err)
+		}
+		list = append(list, firewalls...)
+
+		if resp.Links == nil || resp.Links.IsLastPage() {
 			break
 		}
+
+		page, err := resp.Links.CurrentPage()
+		if err != nil {
+			return nil, fmt.Errorf("ListFirewalls: %v", err)
+		}
+		opt.Page = page + 1
+	}
+
+	return list, nil
+}
+
+// CreateFirewall attempts to create a firewall on DO by firewallRequest and returns the new
+// firewall. The tags of the request are created if they do not exist.
+func CreateFirewall(ctx context.Context, client *godo.Client, firewallRequest *godo.FirewallRequest) (*godo.Firewall, error) {
+	for _, tag := range firewallRequest.Tags {
+		err := createTag(ctx, client, tag)
+		if err != nil {
+			return nil, fmt.Errorf("CreateFirewall: %v", err)
+		}
+	}
+	var firewall *godo.Firewall
+	var response *godo.Response
+	err := createRetryPolicy.retry(ctx, func() (*godo.Response, error) {
+		var err error
+		firewall, response, err = client.Firewalls.Create(ctx, firewallRequest)
+		return response, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("CreateFirewall: %v", err)
+	}
+	log.Println("CreateFirewall: create request for", firewallRequest.Name, "returned", response.Status)
+	return firewall, nil
+}
+
+// AddFirewallRules adds the inbound and outbound rules of rulesRequest to firewall id
+func AddFirewallRules(ctx context.Context, client *godo.Client, id string, rulesRequest *godo.FirewallRulesRequest) error {
+	var response *godo.Response
+	err := defaultRetryPolicy.retry(ctx, func() (*godo.Response, error) {
+		var err error
+		response, err = client.Firewalls.AddRules(ctx, id, rulesRequest)
+		return response, err
+	})
+	if err != nil {
+		return fmt.Errorf("AddFirewallRules: %v", err)
 	}
+	log.Println("AddFirewallRules: add rules request for", id, "returned", response.Status)
 	return nil
 }
 
-// AttachVolume attempts to attach a volume to a droplet
-func AttachVolume(ctx context.Context, client *godo.Client, volID string, dropletID int) error {
-	for i := 0; i < retries; i++ {
-		_, response, err := client.StorageActions.Attach(ctx, volID, dropletID)
+// RemoveFirewallRules removes the inbound and outbound rules of rulesRequest from firewall id
+func RemoveFirewallRules(ctx context.Context, client *godo.Client, id string, rulesRequest *godo.FirewallRulesRequest) error {
+	var response *godo.Response
+	err := defaultRetryPolicy.retry(ctx, func() (*godo.Response, error) {
+		var err error
+		response, err = client.Firewalls.RemoveRules(ctx, id, rulesRequest)
+		return response, err
+	})
+	if err != nil {
+		return fmt.Errorf("RemoveFirewallRules: %v", err)
+	}
+	log.Println("RemoveFirewallRules: remove rules request for", id, "returned", response.Status)
+	return nil
+}
+
+// AddFirewallDroplets applies firewall id to droplets by ID
+func AddFirewallDroplets(ctx context.Context, client *godo.Client, id string, dropletIDs ...int) error {
+	var response *godo.Response
+	err := defaultRetryPolicy.retry(ctx, func() (*godo.Response, error) {
+		var err error
+		response, err = client.Firewalls.AddDroplets(ctx, id, dropletIDs...)
+		return response, err
+	})
+	if err != nil {
+		return fmt.Errorf("AddFirewallDroplets: %v", err)
+	}
+	log.Println("AddFirewallDroplets: add droplets request for", id, "returned", response.Status)
+	return nil
+}
+
+// RemoveFirewallDroplets removes firewall id from droplets by ID
+func RemoveFirewallDroplets(ctx context.Context, client *godo.Client, id string, dropletIDs ...int) error {
+	var response *godo.Response
+	err := defaultRetryPolicy.retry(ctx, func() (*godo.Response, error) {
+		var err error
+		response, err = client.Firewalls.RemoveDroplets(ctx, id, dropletIDs...)
+		return response, err
+	})
+	if err != nil {
+		return fmt.Errorf("RemoveFirewallDroplets: %v", err)
+	}
+	log.Println("RemoveFirewallDroplets: remove droplets request for", id, "returned", response.Status)
+	return nil
+}
+
+// AddFirewallTags applies firewall id to the droplets tagged with tags. The tags are created if
+// they do not exist.
+func AddFirewallTags(ctx context.Context, client *godo.Client, id string, tags ...string) error {
+	for _, tag := range tags {
+		err := createTag(ctx, client, tag)
 		if err != nil {
-			if i == retries-1 {
-				return fmt.Errorf("AttachVolume: %v", err)
-			}
-			timeout()
-		} else {
-			log.Println("AttachVolume: volume action request for attachment", volID, "returned", response.Status)
+			return fmt.Errorf("AddFirewallTags: %v", err)
+		}
+	}
+	var response *godo.Response
+	err := defaultRetryPolicy.retry(ctx, func() (*godo.Response, error) {
+		var err error
+		response, err = client.Firewalls.AddTags(ctx, id, tags...)
+		return response, err
+	})
+	if err != nil {
+		return fmt.Errorf("AddFirewallTags: %v", err)
+	}
+	log.Println("AddFirewallTags: add tags request for", id, "returned", response.Status)
+	return nil
+}
+
+// RemoveFirewallTags removes firewall id from the droplets tagged with tags
+func RemoveFirewallTags(ctx context.Context, client *godo.Client, id string, tags ...string) error {
+	var response *godo.Response
+	err := defaultRetryPolicy.retry(ctx, func() (*godo.Response, error) {
+		var err error
+		response, err = client.Firewalls.RemoveTags(ctx, id, tags...)
+		return response, err
+	})
+	if err != nil {
+		return fmt.Errorf("RemoveFirewallTags: %v", err)
+	}
+	log.Println("RemoveFirewallTags: remove tags request for", id, "returned", response.Status)
+	return nil
+}
+
+// ListDomains lists all domains on the DO account
+func ListDomains(ctx context.Context, client *godo.Client) ([]godo.Domain, error) {
+	list := []godo.Domain{}
+
+	opt := &godo.ListOptions{}
+	for {
+		var domains []godo.Domain
+		var resp *godo.Response
+		err := defaultRetryPolicy.retry(ctx, func() (*godo.Response, error) {
+			var err error
+			domains, resp, err = client.Domains.List(ctx, opt)
+			return resp, err
+		})
+		if err != nil {
+			return list, fmt.Errorf("ListDomains: %v", err)
+		}
+		list = append(list, domains...)
+
+		if resp.Links == nil || resp.Links.IsLastPage() {
 			break
 		}
+
+		page, err := resp.Links.CurrentPage()
+		if err != nil {
+			return nil, fmt.Errorf("ListDomains: %v", err)
+		}
+		opt.Page = page + 1
+	}
+
+	return list, nil
+}
+
+// ListDomainRecords lists all records of domain
+func ListDomainRecords(ctx context.Context, client *godo.Client, domain string) ([]godo.DomainRecord, error) {
+	list := []godo.DomainRecord{}
+
+	opt := &godo.ListOptions{}
+	for {
+		var records []godo.DomainRecord
+		var resp *godo.Response
+		err := defaultRetryPolicy.retry(ctx, func() (*godo.Response, error) {
+			var err error
+			records, resp, err = client.Domains.Records(ctx, domain, opt)
+			return resp, err
+		})
+		if err != nil {
+			return list, fmt.Errorf("ListDomainRecords: %v", err)
+		}
+		list = append(list, records...)
+
+		if resp.Links == nil || resp.Links.IsLastPage() {
+			break
+		}
+
+		page, err := resp.Links.CurrentPage()
+		if err != nil {
+			return nil, fmt.Errorf("ListDomainRecords: %v", err)
+		}
+		opt.Page = page + 1
+	}
+
+	return list, nil
+}
+
+// CreateDomain attempts to create domain name on DO
+func CreateDomain(ctx context.Context, client *godo.Client, name string) error {
+	var response *godo.Response
+	err := createRetryPolicy.retry(ctx, func() (*godo.Response, error) {
+		var err error
+		_, response, err = client.Domains.Create(ctx, &godo.DomainCreateRequest{Name: name})
+		return response, err
+	})
+	if err != nil {
+		return fmt.Errorf("CreateDomain: %v", err)
+	}
+	log.Println("CreateDomain: create request for", name, "returned", response.Status)
+	return nil
+}
+
+// CreateDomainRecord attempts to create a record of domain by recordRequest
+func CreateDomainRecord(ctx context.Context, client *godo.Client, domain string, recordRequest *godo.DomainRecordEditRequest) error {
+	var response *godo.Response
+	err := createRetryPolicy.retry(ctx, func() (*godo.Response, error) {
+		var err error
+		_, response, err = client.Domains.CreateRecord(ctx, domain, recordRequest)
+		return response, err
+	})
+	if err != nil {
+		return fmt.Errorf("CreateDomainRecord: %v", err)
 	}
+	log.Println("CreateDomainRecord: create request for", recordRequest.Type, recordRequest.Name, "of", domain, "returned", response.Status)
 	return nil
 }
 
-// AttachVolume attempts to attach a volume to a droplet
-func DetachVolume(ctx context.Context, client *godo.Client, volID string, dropletID int) error {
-	for i := 0; i < retries; i++ {
-		_, response, err := client.StorageActions.DetachByDropletID(ctx, volID, dropletID)
+// EditDomainRecord attempts to update record id of domain by recordRequest
+func EditDomainRecord(ctx context.Context, client *godo.Client, domain string, id int, recordRequest *godo.DomainRecordEditRequest) error {
+	var response *godo.Response
+	err := defaultRetryPolicy.retry(ctx, func() (*godo.Response, error) {
+		var err error
+		_, response, err = client.Domains.EditRecord(ctx, domain, id, recordRequest)
+		return response, err
+	})
+	if err != nil {
+		return fmt.Errorf("EditDomainRecord: %v", err)
+	}
+	log.Println("EditDomainRecord: edit request for", id, "of", domain, "returned", response.Status)
+	return nil
+}
+
+// DeleteDomainRecord attempts to delete record id of domain
+func DeleteDomainRecord(ctx context.Context, client *godo.Client, domain string, id int) error {
+	var response *godo.Response
+	err := defaultRetryPolicy.retry(ctx, func() (*godo.Response, error) {
+		var err error
+		response, err = client.Domains.DeleteRecord(ctx, domain, id)
+		return response, err
+	})
+	if err != nil {
+		return fmt.Errorf("DeleteDomainRecord: %v", err)
+	}
+	log.Println("DeleteDomainRecord: delete request for", id, "of", domain, "returned", response.Status)
+	return nil
+}
+
+// ListKeys lists all SSH keys on the DO account
+func ListKeys(ctx context.Context, client *godo.Client) ([]godo.Key, error) {
+	list := []godo.Key{}
+
+	opt := &godo.ListOptions{}
+	for {
+		var keys []godo.Key
+		var resp *godo.Response
+		err := defaultRetryPolicy.retry(ctx, func() (*godo.Response, error) {
+			var err error
+			keys, resp, err = client.Keys.List(ctx, opt)
+			return resp, err
+		})
 		if err != nil {
-			if i == retries-1 {
-				return fmt.Errorf("DetachVolume: %v", err)
-			}
-			timeout()
-		} else {
-			log.Println("DetachVolume: volume action request for detachment", volID, "returned", response.Status)
+			return list, fmt.Errorf("ListKeys: %v", err)
+		}
+		list = append(list, keys...)
+
+		if resp.Links == nil || resp.Links.IsLastPage() {
 			break
 		}
+
+		page, err := resp.Links.CurrentPage()
+		if err != nil {
+			return nil, fmt.Errorf("ListKeys: %v", err)
+		}
+		opt.Page = page + 1
 	}
+
+	return list, nil
+}
+
+// CreateKey attempts to upload the SSH key of keyCreateRequest to DO and returns the new key
+func CreateKey(ctx context.Context, client *godo.Client, keyCreateRequest *godo.KeyCreateRequest) (*godo.Key, error) {
+	var key *godo.Key
+	var response *godo.Response
+	err := createRetryPolicy.retry(ctx, func() (*godo.Response, error) {
+		var err error
+		key, response, err = client.Keys.Create(ctx, keyCreateRequest)
+		return response, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("CreateKey: %v", err)
+	}
+	log.Println("CreateKey: create request for", keyCreateRequest.Name, "returned", response.Status)
+	return key, nil
+}
+
+// DeleteKey attempts to delete an SSH key from DO by ID
+func DeleteKey(ctx context.Context, client *godo.Client, id int) error {
+	var response *godo.Response
+	err := defaultRetryPolicy.retry(ctx, func() (*godo.Response, error) {
+		var err error
+		response, err = client.Keys.DeleteByID(ctx, id)
+		return response, err
+	})
+	if err != nil {
+		return fmt.Errorf("DeleteKey: %v", err)
+	}
+	log.Println("DeleteKey: delete request for", id, "returned", response.Status)
 	return nil
 }
 
-// ResizeVolume attempts to perform an action (resize) on an active volume on DO by ID
-func ResizeVolume(ctx context.Context, client *godo.Client, volID, region string, value interface{}) error {
-	for i := 0; i < retries; i++ {
-		_, response, err := client.StorageActions.Resize(ctx, volID, value.(int), region)
+// ListReservedIPs lists all reserved (floating) IPs on the DO account
+func ListReservedIPs(ctx context.Context, client *godo.Client) ([]godo.FloatingIP, error) {
+	list := []godo.FloatingIP{}
+
+	opt := &godo.ListOptions{}
+	for {
+		var floatingIPs []godo.FloatingIP
+		var resp *godo.Response
+		err := defaultRetryPolicy.retry(ctx, func() (*godo.Response, error) {
+			var err error
+			floatingIPs, resp, err = client.FloatingIPs.List(ctx, opt)
+			return resp, err
+		})
 		if err != nil {
-			if i == retries-1 {
-				return fmt.Errorf("ResizeVolume: %v", err)
-			}
-			timeout()
-		} else {
-			log.Println("ResizeVolume: volume action request for resize", volID, "returned", response.Status)
+			return list, fmt.Errorf("ListReservedIPs: %v", err)
+		}
+		list = append(list, floatingIPs...)
+
+		if resp.Links == nil || resp.Links.IsLastPage() {
+			break
+		}
+
+		page, err := resp.Links.CurrentPage()
+		if err != nil {
+			return nil, fmt.Errorf("ListReservedIPs: %v", err)
+		}
+		opt.Page = page + 1
+	}
+
+	return list, nil
+}
+
+// CreateReservedIP attempts to reserve an IP and returns it. The IP is assigned to the droplet
+// of floatingIPCreateRequest if its DropletID is set, otherwise it is reserved in its Region.
+func CreateReservedIP(ctx context.Context, client *godo.Client, floatingIPCreateRequest *godo.FloatingIPCreateRequest) (*godo.FloatingIP, error) {
+	var floatingIP *godo.FloatingIP
+	var response *godo.Response
+	err := createRetryPolicy.retry(ctx, func() (*godo.Response, error) {
+		var err error
+		floatingIP, response, err = client.FloatingIPs.Create(ctx, floatingIPCreateRequest)
+		return response, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("CreateReservedIP: %v", err)
+	}
+	log.Println("CreateReservedIP: create request for", floatingIP.IP, "returned", response.Status)
+	return floatingIP, nil
+}
+
+// AssignReservedIP attempts to assign a reserved IP to a droplet, see WaitForAction
+func AssignReservedIP(ctx context.Context, client *godo.Client, ip string, dropletID int) (*godo.Action, error) {
+	var ipAction *godo.Action
+	var response *godo.Response
+	err := defaultRetryPolicy.retry(ctx, func() (*godo.Response, error) {
+		var err error
+		ipAction, response, err = client.FloatingIPActions.Assign(ctx, ip, dropletID)
+		return response, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("AssignReservedIP: %v", err)
+	}
+	log.Println("AssignReservedIP: reserved IP action request for", ip, "returned", response.Status)
+	return ipAction, nil
+}
+
+// UnassignReservedIP attempts to unassign a reserved IP from its droplet, see WaitForAction
+func UnassignReservedIP(ctx context.Context, client *godo.Client, ip string) (*godo.Action, error) {
+	var ipAction *godo.Action
+	var response *godo.Response
+	err := defaultRetryPolicy.retry(ctx, func() (*godo.Response, error) {
+		var err error
+		ipAction, response, err = client.FloatingIPActions.Unassign(ctx, ip)
+		return response, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("UnassignReservedIP: %v", err)
+	}
+	log.Println("UnassignReservedIP: reserved IP action request for", ip, "returned", response.Status)
+	return ipAction, nil
+}
+
+// ListVPCs lists all VPCs on the DO account
+func ListVPCs(ctx context.Context, client *godo.Client) ([]godo.VPC, error) {
+	list := []godo.VPC{}
+
+	opt := &godo.ListOptions{}
+	for {
+		var vpcs []*godo.VPC
+		var resp *godo.Response
+		err := defaultRetryPolicy.retry(ctx, func() (*godo.Response, error) {
+			var err error
+			vpcs, resp, err = client.VPCs.List(ctx, opt)
+			return resp, err
+		})
+		if err != nil {
+			return list, fmt.Errorf("ListVPCs: %v", err)
+		}
+		for _, vpc := range vpcs {
+			list = append(list, *vpc)
+		}
+
+		if resp.Links == nil || resp.Links.IsLastPage() {
 			break
 		}
+
+		page, err := resp.Links.CurrentPage()
+		if err != nil {
+			return nil, fmt.Errorf("ListVPCs: %v", err)
+		}
+		opt.Page = page + 1
+	}
+
+	return list, nil
+}
+
+// CreateVPC attempts to create a VPC and returns the new VPC
+func CreateVPC(ctx context.Context, client *godo.Client, vpcCreateRequest *godo.VPCCreateRequest) (*godo.VPC, error) {
+	var vpc *godo.VPC
+	var response *godo.Response
+	err := createRetryPolicy.retry(ctx, func() (*godo.Response, error) {
+		var err error
+		vpc, response, err = client.VPCs.Create(ctx, vpcCreateRequest)
+		return response, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("CreateVPC: %v", err)
+	}
+	log.Println("CreateVPC: create request for", vpcCreateRequest.Name, "returned", response.Status)
+	return vpc, nil
+}
+
+// SetVPCDescription attempts to change the description of a VPC
+func SetVPCDescription(ctx context.Context, client *godo.Client, id, description string) error {
+	var response *godo.Response
+	err := defaultRetryPolicy.retry(ctx, func() (*godo.Response, error) {
+		var err error
+		_, response, err = client.VPCs.Set(ctx, id, godo.VPCSetDescription(description))
+		return response, err
+	})
+	if err != nil {
+		return fmt.Errorf("SetVPCDescription: %v", err)
 	}
+	log.Println("SetVPCDescription: set request for", id, "returned", response.Status)
 	return nil
 }
@@ -0,0 +1,128 @@
+package gitdrops
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/digitalocean/godo"
+)
+
+func TestPoll(t *testing.T) {
+	conditionErr := errors.New("action errored")
+	tcases := []struct {
+		name      string
+		timeout   time.Duration
+		condition func() (bool, error)
+		expError  bool
+	}{
+		{
+			name:    "test case 1 - done",
+			timeout: time.Minute,
+			condition: func() (bool, error) {
+				return true, nil
+			},
+			expError: false,
+		},
+		{
+			name:    "test case 2 - condition error",
+			timeout: time.Minute,
+			condition: func() (bool, error) {
+				return false, conditionErr
+			},
+			expError: true,
+		},
+		{
+			name:    "test case 3 - timeout",
+			timeout: time.Millisecond,
+			condition: func() (bool, error) {
+				return false, nil
+			},
+			expError: true,
+		},
+	}
+	for _, tc := range tcases {
+		err := poll(context.Background(), tc.timeout, tc.condition)
+		if (err != nil) != tc.expError {
+			t.Errorf("Failed %v, expected error: %v, got error %v", tc.name, tc.expError, err)
+		}
+	}
+}
+
+func TestIsDropletActive(t *testing.T) {
+	tcases := []struct {
+		name      string
+		status    string
+		expActive bool
+		expError  bool
+	}{
+		{
+			name:      "test case 1 - new",
+			status:    "new",
+			expActive: false,
+			expError:  false,
+		},
+		{
+			name:      "test case 2 - active",
+			status:    "active",
+			expActive: true,
+			expError:  false,
+		},
+		{
+			name:      "test case 3 - errored",
+			status:    "errored",
+			expActive: false,
+			expError:  true,
+		},
+		{
+			name:      "test case 4 - archived",
+			status:    "archive",
+			expActive: false,
+			expError:  true,
+		},
+	}
+	for _, tc := range tcases {
+		active, err := isDropletActive(&godo.Droplet{ID: 1, Status: tc.status})
+		if (err != nil) != tc.expError {
+			t.Errorf("Failed %v, expected error: %v, got error %v", tc.name, tc.expError, err)
+		}
+		if active != tc.expActive {
+			t.Errorf("Failed %v, expected: %v, got %v", tc.name, tc.expActive, active)
+		}
+	}
+}
+
+func TestSSHKeyFingerprint(t *testing.T) {
+	tcases := []struct {
+		name           string
+		publicKey      string
+		expFingerprint string
+		expError       bool
+	}{
+		{
+			name:           "test case 1 - ed25519 key",
+			publicKey:      "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIPwYCqdnmszNZHajGQz1FSzKErFHTloLI58ztprFsCUy user@host",
+			expFingerprint: "ed:6a:5f:41:b7:7d:3b:ac:9f:c7:d0:dd:9f:81:20:6a",
+		},
+		{
+			name:      "test case 2 - missing key",
+			publicKey: "ssh-ed25519",
+			expError:  true,
+		},
+		{
+			name:      "test case 3 - invalid base64",
+			publicKey: "ssh-ed25519 not-base64!",
+			expError:  true,
+		},
+	}
+	for _, tc := range tcases {
+		fingerprint, err := SSHKeyFingerprint(tc.publicKey)
+		if (err != nil) != tc.expError {
+			t.Errorf("Failed %v, expected error: %v, got %v", tc.name, tc.expError, err)
+		}
+		if fingerprint != tc.expFingerprint {
+			t.Errorf("Failed %v, expected: %v, got %v", tc.name, tc.expFingerprint, fingerprint)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package gitdrops
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/digitalocean/godo"
+)
+
+// retryPolicy determines how often and how long to wait before a failed DO request is retried.
+type retryPolicy struct {
+	// attempts is the maximum number of times a request is made
+	attempts int
+	// baseDelay is the delay before the first retry, it is doubled on every further retry up
+	// to maxDelay. A random jitter of up to half the delay is subtracted so that concurrent
+	// requests do not retry in lockstep.
+	baseDelay time.Duration
+	maxDelay  time.Duration
+	// rateLimitThreshold is the number of remaining requests at which all requests are paused
+	// until the rate limit resets, rather than waiting for DO to reject them.
+	rateLimitThreshold int
+	// createsObject is set for requests that create an object. They are only retried when DO
+	// rate limited them, as DO may have created the object before any other failure, eg a
+	// timeout, and retrying would create the object twice. See isRateLimited.
+	createsObject bool
+}
+
+var defaultRetryPolicy = retryPolicy{
+	attempts:           10,
+	baseDelay:          time.Second,
+	maxDelay:           time.Minute,
+	rateLimitThreshold: 10,
+}
+
+// createRetryPolicy is the retryPolicy of requests that create an object.
+var createRetryPolicy = retryPolicy{
+	attempts:           10,
+	baseDelay:          time.Second,
+	maxDelay:           time.Minute,
+	rateLimitThreshold: 10,
+	createsObject:      true,
+}
+
+// rateLimit is shared by all requests of the process, as DO limits requests per token.
+var rateLimit = &rateLimiter{}
+
+// rateLimiter pauses requests until reset once the rate limit is nearly exhausted.
+type rateLimiter struct {
+	mu    sync.Mutex
+	reset time.Time
+}
+
+// update records the rate limit returned with response. Requests are paused until the limit
+// resets if no more than threshold requests remain.
+func (rl *rateLimiter) update(response *godo.Response, threshold int) {
+	if response == nil || response.Rate.Limit == 0 || response.Rate.Remaining > threshold {
+		return
+	}
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	if response.Rate.Reset.Time.After(rl.reset) {
+		log.Println("rateLimiter.update:", response.Rate.Remaining, "requests remaining, pausing requests until", response.Rate.Reset)
+		rl.reset = response.Rate.Reset.Time
+	}
+}
+
+// wait blocks until the rate limit has reset or ctx is done.
+func (rl *rateLimiter) wait(ctx context.Context) error {
+	rl.mu.Lock()
+	pause := time.Until(rl.reset)
+	rl.mu.Unlock()
+	if pause <= 0 {
+		return nil
+	}
+	return sleep(ctx, pause)
+}
+
+type attemptsKey struct{}
+
+// CountAttempts returns a copy of ctx that counts the DO requests made with it, including
+// retries, and a function returning the count.
+func CountAttempts(ctx context.Context) (context.Context, func() int) {
+	attempts := new(int32)
+	return context.WithValue(ctx, attemptsKey{}, attempts), func() int {
+		return int(atomic.LoadInt32(attempts))
+	}
+}
+
+// retry calls request until it succeeds, fails with an error that is not retryable, the
+// attempts of the policy are exhausted or ctx is done. request returns the response of the DO
+// request it makes, which is used to classify errors and track the rate limit.
+func (p retryPolicy) retry(ctx context.Context, request func() (*godo.Response, error)) error {
+	var err error
+	for attempt := 1; attempt <= p.attempts; attempt++ {
+		err = rateLimit.wait(ctx)
+		if err != nil {
+			return err
+		}
+		if attempts, ok := ctx.Value(attemptsKey{}).(*int32); ok {
+			atomic.AddInt32(attempts, 1)
+		}
+		var response *godo.Response
+		response, err = request()
+		rateLimit.update(response, p.rateLimitThreshold)
+		if err == nil {
+			return nil
+		}
+		retryable := isRetryable(err)
+		if p.createsObject {
+			retryable = isRateLimited(err)
+		}
+		if !retryable || attempt == p.attempts {
+			break
+		}
+		delay := p.backoff(attempt)
+		log.Printf("retryPolicy.retry: attempt %d of %d failed, retrying in %v: %v", attempt, p.attempts, delay, err)
+		err = sleep(ctx, delay)
+		if err != nil {
+			return err
+		}
+	}
+	return err
+}
+
+// backoff returns the delay after the given failed attempt, starting at 1.
+func (p retryPolicy) backoff(attempt int) time.Duration {
+	delay := p.baseDelay
+	for i := 1; i < attempt && delay < p.maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.maxDelay {
+		delay = p.maxDelay
+	}
+	jitter := time.Duration(rand.Int63n(int64(delay)/2 + 1))
+	return delay - jitter
+}
+
+// isRetryable returns true if a request failing with err may succeed when retried, ie DO
+// rate limited the request (429), failed to serve it (5xx) or it never reached DO. Any other
+// error response, eg an invalid request, fails again when retried.
+func isRetryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var argError *godo.ArgError
+	if errors.As(err, &argError) {
+		return false
+	}
+	var errorResponse *godo.ErrorResponse
+	if errors.As(err, &errorResponse) {
+		if errorResponse.Response == nil {
+			return true
+		}
+		statusCode := errorResponse.Response.StatusCode
+		return statusCode == http.StatusTooManyRequests || statusCode >= http.StatusInternalServerError
+	}
+	return true
+}
+
+// isRateLimited returns true if err is a rate limited (429) response from DO, ie DO rejected the
+// request without acting on it.
+func isRateLimited(err error) bool {
+	var errorResponse *godo.ErrorResponse
+	if errors.As(err, &errorResponse) && errorResponse.Response != nil {
+		return errorResponse.Response.StatusCode == http.StatusTooManyRequests
+	}
+	return false
+}
+
+// sleep waits for d or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return fmt.Errorf("cancelled while waiting to retry: %v", ctx.Err())
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,244 @@
+package gitdrops
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/digitalocean/godo"
+)
+
+func newTestResponse(statusCode int) *godo.Response {
+	return &godo.Response{Response: &http.Response{StatusCode: statusCode}}
+}
+
+func TestIsRetryable(t *testing.T) {
+	tcases := []struct {
+		name         string
+		err          error
+		expRetryable bool
+	}{
+		{
+			name:         "test case 1 - rate limited",
+			err:          &godo.ErrorResponse{Response: &http.Response{StatusCode: http.StatusTooManyRequests}},
+			expRetryable: true,
+		},
+		{
+			name:         "test case 2 - server error",
+			err:          &godo.ErrorResponse{Response: &http.Response{StatusCode: http.StatusServiceUnavailable}},
+			expRetryable: true,
+		},
+		{
+			name:         "test case 3 - invalid request",
+			err:          &godo.ErrorResponse{Response: &http.Response{StatusCode: http.StatusUnprocessableEntity}},
+			expRetryable: false,
+		},
+		{
+			name:         "test case 4 - network error",
+			err:          errors.New("connection reset by peer"),
+			expRetryable: true,
+		},
+		{
+			name:         "test case 5 - context cancelled",
+			err:          context.Canceled,
+			expRetryable: false,
+		},
+		{
+			name:         "test case 6 - invalid argument",
+			err:          godo.NewArgError("dropletID", "cannot be less than 1"),
+			expRetryable: false,
+		},
+	}
+	for _, tc := range tcases {
+		retryable := isRetryable(tc.err)
+		if retryable != tc.expRetryable {
+			t.Errorf("Failed %v, expected: %v, got %v", tc.name, tc.expRetryable, retryable)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	policy := retryPolicy{attempts: 10, baseDelay: time.Second, maxDelay: 10 * time.Second}
+	tcases := []struct {
+		name     string
+		attempt  int
+		expDelay time.Duration
+	}{
+		{
+			name:     "test case 1 - first retry",
+			attempt:  1,
+			expDelay: time.Second,
+		},
+		{
+			name:     "test case 2 - doubled",
+			attempt:  3,
+			expDelay: 4 * time.Second,
+		},
+		{
+			name:     "test case 3 - capped",
+			attempt:  8,
+			expDelay: 10 * time.Second,
+		},
+	}
+	for _, tc := range tcases {
+		delay := policy.backoff(tc.attempt)
+		// the jitter subtracts up to half of the delay
+		if delay > tc.expDelay || delay < tc.expDelay/2 {
+			t.Errorf("Failed %v, expected delay between: %v and %v, got %v", tc.name, tc.expDelay/2, tc.expDelay, delay)
+		}
+	}
+}
+
+func TestRetry(t *testing.T) {
+	policy := retryPolicy{attempts: 3, baseDelay: time.Millisecond, maxDelay: time.Millisecond}
+	tcases := []struct {
+		name        string
+		responses   []*godo.Response
+		errs        []error
+		expAttempts int
+		expError    bool
+	}{
+		{
+			name:        "test case 1 - success",
+			responses:   []*godo.Response{newTestResponse(http.StatusOK)},
+			errs:        []error{nil},
+			expAttempts: 1,
+			expError:    false,
+		},
+		{
+			name: "test case 2 - success after server error",
+			responses: []*godo.Response{
+				newTestResponse(http.StatusInternalServerError),
+				newTestResponse(http.StatusOK),
+			},
+			errs: []error{
+				&godo.ErrorResponse{Response: &http.Response{StatusCode: http.StatusInternalServerError}},
+				nil,
+			},
+			expAttempts: 2,
+			expError:    false,
+		},
+		{
+			name:      "test case 3 - invalid request is not retried",
+			responses: []*godo.Response{newTestResponse(http.StatusUnprocessableEntity)},
+			errs: []error{
+				&godo.ErrorResponse{Response: &http.Response{StatusCode: http.StatusUnprocessableEntity}},
+			},
+			expAttempts: 1,
+			expError:    true,
+		},
+		{
+			name:        "test case 4 - attempts exhausted",
+			responses:   []*godo.Response{nil, nil, nil},
+			errs:        []error{errors.New("timeout"), errors.New("timeout"), errors.New("timeout")},
+			expAttempts: 3,
+			expError:    true,
+		},
+	}
+	for _, tc := range tcases {
+		ctx, countedAttempts := CountAttempts(context.Background())
+		attempts := 0
+		err := policy.retry(ctx, func() (*godo.Response, error) {
+			attempts++
+			return tc.responses[attempts-1], tc.errs[attempts-1]
+		})
+		if (err != nil) != tc.expError {
+			t.Errorf("Failed %v, expected error: %v, got error %v", tc.name, tc.expError, err)
+		}
+		if attempts != tc.expAttempts || countedAttempts() != tc.expAttempts {
+			t.Errorf("Failed %v, expected attempts: %v, got %v (counted %v)", tc.name, tc.expAttempts, attempts, countedAttempts())
+		}
+	}
+}
+
+func TestRetryCreate(t *testing.T) {
+	policy := retryPolicy{attempts: 3, baseDelay: time.Millisecond, maxDelay: time.Millisecond, createsObject: true}
+	tcases := []struct {
+		name        string
+		responses   []*godo.Response
+		errs        []error
+		expAttempts int
+		expError    bool
+	}{
+		{
+			name: "test case 1 - success after rate limit",
+			responses: []*godo.Response{
+				newTestResponse(http.StatusTooManyRequests),
+				newTestResponse(http.StatusCreated),
+			},
+			errs: []error{
+				&godo.ErrorResponse{Response: &http.Response{StatusCode: http.StatusTooManyRequests}},
+				nil,
+			},
+			expAttempts: 2,
+			expError:    false,
+		},
+		{
+			name:        "test case 2 - network error is not retried",
+			responses:   []*godo.Response{nil},
+			errs:        []error{errors.New("timeout")},
+			expAttempts: 1,
+			expError:    true,
+		},
+		{
+			name:      "test case 3 - server error is not retried",
+			responses: []*godo.Response{newTestResponse(http.StatusGatewayTimeout)},
+			errs: []error{
+				&godo.ErrorResponse{Response: &http.Response{StatusCode: http.StatusGatewayTimeout}},
+			},
+			expAttempts: 1,
+			expError:    true,
+		},
+	}
+	for _, tc := range tcases {
+		attempts := 0
+		err := policy.retry(context.Background(), func() (*godo.Response, error) {
+			attempts++
+			return tc.responses[attempts-1], tc.errs[attempts-1]
+		})
+		if (err != nil) != tc.expError {
+			t.Errorf("Failed %v, expected error: %v, got error %v", tc.name, tc.expError, err)
+		}
+		if attempts != tc.expAttempts {
+			t.Errorf("Failed %v, expected attempts: %v, got %v", tc.name, tc.expAttempts, attempts)
+		}
+	}
+}
+
+func TestRetryCancelled(t *testing.T) {
+	policy := retryPolicy{attempts: 3, baseDelay: time.Hour, maxDelay: time.Hour}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	attempts := 0
+	err := policy.retry(ctx, func() (*godo.Response, error) {
+		attempts++
+		return nil, errors.New("timeout")
+	})
+	if err == nil || attempts != 1 {
+		t.Errorf("Failed, expected error after 1 attempt, got error %v after %v attempts", err, attempts)
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	rl := &rateLimiter{}
+	reset := time.Now().Add(time.Hour)
+	response := &godo.Response{Rate: godo.Rate{Limit: 5000, Remaining: 100, Reset: godo.Timestamp{Time: reset}}}
+	rl.update(response, 10)
+	if !rl.reset.IsZero() {
+		t.Errorf("Failed, expected no pause with 100 requests remaining, got pause until %v", rl.reset)
+	}
+
+	response.Rate.Remaining = 5
+	rl.update(response, 10)
+	if !rl.reset.Equal(reset) {
+		t.Errorf("Failed, expected pause until: %v, got %v", reset, rl.reset)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	err := rl.wait(ctx)
+	if err == nil {
+		t.Errorf("Failed, expected wait to be cancelled")
+	}
+}
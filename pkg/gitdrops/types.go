@@ -1,53 +1,321 @@
 package gitdrops
 
 type GitDrops struct {
-	Privileges Privileges `yaml:"privileges"`
-	Droplets   []Droplet  `yaml:"droplets"`
-	Volumes    []Volume   `yaml:"volumes"`
+	// Stack identifies the droplets and volumes managed by this gitdrops.yaml, so that several
+	// gitdrops.yaml can manage objects on the same DO account. It defaults to "default".
+	Stack      string     `yaml:"stack,omitempty" json:"stack,omitempty"`
+	Privileges Privileges `yaml:"privileges" json:"privileges"`
+	// SnapshotBeforeDelete and SnapshotBeforeRebuild snapshot a droplet or volume before it is
+	// deleted (or replaced), or a droplet before it is rebuilt. SnapshotRetention is the number
+	// of snapshots taken by gitdrops kept per droplet or volume, 0 keeps all snapshots.
+	SnapshotBeforeDelete  bool         `yaml:"snapshotBeforeDelete,omitempty" json:"snapshotBeforeDelete,omitempty"`
+	SnapshotBeforeRebuild bool         `yaml:"snapshotBeforeRebuild,omitempty" json:"snapshotBeforeRebuild,omitempty"`
+	SnapshotRetention     int          `yaml:"snapshotRetention,omitempty" json:"snapshotRetention,omitempty"`
+	Droplets              []Droplet    `yaml:"droplets" json:"droplets"`
+	Volumes               []Volume     `yaml:"volumes" json:"volumes"`
+	Firewalls             []Firewall   `yaml:"firewalls,omitempty" json:"firewalls,omitempty"`
+	Domains               []Domain     `yaml:"domains,omitempty" json:"domains,omitempty"`
+	SSHKeys               []SSHKey     `yaml:"sshKeys,omitempty" json:"sshKeys,omitempty"`
+	ReservedIPs           []ReservedIP `yaml:"reservedIPs,omitempty" json:"reservedIPs,omitempty"`
+	VPCs                  []VPC        `yaml:"vpcs,omitempty" json:"vpcs,omitempty"`
 }
 
+// Privileges determine which changes gitdrops may make. Create, Update and Delete are the
+// defaults for all resource kinds and actions, Droplets, Volumes, Firewalls, Domains, SSHKeys,
+// ReservedIPs and VPCs override them per kind.
 type Privileges struct {
-	Create bool `yaml:"create"`
-	Update bool `yaml:"update"`
-	Delete bool `yaml:"delete"`
+	Create      bool                `yaml:"create" json:"create"`
+	Update      bool                `yaml:"update" json:"update"`
+	Delete      bool                `yaml:"delete" json:"delete"`
+	Droplets    *ResourcePrivileges `yaml:"droplets,omitempty" json:"droplets,omitempty"`
+	Volumes     *ResourcePrivileges `yaml:"volumes,omitempty" json:"volumes,omitempty"`
+	Firewalls   *ResourcePrivileges `yaml:"firewalls,omitempty" json:"firewalls,omitempty"`
+	Domains     *ResourcePrivileges `yaml:"domains,omitempty" json:"domains,omitempty"`
+	SSHKeys     *ResourcePrivileges `yaml:"sshKeys,omitempty" json:"sshKeys,omitempty"`
+	ReservedIPs *ResourcePrivileges `yaml:"reservedIPs,omitempty" json:"reservedIPs,omitempty"`
+	VPCs        *ResourcePrivileges `yaml:"vpcs,omitempty" json:"vpcs,omitempty"`
+}
+
+// ResourcePrivileges override Privileges for one resource kind. Unset fields fall back to
+// Privileges. Actions grants or denies single update actions (see DropletActions,
+// VolumeActions, FirewallActions, DomainActions, ReservedIPActions and VPCActions), falling back
+// to Update.
+type ResourcePrivileges struct {
+	Create  *bool           `yaml:"create,omitempty" json:"create,omitempty"`
+	Update  *bool           `yaml:"update,omitempty" json:"update,omitempty"`
+	Delete  *bool           `yaml:"delete,omitempty" json:"delete,omitempty"`
+	Actions map[string]bool `yaml:"actions,omitempty" json:"actions,omitempty"`
+}
+
+// Resource kinds and actions of Privileges.Allows.
+const (
+	DropletResource    = "droplet"
+	VolumeResource     = "volume"
+	FirewallResource   = "firewall"
+	DomainResource     = "domain"
+	SSHKeyResource     = "sshKey"
+	ReservedIPResource = "reservedIP"
+	VPCResource        = "vpc"
+	CreateAction       = "create"
+	DeleteAction       = "delete"
+)
+
+// DropletActions, VolumeActions, FirewallActions, DomainActions, ReservedIPActions and VPCActions
+// are the update actions that can be listed in ResourcePrivileges.Actions.
+var (
+	DropletActions    = []string{"resize", "rebuild", "replace", "protect", "enableBackups", "disableBackups", "enableIPv6", "tag", "untag", "powerOn", "powerOff"}
+	VolumeActions     = []string{"resize", "replace", "attach", "detach", "protect", "tag", "untag"}
+	FirewallActions   = []string{"addRule", "removeRule", "addDroplet", "removeDroplet", "addTag", "removeTag"}
+	DomainActions     = []string{"createRecord", "editRecord", "deleteRecord"}
+	ReservedIPActions = []string{"assign", "unassign"}
+	VPCActions        = []string{"updateDescription"}
+)
+
+// Allows returns true if gitdrops may perform action on an object of the resource kind. Any
+// action other than create or delete is an update action.
+func (p Privileges) Allows(resource, action string) bool {
+	var resourcePrivileges *ResourcePrivileges
+	switch resource {
+	case DropletResource:
+		resourcePrivileges = p.Droplets
+	case VolumeResource:
+		resourcePrivileges = p.Volumes
+	case FirewallResource:
+		resourcePrivileges = p.Firewalls
+	case DomainResource:
+		resourcePrivileges = p.Domains
+	case SSHKeyResource:
+		resourcePrivileges = p.SSHKeys
+	case ReservedIPResource:
+		resourcePrivileges = p.ReservedIPs
+	case VPCResource:
+		resourcePrivileges = p.VPCs
+	}
+	if resourcePrivileges == nil {
+		resourcePrivileges = &ResourcePrivileges{}
+	}
+	switch action {
+	case CreateAction:
+		if resourcePrivileges.Create != nil {
+			return *resourcePrivileges.Create
+		}
+		return p.Create
+	case DeleteAction:
+		if resourcePrivileges.Delete != nil {
+			return *resourcePrivileges.Delete
+		}
+		return p.Delete
+	}
+	if allowed, ok := resourcePrivileges.Actions[action]; ok {
+		return allowed
+	}
+	if resourcePrivileges.Update != nil {
+		return *resourcePrivileges.Update
+	}
+	return p.Update
 }
 
 // Droplet is a simplified gitdrops representation of godo.DropletCreateRequest
 type Droplet struct {
-	Name   string `yaml:"name"`
-	Region string `yaml:"region"`
-	Size   string `yaml:"size"`
+	Name   string `yaml:"name" json:"name"`
+	Region string `yaml:"region" json:"region"`
+	Size   string `yaml:"size" json:"size"`
 	// Image represents the image name for the droplet. It is the equivalient of
 	// godo.DropletCreateRequest.Image.Slug
-	Image string `yaml:"image"`
+	Image string `yaml:"image" json:"image"`
 	// SSHKeyFingerprint represents the SSH key fingerprints for the droplet.
 	// It is the equivalient of godo.DropletCreateRequest.[]SSHKeys.FingerPrint
-	SSHKeyFingerprints []string `yaml:"sshKeyFingerprints"`
-	Backups            bool     `yaml:"backups"`
-	IPv6               bool     `yaml:"ipv6"`
-	Monitoring         bool     `yaml:"monitoring"`
+	SSHKeyFingerprints []string `yaml:"sshKeyFingerprints" json:"sshKeyFingerprints"`
+	// SSHKeys is a []string of the names of the keys declared in GitDrops.SSHKeys to be added
+	// to the droplet, in addition to SSHKeyFingerprints.
+	SSHKeys    []string `yaml:"sshKeys,omitempty" json:"sshKeys,omitempty"`
+	Backups    bool     `yaml:"backups" json:"backups"`
+	IPv6       bool     `yaml:"ipv6" json:"ipv6"`
+	Monitoring bool     `yaml:"monitoring" json:"monitoring"`
 	// See type UserData
-	UserData UserData `yaml:"userData,omitempty"`
+	UserData UserData `yaml:"userData,omitempty" json:"userData,omitempty"`
 	// Volumes is a []string of the volume names to be attached to the droplet.
-	Volumes []string `yaml:"volumes,omitempty"`
-	Tags    []string `yaml:"tags"`
-	VPCUUID string   `yaml:"vpcuuid,omitempty"`
+	Volumes []string `yaml:"volumes,omitempty" json:"volumes,omitempty"`
+	Tags    []string `yaml:"tags" json:"tags"`
+	VPCUUID string   `yaml:"vpcuuid,omitempty" json:"vpcuuid,omitempty"`
+	// VPC is the name of a VPC declared in GitDrops.VPCs, resolved to VPCUUID.
+	VPC string `yaml:"vpc,omitempty" json:"vpc,omitempty"`
+	// Protect prevents gitdrops from deleting, rebuilding or replacing the droplet, also once
+	// it is removed from gitdrops.yaml.
+	Protect bool `yaml:"protect,omitempty" json:"protect,omitempty"`
+	// IgnoreChanges lists the fields (see DropletFields) of which changes are not applied to the
+	// existing droplet.
+	IgnoreChanges []string `yaml:"ignoreChanges,omitempty" json:"ignoreChanges,omitempty"`
+	// ReplaceOnChanges lists the fields that cannot be changed in place (region, monitoring, ipv6
+	// and sshKeyFingerprints) for which the droplet is replaced when they change. Changes to other
+	// such fields are only reported as requiring replacement.
+	ReplaceOnChanges []string `yaml:"replaceOnChanges,omitempty" json:"replaceOnChanges,omitempty"`
+	// PowerState is the desired power state of the droplet, PowerOn or PowerOff. The power state
+	// is not managed if it is empty.
+	PowerState string `yaml:"powerState,omitempty" json:"powerState,omitempty"`
+	// CreateBeforeDestroy creates the new droplet before deleting the existing one when the
+	// droplet is replaced, eg because its region changed.
+	CreateBeforeDestroy bool `yaml:"createBeforeDestroy,omitempty" json:"createBeforeDestroy,omitempty"`
 }
 
 // Volume is a simplified gitdrops representation of godo.VolumeCreateRequest
 type Volume struct {
-	Name            string   `yaml:"name"`
-	Region          string   `yaml:"region"`
-	SizeGigaBytes   int64    `yaml:"sizeGigaBytes"`
-	SnapshotID      string   `yaml:"snapShotID"`
-	FilesystemType  string   `yaml:"filesystemType"`
-	FilesystemLabel string   `yaml:"filesystemLabel"`
-	Tags            []string `yaml:"tags"`
+	Name            string   `yaml:"name" json:"name"`
+	Region          string   `yaml:"region" json:"region"`
+	SizeGigaBytes   int64    `yaml:"sizeGigaBytes" json:"sizeGigaBytes"`
+	SnapshotID      string   `yaml:"snapShotID" json:"snapShotID"`
+	FilesystemType  string   `yaml:"filesystemType" json:"filesystemType"`
+	FilesystemLabel string   `yaml:"filesystemLabel" json:"filesystemLabel"`
+	Tags            []string `yaml:"tags" json:"tags"`
+	// Protect prevents gitdrops from deleting the volume, also once it is removed from
+	// gitdrops.yaml.
+	Protect bool `yaml:"protect,omitempty" json:"protect,omitempty"`
+	// IgnoreChanges lists the fields (see VolumeFields) of which changes are not applied to, or
+	// reported for, the existing volume.
+	IgnoreChanges []string `yaml:"ignoreChanges,omitempty" json:"ignoreChanges,omitempty"`
+	// ReplaceOnShrink replaces the volume with a new, empty volume when SizeGigaBytes is smaller
+	// than the size of the existing volume, as DO cannot shrink volumes. Without it, planning
+	// a shrink fails.
+	ReplaceOnShrink bool `yaml:"replaceOnShrink,omitempty" json:"replaceOnShrink,omitempty"`
+}
+
+// Firewall is a simplified gitdrops representation of godo.FirewallRequest. The firewall applies
+// to the droplets named in Droplets and to the droplets tagged with any of Tags.
+type Firewall struct {
+	Name          string         `yaml:"name" json:"name"`
+	InboundRules  []FirewallRule `yaml:"inboundRules,omitempty" json:"inboundRules,omitempty"`
+	OutboundRules []FirewallRule `yaml:"outboundRules,omitempty" json:"outboundRules,omitempty"`
+	// Droplets is a []string of the names of the droplets the firewall applies to.
+	Droplets []string `yaml:"droplets,omitempty" json:"droplets,omitempty"`
+	Tags     []string `yaml:"tags,omitempty" json:"tags,omitempty"`
+}
+
+// FirewallRule is a simplified gitdrops representation of godo.InboundRule and
+// godo.OutboundRule. Addresses and Tags are the sources of inbound rules and the destinations
+// of outbound rules.
+type FirewallRule struct {
+	// Protocol is one of FirewallProtocols.
+	Protocol string `yaml:"protocol" json:"protocol"`
+	// Ports is a port eg "22", a range eg "8000-9000" or "all". It is not set for icmp.
+	Ports     string   `yaml:"ports,omitempty" json:"ports,omitempty"`
+	Addresses []string `yaml:"addresses,omitempty" json:"addresses,omitempty"`
+	Tags      []string `yaml:"tags,omitempty" json:"tags,omitempty"`
+}
+
+// FirewallProtocols are the protocols of a FirewallRule.
+var FirewallProtocols = []string{"tcp", "udp", "icmp"}
+
+// Domain is a simplified gitdrops representation of godo.Domain and its records.
+type Domain struct {
+	Name    string         `yaml:"name" json:"name"`
+	Records []DomainRecord `yaml:"records,omitempty" json:"records,omitempty"`
+}
+
+// DomainRecord is a simplified gitdrops representation of godo.DomainRecord. The value of the
+// record is either Data, or the public IPv4 (type A) or IPv6 (type AAAA) address of the droplet
+// named DropletRef.
+type DomainRecord struct {
+	// Type is one of DomainRecordTypes.
+	Type string `yaml:"type" json:"type"`
+	// Name is the host name of the record relative to the domain, @ for the domain itself.
+	Name       string `yaml:"name" json:"name"`
+	Data       string `yaml:"data,omitempty" json:"data,omitempty"`
+	DropletRef string `yaml:"dropletRef,omitempty" json:"dropletRef,omitempty"`
+	// TTL is the time to live of the record in seconds, DO defaults to 1800.
+	TTL int `yaml:"ttl,omitempty" json:"ttl,omitempty"`
+}
+
+// DomainRecordTypes are the types of a DomainRecord.
+var DomainRecordTypes = []string{"A", "AAAA", "CNAME", "TXT"}
+
+// SSHKey is a public SSH key uploaded to DO by gitdrops, see godo.KeyCreateRequest. PublicKey
+// is read from PublicKeyFile, and Fingerprint is the MD5 fingerprint of PublicKey by which DO
+// identifies the key, see ReadGitDrops.
+type SSHKey struct {
+	Name          string `yaml:"name" json:"name"`
+	PublicKeyFile string `yaml:"publicKeyFile,omitempty" json:"publicKeyFile,omitempty"`
+	PublicKey     string `yaml:"publicKey,omitempty" json:"publicKey,omitempty"`
+	Fingerprint   string `yaml:"-" json:"fingerprint,omitempty"`
+}
+
+// ReservedIP is a reserved (formerly floating) IP in Region, assigned to the droplet named
+// Droplet. DO reserved IPs have no name and cannot be tagged: IP is the address of an existing
+// reserved IP, and a reserved IP without IP is the one assigned to Droplet, which is reserved
+// when Droplet has none. Setting IP keeps the address when Droplet is deleted.
+type ReservedIP struct {
+	Region  string `yaml:"region" json:"region"`
+	Droplet string `yaml:"droplet,omitempty" json:"droplet,omitempty"`
+	IP      string `yaml:"ip,omitempty" json:"ip,omitempty"`
+}
+
+// VPC is a simplified gitdrops representation of godo.VPCCreateRequest. IPRange is the private
+// IP range of the VPC in CIDR notation, DO picks one if it is empty. The region and IP range of
+// a VPC cannot be changed once it is created.
+type VPC struct {
+	Name        string `yaml:"name" json:"name"`
+	Region      string `yaml:"region" json:"region"`
+	IPRange     string `yaml:"ipRange,omitempty" json:"ipRange,omitempty"`
+	Description string `yaml:"description,omitempty" json:"description,omitempty"`
+}
+
+// Fields of droplets and volumes that can be listed in IgnoreChanges.
+const (
+	SizeField               = "size"
+	ImageField              = "image"
+	RegionField             = "region"
+	BackupsField            = "backups"
+	IPv6Field               = "ipv6"
+	MonitoringField         = "monitoring"
+	TagsField               = "tags"
+	SSHKeyFingerprintsField = "sshKeyFingerprints"
+	PowerStateField         = "powerState"
+	FilesystemTypeField     = "filesystemType"
+	FilesystemLabelField    = "filesystemLabel"
+	SnapshotIDField         = "snapShotID"
+)
+
+// Values of Droplet.PowerState.
+const (
+	PowerOn  = "on"
+	PowerOff = "off"
+)
+
+// DropletFields are the droplet fields that can be listed in Droplet.IgnoreChanges, and
+// ReplacementFields those that can be listed in Droplet.ReplaceOnChanges.
+var (
+	DropletFields     = []string{SizeField, ImageField, RegionField, BackupsField, IPv6Field, MonitoringField, TagsField, SSHKeyFingerprintsField, PowerStateField}
+	ReplacementFields = []string{RegionField, MonitoringField, IPv6Field, SSHKeyFingerprintsField}
+	// VolumeFields are the volume fields that can be listed in Volume.IgnoreChanges.
+	VolumeFields = []string{SizeField, TagsField, RegionField, FilesystemTypeField, FilesystemLabelField, SnapshotIDField}
+)
+
+// IgnoresChanges returns true if changes to field of the droplet are ignored.
+func (d Droplet) IgnoresChanges(field string) bool {
+	return contains(d.IgnoreChanges, field)
+}
+
+// ReplacesOnChanges returns true if the droplet is replaced when field changes.
+func (d Droplet) ReplacesOnChanges(field string) bool {
+	return contains(d.ReplaceOnChanges, field)
+}
+
+// IgnoresChanges returns true if changes to field of the volume are ignored.
+func (v Volume) IgnoresChanges(field string) bool {
+	return contains(v.IgnoreChanges, field)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
 }
 
 // UserData stores the Path of a userdata file and/or the Data itself. In the event that path is
 // defined, Data is populated with contents of the file at Path. Thus Path takes precedence over Data.
 type UserData struct {
-	Path string `yaml:"path,omitempty"`
-	Data string `yaml:"data,omitempty"`
+	Path string `yaml:"path,omitempty" json:"path,omitempty"`
+	Data string `yaml:"data,omitempty" json:"data,omitempty"`
 }
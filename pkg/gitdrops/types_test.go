@@ -0,0 +1,86 @@
+package gitdrops
+
+import (
+	"testing"
+)
+
+func TestPrivilegesAllows(t *testing.T) {
+	yes, no := true, false
+	privileges := Privileges{
+		Create: true,
+		Update: false,
+		Delete: true,
+		Droplets: &ResourcePrivileges{
+			Actions: map[string]bool{"resize": true},
+		},
+		Volumes: &ResourcePrivileges{
+			Update:  &yes,
+			Delete:  &no,
+			Actions: map[string]bool{"detach": false},
+		},
+		Firewalls: &ResourcePrivileges{
+			Actions: map[string]bool{"addRule": true},
+		},
+	}
+	tcases := []struct {
+		name     string
+		resource string
+		action   string
+		expAllow bool
+	}{
+		{
+			name:     "test case 1 - droplet create falls back to top level",
+			resource: DropletResource,
+			action:   CreateAction,
+			expAllow: true,
+		},
+		{
+			name:     "test case 2 - droplet resize allowed by action",
+			resource: DropletResource,
+			action:   "resize",
+			expAllow: true,
+		},
+		{
+			name:     "test case 3 - droplet rebuild falls back to top level update",
+			resource: DropletResource,
+			action:   "rebuild",
+			expAllow: false,
+		},
+		{
+			name:     "test case 4 - volume delete overridden",
+			resource: VolumeResource,
+			action:   DeleteAction,
+			expAllow: false,
+		},
+		{
+			name:     "test case 5 - volume attach falls back to volume update",
+			resource: VolumeResource,
+			action:   "attach",
+			expAllow: true,
+		},
+		{
+			name:     "test case 6 - volume detach denied by action",
+			resource: VolumeResource,
+			action:   "detach",
+			expAllow: false,
+		},
+		{
+			name:     "test case 7 - firewall addRule allowed by action",
+			resource: FirewallResource,
+			action:   "addRule",
+			expAllow: true,
+		},
+		{
+			name:     "test case 8 - firewall removeRule falls back to top level update",
+			resource: FirewallResource,
+			action:   "removeRule",
+			expAllow: false,
+		},
+	}
+	for _, tc := range tcases {
+		allow := privileges.Allows(tc.resource, tc.action)
+		if allow != tc.expAllow {
+			t.Errorf("Failed %v, expected: %v, got %v", tc.name, tc.expAllow, allow)
+		}
+	}
+}
@@ -0,0 +1,500 @@
+package gitdrops
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ValidationError is a single problem found in gitdrops.yaml. Line is the line of the offending
+// field, or of the droplet/volume it belongs to if the field is missing.
+type ValidationError struct {
+	Line    int
+	Message string
+}
+
+func (e ValidationError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Message)
+}
+
+// ValidationErrors is the list of all problems found in gitdrops.yaml, ordered by line.
+type ValidationErrors []ValidationError
+
+func (e ValidationErrors) Error() string {
+	messages := make([]string, 0, len(e))
+	for _, validationError := range e {
+		messages = append(messages, validationError.Error())
+	}
+	return strings.Join(messages, "\n")
+}
+
+// typeErrorRegexp matches the errors reported by yaml.TypeError eg for unknown fields.
+var typeErrorRegexp = regexp.MustCompile(`^line (\d+): (.*)$`)
+
+// stackRegexp matches the stack IDs that can be used in a DO tag. Stacks cannot contain '-', as
+// it separates the stack from the name of the SSH keys and firewalls created by gitdrops.
+var stackRegexp = regexp.MustCompile(`^[a-zA-Z0-9_]{1,64}$`)
+
+// Validate checks the gitdrops.yaml at path without contacting DO. It returns ValidationErrors
+// describing every problem found, or nil if the file is valid.
+func Validate(path string) error {
+	gitdropsYaml, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("Validate: %v", err)
+	}
+	validationErrors := validateGitDrops(gitdropsYaml)
+	if len(validationErrors) != 0 {
+		return validationErrors
+	}
+	return nil
+}
+
+// fieldLines records the line of a droplet or volume and of each of its fields.
+type fieldLines struct {
+	line   int
+	fields map[string]int
+}
+
+func (f fieldLines) of(field string) int {
+	if line, ok := f.fields[field]; ok {
+		return line
+	}
+	return f.line
+}
+
+func validateGitDrops(gitdropsYaml []byte) ValidationErrors {
+	validationErrors := make(ValidationErrors, 0)
+
+	root := yaml.Node{}
+	err := yaml.Unmarshal(gitdropsYaml, &root)
+	if err != nil {
+		return append(validationErrors, yamlError(err)...)
+	}
+
+	gitDrops := GitDrops{}
+	decoder := yaml.NewDecoder(bytes.NewReader(gitdropsYaml))
+	decoder.KnownFields(true)
+	err = decoder.Decode(&gitDrops)
+	if err != nil && !errors.Is(err, io.EOF) {
+		// type errors do not stop decoding, so the remaining checks can still be made
+		validationErrors = append(validationErrors, yamlError(err)...)
+	}
+
+	dropletLines := sequenceLines(&root, "droplets")
+	volumeLines := sequenceLines(&root, "volumes")
+	firewallLines := sequenceLines(&root, "firewalls")
+	domainLines := sequenceLines(&root, "domains")
+	sshKeyLines := sequenceLines(&root, "sshKeys")
+	reservedIPLines := sequenceLines(&root, "reservedIPs")
+	vpcLines := sequenceLines(&root, "vpcs")
+	lineOf := func(lines []fieldLines, i int, field string) int {
+		if i < len(lines) {
+			return lines[i].of(field)
+		}
+		return 0
+	}
+	addError := func(line int, format string, a ...interface{}) {
+		validationErrors = append(validationErrors, ValidationError{Line: line, Message: fmt.Sprintf(format, a...)})
+	}
+
+	if gitDrops.Stack != "" && !stackRegexp.MatchString(gitDrops.Stack) {
+		addError(topLevelLine(&root, "stack"), "stack %q must be at most 64 letters, digits or underscores", gitDrops.Stack)
+	}
+
+	if gitDrops.SnapshotRetention < 0 {
+		addError(topLevelLine(&root, "snapshotRetention"), "snapshotRetention %d must not be negative", gitDrops.SnapshotRetention)
+	}
+
+	privilegesLine := topLevelLine(&root, "privileges")
+	validateActions := func(kind string, resourcePrivileges *ResourcePrivileges, actions []string) {
+		if resourcePrivileges == nil {
+			return
+		}
+		names := make([]string, 0, len(resourcePrivileges.Actions))
+		for name := range resourcePrivileges.Actions {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if len(actions) == 0 {
+				addError(privilegesLine, "privileges: %s: unknown action %q, %s have no update actions", kind, name, kind)
+			} else if !contains(actions, name) {
+				addError(privilegesLine, "privileges: %s: unknown action %q, expected one of %s", kind, name, strings.Join(actions, ", "))
+			}
+		}
+	}
+	validateActions("droplets", gitDrops.Privileges.Droplets, DropletActions)
+	validateActions("volumes", gitDrops.Privileges.Volumes, VolumeActions)
+	validateActions("firewalls", gitDrops.Privileges.Firewalls, FirewallActions)
+	validateActions("domains", gitDrops.Privileges.Domains, DomainActions)
+	validateActions("sshKeys", gitDrops.Privileges.SSHKeys, nil)
+	validateActions("reservedIPs", gitDrops.Privileges.ReservedIPs, ReservedIPActions)
+	validateActions("vpcs", gitDrops.Privileges.VPCs, VPCActions)
+
+	volumesByName := make(map[string]Volume)
+	volumeLineByName := make(map[string]int)
+	for i, volume := range gitDrops.Volumes {
+		line := lineOf(volumeLines, i, "")
+		if volume.Name == "" {
+			addError(line, "volume name not specified")
+		} else if firstLine, ok := volumeLineByName[volume.Name]; ok {
+			addError(lineOf(volumeLines, i, "name"), "volume %q is already declared on line %d", volume.Name, firstLine)
+		} else {
+			volumesByName[volume.Name] = volume
+			volumeLineByName[volume.Name] = line
+		}
+		if volume.Region == "" {
+			addError(line, "volume %q: region not specified", volume.Name)
+		}
+		if volume.SizeGigaBytes == 0 {
+			addError(line, "volume %q: sizeGigaBytes not specified", volume.Name)
+		}
+		for _, field := range volume.IgnoreChanges {
+			if !contains(VolumeFields, field) {
+				addError(lineOf(volumeLines, i, "ignoreChanges"), "volume %q: cannot ignore changes to %q, expected one of %s", volume.Name, field, strings.Join(VolumeFields, ", "))
+			}
+		}
+	}
+
+	dropletLineByName := make(map[string]int)
+	volumeAttachedTo := make(map[string]string)
+	for i, droplet := range gitDrops.Droplets {
+		line := lineOf(dropletLines, i, "")
+		if droplet.Name == "" {
+			addError(line, "droplet name not specified")
+		} else if firstLine, ok := dropletLineByName[droplet.Name]; ok {
+			addError(lineOf(dropletLines, i, "name"), "droplet %q is already declared on line %d", droplet.Name, firstLine)
+		} else {
+			dropletLineByName[droplet.Name] = line
+		}
+		if droplet.Region == "" {
+			addError(line, "droplet %q: region not specified", droplet.Name)
+		}
+		if droplet.Size == "" {
+			addError(line, "droplet %q: size not specified", droplet.Name)
+		}
+		if droplet.Image == "" {
+			addError(line, "droplet %q: image not specified", droplet.Name)
+		}
+		for _, field := range droplet.IgnoreChanges {
+			if !contains(DropletFields, field) {
+				addError(lineOf(dropletLines, i, "ignoreChanges"), "droplet %q: cannot ignore changes to %q, expected one of %s", droplet.Name, field, strings.Join(DropletFields, ", "))
+			}
+		}
+		for _, field := range droplet.ReplaceOnChanges {
+			if !contains(ReplacementFields, field) {
+				addError(lineOf(dropletLines, i, "replaceOnChanges"), "droplet %q: cannot replace on changes to %q, expected one of %s", droplet.Name, field, strings.Join(ReplacementFields, ", "))
+			}
+		}
+		if droplet.PowerState != "" && droplet.PowerState != PowerOn && droplet.PowerState != PowerOff {
+			addError(lineOf(dropletLines, i, "powerState"), "droplet %q: powerState %q must be %q or %q", droplet.Name, droplet.PowerState, PowerOn, PowerOff)
+		}
+		if droplet.CreateBeforeDestroy && len(droplet.Volumes) != 0 {
+			addError(lineOf(dropletLines, i, "createBeforeDestroy"), "droplet %q: createBeforeDestroy cannot be used with volumes, a volume can only be attached to one droplet", droplet.Name)
+		}
+		if droplet.UserData.Path != "" {
+			_, err := os.Stat(droplet.UserData.Path)
+			if err != nil {
+				addError(lineOf(dropletLines, i, "userData"), "droplet %q: userData path %q does not exist", droplet.Name, droplet.UserData.Path)
+			}
+		}
+		for _, volumeName := range droplet.Volumes {
+			volumesLine := lineOf(dropletLines, i, "volumes")
+			volume, ok := volumesByName[volumeName]
+			if !ok {
+				addError(volumesLine, "droplet %q: volume %q is not declared in volumes", droplet.Name, volumeName)
+				continue
+			}
+			if droplet.Region != "" && volume.Region != "" && volume.Region != droplet.Region {
+				addError(volumesLine, "droplet %q: volume %q is in region %q, droplet is in region %q", droplet.Name, volumeName, volume.Region, droplet.Region)
+			}
+			if attachedTo, ok := volumeAttachedTo[volumeName]; ok && attachedTo != droplet.Name {
+				addError(volumesLine, "droplet %q: volume %q is already attached to droplet %q", droplet.Name, volumeName, attachedTo)
+				continue
+			}
+			volumeAttachedTo[volumeName] = droplet.Name
+		}
+	}
+
+	firewallLineByName := make(map[string]int)
+	for i, firewall := range gitDrops.Firewalls {
+		line := lineOf(firewallLines, i, "")
+		if firewall.Name == "" {
+			addError(line, "firewall name not specified")
+		} else if firstLine, ok := firewallLineByName[firewall.Name]; ok {
+			addError(lineOf(firewallLines, i, "name"), "firewall %q is already declared on line %d", firewall.Name, firstLine)
+		} else {
+			firewallLineByName[firewall.Name] = line
+		}
+		if len(firewall.InboundRules) == 0 && len(firewall.OutboundRules) == 0 {
+			addError(line, "firewall %q: no inboundRules or outboundRules specified", firewall.Name)
+		}
+		for _, rule := range firewall.InboundRules {
+			for _, message := range validateFirewallRule(rule) {
+				addError(lineOf(firewallLines, i, "inboundRules"), "firewall %q: inbound rule: %s", firewall.Name, message)
+			}
+		}
+		for _, rule := range firewall.OutboundRules {
+			for _, message := range validateFirewallRule(rule) {
+				addError(lineOf(firewallLines, i, "outboundRules"), "firewall %q: outbound rule: %s", firewall.Name, message)
+			}
+		}
+		for _, dropletName := range firewall.Droplets {
+			if _, ok := dropletLineByName[dropletName]; !ok {
+				addError(lineOf(firewallLines, i, "droplets"), "firewall %q: droplet %q is not declared in droplets", firewall.Name, dropletName)
+			}
+		}
+	}
+
+	domainLineByName := make(map[string]int)
+	for i, domain := range gitDrops.Domains {
+		line := lineOf(domainLines, i, "")
+		if domain.Name == "" {
+			addError(line, "domain name not specified")
+		} else if firstLine, ok := domainLineByName[domain.Name]; ok {
+			addError(lineOf(domainLines, i, "name"), "domain %q is already declared on line %d", domain.Name, firstLine)
+		} else {
+			domainLineByName[domain.Name] = line
+		}
+		for _, record := range domain.Records {
+			for _, message := range validateDomainRecord(record) {
+				addError(lineOf(domainLines, i, "records"), "domain %q: record %q: %s", domain.Name, record.Name, message)
+			}
+			if _, ok := dropletLineByName[record.DropletRef]; record.DropletRef != "" && !ok {
+				addError(lineOf(domainLines, i, "records"), "domain %q: record %q: droplet %q is not declared in droplets", domain.Name, record.Name, record.DropletRef)
+			}
+		}
+	}
+
+	sshKeyLineByName := make(map[string]int)
+	for i, sshKey := range gitDrops.SSHKeys {
+		line := lineOf(sshKeyLines, i, "")
+		if sshKey.Name == "" {
+			addError(line, "sshKey name not specified")
+		} else if firstLine, ok := sshKeyLineByName[sshKey.Name]; ok {
+			addError(lineOf(sshKeyLines, i, "name"), "sshKey %q is already declared on line %d", sshKey.Name, firstLine)
+		} else {
+			sshKeyLineByName[sshKey.Name] = line
+		}
+		switch {
+		case sshKey.PublicKeyFile == "" && sshKey.PublicKey == "":
+			addError(line, "sshKey %q: one of publicKeyFile or publicKey must be specified", sshKey.Name)
+		case sshKey.PublicKeyFile != "" && sshKey.PublicKey != "":
+			addError(lineOf(sshKeyLines, i, "publicKey"), "sshKey %q: publicKeyFile and publicKey cannot both be specified", sshKey.Name)
+		case sshKey.PublicKeyFile != "":
+			publicKey, err := ioutil.ReadFile(sshKey.PublicKeyFile)
+			if err != nil {
+				addError(lineOf(sshKeyLines, i, "publicKeyFile"), "sshKey %q: publicKeyFile %q cannot be read", sshKey.Name, sshKey.PublicKeyFile)
+			} else if _, err := SSHKeyFingerprint(string(publicKey)); err != nil {
+				addError(lineOf(sshKeyLines, i, "publicKeyFile"), "sshKey %q: publicKeyFile %q is not a public key in authorized_keys format", sshKey.Name, sshKey.PublicKeyFile)
+			}
+		default:
+			if _, err := SSHKeyFingerprint(sshKey.PublicKey); err != nil {
+				addError(lineOf(sshKeyLines, i, "publicKey"), "sshKey %q: publicKey is not a public key in authorized_keys format", sshKey.Name)
+			}
+		}
+	}
+	for i, droplet := range gitDrops.Droplets {
+		for _, sshKeyName := range droplet.SSHKeys {
+			if _, ok := sshKeyLineByName[sshKeyName]; !ok {
+				addError(lineOf(dropletLines, i, "sshKeys"), "droplet %q: sshKey %q is not declared in sshKeys", droplet.Name, sshKeyName)
+			}
+		}
+	}
+
+	reservedIPLineByIP := make(map[string]int)
+	reservedIPLineByDroplet := make(map[string]int)
+	for i, reservedIP := range gitDrops.ReservedIPs {
+		line := lineOf(reservedIPLines, i, "")
+		if reservedIP.Region == "" {
+			addError(line, "reservedIP region not specified")
+		}
+		if reservedIP.IP == "" && reservedIP.Droplet == "" {
+			addError(line, "reservedIP: one of droplet or ip must be specified")
+		}
+		if reservedIP.IP != "" {
+			if parsed := net.ParseIP(reservedIP.IP); parsed == nil || parsed.To4() == nil {
+				addError(lineOf(reservedIPLines, i, "ip"), "reservedIP: ip %q is not an IPv4 address", reservedIP.IP)
+			} else if firstLine, ok := reservedIPLineByIP[reservedIP.IP]; ok {
+				addError(lineOf(reservedIPLines, i, "ip"), "reservedIP %q is already declared on line %d", reservedIP.IP, firstLine)
+			} else {
+				reservedIPLineByIP[reservedIP.IP] = line
+			}
+		}
+		if reservedIP.Droplet == "" {
+			continue
+		}
+		if firstLine, ok := reservedIPLineByDroplet[reservedIP.Droplet]; ok {
+			addError(lineOf(reservedIPLines, i, "droplet"), "reservedIP: droplet %q is already assigned a reservedIP on line %d", reservedIP.Droplet, firstLine)
+		} else {
+			reservedIPLineByDroplet[reservedIP.Droplet] = line
+		}
+		if _, ok := dropletLineByName[reservedIP.Droplet]; !ok {
+			addError(lineOf(reservedIPLines, i, "droplet"), "reservedIP: droplet %q is not declared in droplets", reservedIP.Droplet)
+			continue
+		}
+		for _, droplet := range gitDrops.Droplets {
+			if droplet.Name == reservedIP.Droplet && droplet.Region != reservedIP.Region {
+				addError(lineOf(reservedIPLines, i, "droplet"), "reservedIP: droplet %q is in region %q, not %q", reservedIP.Droplet, droplet.Region, reservedIP.Region)
+			}
+		}
+	}
+
+	vpcsByName := make(map[string]VPC)
+	vpcLineByName := make(map[string]int)
+	for i, vpc := range gitDrops.VPCs {
+		line := lineOf(vpcLines, i, "")
+		if vpc.Name == "" {
+			addError(line, "vpc name not specified")
+		} else if firstLine, ok := vpcLineByName[vpc.Name]; ok {
+			addError(lineOf(vpcLines, i, "name"), "vpc %q is already declared on line %d", vpc.Name, firstLine)
+		} else {
+			vpcsByName[vpc.Name] = vpc
+			vpcLineByName[vpc.Name] = line
+		}
+		if vpc.Region == "" {
+			addError(line, "vpc %q: region not specified", vpc.Name)
+		}
+		if _, _, err := net.ParseCIDR(vpc.IPRange); vpc.IPRange != "" && err != nil {
+			addError(lineOf(vpcLines, i, "ipRange"), "vpc %q: ipRange %q is not in CIDR notation", vpc.Name, vpc.IPRange)
+		}
+	}
+	for i, droplet := range gitDrops.Droplets {
+		if droplet.VPC == "" {
+			continue
+		}
+		if droplet.VPCUUID != "" {
+			addError(lineOf(dropletLines, i, "vpc"), "droplet %q: vpc and vpcuuid cannot both be specified", droplet.Name)
+		}
+		vpc, ok := vpcsByName[droplet.VPC]
+		if !ok {
+			addError(lineOf(dropletLines, i, "vpc"), "droplet %q: vpc %q is not declared in vpcs", droplet.Name, droplet.VPC)
+		} else if vpc.Region != droplet.Region {
+			addError(lineOf(dropletLines, i, "vpc"), "droplet %q: vpc %q is in region %q, not %q", droplet.Name, droplet.VPC, vpc.Region, droplet.Region)
+		}
+	}
+
+	sort.SliceStable(validationErrors, func(i, j int) bool {
+		return validationErrors[i].Line < validationErrors[j].Line
+	})
+	return validationErrors
+}
+
+// validateFirewallRule returns the problems found in rule.
+func validateFirewallRule(rule FirewallRule) []string {
+	messages := make([]string, 0)
+	if !contains(FirewallProtocols, rule.Protocol) {
+		messages = append(messages, fmt.Sprintf("protocol %q must be one of %s", rule.Protocol, strings.Join(FirewallProtocols, ", ")))
+	}
+	if rule.Protocol == "icmp" && rule.Ports != "" {
+		messages = append(messages, "ports cannot be specified for protocol icmp")
+	}
+	if (rule.Protocol == "tcp" || rule.Protocol == "udp") && rule.Ports == "" {
+		messages = append(messages, fmt.Sprintf("ports not specified for protocol %s", rule.Protocol))
+	}
+	if len(rule.Addresses) == 0 && len(rule.Tags) == 0 {
+		messages = append(messages, "no addresses or tags specified")
+	}
+	return messages
+}
+
+// validateDomainRecord returns the problems found in record.
+func validateDomainRecord(record DomainRecord) []string {
+	messages := make([]string, 0)
+	if !contains(DomainRecordTypes, record.Type) {
+		messages = append(messages, fmt.Sprintf("type %q must be one of %s", record.Type, strings.Join(DomainRecordTypes, ", ")))
+	}
+	if record.Name == "" {
+		messages = append(messages, "name not specified, use @ for the domain itself")
+	}
+	if record.Data == "" && record.DropletRef == "" {
+		messages = append(messages, "one of data or dropletRef must be specified")
+	}
+	if record.Data != "" && record.DropletRef != "" {
+		messages = append(messages, "data and dropletRef cannot both be specified")
+	}
+	if record.DropletRef != "" && record.Type != "A" && record.Type != "AAAA" {
+		messages = append(messages, fmt.Sprintf("dropletRef cannot be specified for type %s, only for A and AAAA", record.Type))
+	}
+	if record.TTL < 0 {
+		messages = append(messages, fmt.Sprintf("ttl %d must not be negative", record.TTL))
+	}
+	return messages
+}
+
+// yamlError converts an error returned by the yaml decoder into ValidationErrors.
+func yamlError(err error) ValidationErrors {
+	var messages []string
+	var typeError *yaml.TypeError
+	if errors.As(err, &typeError) {
+		messages = typeError.Errors
+	} else {
+		messages = []string{strings.TrimPrefix(err.Error(), "yaml: ")}
+	}
+	validationErrors := make(ValidationErrors, 0, len(messages))
+	for _, message := range messages {
+		validationError := ValidationError{Message: message}
+		if match := typeErrorRegexp.FindStringSubmatch(message); match != nil {
+			validationError.Line, _ = strconv.Atoi(match[1])
+			validationError.Message = match[2]
+		}
+		validationErrors = append(validationErrors, validationError)
+	}
+	return validationErrors
+}
+
+// sequenceLines returns the lines of each item in the top level sequence named key.
+func sequenceLines(root *yaml.Node, key string) []fieldLines {
+	lines := make([]fieldLines, 0)
+	if root.Kind != yaml.DocumentNode || len(root.Content) == 0 {
+		return lines
+	}
+	sequence := mappingValue(root.Content[0], key)
+	if sequence == nil || sequence.Kind != yaml.SequenceNode {
+		return lines
+	}
+	for _, item := range sequence.Content {
+		itemLines := fieldLines{line: item.Line, fields: make(map[string]int)}
+		if item.Kind == yaml.MappingNode {
+			for i := 0; i+1 < len(item.Content); i += 2 {
+				itemLines.fields[item.Content[i].Value] = item.Content[i].Line
+			}
+		}
+		lines = append(lines, itemLines)
+	}
+	return lines
+}
+
+// topLevelLine returns the line of the top level key.
+func topLevelLine(root *yaml.Node, key string) int {
+	if root.Kind != yaml.DocumentNode || len(root.Content) == 0 {
+		return 0
+	}
+	value := mappingValue(root.Content[0], key)
+	if value == nil {
+		return 0
+	}
+	return value.Line
+}
+
+func mappingValue(mapping *yaml.Node, key string) *yaml.Node {
+	if mapping.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1]
+		}
+	}
+	return nil
+}
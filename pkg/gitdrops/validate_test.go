@@ -0,0 +1,358 @@
+package gitdrops
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestValidateGitDrops(t *testing.T) {
+	tcases := []struct {
+		name         string
+		gitdropsYaml string
+		expErrors    ValidationErrors
+	}{
+		{
+			name:         "test case 1 - empty",
+			gitdropsYaml: "",
+			expErrors:    ValidationErrors{},
+		},
+		{
+			name: "test case 2 - valid",
+			gitdropsYaml: `privileges:
+  create: true
+droplets:
+- name: droplet-1
+  region: nyc3
+  size: s-1vcpu-1gb
+  image: centos-8-x64
+  volumes: ["volume-1"]
+volumes:
+- name: volume-1
+  region: nyc3
+  sizeGigaBytes: 100
+`,
+			expErrors: ValidationErrors{},
+		},
+		{
+			name: "test case 3 - unknown field and missing fields",
+			gitdropsYaml: `droplets:
+- name: droplet-1
+  region: nyc3
+  sshKeyFingerprint: ["abc"]
+volumes:
+- region: nyc3
+`,
+			expErrors: ValidationErrors{
+				{Line: 2, Message: `droplet "droplet-1": size not specified`},
+				{Line: 2, Message: `droplet "droplet-1": image not specified`},
+				{Line: 4, Message: "field sshKeyFingerprint not found in type gitdrops.Droplet"},
+				{Line: 6, Message: "volume name not specified"},
+				{Line: 6, Message: `volume "": sizeGigaBytes not specified`},
+			},
+		},
+		{
+			name: "test case 4 - duplicates and volume references",
+			gitdropsYaml: `droplets:
+- name: droplet-1
+  region: nyc3
+  size: s-1vcpu-1gb
+  image: centos-8-x64
+  volumes: ["volume-1", "volume-2"]
+- name: droplet-1
+  region: nyc3
+  size: s-1vcpu-1gb
+  image: centos-8-x64
+  volumes: ["volume-1"]
+  userData:
+    path: does-not-exist
+volumes:
+- name: volume-1
+  region: sfo3
+  sizeGigaBytes: 100
+- name: volume-1
+  region: sfo3
+  sizeGigaBytes: 100
+`,
+			expErrors: ValidationErrors{
+				{Line: 6, Message: `droplet "droplet-1": volume "volume-1" is in region "sfo3", droplet is in region "nyc3"`},
+				{Line: 6, Message: `droplet "droplet-1": volume "volume-2" is not declared in volumes`},
+				{Line: 7, Message: `droplet "droplet-1" is already declared on line 2`},
+				{Line: 11, Message: `droplet "droplet-1": volume "volume-1" is in region "sfo3", droplet is in region "nyc3"`},
+				{Line: 12, Message: `droplet "droplet-1": userData path "does-not-exist" does not exist`},
+				{Line: 18, Message: `volume "volume-1" is already declared on line 15`},
+			},
+		},
+		{
+			name: "test case 5 - volume attached to two droplets",
+			gitdropsYaml: `droplets:
+- name: droplet-1
+  region: nyc3
+  size: s-1vcpu-1gb
+  image: centos-8-x64
+  volumes: ["volume-1"]
+- name: droplet-2
+  region: nyc3
+  size: s-1vcpu-1gb
+  image: centos-8-x64
+  volumes: ["volume-1"]
+volumes:
+- name: volume-1
+  region: nyc3
+  sizeGigaBytes: 100
+`,
+			expErrors: ValidationErrors{
+				{Line: 11, Message: `droplet "droplet-2": volume "volume-1" is already attached to droplet "droplet-1"`},
+			},
+		},
+		{
+			name: "test case 6 - invalid stack",
+			gitdropsYaml: `stack: team:web
+privileges:
+  delete: true
+`,
+			expErrors: ValidationErrors{
+				{Line: 1, Message: `stack "team:web" must be at most 64 letters, digits or underscores`},
+			},
+		},
+		{
+			name: "test case 7 - lifecycle flags",
+			gitdropsYaml: `droplets:
+- name: droplet-1
+  region: nyc3
+  size: s-1vcpu-1gb
+  image: centos-8-x64
+  volumes: ["volume-1"]
+  protect: true
+  ignoreChanges: [image, tags, name]
+  createBeforeDestroy: true
+  replaceOnChanges: [monitoring, size]
+  powerState: suspended
+volumes:
+- name: volume-1
+  region: nyc3
+  sizeGigaBytes: 100
+  ignoreChanges: [size, region, name]
+`,
+			expErrors: ValidationErrors{
+				{Line: 8, Message: `droplet "droplet-1": cannot ignore changes to "name", expected one of size, image, region, backups, ipv6, monitoring, tags, sshKeyFingerprints, powerState`},
+				{Line: 9, Message: `droplet "droplet-1": createBeforeDestroy cannot be used with volumes, a volume can only be attached to one droplet`},
+				{Line: 10, Message: `droplet "droplet-1": cannot replace on changes to "size", expected one of region, monitoring, ipv6, sshKeyFingerprints`},
+				{Line: 11, Message: `droplet "droplet-1": powerState "suspended" must be "on" or "off"`},
+				{Line: 16, Message: `volume "volume-1": cannot ignore changes to "name", expected one of size, tags, region, filesystemType, filesystemLabel, snapShotID`},
+			},
+		},
+		{
+			name:         "test case 8 - syntax error",
+			gitdropsYaml: "droplets:\n- name: [droplet-1\n",
+			expErrors: ValidationErrors{
+				{Line: 1, Message: "did not find expected ',' or ']'"},
+			},
+		},
+		{
+			name: "test case 9 - unknown privileged actions",
+			gitdropsYaml: `privileges:
+  update: true
+  droplets:
+    actions:
+      resize: true
+      attach: false
+  volumes:
+    delete: false
+    actions:
+      rebuild: true
+`,
+			expErrors: ValidationErrors{
+				{Line: 2, Message: `privileges: droplets: unknown action "attach", expected one of resize, rebuild, replace, protect, enableBackups, disableBackups, enableIPv6, tag, untag, powerOn, powerOff`},
+				{Line: 2, Message: `privileges: volumes: unknown action "rebuild", expected one of resize, replace, attach, detach, protect, tag, untag`},
+			},
+		},
+		{
+			name: "test case 10 - negative snapshot retention",
+			gitdropsYaml: `snapshotBeforeDelete: true
+snapshotRetention: -1
+`,
+			expErrors: ValidationErrors{
+				{Line: 2, Message: "snapshotRetention -1 must not be negative"},
+			},
+		},
+		{
+			name: "test case 11 - firewalls",
+			gitdropsYaml: `droplets:
+- name: droplet-1
+  region: nyc3
+  size: s-1vcpu-1gb
+  image: centos-8-x64
+firewalls:
+- name: firewall-1
+  inboundRules:
+  - protocol: tcp
+    ports: "22"
+    addresses: ["0.0.0.0/0"]
+  droplets: ["droplet-1"]
+- name: firewall-1
+  inboundRules:
+  - protocol: icmp
+    ports: "22"
+  outboundRules:
+  - protocol: sctp
+    ports: "all"
+    tags: ["web"]
+  droplets: ["droplet-2"]
+- name: firewall-2
+`,
+			expErrors: ValidationErrors{
+				{Line: 13, Message: `firewall "firewall-1" is already declared on line 7`},
+				{Line: 14, Message: `firewall "firewall-1": inbound rule: ports cannot be specified for protocol icmp`},
+				{Line: 14, Message: `firewall "firewall-1": inbound rule: no addresses or tags specified`},
+				{Line: 17, Message: `firewall "firewall-1": outbound rule: protocol "sctp" must be one of tcp, udp, icmp`},
+				{Line: 21, Message: `firewall "firewall-1": droplet "droplet-2" is not declared in droplets`},
+				{Line: 22, Message: `firewall "firewall-2": no inboundRules or outboundRules specified`},
+			},
+		},
+		{
+			name: "test case 12 - domains",
+			gitdropsYaml: `droplets:
+- name: droplet-1
+  region: nyc3
+  size: s-1vcpu-1gb
+  image: centos-8-x64
+domains:
+- name: example.com
+  records:
+  - type: A
+    name: "@"
+    dropletRef: droplet-1
+  - type: CNAME
+    name: www
+    dropletRef: droplet-1
+  - type: AAAA
+    name: api
+    dropletRef: droplet-2
+- name: example.com
+  records:
+  - type: MX
+    data: mail.example.com.
+    ttl: -1
+`,
+			expErrors: ValidationErrors{
+				{Line: 8, Message: `domain "example.com": record "www": dropletRef cannot be specified for type CNAME, only for A and AAAA`},
+				{Line: 8, Message: `domain "example.com": record "api": droplet "droplet-2" is not declared in droplets`},
+				{Line: 18, Message: `domain "example.com" is already declared on line 7`},
+				{Line: 19, Message: `domain "example.com": record "": type "MX" must be one of A, AAAA, CNAME, TXT`},
+				{Line: 19, Message: `domain "example.com": record "": name not specified, use @ for the domain itself`},
+				{Line: 19, Message: `domain "example.com": record "": ttl -1 must not be negative`},
+			},
+		},
+		{
+			name: "test case 13 - ssh keys",
+			gitdropsYaml: `privileges:
+  sshKeys:
+    actions:
+      resize: true
+droplets:
+- name: droplet-1
+  region: nyc3
+  size: s-1vcpu-1gb
+  image: centos-8-x64
+  sshKeys: ["deploy", "admin", "ci-2"]
+sshKeys:
+- name: deploy
+  publicKey: ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIPwYCqdnmszNZHajGQz1FSzKErFHTloLI58ztprFsCUy user@host
+- name: deploy
+  publicKeyFile: does-not-exist.pub
+- name: ci
+- name: admin
+  publicKey: not-a-key
+`,
+			expErrors: ValidationErrors{
+				{Line: 2, Message: `privileges: sshKeys: unknown action "resize", sshKeys have no update actions`},
+				{Line: 10, Message: `droplet "droplet-1": sshKey "ci-2" is not declared in sshKeys`},
+				{Line: 14, Message: `sshKey "deploy" is already declared on line 12`},
+				{Line: 15, Message: `sshKey "deploy": publicKeyFile "does-not-exist.pub" cannot be read`},
+				{Line: 16, Message: `sshKey "ci": one of publicKeyFile or publicKey must be specified`},
+				{Line: 18, Message: `sshKey "admin": publicKey is not a public key in authorized_keys format`},
+			},
+		},
+		{
+			name: "test case 14 - reserved ips",
+			gitdropsYaml: `droplets:
+- name: droplet-1
+  region: nyc3
+  size: s-1vcpu-1gb
+  image: centos-8-x64
+reservedIPs:
+- region: nyc3
+  droplet: droplet-1
+- region: sfo3
+  droplet: droplet-1
+- region: nyc3
+  ip: 203.0.113.300
+- region: nyc3
+  droplet: droplet-2
+- ip: 203.0.113.1
+- region: nyc3
+`,
+			expErrors: ValidationErrors{
+				{Line: 10, Message: `reservedIP: droplet "droplet-1" is already assigned a reservedIP on line 7`},
+				{Line: 10, Message: `reservedIP: droplet "droplet-1" is in region "nyc3", not "sfo3"`},
+				{Line: 12, Message: `reservedIP: ip "203.0.113.300" is not an IPv4 address`},
+				{Line: 14, Message: `reservedIP: droplet "droplet-2" is not declared in droplets`},
+				{Line: 15, Message: "reservedIP region not specified"},
+				{Line: 16, Message: "reservedIP: one of droplet or ip must be specified"},
+			},
+		},
+		{
+			name: "test case 15 - vpcs",
+			gitdropsYaml: `droplets:
+- name: droplet-1
+  region: nyc3
+  size: s-1vcpu-1gb
+  image: centos-8-x64
+  vpc: vpc-1
+- name: droplet-2
+  region: sfo3
+  size: s-1vcpu-1gb
+  image: centos-8-x64
+  vpc: vpc-1
+  vpcuuid: 5a4981aa-9653-4bd1-bef5-d6bff52042e4
+- name: droplet-3
+  region: nyc3
+  size: s-1vcpu-1gb
+  image: centos-8-x64
+  vpc: vpc-2
+vpcs:
+- name: vpc-1
+  region: nyc3
+  ipRange: 10.10.10.0/24
+- name: vpc-1
+  region: nyc3
+- name: vpc-3
+  ipRange: 10.10.300.0/24
+`,
+			expErrors: ValidationErrors{
+				{Line: 11, Message: `droplet "droplet-2": vpc and vpcuuid cannot both be specified`},
+				{Line: 11, Message: `droplet "droplet-2": vpc "vpc-1" is in region "nyc3", not "sfo3"`},
+				{Line: 17, Message: `droplet "droplet-3": vpc "vpc-2" is not declared in vpcs`},
+				{Line: 22, Message: `vpc "vpc-1" is already declared on line 19`},
+				{Line: 24, Message: `vpc "vpc-3": region not specified`},
+				{Line: 25, Message: `vpc "vpc-3": ipRange "10.10.300.0/24" is not in CIDR notation`},
+			},
+		},
+		{
+			name: "test case 16 - stack with a dash",
+			gitdropsYaml: `stack: prod-eu
+privileges:
+  delete: true
+`,
+			expErrors: ValidationErrors{
+				{Line: 1, Message: `stack "prod-eu" must be at most 64 letters, digits or underscores`},
+			},
+		},
+	}
+	for _, tc := range tcases {
+		validationErrors := validateGitDrops([]byte(tc.gitdropsYaml))
+		if !reflect.DeepEqual(validationErrors, tc.expErrors) {
+			t.Errorf("Failed %v, expected: %v, got %v", tc.name, tc.expErrors, validationErrors)
+		}
+	}
+}
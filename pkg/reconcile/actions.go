@@ -0,0 +1,211 @@
+package reconcile
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/nolancon/gitdrops/pkg/gitdrops"
+)
+
+// dropletID, volumeID, firewallID and vpcID are the DO IDs of droplets, volumes, firewalls and
+// VPCs, and domainName and reservedIPAddress are the name of a domain and the address of a
+// reserved IP, which DO identifies them by. The actions of each kind of object are keyed by these
+// types rather than int and string, so that they cannot be mistaken for those of another kind.
+type dropletID int
+type volumeID string
+type firewallID string
+type domainName string
+type reservedIPAddress string
+type vpcID string
+
+// dropletAction is an action to be taken on a droplet. Only the fields of the action are set,
+// actions without parameters eg powerOn only set Action.
+type dropletAction struct {
+	// Action is the name of the action eg resize, rebuild, tag, powerOn.
+	Action string `json:"action"`
+	// Size is the new size slug of a resize.
+	Size string `json:"size,omitempty"`
+	// Image is the new image slug of a rebuild.
+	Image string `json:"image,omitempty"`
+	// Field is the field of a change that requires replacement, or that causes a replace. Value
+	// is the new value of the field, if it is shown in the plan.
+	Field string `json:"field,omitempty"`
+	Value string `json:"value,omitempty"`
+	// Tag is the tag of a tag or untag action.
+	Tag string `json:"tag,omitempty"`
+	// Protected is whether the droplet is protected or unprotected by a protect action.
+	Protected bool `json:"protected,omitempty"`
+}
+
+// volumeAction is an action to be taken on a volume. Only the fields of the action are set.
+type volumeAction struct {
+	// Action is the name of the action eg resize, replace, tag.
+	Action string `json:"action"`
+	// SizeGigaBytes is the new size of a resize or replace.
+	SizeGigaBytes int64 `json:"sizeGigaBytes,omitempty"`
+	// Field is the field of a change that requires replacement.
+	Field string `json:"field,omitempty"`
+	// Tag is the tag of a tag or untag action.
+	Tag string `json:"tag,omitempty"`
+	// Protected is whether the volume is protected or unprotected by a protect action.
+	Protected bool `json:"protected,omitempty"`
+}
+
+// firewallAction is an action to be taken on a firewall. Only the fields of the action are set.
+type firewallAction struct {
+	// Action is the name of the action eg addRule, removeDroplet, addTag.
+	Action string `json:"action"`
+	// Rule is the rule of an addRule or removeRule action.
+	Rule firewallRule `json:"rule"`
+	// Droplet is the droplet of an addDroplet or removeDroplet action. DropletID is 0 for
+	// droplets that are yet to be created or replaced, the droplet is then found by name when
+	// the action is applied.
+	Droplet   string `json:"droplet,omitempty"`
+	DropletID int    `json:"dropletID,omitempty"`
+	// Tag is the tag of an addTag or removeTag action.
+	Tag string `json:"tag,omitempty"`
+}
+
+// firewallRule is a firewall rule in the form it is compared with the rules of an active
+// firewall. Direction is inbound or outbound.
+type firewallRule struct {
+	Direction string                `json:"direction"`
+	Rule      gitdrops.FirewallRule `json:"rule"`
+}
+
+// domainAction is a createRecord, editRecord or deleteRecord action to be taken on a domain.
+type domainAction struct {
+	// Action is the name of the action.
+	Action string `json:"action"`
+	// ID is the DO ID of the record to edit or delete.
+	ID int `json:"id,omitempty"`
+	// Record is the record to create, edit or delete. Record.Data is empty for records
+	// referencing a droplet that is yet to be created or replaced, the address of the droplet is
+	// then found when the action is applied.
+	Record gitdrops.DomainRecord `json:"record"`
+}
+
+// reservedIPAction is an assign or unassign action to be taken on a reserved IP.
+type reservedIPAction struct {
+	// Action is the name of the action.
+	Action string `json:"action"`
+	// Droplet is the droplet the reserved IP is assigned to or unassigned from. DropletID is 0
+	// for droplets that are yet to be created or replaced, the droplet is then found by name
+	// when the action is applied.
+	Droplet   string `json:"droplet"`
+	DropletID int    `json:"dropletID,omitempty"`
+}
+
+// vpcAction is an action to be taken on a VPC. Only the fields of the action are set.
+type vpcAction struct {
+	// Action is the name of the action, requiresReplacement or updateDescription.
+	Action string `json:"action"`
+	// Field is the field of a change that requires replacement.
+	Field string `json:"field,omitempty"`
+	// Description is the new description of an updateDescription action.
+	Description string `json:"description,omitempty"`
+}
+
+// value returns the value of the action as it is shown in the plan.
+func (a dropletAction) value() string {
+	switch a.Action {
+	case resize:
+		return a.Size
+	case rebuild:
+		return a.Image
+	case replace, requiresReplacement:
+		if a.Value != "" {
+			return a.Value
+		}
+		return a.Field
+	case tag, untag:
+		return a.Tag
+	case protect:
+		return strconv.FormatBool(a.Protected)
+	}
+	return ""
+}
+
+// value returns the value of the action as it is shown in the plan.
+func (a volumeAction) value() string {
+	switch a.Action {
+	case resize, replace:
+		return strconv.FormatInt(a.SizeGigaBytes, 10)
+	case requiresReplacement:
+		return a.Field
+	case tag, untag:
+		return a.Tag
+	case protect:
+		return strconv.FormatBool(a.Protected)
+	}
+	return ""
+}
+
+// value returns the value of the action as it is shown in the plan.
+func (a firewallAction) value() string {
+	switch a.Action {
+	case addRule, removeRule:
+		return a.Rule.String()
+	case addDroplet, removeDroplet:
+		return dropletString(a.Droplet, a.DropletID)
+	case addTag, removeTag:
+		return a.Tag
+	}
+	return ""
+}
+
+// value returns the value of the action as it is shown in the plan.
+func (a domainAction) value() string {
+	return recordString(a.Record)
+}
+
+// value returns the value of the action as it is shown in the plan.
+func (a reservedIPAction) value() string {
+	return dropletString(a.Droplet, a.DropletID)
+}
+
+// value returns the value of the action as it is shown in the plan.
+func (a vpcAction) value() string {
+	switch a.Action {
+	case requiresReplacement:
+		return a.Field
+	case updateDescription:
+		return a.Description
+	}
+	return ""
+}
+
+// String returns the rule as eg "inbound tcp 22 0.0.0.0/0 tag:web".
+func (r firewallRule) String() string {
+	fields := []string{r.Direction, r.Rule.Protocol}
+	if r.Rule.Ports != "" {
+		fields = append(fields, r.Rule.Ports)
+	}
+	fields = append(fields, r.Rule.Addresses...)
+	for _, tag := range r.Rule.Tags {
+		fields = append(fields, "tag:"+tag)
+	}
+	return strings.Join(fields, " ")
+}
+
+// dropletString returns the name of a droplet, or its ID if the name is unknown.
+func dropletString(name string, id int) string {
+	if name != "" {
+		return name
+	}
+	return strconv.Itoa(id)
+}
+
+// recordString returns the record as eg "A www 203.0.113.1 ttl 300".
+func recordString(record gitdrops.DomainRecord) string {
+	fields := []string{record.Type, record.Name}
+	if record.Data != "" {
+		fields = append(fields, record.Data)
+	} else {
+		fields = append(fields, "droplet:"+record.DropletRef)
+	}
+	if record.TTL != 0 {
+		fields = append(fields, "ttl", strconv.Itoa(record.TTL))
+	}
+	return strings.Join(fields, " ")
+}
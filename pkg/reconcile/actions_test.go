@@ -0,0 +1,248 @@
+package reconcile
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/nolancon/gitdrops/pkg/gitdrops"
+)
+
+// testActions holds the actions of each kind of object, as they are held in the steps of a plan.
+type testActions struct {
+	Droplets    map[dropletID][]dropletAction            `json:"droplets"`
+	Volumes     map[volumeID][]volumeAction              `json:"volumes"`
+	Firewalls   map[firewallID][]firewallAction          `json:"firewalls"`
+	Domains     map[domainName][]domainAction            `json:"domains"`
+	ReservedIPs map[reservedIPAddress][]reservedIPAction `json:"reservedIPs"`
+	VPCs        map[vpcID][]vpcAction                    `json:"vpcs"`
+}
+
+func TestActionsJSON(t *testing.T) {
+	tcases := []struct {
+		name    string
+		actions testActions
+	}{
+		{
+			name: "test case 1 - empty",
+			actions: testActions{
+				Droplets: make(map[dropletID][]dropletAction),
+				Volumes:  make(map[volumeID][]volumeAction),
+			},
+		},
+		{
+			name: "test case 2 - droplet actions",
+			actions: testActions{
+				Droplets: map[dropletID][]dropletAction{
+					dropletID(1): {
+						{
+							Action: "resize",
+							Size:   "s-1vcpu-2gb",
+						},
+						{
+							Action: "tag",
+							Tag:    "web",
+						},
+					},
+					dropletID(2): {
+						{
+							Action: "rebuild",
+							Image:  "centos-8-x64",
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "test case 3 - volume actions",
+			actions: testActions{
+				Volumes: map[volumeID][]volumeAction{
+					volumeID("abc"): {
+						{
+							Action:        "resize",
+							SizeGigaBytes: 200,
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "test case 4 - droplet and volume actions with the same ID",
+			actions: testActions{
+				Droplets: map[dropletID][]dropletAction{
+					dropletID(1): {
+						{
+							Action:    "protect",
+							Protected: true,
+						},
+						{
+							Action: "requiresReplacement",
+							Field:  "monitoring",
+						},
+						{
+							Action: "powerOn",
+						},
+					},
+				},
+				Volumes: map[volumeID][]volumeAction{
+					volumeID("1"): {
+						{
+							Action:        "replace",
+							SizeGigaBytes: 50,
+						},
+						{
+							Action: "untag",
+							Tag:    "web",
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "test case 5 - firewall, domain, reserved IP and VPC actions",
+			actions: testActions{
+				Firewalls: map[firewallID][]firewallAction{
+					firewallID("abc"): {
+						{
+							Action: "addRule",
+							Rule:   firewallRule{Direction: "inbound", Rule: gitdrops.FirewallRule{Protocol: "tcp", Ports: "22", Addresses: []string{"0.0.0.0/0"}}},
+						},
+						{
+							Action:    "removeDroplet",
+							Droplet:   "droplet-1",
+							DropletID: 1,
+						},
+					},
+				},
+				Domains: map[domainName][]domainAction{
+					domainName("example.com"): {
+						{
+							Action: "editRecord",
+							ID:     10,
+							Record: gitdrops.DomainRecord{Type: "A", Name: "www", DropletRef: "droplet-1"},
+						},
+					},
+				},
+				ReservedIPs: map[reservedIPAddress][]reservedIPAction{
+					reservedIPAddress("203.0.113.1"): {
+						{
+							Action:  "assign",
+							Droplet: "droplet-1",
+						},
+					},
+				},
+				VPCs: map[vpcID][]vpcAction{
+					vpcID("abc"): {
+						{
+							Action:      "updateDescription",
+							Description: "backend",
+						},
+					},
+				},
+			},
+		},
+	}
+	for _, tc := range tcases {
+		actionsJSON, err := json.Marshal(tc.actions)
+		if err != nil {
+			t.Errorf("Failed %v, unexpected error %v", tc.name, err)
+			continue
+		}
+		decoded := testActions{}
+		err = json.Unmarshal(actionsJSON, &decoded)
+		if err != nil {
+			t.Errorf("Failed %v, unexpected error %v", tc.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(decoded, tc.actions) {
+			t.Errorf("Failed %v, expected: %v, got %v", tc.name, tc.actions, decoded)
+		}
+	}
+}
+
+func TestActionsUnmarshalJSON(t *testing.T) {
+	tcases := []struct {
+		name     string
+		json     string
+		expError bool
+	}{
+		{
+			name:     "test case 1 - valid",
+			json:     `{"volumes":{"abc":[{"action":"resize","sizeGigaBytes":200}]}}`,
+			expError: false,
+		},
+		{
+			name:     "test case 2 - invalid droplet id",
+			json:     `{"droplets":{"abc":[]}}`,
+			expError: true,
+		},
+		{
+			name:     "test case 3 - invalid action field",
+			json:     `{"volumes":{"abc":[{"action":"resize","sizeGigaBytes":"200"}]}}`,
+			expError: true,
+		},
+	}
+	for _, tc := range tcases {
+		decoded := testActions{}
+		err := json.Unmarshal([]byte(tc.json), &decoded)
+		if (err != nil) != tc.expError {
+			t.Errorf("Failed %v, expected error: %v, got %v", tc.name, tc.expError, err)
+		}
+	}
+}
+
+func TestActionValue(t *testing.T) {
+	tcases := []struct {
+		name   string
+		action interface {
+			value() string
+		}
+		expValue string
+	}{
+		{
+			name:     "test case 1 - no parameters",
+			action:   dropletAction{Action: powerOn},
+			expValue: "",
+		},
+		{
+			name:     "test case 2 - volume resize",
+			action:   volumeAction{Action: resize, SizeGigaBytes: 200},
+			expValue: "200",
+		},
+		{
+			name:     "test case 3 - droplet region replacement",
+			action:   dropletAction{Action: replace, Field: "region", Value: "sfo3"},
+			expValue: "sfo3",
+		},
+		{
+			name:     "test case 4 - requires replacement",
+			action:   dropletAction{Action: requiresReplacement, Field: "monitoring"},
+			expValue: "monitoring",
+		},
+		{
+			name: "test case 5 - firewall rule",
+			action: firewallAction{Action: addRule, Rule: firewallRule{Direction: "inbound", Rule: gitdrops.FirewallRule{
+				Protocol:  "tcp",
+				Ports:     "22",
+				Addresses: []string{"0.0.0.0/0"},
+				Tags:      []string{"web"},
+			}}},
+			expValue: "inbound tcp 22 0.0.0.0/0 tag:web",
+		},
+		{
+			name:     "test case 6 - reserved IP assigned to a droplet by ID",
+			action:   reservedIPAction{Action: unassign, DropletID: 1},
+			expValue: "1",
+		},
+		{
+			name:     "test case 7 - domain record",
+			action:   domainAction{Action: createRecord, Record: gitdrops.DomainRecord{Type: "A", Name: "www", DropletRef: "droplet-1", TTL: 300}},
+			expValue: "A www droplet:droplet-1 ttl 300",
+		},
+	}
+	for _, tc := range tcases {
+		if tc.action.value() != tc.expValue {
+			t.Errorf("Failed %v, expected: %v, got %v", tc.name, tc.expValue, tc.action.value())
+		}
+	}
+}
@@ -0,0 +1,362 @@
+package reconcile
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nolancon/gitdrops/pkg/gitdrops"
+
+	"github.com/digitalocean/godo"
+)
+
+const attachmentResource = "attachment"
+
+// attachment is the relationship between a droplet and a volume attached to it. VolumeID is
+// empty for volumes that are yet to be created, the volume is then found by name when the
+// attachment is applied.
+type attachment struct {
+	Droplet   string `json:"droplet"`
+	DropletID int    `json:"dropletID"`
+	Volume    string `json:"volume"`
+	VolumeID  string `json:"volumeID,omitempty"`
+}
+
+// attachmentReconciler reconciles the volumes attached to each droplet declared in
+// gitdrops.yaml. Attachments are planned and applied independently of any other droplet or
+// volume changes. Volumes of droplets to be created or replaced are attached by the droplet
+// reconciler when the droplet is created.
+type attachmentReconciler struct {
+	privileges    gitdrops.Privileges
+	client        *godo.Client
+	actionTimeout time.Duration
+	// droplets is the droplet reconciler, which must be planned first. Droplets it replaces are
+	// not reconciled.
+	droplets         *dropletReconciler
+	gitdropsDroplets []gitdrops.Droplet
+	activeDroplets   []godo.Droplet
+	// mu guards activeVolumes, which are listed again to find volumes created after planning
+	mu                  sync.Mutex
+	activeVolumes       []godo.Volume
+	attachmentsToCreate []attachment
+	attachmentsToDelete []attachment
+}
+
+var _ objectReconciler = &attachmentReconciler{}
+
+// attachmentSteps is the serializable form of the volumes to attach and detach.
+type attachmentSteps struct {
+	Attach []attachment `json:"attach"`
+	Detach []attachment `json:"detach"`
+}
+
+func (ar *attachmentReconciler) getResourceType() string {
+	return attachmentResource
+}
+
+func (ar *attachmentReconciler) setActiveObjects(ctx context.Context) error {
+	activeDroplets, err := gitdrops.ListDroplets(ctx, ar.client)
+	if err != nil {
+		return fmt.Errorf("attachmentReconciler.setActiveObjects: %v", err)
+	}
+	activeVolumes, err := gitdrops.ListVolumes(ctx, ar.client)
+	if err != nil {
+		return fmt.Errorf("attachmentReconciler.setActiveObjects: %v", err)
+	}
+	ar.activeDroplets = activeDroplets
+	ar.activeVolumes = activeVolumes
+	return nil
+}
+
+// setObjectsToUpdateAndCreate populates attachmentReconciler with the volumes to attach to the
+// active droplets declared in gitdrops.yaml, ie the volumes listed in droplets.volumes that are
+// not attached to the droplet.
+func (ar *attachmentReconciler) setObjectsToUpdateAndCreate() error {
+	attachmentsToCreate := make([]attachment, 0)
+	for _, gitdropsDroplet := range ar.gitdropsDroplets {
+		activeDroplet, ok := ar.findActiveDroplet(gitdropsDroplet.Name)
+		if !ok || ar.droplets.replaces(activeDroplet.ID) {
+			continue
+		}
+		for _, volumeName := range gitdropsDroplet.Volumes {
+			volumeID := ar.findVolumeID(volumeName)
+			if volumeID != "" && hasVolume(activeDroplet.VolumeIDs, volumeID) {
+				continue
+			}
+			log.Println("attachmentReconciler.setObjectsToUpdateAndCreate: volume", volumeName, "not attached, attach to droplet", activeDroplet.Name)
+			attachmentsToCreate = append(attachmentsToCreate, attachment{
+				Droplet:   activeDroplet.Name,
+				DropletID: activeDroplet.ID,
+				Volume:    volumeName,
+				VolumeID:  volumeID,
+			})
+		}
+	}
+	ar.attachmentsToCreate = attachmentsToCreate
+	log.Println("attachmentReconciler.setObjectsToUpdateAndCreate: volumes to attach", ar.attachmentsToCreate)
+	return nil
+}
+
+// setObjectsToDelete populates attachmentReconciler with the volumes to detach from the active
+// droplets declared in gitdrops.yaml, ie the attached volumes not listed in droplets.volumes.
+// Volumes are detached from deleted droplets by DO.
+func (ar *attachmentReconciler) setObjectsToDelete() {
+	attachmentsToDelete := make([]attachment, 0)
+	for _, gitdropsDroplet := range ar.gitdropsDroplets {
+		activeDroplet, ok := ar.findActiveDroplet(gitdropsDroplet.Name)
+		if !ok || ar.droplets.replaces(activeDroplet.ID) {
+			continue
+		}
+		for _, volumeID := range activeDroplet.VolumeIDs {
+			volumeName := ar.findVolumeName(volumeID)
+			if hasVolume(gitdropsDroplet.Volumes, volumeName) {
+				continue
+			}
+			log.Println("attachmentReconciler.setObjectsToDelete: volume", volumeID, "to be detached from droplet", activeDroplet.Name)
+			attachmentsToDelete = append(attachmentsToDelete, attachment{
+				Droplet:   activeDroplet.Name,
+				DropletID: activeDroplet.ID,
+				Volume:    volumeName,
+				VolumeID:  volumeID,
+			})
+		}
+	}
+	ar.attachmentsToDelete = attachmentsToDelete
+	log.Println("attachmentReconciler.setObjectsToDelete: volumes to detach", ar.attachmentsToDelete)
+}
+
+func (ar *attachmentReconciler) getChanges() []Change {
+	changes := make([]Change, 0)
+	for _, attachmentToDelete := range ar.attachmentsToDelete {
+		changes = append(changes, attachmentChange(detach, attachmentToDelete))
+	}
+	for _, attachmentToCreate := range ar.attachmentsToCreate {
+		changes = append(changes, attachmentChange(attach, attachmentToCreate))
+	}
+	return changes
+}
+
+// attachmentChange returns the change attaching or detaching a volume. The name and ID are
+// those of the droplet, the value is the volume.
+func attachmentChange(action string, a attachment) Change {
+	value := a.Volume
+	if value == "" {
+		value = a.VolumeID
+	}
+	return Change{
+		Resource: attachmentResource,
+		Action:   action,
+		Name:     a.Droplet,
+		ID:       strconv.Itoa(a.DropletID),
+		Value:    value,
+	}
+}
+
+func (ar *attachmentReconciler) getSteps() interface{} {
+	return attachmentSteps{
+		Attach: ar.attachmentsToCreate,
+		Detach: ar.attachmentsToDelete,
+	}
+}
+
+func (ar *attachmentReconciler) setSteps(stepsJSON json.RawMessage) error {
+	steps := attachmentSteps{}
+	err := unmarshalSteps(stepsJSON, &steps)
+	if err != nil {
+		return fmt.Errorf("attachmentReconciler.setSteps: %v", err)
+	}
+	ar.attachmentsToCreate = steps.Attach
+	ar.attachmentsToDelete = steps.Detach
+	return nil
+}
+
+// getObservedObjects returns no objects, the attachments of an object are part of the
+// fingerprints of droplets and volumes.
+func (ar *attachmentReconciler) getObservedObjects() []observedObject {
+	return []observedObject{}
+}
+
+// countManagedObjects returns 0, attachments are never counted as deletions.
+func (ar *attachmentReconciler) countManagedObjects() int {
+	return 0
+}
+
+func (ar *attachmentReconciler) reconcileObjectsToCreate() []operation {
+	operations := make([]operation, 0)
+	if len(ar.attachmentsToCreate) != 0 {
+		if ar.privileges.Allows(volume, attach) {
+			log.Println("attachmentReconciler.reconcileObjectsToCreate: attach volumes", ar.attachmentsToCreate)
+			operations = ar.attachOperations()
+		} else {
+			log.Println("gitdrops discovered volumes to attach, but does not have attach privileges")
+		}
+	}
+	return operations
+}
+
+// reconcileObjectsToUpdate returns no operations, an attachment is either created or deleted.
+func (ar *attachmentReconciler) reconcileObjectsToUpdate() []operation {
+	return []operation{}
+}
+
+func (ar *attachmentReconciler) reconcileObjectsToDelete() []operation {
+	operations := make([]operation, 0)
+	if len(ar.attachmentsToDelete) != 0 {
+		if ar.privileges.Allows(volume, detach) {
+			log.Println("attachmentReconciler.reconcileObjectsToDelete: detach volumes", ar.attachmentsToDelete)
+			operations = ar.detachOperations()
+		} else {
+			log.Println("gitdrops discovered volumes to detach, but does not have detach privileges")
+		}
+	}
+	return operations
+}
+
+// attachOperations returns an operation per volume to attach. Each operation depends on the
+// creation of the volume, on its detachment from another droplet or the deletion of an
+// undeclared droplet it is attached to, on the volumes detached from the droplet, as DO limits
+// the number of volumes per droplet, and on the droplet update, as DO rejects volume actions
+// while a droplet action is in progress.
+func (ar *attachmentReconciler) attachOperations() []operation {
+	operations := make([]operation, 0)
+	for _, attachmentToCreate := range ar.attachmentsToCreate {
+		attachmentToCreate := attachmentToCreate
+		volIDOrName := attachmentToCreate.VolumeID
+		if volIDOrName == "" {
+			volIDOrName = attachmentToCreate.Volume
+		}
+		dropletID := strconv.Itoa(attachmentToCreate.DropletID)
+		dependsOn := []string{
+			operationKey(volume, create, attachmentToCreate.Volume),
+			operationKey(attachmentResource, detach, volIDOrName),
+			operationKey(droplet, update, dropletID),
+		}
+		for _, attachmentToDelete := range ar.attachmentsToDelete {
+			if attachmentToDelete.DropletID == attachmentToCreate.DropletID {
+				dependsOn = append(dependsOn, operationKey(attachmentResource, detach, attachmentToDelete.VolumeID))
+			}
+		}
+		dependsOn = append(dependsOn, ar.droplets.undeclaredDropletDependencies(attachmentToCreate.VolumeID)...)
+		operations = append(operations, operation{
+			key:       operationKey(attachmentResource, attach, volIDOrName),
+			dependsOn: dependsOn,
+			// DO rejects concurrent actions on the same droplet or volume
+			locks: []string{lockKey(droplet, dropletID), lockKey(volume, volIDOrName)},
+			run: func(ctx context.Context) error {
+				return ar.attachObject(ctx, attachmentToCreate)
+			},
+		})
+	}
+	return operations
+}
+
+func (ar *attachmentReconciler) attachObject(ctx context.Context, attachmentToCreate attachment) error {
+	volumeID := attachmentToCreate.VolumeID
+	if volumeID == "" {
+		// the volume was created after planning
+		var err error
+		volumeID, err = ar.lockedFindVolumeID(ctx, attachmentToCreate.Volume)
+		if err != nil {
+			return fmt.Errorf("attachmentReconciler.attachObject: %v", err)
+		}
+	}
+	action, err := gitdrops.AttachVolume(ctx, ar.client, volumeID, attachmentToCreate.DropletID)
+	if err != nil {
+		return fmt.Errorf("attachmentReconciler.attachObject: %v", err)
+	}
+	err = gitdrops.WaitForAction(ctx, ar.client, action, ar.actionTimeout)
+	if err != nil {
+		return fmt.Errorf("attachmentReconciler.attachObject: %v", err)
+	}
+	return nil
+}
+
+// detachOperations returns an operation per volume to detach, each depending on the droplet
+// update.
+func (ar *attachmentReconciler) detachOperations() []operation {
+	operations := make([]operation, 0)
+	for _, attachmentToDelete := range ar.attachmentsToDelete {
+		attachmentToDelete := attachmentToDelete
+		dropletID := strconv.Itoa(attachmentToDelete.DropletID)
+		operations = append(operations, operation{
+			key:       operationKey(attachmentResource, detach, attachmentToDelete.VolumeID),
+			dependsOn: []string{operationKey(droplet, update, dropletID)},
+			locks:     []string{lockKey(droplet, dropletID), lockKey(volume, attachmentToDelete.VolumeID)},
+			run: func(ctx context.Context) error {
+				return ar.detachObject(ctx, attachmentToDelete)
+			},
+		})
+	}
+	return operations
+}
+
+func (ar *attachmentReconciler) detachObject(ctx context.Context, attachmentToDelete attachment) error {
+	action, err := gitdrops.DetachVolume(ctx, ar.client, attachmentToDelete.VolumeID, attachmentToDelete.DropletID)
+	if err != nil {
+		return fmt.Errorf("attachmentReconciler.detachObject: %v", err)
+	}
+	err = gitdrops.WaitForAction(ctx, ar.client, action, ar.actionTimeout)
+	if err != nil {
+		return fmt.Errorf("attachmentReconciler.detachObject: %v", err)
+	}
+	return nil
+}
+
+// lockedFindVolumeID returns the ID of the volume named name while holding ar.mu, listing the
+// volumes on DO again if it is not yet known.
+func (ar *attachmentReconciler) lockedFindVolumeID(ctx context.Context, name string) (string, error) {
+	ar.mu.Lock()
+	defer ar.mu.Unlock()
+	if volumeID := ar.findVolumeID(name); volumeID != "" {
+		return volumeID, nil
+	}
+	activeVolumes, err := gitdrops.ListVolumes(ctx, ar.client)
+	if err != nil {
+		return "", err
+	}
+	ar.activeVolumes = activeVolumes
+	if volumeID := ar.findVolumeID(name); volumeID != "" {
+		return volumeID, nil
+	}
+	return "", fmt.Errorf("volume %q not found", name)
+}
+
+func (ar *attachmentReconciler) findActiveDroplet(name string) (godo.Droplet, bool) {
+	for _, activeDroplet := range ar.activeDroplets {
+		if activeDroplet.Name == name {
+			return activeDroplet, true
+		}
+	}
+	return godo.Droplet{}, false
+}
+
+func (ar *attachmentReconciler) findVolumeID(name string) string {
+	for _, activeVolume := range ar.activeVolumes {
+		if activeVolume.Name == name {
+			return activeVolume.ID
+		}
+	}
+	return ""
+}
+
+func (ar *attachmentReconciler) findVolumeName(id string) string {
+	for _, activeVolume := range ar.activeVolumes {
+		if activeVolume.ID == id {
+			return activeVolume.Name
+		}
+	}
+	return ""
+}
+
+func hasVolume(volumes []string, volume string) bool {
+	for _, v := range volumes {
+		if v == volume {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,278 @@
+package reconcile
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/nolancon/gitdrops/pkg/gitdrops"
+
+	"github.com/digitalocean/godo"
+)
+
+func newTestAttachmentReconciler(privileges gitdrops.Privileges, droplets *dropletReconciler, activeVolumes []godo.Volume) *attachmentReconciler {
+	return &attachmentReconciler{
+		privileges:       privileges,
+		droplets:         droplets,
+		gitdropsDroplets: droplets.gitdropsDroplets,
+		activeDroplets:   droplets.activeDroplets,
+		activeVolumes:    activeVolumes,
+	}
+}
+
+func TestSetAttachments(t *testing.T) {
+	tcases := []struct {
+		name                string
+		activeDroplets      []godo.Droplet
+		gitdropsDroplets    []gitdrops.Droplet
+		activeVolumes       []godo.Volume
+		attachmentsToCreate []attachment
+		attachmentsToDelete []attachment
+	}{
+		{
+			name: "test case 1 - no change",
+			activeDroplets: []godo.Droplet{
+				{
+					ID:        1,
+					Name:      "droplet-1",
+					VolumeIDs: []string{"abc"},
+				},
+			},
+			gitdropsDroplets: []gitdrops.Droplet{
+				{
+					Name:    "droplet-1",
+					Volumes: []string{"volume-1"},
+				},
+			},
+			activeVolumes: []godo.Volume{
+				{
+					ID:   "abc",
+					Name: "volume-1",
+				},
+			},
+			attachmentsToCreate: []attachment{},
+			attachmentsToDelete: []attachment{},
+		},
+		{
+			name: "test case 2 - attach active and new volumes",
+			activeDroplets: []godo.Droplet{
+				{
+					ID:   1,
+					Name: "droplet-1",
+				},
+			},
+			gitdropsDroplets: []gitdrops.Droplet{
+				{
+					Name:    "droplet-1",
+					Volumes: []string{"volume-1", "volume-2"},
+				},
+			},
+			activeVolumes: []godo.Volume{
+				{
+					ID:   "abc",
+					Name: "volume-1",
+				},
+			},
+			attachmentsToCreate: []attachment{
+				{
+					Droplet:   "droplet-1",
+					DropletID: 1,
+					Volume:    "volume-1",
+					VolumeID:  "abc",
+				},
+				{
+					Droplet:   "droplet-1",
+					DropletID: 1,
+					Volume:    "volume-2",
+				},
+			},
+			attachmentsToDelete: []attachment{},
+		},
+		{
+			name: "test case 3 - move volumes between droplets",
+			activeDroplets: []godo.Droplet{
+				{
+					ID:        1,
+					Name:      "droplet-1",
+					VolumeIDs: []string{"def"},
+				},
+				{
+					ID:        2,
+					Name:      "droplet-2",
+					VolumeIDs: []string{"abc"},
+				},
+			},
+			gitdropsDroplets: []gitdrops.Droplet{
+				{
+					Name:    "droplet-1",
+					Volumes: []string{"volume-1"},
+				},
+				{
+					Name:    "droplet-2",
+					Volumes: []string{"volume-2"},
+				},
+			},
+			activeVolumes: []godo.Volume{
+				{
+					ID:   "abc",
+					Name: "volume-1",
+				},
+				{
+					ID:   "def",
+					Name: "volume-2",
+				},
+			},
+			attachmentsToCreate: []attachment{
+				{
+					Droplet:   "droplet-1",
+					DropletID: 1,
+					Volume:    "volume-1",
+					VolumeID:  "abc",
+				},
+				{
+					Droplet:   "droplet-2",
+					DropletID: 2,
+					Volume:    "volume-2",
+					VolumeID:  "def",
+				},
+			},
+			attachmentsToDelete: []attachment{
+				{
+					Droplet:   "droplet-1",
+					DropletID: 1,
+					Volume:    "volume-2",
+					VolumeID:  "def",
+				},
+				{
+					Droplet:   "droplet-2",
+					DropletID: 2,
+					Volume:    "volume-1",
+					VolumeID:  "abc",
+				},
+			},
+		},
+		{
+			name: "test case 4 - droplets to create and delete are ignored",
+			activeDroplets: []godo.Droplet{
+				{
+					ID:        1,
+					Name:      "droplet-1",
+					VolumeIDs: []string{"abc"},
+				},
+			},
+			gitdropsDroplets: []gitdrops.Droplet{
+				{
+					Name:    "droplet-2",
+					Volumes: []string{"volume-1"},
+				},
+			},
+			activeVolumes: []godo.Volume{
+				{
+					ID:   "abc",
+					Name: "volume-1",
+				},
+			},
+			attachmentsToCreate: []attachment{},
+			attachmentsToDelete: []attachment{},
+		},
+		{
+			name: "test case 5 - replaced droplet is ignored",
+			activeDroplets: []godo.Droplet{
+				{
+					ID:        1,
+					Name:      "droplet-1",
+					Region:    &godo.Region{Slug: "nyc3"},
+					Tags:      testOwnershipTags,
+					VolumeIDs: []string{"abc"},
+				},
+			},
+			gitdropsDroplets: []gitdrops.Droplet{
+				{
+					Name:             "droplet-1",
+					Region:           "sfo3",
+					ReplaceOnChanges: []string{gitdrops.RegionField},
+					Volumes:          []string{"volume-2"},
+				},
+			},
+			activeVolumes: []godo.Volume{
+				{
+					ID:   "abc",
+					Name: "volume-1",
+				},
+			},
+			attachmentsToCreate: []attachment{},
+			attachmentsToDelete: []attachment{},
+		},
+	}
+	for _, tc := range tcases {
+		dr := newTestDropletReconciler(gitdrops.Privileges{}, nil, tc.activeDroplets, tc.gitdropsDroplets, nil)
+		dr.setObjectsToUpdateAndCreate()
+		ar := newTestAttachmentReconciler(gitdrops.Privileges{}, dr, tc.activeVolumes)
+		ar.setObjectsToUpdateAndCreate()
+		ar.setObjectsToDelete()
+		if !reflect.DeepEqual(ar.attachmentsToCreate, tc.attachmentsToCreate) {
+			t.Errorf("AttachmentsToCreate - Failed %v, expected: %v, got %v", tc.name, tc.attachmentsToCreate, ar.attachmentsToCreate)
+		}
+		if !reflect.DeepEqual(ar.attachmentsToDelete, tc.attachmentsToDelete) {
+			t.Errorf("AttachmentsToDelete - Failed %v, expected: %v, got %v", tc.name, tc.attachmentsToDelete, ar.attachmentsToDelete)
+		}
+	}
+}
+
+func TestAttachmentSteps(t *testing.T) {
+	dr := newTestDropletReconciler(gitdrops.Privileges{}, nil, nil, nil, nil)
+	ar := newTestAttachmentReconciler(gitdrops.Privileges{}, dr, nil)
+	ar.attachmentsToCreate = []attachment{
+		{
+			Droplet:   "droplet-1",
+			DropletID: 1,
+			Volume:    "volume-2",
+		},
+	}
+	ar.attachmentsToDelete = []attachment{
+		{
+			Droplet:   "droplet-1",
+			DropletID: 1,
+			Volume:    "volume-1",
+			VolumeID:  "abc",
+		},
+	}
+
+	steps, err := marshalSteps(ar)
+	if err != nil {
+		t.Fatalf("Failed, unexpected error %v", err)
+	}
+	loaded := newTestAttachmentReconciler(gitdrops.Privileges{}, dr, nil)
+	err = loaded.setSteps(steps)
+	if err != nil {
+		t.Fatalf("Failed, unexpected error %v", err)
+	}
+	if !reflect.DeepEqual(loaded.attachmentsToCreate, ar.attachmentsToCreate) {
+		t.Errorf("AttachmentsToCreate - Failed, expected: %v, got %v", ar.attachmentsToCreate, loaded.attachmentsToCreate)
+	}
+	if !reflect.DeepEqual(loaded.attachmentsToDelete, ar.attachmentsToDelete) {
+		t.Errorf("AttachmentsToDelete - Failed, expected: %v, got %v", ar.attachmentsToDelete, loaded.attachmentsToDelete)
+	}
+}
+
+func TestAttachOperations(t *testing.T) {
+	activeDroplets := []godo.Droplet{
+		{ID: 1, Name: "droplet-1", Tags: testOwnershipTags},
+		{ID: 2, Name: "droplet-old", Tags: testOwnershipTags, VolumeIDs: []string{"abc"}},
+	}
+	gitdropsDroplets := []gitdrops.Droplet{
+		{Name: "droplet-1", Volumes: []string{"volume-1"}},
+	}
+	activeVolumes := []godo.Volume{
+		{ID: "abc", Name: "volume-1", DropletIDs: []int{2}},
+	}
+	dr := newTestDropletReconciler(gitdrops.Privileges{}, nil, activeDroplets, gitdropsDroplets, nil)
+	dr.setObjectsToDelete()
+	ar := newTestAttachmentReconciler(gitdrops.Privileges{}, dr, activeVolumes)
+	ar.setObjectsToUpdateAndCreate()
+
+	operations := ar.attachOperations()
+	expDependsOn := operationKey(droplet, remove, "2")
+	if len(operations) != 1 || !hasName(operations[0].dependsOn, expDependsOn) {
+		t.Errorf("DependsOn - Failed, expected: %v, got %v", expDependsOn, operations)
+	}
+}
@@ -0,0 +1,528 @@
+package reconcile
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/nolancon/gitdrops/pkg/gitdrops"
+
+	"github.com/digitalocean/godo"
+)
+
+// domainReconciler reconciles the domains declared in gitdrops.yaml and their A, AAAA, CNAME
+// and TXT records. Other records, such as the NS and SOA records created by DO, are left as they
+// are. Records referencing a droplet track its current address, and are updated once a droplet
+// is created or replaced. DO domains cannot be tagged, so gitdrops cannot tell which domains it
+// created and never deletes a domain.
+type domainReconciler struct {
+	privileges gitdrops.Privileges
+	client     *godo.Client
+	// droplets is the droplet reconciler, which must be planned first. Droplet references are
+	// resolved to the addresses of its active droplets.
+	droplets        *dropletReconciler
+	gitdropsDomains []gitdrops.Domain
+	activeDomains   []godo.Domain
+	// activeRecords are the records of the active domains declared in gitdrops.yaml, keyed by
+	// domain name
+	activeRecords   map[string][]godo.DomainRecord
+	domainsToCreate []gitdrops.Domain
+	domainsToUpdate map[domainName][]domainAction
+	// mu guards appliedDroplets, the droplets listed when applying to find the addresses of
+	// droplets created after planning
+	mu              sync.Mutex
+	appliedDroplets []godo.Droplet
+}
+
+var _ objectReconciler = &domainReconciler{}
+
+// domainSteps is the serializable form of the domains to create and update.
+type domainSteps struct {
+	Create []gitdrops.Domain             `json:"create"`
+	Update map[domainName][]domainAction `json:"update"`
+}
+
+func (dmr *domainReconciler) getResourceType() string {
+	return domain
+}
+
+func (dmr *domainReconciler) setActiveObjects(ctx context.Context) error {
+	activeDomains, err := gitdrops.ListDomains(ctx, dmr.client)
+	if err != nil {
+		return fmt.Errorf("domainReconciler.setActiveObjects: %v", err)
+	}
+	activeRecords := make(map[string][]godo.DomainRecord)
+	for _, activeDomain := range activeDomains {
+		if _, ok := dmr.findGitdropsDomain(activeDomain.Name); !ok {
+			continue
+		}
+		records, err := gitdrops.ListDomainRecords(ctx, dmr.client, activeDomain.Name)
+		if err != nil {
+			return fmt.Errorf("domainReconciler.setActiveObjects: %v", err)
+		}
+		activeRecords[activeDomain.Name] = records
+	}
+	dmr.activeDomains = activeDomains
+	dmr.activeRecords = activeRecords
+	log.Println("domainReconciler.setActiveObjects: active domains", len(dmr.activeDomains))
+	return nil
+}
+
+// setObjectsToUpdateAndCreate populates domainReconciler with the domains declared in
+// gitdrops.yaml that are not active on DO, and with the record actions that bring the active
+// domains in line with gitdrops.yaml.
+func (dmr *domainReconciler) setObjectsToUpdateAndCreate() error {
+	domainsToCreate := make([]gitdrops.Domain, 0)
+	domainActionsByName := make(map[domainName][]domainAction)
+	for _, gitdropsDomain := range dmr.gitdropsDomains {
+		if !dmr.isActive(gitdropsDomain.Name) {
+			domainsToCreate = append(domainsToCreate, gitdropsDomain)
+			continue
+		}
+		domainActions := dmr.getDomainActions(gitdropsDomain, dmr.activeRecords[gitdropsDomain.Name])
+		if len(domainActions) != 0 {
+			domainActionsByName[domainName(gitdropsDomain.Name)] = domainActions
+		}
+	}
+	dmr.domainsToCreate = domainsToCreate
+	dmr.domainsToUpdate = domainActionsByName
+	log.Println("domainReconciler.setObjectsToUpdateAndCreate: domains to create", dmr.domainsToCreate)
+	log.Println("domainReconciler.setObjectsToUpdateAndCreate: domains to update", dmr.domainsToUpdate)
+	return nil
+}
+
+// setObjectsToDelete only logs the active domains not declared in gitdrops.yaml, domains are
+// never deleted.
+func (dmr *domainReconciler) setObjectsToDelete() {
+	for _, activeDomain := range dmr.activeDomains {
+		if _, ok := dmr.findGitdropsDomain(activeDomain.Name); !ok {
+			log.Println("domainReconciler.setObjectsToDelete: domain", activeDomain.Name, "is not declared in gitdrops.yaml, gitdrops does not delete domains")
+		}
+	}
+}
+
+func (dmr *domainReconciler) getChanges() []Change {
+	changes := make([]Change, 0)
+	for _, domainToCreate := range dmr.domainsToCreate {
+		changes = append(changes, Change{Resource: domain, Action: create, Name: domainToCreate.Name})
+		for _, record := range domainToCreate.Records {
+			changes = append(changes, Change{
+				Resource: domain,
+				Action:   createRecord,
+				Name:     domainToCreate.Name,
+				Value:    recordString(record),
+			})
+		}
+	}
+	for _, gitdropsDomain := range dmr.gitdropsDomains {
+		for _, domainAction := range dmr.domainsToUpdate[domainName(gitdropsDomain.Name)] {
+			changes = append(changes, Change{
+				Resource: domain,
+				Action:   domainAction.Action,
+				Name:     gitdropsDomain.Name,
+				Value:    domainAction.value(),
+			})
+		}
+	}
+	return changes
+}
+
+func (dmr *domainReconciler) getSteps() interface{} {
+	return domainSteps{
+		Create: dmr.domainsToCreate,
+		Update: dmr.domainsToUpdate,
+	}
+}
+
+func (dmr *domainReconciler) setSteps(stepsJSON json.RawMessage) error {
+	steps := domainSteps{}
+	err := unmarshalSteps(stepsJSON, &steps)
+	if err != nil {
+		return fmt.Errorf("domainReconciler.setSteps: %v", err)
+	}
+	if steps.Update == nil {
+		steps.Update = make(map[domainName][]domainAction)
+	}
+	dmr.domainsToCreate = steps.Create
+	dmr.domainsToUpdate = steps.Update
+	return nil
+}
+
+// getObservedObjects returns the records of the active domains declared in gitdrops.yaml.
+func (dmr *domainReconciler) getObservedObjects() []observedObject {
+	observedObjects := make([]observedObject, 0)
+	for _, activeDomain := range dmr.activeDomains {
+		records, ok := dmr.activeRecords[activeDomain.Name]
+		if !ok {
+			continue
+		}
+		observed := struct {
+			Name    string              `json:"name"`
+			Records []godo.DomainRecord `json:"records"`
+		}{
+			Name:    activeDomain.Name,
+			Records: records,
+		}
+		observedObjects = append(observedObjects, observedObject{
+			id:       activeDomain.Name,
+			name:     activeDomain.Name,
+			observed: observed,
+		})
+	}
+	return observedObjects
+}
+
+// countManagedObjects returns the number of records of the active domains that gitdrops
+// manages, see isManagedRecord. Domains are never deleted.
+func (dmr *domainReconciler) countManagedObjects() int {
+	managed := 0
+	for _, gitdropsDomain := range dmr.gitdropsDomains {
+		for _, activeRecord := range dmr.activeRecords[gitdropsDomain.Name] {
+			if isManagedRecord(gitdropsDomain, activeRecord) {
+				managed++
+			}
+		}
+	}
+	return managed
+}
+
+// getDomainActions returns the record actions that bring activeRecords in line with
+// gitdropsDomain. A record that only differs in its value or TTL is edited in place, so that
+// the name keeps resolving while a droplet is replaced. Records referencing a droplet that is
+// yet to be created or replaced, or that has no address of the record type yet, are edited or
+// created once the droplet is created. Only records managed by gitdrops are deleted, see
+// isManagedRecord.
+func (dmr *domainReconciler) getDomainActions(gitdropsDomain gitdrops.Domain, activeRecords []godo.DomainRecord) []domainAction {
+	domainActions := make([]domainAction, 0)
+	managedRecords := make([]godo.DomainRecord, 0)
+	for _, activeRecord := range activeRecords {
+		if isManagedRecord(gitdropsDomain, activeRecord) {
+			managedRecords = append(managedRecords, activeRecord)
+		}
+	}
+	matched := make(map[int]bool)
+
+	unmatchedRecords := make([]gitdrops.DomainRecord, 0)
+	for _, gitdropsRecord := range gitdropsDomain.Records {
+		record := dmr.resolveRecord(gitdropsRecord)
+		activeRecord, ok := findRecord(managedRecords, matched, record, true)
+		if !ok {
+			unmatchedRecords = append(unmatchedRecords, record)
+			continue
+		}
+		matched[activeRecord.ID] = true
+		if record.TTL != 0 && record.TTL != activeRecord.TTL {
+			domainActions = append(domainActions, domainAction{Action: editRecord, ID: activeRecord.ID, Record: record})
+		}
+	}
+	for _, record := range unmatchedRecords {
+		activeRecord, ok := findRecord(managedRecords, matched, record, false)
+		if !ok {
+			domainActions = append(domainActions, domainAction{Action: createRecord, Record: record})
+			continue
+		}
+		matched[activeRecord.ID] = true
+		domainActions = append(domainActions, domainAction{Action: editRecord, ID: activeRecord.ID, Record: record})
+	}
+	for _, activeRecord := range managedRecords {
+		if matched[activeRecord.ID] {
+			continue
+		}
+		domainActions = append(domainActions, domainAction{
+			Action: deleteRecord,
+			ID:     activeRecord.ID,
+			Record: gitdrops.DomainRecord{
+				Type: activeRecord.Type,
+				Name: activeRecord.Name,
+				Data: activeRecord.Data,
+				TTL:  activeRecord.TTL,
+			},
+		})
+	}
+	return domainActions
+}
+
+// isManagedRecord returns true if gitdropsDomain declares a record with the type and name of
+// activeRecord. Other records, such as verification TXT records or records created outside of
+// gitdrops, are left as they are.
+func isManagedRecord(gitdropsDomain gitdrops.Domain, activeRecord godo.DomainRecord) bool {
+	for _, record := range gitdropsDomain.Records {
+		if record.Type == activeRecord.Type && record.Name == activeRecord.Name {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveRecord sets the data of a record referencing a droplet to the address of the droplet,
+// if the droplet is active and is not replaced.
+func (dmr *domainReconciler) resolveRecord(record gitdrops.DomainRecord) gitdrops.DomainRecord {
+	if record.DropletRef == "" {
+		return record
+	}
+	for _, activeDroplet := range dmr.droplets.activeDroplets {
+		if activeDroplet.Name == record.DropletRef && !dmr.droplets.replaces(activeDroplet.ID) {
+			record.Data = dropletAddress(activeDroplet, record.Type)
+		}
+	}
+	return record
+}
+
+// findRecord returns the first record of activeRecords that is not matched and has the type and
+// name of record, and if sameData is set, its data.
+func findRecord(activeRecords []godo.DomainRecord, matched map[int]bool, record gitdrops.DomainRecord, sameData bool) (godo.DomainRecord, bool) {
+	for _, activeRecord := range activeRecords {
+		if matched[activeRecord.ID] || activeRecord.Type != record.Type || activeRecord.Name != record.Name {
+			continue
+		}
+		if sameData && (record.Data == "" || strings.TrimSuffix(activeRecord.Data, ".") != strings.TrimSuffix(record.Data, ".")) {
+			continue
+		}
+		return activeRecord, true
+	}
+	return godo.DomainRecord{}, false
+}
+
+func (dmr *domainReconciler) reconcileObjectsToCreate() []operation {
+	operations := make([]operation, 0)
+	if len(dmr.domainsToCreate) != 0 {
+		if dmr.privileges.Allows(domain, create) {
+			log.Println("domainReconciler.reconcileObjectsToCreate: create domains", dmr.domainsToCreate)
+			operations = dmr.createOperations()
+		} else {
+			log.Println("gitdrops discovered domains to create, but does not have create privileges")
+		}
+	}
+	return operations
+}
+
+func (dmr *domainReconciler) reconcileObjectsToUpdate() []operation {
+	operations := make([]operation, 0)
+	if len(dmr.domainsToUpdate) != 0 {
+		log.Println("domainReconciler.reconcileObjectsToUpdate: update domains", dmr.domainsToUpdate)
+		operations = dmr.updateOperations()
+	}
+	return operations
+}
+
+// reconcileObjectsToDelete returns no operations, domains are never deleted.
+func (dmr *domainReconciler) reconcileObjectsToDelete() []operation {
+	return []operation{}
+}
+
+// createOperations returns an operation per domain to create, which also creates its records.
+// Each operation depends on the creation or replacement of the droplets its records reference.
+func (dmr *domainReconciler) createOperations() []operation {
+	operations := make([]operation, 0)
+	for _, domainToCreate := range dmr.domainsToCreate {
+		domainToCreate := domainToCreate
+		dependsOn := make([]string, 0)
+		for _, record := range domainToCreate.Records {
+			if record.DropletRef != "" {
+				dependsOn = append(dependsOn, dmr.dropletDependencies(record.DropletRef)...)
+			}
+		}
+		operations = append(operations, operation{
+			key:       operationKey(domain, create, domainToCreate.Name),
+			dependsOn: dependsOn,
+			run: func(ctx context.Context) error {
+				return dmr.createObject(ctx, domainToCreate)
+			},
+		})
+	}
+	return operations
+}
+
+func (dmr *domainReconciler) createObject(ctx context.Context, domainToCreate gitdrops.Domain) error {
+	err := gitdrops.CreateDomain(ctx, dmr.client, domainToCreate.Name)
+	if err != nil {
+		return fmt.Errorf("domainReconciler.createObject: %v", err)
+	}
+	for _, record := range domainToCreate.Records {
+		recordRequest, err := dmr.lockedRecordRequest(ctx, record)
+		if err != nil {
+			return fmt.Errorf("domainReconciler.createObject: %v", err)
+		}
+		err = gitdrops.CreateDomainRecord(ctx, dmr.client, domainToCreate.Name, recordRequest)
+		if err != nil {
+			return fmt.Errorf("domainReconciler.createObject: %v", err)
+		}
+	}
+	return nil
+}
+
+// updateOperations returns an operation per domain to update, with the actions gitdrops has
+// the privileges for. Records referencing a droplet that is yet to be created or replaced are
+// updated once the droplet is created. Each record deletion is an operation of its own, which
+// requires deleteRecord and delete privileges and is counted by checkMassDelete.
+func (dmr *domainReconciler) updateOperations() []operation {
+	names := make([]string, 0, len(dmr.domainsToUpdate))
+	for name := range dmr.domainsToUpdate {
+		names = append(names, string(name))
+	}
+	sort.Strings(names)
+
+	operations := make([]operation, 0)
+	for _, name := range names {
+		name := name
+		domainActions := make([]domainAction, 0)
+		dependsOn := make([]string, 0)
+		for _, domainAction := range dmr.domainsToUpdate[domainName(name)] {
+			if !dmr.privileges.Allows(domain, domainAction.Action) {
+				log.Printf("gitdrops discovered domain records to %s, but does not have %s privileges", domainAction.Action, domainAction.Action)
+				continue
+			}
+			if domainAction.Action == deleteRecord {
+				if !dmr.privileges.Allows(domain, remove) {
+					log.Println("gitdrops discovered domain records to delete, but does not have deleteRecord and delete privileges")
+					continue
+				}
+				operations = append(operations, dmr.deleteRecordOperation(name, domainAction))
+				continue
+			}
+			domainActions = append(domainActions, domainAction)
+			if domainAction.Record.DropletRef != "" && domainAction.Record.Data == "" {
+				dependsOn = append(dependsOn, dmr.dropletDependencies(domainAction.Record.DropletRef)...)
+			}
+		}
+		if len(domainActions) == 0 {
+			continue
+		}
+		operations = append(operations, operation{
+			key:       operationKey(domain, update, name),
+			dependsOn: dependsOn,
+			locks:     []string{lockKey(domain, name)},
+			run: func(ctx context.Context) error {
+				return dmr.updateObject(ctx, name, domainActions)
+			},
+		})
+	}
+	return operations
+}
+
+// deleteRecordOperation returns the operation deleting a record of domain name. Its key has the
+// deleteRecord action, so that checkMassDelete counts the deletion of the record.
+func (dmr *domainReconciler) deleteRecordOperation(name string, deleteAction domainAction) operation {
+	return operation{
+		key:   operationKey(domain, deleteRecord, name+"/"+strconv.Itoa(deleteAction.ID)),
+		locks: []string{lockKey(domain, name)},
+		run: func(ctx context.Context) error {
+			return dmr.updateObject(ctx, name, []domainAction{deleteAction})
+		},
+	}
+}
+
+// dropletDependencies returns the keys of the operations creating, replacing or updating the
+// droplet named dropletName, after which its addresses are known.
+func (dmr *domainReconciler) dropletDependencies(dropletName string) []string {
+	dependsOn := []string{operationKey(droplet, create, dropletName)}
+	for _, activeDroplet := range dmr.droplets.activeDroplets {
+		if activeDroplet.Name == dropletName {
+			dependsOn = append(dependsOn, operationKey(droplet, update, strconv.Itoa(activeDroplet.ID)), operationKey(droplet, replace, strconv.Itoa(activeDroplet.ID)))
+		}
+	}
+	return dependsOn
+}
+
+func (dmr *domainReconciler) updateObject(ctx context.Context, name string, domainActions []domainAction) error {
+	for _, domainAction := range domainActions {
+		var err error
+		switch domainAction.Action {
+		case createRecord, editRecord:
+			var recordRequest *godo.DomainRecordEditRequest
+			recordRequest, err = dmr.lockedRecordRequest(ctx, domainAction.Record)
+			if err != nil {
+				break
+			}
+			if domainAction.Action == createRecord {
+				err = gitdrops.CreateDomainRecord(ctx, dmr.client, name, recordRequest)
+			} else {
+				err = gitdrops.EditDomainRecord(ctx, dmr.client, name, domainAction.ID, recordRequest)
+			}
+		case deleteRecord:
+			err = gitdrops.DeleteDomainRecord(ctx, dmr.client, name, domainAction.ID)
+		default:
+			err = fmt.Errorf("unknown action %q", domainAction.Action)
+		}
+		if err != nil {
+			return fmt.Errorf("domainReconciler.updateObject: %v", err)
+		}
+	}
+	return nil
+}
+
+// lockedRecordRequest translates record while holding dmr.mu. The address of a droplet created,
+// replaced or updated after planning is found by listing the droplets on DO again.
+func (dmr *domainReconciler) lockedRecordRequest(ctx context.Context, record gitdrops.DomainRecord) (*godo.DomainRecordEditRequest, error) {
+	recordRequest := &godo.DomainRecordEditRequest{
+		Type: record.Type,
+		Name: record.Name,
+		Data: record.Data,
+		TTL:  record.TTL,
+	}
+	if recordRequest.Data != "" {
+		return recordRequest, nil
+	}
+	dmr.mu.Lock()
+	defer dmr.mu.Unlock()
+	if address := dmr.findAppliedAddress(record); address != "" {
+		recordRequest.Data = address
+		return recordRequest, nil
+	}
+	appliedDroplets, err := gitdrops.ListDroplets(ctx, dmr.client)
+	if err != nil {
+		return nil, err
+	}
+	dmr.appliedDroplets = appliedDroplets
+	if address := dmr.findAppliedAddress(record); address != "" {
+		recordRequest.Data = address
+		return recordRequest, nil
+	}
+	return nil, fmt.Errorf("droplet %q not found or has no %s address", record.DropletRef, record.Type)
+}
+
+// findAppliedAddress returns the address of the droplet referenced by record, ignoring droplets
+// replaced by the droplet reconciler.
+func (dmr *domainReconciler) findAppliedAddress(record gitdrops.DomainRecord) string {
+	for _, appliedDroplet := range dmr.appliedDroplets {
+		if appliedDroplet.Name == record.DropletRef && !dmr.droplets.replaces(appliedDroplet.ID) {
+			return dropletAddress(appliedDroplet, record.Type)
+		}
+	}
+	return ""
+}
+
+// dropletAddress returns the public IPv6 address of activeDroplet for AAAA records, and its
+// public IPv4 address otherwise.
+func dropletAddress(activeDroplet godo.Droplet, recordType string) string {
+	var address string
+	if recordType == "AAAA" {
+		address, _ = activeDroplet.PublicIPv6()
+	} else {
+		address, _ = activeDroplet.PublicIPv4()
+	}
+	return address
+}
+
+func (dmr *domainReconciler) isActive(name string) bool {
+	for _, activeDomain := range dmr.activeDomains {
+		if activeDomain.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (dmr *domainReconciler) findGitdropsDomain(name string) (gitdrops.Domain, bool) {
+	for _, gitdropsDomain := range dmr.gitdropsDomains {
+		if gitdropsDomain.Name == name {
+			return gitdropsDomain, true
+		}
+	}
+	return gitdrops.Domain{}, false
+}
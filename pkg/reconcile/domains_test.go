@@ -0,0 +1,300 @@
+package reconcile
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/nolancon/gitdrops/pkg/gitdrops"
+
+	"github.com/digitalocean/godo"
+)
+
+func newTestDomainReconciler(privileges gitdrops.Privileges, droplets *dropletReconciler, activeDomains []godo.Domain, activeRecords map[string][]godo.DomainRecord, gitdropsDomains []gitdrops.Domain) *domainReconciler {
+	return &domainReconciler{
+		privileges:      privileges,
+		droplets:        droplets,
+		activeDomains:   activeDomains,
+		activeRecords:   activeRecords,
+		gitdropsDomains: gitdropsDomains,
+	}
+}
+
+func TestSetDomainsToUpdateCreate(t *testing.T) {
+	networks := &godo.Networks{
+		V4: []godo.NetworkV4{{IPAddress: "203.0.113.1", Type: "public"}},
+		V6: []godo.NetworkV6{{IPAddress: "2001:db8::1", Type: "public"}},
+	}
+	nsRecord := godo.DomainRecord{ID: 1, Type: "NS", Name: "@", Data: "ns1.digitalocean.com", TTL: 1800}
+	tcases := []struct {
+		name             string
+		activeDroplets   []godo.Droplet
+		gitdropsDroplets []gitdrops.Droplet
+		activeDomains    []godo.Domain
+		activeRecords    map[string][]godo.DomainRecord
+		gitdropsDomains  []gitdrops.Domain
+		domainsToCreate  []gitdrops.Domain
+		domainsToUpdate  map[domainName][]domainAction
+	}{
+		{
+			name: "test case 1 - create domain",
+			gitdropsDomains: []gitdrops.Domain{
+				{
+					Name:    "example.com",
+					Records: []gitdrops.DomainRecord{{Type: "A", Name: "@", DropletRef: "droplet-1"}},
+				},
+			},
+			domainsToCreate: []gitdrops.Domain{
+				{
+					Name:    "example.com",
+					Records: []gitdrops.DomainRecord{{Type: "A", Name: "@", DropletRef: "droplet-1"}},
+				},
+			},
+			domainsToUpdate: map[domainName][]domainAction{},
+		},
+		{
+			name: "test case 2 - no change",
+			activeDroplets: []godo.Droplet{
+				{
+					ID:       1,
+					Name:     "droplet-1",
+					Networks: networks,
+				},
+			},
+			gitdropsDroplets: []gitdrops.Droplet{
+				{
+					Name: "droplet-1",
+				},
+			},
+			activeDomains: []godo.Domain{{Name: "example.com"}},
+			activeRecords: map[string][]godo.DomainRecord{
+				"example.com": {
+					nsRecord,
+					{ID: 2, Type: "A", Name: "@", Data: "203.0.113.1", TTL: 1800},
+					{ID: 3, Type: "AAAA", Name: "@", Data: "2001:db8::1", TTL: 1800},
+					{ID: 4, Type: "CNAME", Name: "www", Data: "@", TTL: 300},
+				},
+			},
+			gitdropsDomains: []gitdrops.Domain{
+				{
+					Name: "example.com",
+					Records: []gitdrops.DomainRecord{
+						{Type: "A", Name: "@", DropletRef: "droplet-1"},
+						{Type: "AAAA", Name: "@", DropletRef: "droplet-1"},
+						{Type: "CNAME", Name: "www", Data: "@", TTL: 300},
+					},
+				},
+			},
+			domainsToCreate: []gitdrops.Domain{},
+			domainsToUpdate: map[domainName][]domainAction{},
+		},
+		{
+			name: "test case 3 - edit, create and delete records, undeclared records are kept",
+			activeDroplets: []godo.Droplet{
+				{
+					ID:       1,
+					Name:     "droplet-1",
+					Networks: networks,
+				},
+			},
+			gitdropsDroplets: []gitdrops.Droplet{
+				{
+					Name: "droplet-1",
+				},
+				{
+					Name: "droplet-2",
+				},
+			},
+			activeDomains: []godo.Domain{{Name: "example.com"}},
+			activeRecords: map[string][]godo.DomainRecord{
+				"example.com": {
+					nsRecord,
+					{ID: 2, Type: "A", Name: "@", Data: "198.51.100.1", TTL: 1800},
+					{ID: 3, Type: "TXT", Name: "@", Data: "v=spf1 -all", TTL: 1800},
+					{ID: 4, Type: "CNAME", Name: "www", Data: "@", TTL: 1800},
+					{ID: 5, Type: "A", Name: "@", Data: "198.51.100.2", TTL: 1800},
+					{ID: 6, Type: "A", Name: "mail", Data: "198.51.100.3", TTL: 1800},
+				},
+			},
+			gitdropsDomains: []gitdrops.Domain{
+				{
+					Name: "example.com",
+					Records: []gitdrops.DomainRecord{
+						{Type: "A", Name: "@", DropletRef: "droplet-1"},
+						{Type: "A", Name: "api", DropletRef: "droplet-2"},
+						{Type: "CNAME", Name: "www", Data: "@", TTL: 300},
+					},
+				},
+			},
+			domainsToCreate: []gitdrops.Domain{},
+			domainsToUpdate: map[domainName][]domainAction{
+				domainName("example.com"): []domainAction{
+					{
+						Action: editRecord,
+						ID:     4,
+						Record: gitdrops.DomainRecord{Type: "CNAME", Name: "www", Data: "@", TTL: 300},
+					},
+					{
+						Action: editRecord,
+						ID:     2,
+						Record: gitdrops.DomainRecord{Type: "A", Name: "@", Data: "203.0.113.1", DropletRef: "droplet-1"},
+					},
+					{
+						Action: createRecord,
+						Record: gitdrops.DomainRecord{Type: "A", Name: "api", DropletRef: "droplet-2"},
+					},
+					{
+						Action: deleteRecord,
+						ID:     5,
+						Record: gitdrops.DomainRecord{Type: "A", Name: "@", Data: "198.51.100.2", TTL: 1800},
+					},
+				},
+			},
+		},
+		{
+			name: "test case 4 - replaced droplet is resolved when applied",
+			activeDroplets: []godo.Droplet{
+				{
+					ID:       1,
+					Name:     "droplet-1",
+					Region:   &godo.Region{Slug: "nyc3"},
+					Tags:     testOwnershipTags,
+					Networks: networks,
+				},
+			},
+			gitdropsDroplets: []gitdrops.Droplet{
+				{
+					Name:             "droplet-1",
+					Region:           "sfo3",
+					ReplaceOnChanges: []string{gitdrops.RegionField},
+				},
+			},
+			activeDomains: []godo.Domain{{Name: "example.com"}},
+			activeRecords: map[string][]godo.DomainRecord{
+				"example.com": {
+					{ID: 2, Type: "A", Name: "@", Data: "203.0.113.1", TTL: 1800},
+				},
+			},
+			gitdropsDomains: []gitdrops.Domain{
+				{
+					Name:    "example.com",
+					Records: []gitdrops.DomainRecord{{Type: "A", Name: "@", DropletRef: "droplet-1"}},
+				},
+			},
+			domainsToCreate: []gitdrops.Domain{},
+			domainsToUpdate: map[domainName][]domainAction{
+				domainName("example.com"): []domainAction{
+					{
+						Action: editRecord,
+						ID:     2,
+						Record: gitdrops.DomainRecord{Type: "A", Name: "@", DropletRef: "droplet-1"},
+					},
+				},
+			},
+		},
+	}
+	for _, tc := range tcases {
+		dr := newTestDropletReconciler(gitdrops.Privileges{}, nil, tc.activeDroplets, tc.gitdropsDroplets, nil)
+		dr.setObjectsToUpdateAndCreate()
+		dmr := newTestDomainReconciler(gitdrops.Privileges{}, dr, tc.activeDomains, tc.activeRecords, tc.gitdropsDomains)
+		dmr.setObjectsToUpdateAndCreate()
+		if !reflect.DeepEqual(dmr.domainsToCreate, tc.domainsToCreate) {
+			t.Errorf("DomainsToCreate - Failed %v, expected: %v, got %v", tc.name, tc.domainsToCreate, dmr.domainsToCreate)
+		}
+		if !reflect.DeepEqual(dmr.domainsToUpdate, tc.domainsToUpdate) {
+			t.Errorf("DomainsToUpdate - Failed %v, expected: %v, got %v", tc.name, tc.domainsToUpdate, dmr.domainsToUpdate)
+		}
+	}
+}
+
+func TestDomainSteps(t *testing.T) {
+	dr := newTestDropletReconciler(gitdrops.Privileges{}, nil, nil, nil, nil)
+	dmr := newTestDomainReconciler(gitdrops.Privileges{}, dr, nil, nil, nil)
+	dmr.domainsToCreate = []gitdrops.Domain{
+		{
+			Name:    "example.org",
+			Records: []gitdrops.DomainRecord{{Type: "AAAA", Name: "@", DropletRef: "droplet-1", TTL: 300}},
+		},
+	}
+	dmr.domainsToUpdate = map[domainName][]domainAction{
+		domainName("example.com"): []domainAction{
+			{
+				Action: editRecord,
+				ID:     2,
+				Record: gitdrops.DomainRecord{Type: "A", Name: "@", DropletRef: "droplet-1"},
+			},
+			{
+				Action: deleteRecord,
+				ID:     3,
+				Record: gitdrops.DomainRecord{Type: "TXT", Name: "@", Data: "v=spf1 -all"},
+			},
+		},
+	}
+
+	steps, err := marshalSteps(dmr)
+	if err != nil {
+		t.Fatalf("Failed, unexpected error %v", err)
+	}
+	loaded := newTestDomainReconciler(gitdrops.Privileges{}, dr, nil, nil, nil)
+	err = loaded.setSteps(steps)
+	if err != nil {
+		t.Fatalf("Failed, unexpected error %v", err)
+	}
+	if !reflect.DeepEqual(loaded.domainsToCreate, dmr.domainsToCreate) {
+		t.Errorf("DomainsToCreate - Failed, expected: %v, got %v", dmr.domainsToCreate, loaded.domainsToCreate)
+	}
+	if !reflect.DeepEqual(loaded.domainsToUpdate, dmr.domainsToUpdate) {
+		t.Errorf("DomainsToUpdate - Failed, expected: %v, got %v", dmr.domainsToUpdate, loaded.domainsToUpdate)
+	}
+}
+
+func TestDomainUpdateOperations(t *testing.T) {
+	yes, no := true, false
+	domainActions := map[domainName][]domainAction{
+		domainName("example.com"): []domainAction{
+			{
+				Action: createRecord,
+				Record: gitdrops.DomainRecord{Type: "A", Name: "api", Data: "203.0.113.1"},
+			},
+			{
+				Action: deleteRecord,
+				ID:     3,
+				Record: gitdrops.DomainRecord{Type: "A", Name: "@", Data: "198.51.100.2"},
+			},
+		},
+	}
+	tcases := []struct {
+		name       string
+		privileges gitdrops.Privileges
+		expKeys    []string
+	}{
+		{
+			name:       "test case 1 - update and delete privileges",
+			privileges: gitdrops.Privileges{Update: true, Delete: true},
+			expKeys:    []string{"domain/deleteRecord/example.com/3", "domain/update/example.com"},
+		},
+		{
+			name:       "test case 2 - update privileges do not delete records",
+			privileges: gitdrops.Privileges{Update: true},
+			expKeys:    []string{"domain/update/example.com"},
+		},
+		{
+			name:       "test case 3 - domain delete privileges",
+			privileges: gitdrops.Privileges{Update: true, Domains: &gitdrops.ResourcePrivileges{Delete: &yes}},
+			expKeys:    []string{"domain/deleteRecord/example.com/3", "domain/update/example.com"},
+		},
+		{
+			name:       "test case 4 - no domain delete privileges",
+			privileges: gitdrops.Privileges{Update: true, Delete: true, Domains: &gitdrops.ResourcePrivileges{Delete: &no}},
+			expKeys:    []string{"domain/update/example.com"},
+		},
+	}
+	for _, tc := range tcases {
+		dr := newTestDropletReconciler(gitdrops.Privileges{}, nil, nil, nil, nil)
+		dmr := newTestDomainReconciler(tc.privileges, dr, nil, nil, nil)
+		dmr.domainsToUpdate = domainActions
+		keys := operationKeys(dmr.updateOperations())
+		if !reflect.DeepEqual(keys, tc.expKeys) {
+			t.Errorf("Failed %v, expected: %v, got %v", tc.name, tc.expKeys, keys)
+		}
+	}
+}
@@ -2,9 +2,14 @@ package reconcile
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/nolancon/gitdrops/pkg/gitdrops"
 
@@ -21,62 +26,69 @@ const (
 type dropletReconciler struct {
 	privileges       gitdrops.Privileges
 	client           *godo.Client
+	actionTimeout    time.Duration
+	shutdownTimeout  time.Duration
 	activeDroplets   []godo.Droplet
 	gitdropsDroplets []gitdrops.Droplet
 	dropletsToCreate []gitdrops.Droplet
-	dropletsToUpdate actionsByID
+	dropletsToUpdate map[dropletID][]dropletAction
 	dropletsToDelete []int
-	volumeNameToID   map[string]string
+	// ownershipTags are applied to created droplets, only droplets with these tags are deleted
+	ownershipTags  []string
+	snapshotPolicy snapshotPolicy
+	// mu guards volumeNameToID and vpcNameToID, which createObject refreshes while other
+	// operations run
+	mu             sync.Mutex
+	volumeNameToID map[string]string
+	vpcNameToID    map[string]string
 }
 
 var _ objectReconciler = &dropletReconciler{}
 
-func (dr *dropletReconciler) reconcileObjectsToCreate(ctx context.Context) error {
+// dropletSteps is the serializable form of the droplets to create, update and delete.
+type dropletSteps struct {
+	Create []gitdrops.Droplet            `json:"create"`
+	Update map[dropletID][]dropletAction `json:"update"`
+	Delete []int                         `json:"delete"`
+}
+
+func (dr *dropletReconciler) getResourceType() string {
+	return droplet
+}
+
+func (dr *dropletReconciler) reconcileObjectsToCreate() []operation {
+	operations := make([]operation, 0)
 	if len(dr.dropletsToCreate) != 0 {
-		if dr.privileges.Create {
+		if dr.privileges.Allows(droplet, create) {
 			log.Println("dropletReconciler.reconcileObjectsToCreate: create droplet", dr.dropletsToCreate)
-			err := dr.createObjects(ctx)
-			if err != nil {
-				return fmt.Errorf("dropletReconciler.reconcile: %v", err)
-			}
+			operations = dr.createOperations()
 		} else {
 			log.Println("gitdrops has discovered droplets to create, but does not have create privileges")
 		}
 	}
-	return nil
+	return operations
 }
 
-func (dr *dropletReconciler) reconcileObjectsToUpdate(ctx context.Context, outsideActions actionsByID) error {
+func (dr *dropletReconciler) reconcileObjectsToUpdate() []operation {
+	operations := make([]operation, 0)
 	if len(dr.dropletsToUpdate) != 0 {
-		if len(outsideActions) != 0 {
-			dr.dropletsToUpdate = outsideActions
-		}
-		if dr.privileges.Update {
-			log.Println("dropletReconciler.reconcileObjectsToUpdate: update droplet", dr.dropletsToUpdate)
-			err := dr.updateObjects(ctx)
-			if err != nil {
-				return fmt.Errorf("dropletReconciler.reconcile: %v", err)
-			}
-		} else {
-			log.Println("gitdrops has discovered droplets to update, but does not have update privileges")
-		}
+		log.Println("dropletReconciler.reconcileObjectsToUpdate: update droplet", dr.dropletsToUpdate)
+		operations = dr.updateOperations()
 	}
-	return nil
+	return operations
 }
 
-func (dr *dropletReconciler) reconcileObjectsToDelete(ctx context.Context) error {
+func (dr *dropletReconciler) reconcileObjectsToDelete() []operation {
+	operations := make([]operation, 0)
 	if len(dr.dropletsToDelete) != 0 {
-		if dr.privileges.Delete {
+		if dr.privileges.Allows(droplet, remove) {
 			log.Println("dropletReconciler.reconcileObjectsToDelete: delete droplet", dr.dropletsToDelete)
-			err := dr.deleteObjects(ctx)
-			if err != nil {
-				return fmt.Errorf("dropletReconciler.reconcile: %v", err)
-			}
+			operations = dr.deleteOperations()
 		} else {
 			log.Println("gitdrops has discovered droplets to delete, but does not have delete privileges")
 		}
 	}
-	return nil
+	return operations
 }
 
 func (dr *dropletReconciler) setActiveObjects(ctx context.Context) error {
@@ -86,44 +98,73 @@ func (dr *dropletReconciler) setActiveObjects(ctx context.Context) error {
 	}
 	dr.activeDroplets = activeDroplets
 
-	activeVolumes, err := gitdrops.ListVolumes(ctx, dr.client)
+	err = dr.setVolumeNameToID(ctx)
+	if err != nil {
+		return fmt.Errorf("dropletReconciler.setActiveObjects: %v", err)
+	}
+	err = dr.setVPCNameToID(ctx)
 	if err != nil {
 		return fmt.Errorf("dropletReconciler.setActiveObjects: %v", err)
 	}
+	log.Println("dropletReconciler.setActiveObjects: active droplets", len(dr.activeDroplets))
+	return nil
+}
+
+func (dr *dropletReconciler) setVolumeNameToID(ctx context.Context) error {
+	activeVolumes, err := gitdrops.ListVolumes(ctx, dr.client)
+	if err != nil {
+		return fmt.Errorf("dropletReconciler.setVolumeNameToID: %v", err)
+	}
 
 	volumeNameToID := make(map[string]string)
 	for _, activeVolume := range activeVolumes {
 		volumeNameToID[activeVolume.Name] = activeVolume.ID
 	}
 	dr.volumeNameToID = volumeNameToID
-	log.Println("dropletReconciler.setActiveObjects: active droplets", len(dr.activeDroplets))
+	return nil
+}
+
+func (dr *dropletReconciler) setVPCNameToID(ctx context.Context) error {
+	activeVPCs, err := gitdrops.ListVPCs(ctx, dr.client)
+	if err != nil {
+		return fmt.Errorf("dropletReconciler.setVPCNameToID: %v", err)
+	}
+
+	vpcNameToID := make(map[string]string)
+	for _, activeVPC := range activeVPCs {
+		vpcNameToID[activeVPC.Name] = activeVPC.ID
+	}
+	dr.vpcNameToID = vpcNameToID
 	return nil
 }
 
 // dropletsToUpdateCreate poulates DropletReconciler with two lists:
-// * dropletsToUpdate: dropletActionsByID of droplets that are active on DO and are defined in
+// * dropletsToUpdate: the actions to take on droplets that are active on DO and are defined in
 // gitdrops.yaml, but the active droplets are no longer in sync with the local gitdrops version.
 // * dropletsToCreate: Droplets of droplets defined in gitdrops.yaml that are NOT
 // active on DO and therefore should be created.
-func (dr *dropletReconciler) setObjectsToUpdateAndCreate() {
+func (dr *dropletReconciler) setObjectsToUpdateAndCreate() error {
 	dropletsToCreate := make([]gitdrops.Droplet, 0)
-	dropletActionsByID := make(actionsByID)
+	dropletActionsByID := make(map[dropletID][]dropletAction)
 	for _, gitdropsDroplet := range dr.gitdropsDroplets {
 		dropletIsActive := false
 		for _, activeDroplet := range dr.activeDroplets {
 			if gitdropsDroplet.Name == activeDroplet.Name {
 				// droplet already exists, check for change in request
-				dropletActions := getDropletActions(gitdropsDroplet, activeDroplet)
-				dropletActions = append(dropletActions, dr.volumesToDetach(activeDroplet, gitdropsDroplet)...)
-				dropletActions = append(dropletActions, dr.volumesToAttach(activeDroplet, gitdropsDroplet)...)
+				dropletActions := getDropletActions(gitdropsDroplet, activeDroplet, dr.ownershipTags)
 				if len(dropletActions) != 0 {
-					dropletActionsByID[activeDroplet.ID] = dropletActions
+					dropletActionsByID[dropletID(activeDroplet.ID)] = dropletActions
 				}
 				dropletIsActive = true
 				continue
 			}
 		}
 		if !dropletIsActive {
+			// the VPC of the droplet is resolved now if it exists, or when the droplet is created
+			// if the VPC is created by the same plan.
+			if gitdropsDroplet.VPC != "" && gitdropsDroplet.VPCUUID == "" {
+				gitdropsDroplet.VPCUUID = dr.vpcNameToID[gitdropsDroplet.VPC]
+			}
 			dropletsToCreate = append(dropletsToCreate, gitdropsDroplet)
 		}
 	}
@@ -131,11 +172,13 @@ func (dr *dropletReconciler) setObjectsToUpdateAndCreate() {
 	dr.dropletsToCreate = dropletsToCreate
 	log.Println("dropletReconciler.setObjectsToUpdateAndCreate: droplets to create", dr.dropletsToCreate)
 	log.Println("dropletReconciler.setObjectsToUpdateAndCreate: droplets to update", dr.dropletsToUpdate)
+	return nil
 }
 
 // ObjectToDelete populates DropletReconciler with a list of IDs for droplets that need
 // to be deleted upon reconciliation of gitdrops.yaml (ie these droplets are active but not present
-// in the spec)
+// in the spec). Droplets without the ownership tags were not created by gitdrops and are never
+// deleted.
 func (dr *dropletReconciler) setObjectsToDelete() {
 	dropletsToDelete := make([]int, 0)
 
@@ -147,136 +190,636 @@ func (dr *dropletReconciler) setObjectsToDelete() {
 				continue
 			}
 		}
-		if !activeDropletInSpec {
-			//create droplet from local request
-			dropletsToDelete = append(dropletsToDelete, activeDroplet.ID)
+		if activeDropletInSpec {
+			continue
+		}
+		if !isManaged(activeDroplet.Tags, dr.ownershipTags) {
+			log.Println("dropletReconciler.setObjectsToDelete: droplet", activeDroplet.Name, "is not managed by gitdrops, it will not be deleted")
+			continue
+		}
+		if isProtected(activeDroplet.Tags) {
+			log.Println("dropletReconciler.setObjectsToDelete: droplet", activeDroplet.Name, "is protected, it will not be deleted")
+			continue
 		}
+		dropletsToDelete = append(dropletsToDelete, activeDroplet.ID)
 	}
 	dr.dropletsToDelete = dropletsToDelete
 	log.Println("dropletReconciler.setObjectsToDelete: droplets to delete", dr.dropletsToDelete)
 }
 
-func (dr *dropletReconciler) getActiveObjects() interface{} {
-	return dr.activeDroplets
+func (dr *dropletReconciler) getChanges() []Change {
+	changes := make([]Change, 0)
+	for _, dropletToCreate := range dr.dropletsToCreate {
+		changes = append(changes, Change{Resource: droplet, Action: create, Name: dropletToCreate.Name})
+	}
+	for _, activeDroplet := range dr.activeDroplets {
+		for _, dropletAction := range dr.dropletsToUpdate[dropletID(activeDroplet.ID)] {
+			changes = append(changes, Change{
+				Resource: droplet,
+				Action:   dropletAction.Action,
+				Name:     activeDroplet.Name,
+				ID:       strconv.Itoa(activeDroplet.ID),
+				Value:    dropletAction.value(),
+			})
+		}
+	}
+	for _, id := range dr.dropletsToDelete {
+		changes = append(changes, Change{Resource: droplet, Action: remove, Name: dr.findDropletName(id), ID: strconv.Itoa(id)})
+	}
+	return changes
+}
+
+func (dr *dropletReconciler) getSteps() interface{} {
+	return dropletSteps{
+		Create: dr.dropletsToCreate,
+		Update: dr.dropletsToUpdate,
+		Delete: dr.dropletsToDelete,
+	}
+}
+
+func (dr *dropletReconciler) setSteps(stepsJSON json.RawMessage) error {
+	steps := dropletSteps{}
+	err := unmarshalSteps(stepsJSON, &steps)
+	if err != nil {
+		return fmt.Errorf("dropletReconciler.setSteps: %v", err)
+	}
+	if steps.Update == nil {
+		steps.Update = make(map[dropletID][]dropletAction)
+	}
+	dr.dropletsToCreate = steps.Create
+	dr.dropletsToUpdate = steps.Update
+	dr.dropletsToDelete = steps.Delete
+	return nil
 }
 
-func (dr *dropletReconciler) getObjectsToCreate() interface{} {
-	return dr.dropletsToCreate
+// getObservedObjects returns the droplet fields that the droplet reconciler compares or acts on.
+func (dr *dropletReconciler) getObservedObjects() []observedObject {
+	observedObjects := make([]observedObject, 0)
+	for _, activeDroplet := range dr.activeDroplets {
+		observed := struct {
+			Name      string   `json:"name"`
+			Size      string   `json:"size"`
+			Image     string   `json:"image"`
+			VolumeIDs []string `json:"volumeIDs"`
+			Features  []string `json:"features"`
+			Tags      []string `json:"tags"`
+			Status    string   `json:"status"`
+		}{
+			Name:      activeDroplet.Name,
+			VolumeIDs: activeDroplet.VolumeIDs,
+			Features:  activeDroplet.Features,
+			Tags:      activeDroplet.Tags,
+			Status:    activeDroplet.Status,
+		}
+		if activeDroplet.Size != nil {
+			observed.Size = activeDroplet.Size.Slug
+		}
+		if activeDroplet.Image != nil {
+			observed.Image = activeDroplet.Image.Slug
+		}
+		observedObjects = append(observedObjects, observedObject{
+			id:       strconv.Itoa(activeDroplet.ID),
+			name:     activeDroplet.Name,
+			observed: observed,
+		})
+	}
+	return observedObjects
 }
 
-func (dr *dropletReconciler) getObjectsToUpdate() actionsByID {
-	return dr.dropletsToUpdate
+func (dr *dropletReconciler) countManagedObjects() int {
+	managed := 0
+	for _, activeDroplet := range dr.activeDroplets {
+		if isManaged(activeDroplet.Tags, dr.ownershipTags) {
+			managed++
+		}
+	}
+	return managed
 }
 
-func (dr *dropletReconciler) getObjectsToDelete() interface{} {
-	return dr.dropletsToDelete
+func (dr *dropletReconciler) findDropletName(id int) string {
+	for _, activeDroplet := range dr.activeDroplets {
+		if activeDroplet.ID == id {
+			return activeDroplet.Name
+		}
+	}
+	return ""
 }
 
-func getDropletActions(gitdropsDroplet gitdrops.Droplet, activeDroplet godo.Droplet) []action {
-	var dropletActions []action
-	if activeDroplet.Size != nil && activeDroplet.Size.Slug != gitdropsDroplet.Size {
+// getDropletActions returns the actions that bring activeDroplet in line with gitdropsDroplet.
+// Changes to fields in gitdropsDroplet.IgnoreChanges are ignored. Changes that cannot be applied
+// in place are reported as requiring replacement, unless gitdropsDroplet.ReplaceOnChanges lists
+// the field. A droplet that is protected or not managed by gitdrops is never replaced, see
+// isReplaceable.
+func getDropletActions(gitdropsDroplet gitdrops.Droplet, activeDroplet godo.Droplet, ownershipTags []string) []dropletAction {
+	var dropletActions []dropletAction
+	if isProtected(activeDroplet.Tags) != gitdropsDroplet.Protect {
+		dropletActions = append(dropletActions, dropletAction{Action: protect, Protected: gitdropsDroplet.Protect})
+	}
+	replacementFields := getReplacementFields(gitdropsDroplet, activeDroplet)
+	for _, field := range replacementFields {
+		if !gitdropsDroplet.ReplacesOnChanges(field) {
+			continue
+		}
+		if err := isReplaceable(gitdropsDroplet, activeDroplet, ownershipTags); err != nil {
+			log.Println("getDropletActions: droplet", activeDroplet.Name, field, "has been updated in gitdrops.yaml, but", err)
+			break
+		}
+		log.Println("getDropletActions: droplet", activeDroplet.Name, field, "has been updated in gitdrops.yaml, the droplet will be replaced")
+		// the new droplet is created with the size and image in gitdrops.yaml
+		return append(dropletActions, replacementAction(gitdropsDroplet, replace, field))
+	}
+	for _, field := range replacementFields {
+		log.Println("getDropletActions: droplet", activeDroplet.Name, field, "has been updated in gitdrops.yaml, but cannot be changed in place and requires replacement")
+		dropletActions = append(dropletActions, replacementAction(gitdropsDroplet, requiresReplacement, field))
+	}
+	// the droplet is powered off before and powered on after any other action
+	if activeDroplet.Status == dropletActive && gitdropsDroplet.PowerState == gitdrops.PowerOff && !gitdropsDroplet.IgnoresChanges(gitdrops.PowerStateField) {
+		log.Println("getDropletActions: droplet", activeDroplet.Name, "is to be powered off")
+		dropletActions = append(dropletActions, dropletAction{
+			Action: powerOff,
+		})
+	}
+	if activeDroplet.Size != nil && activeDroplet.Size.Slug != gitdropsDroplet.Size && !gitdropsDroplet.IgnoresChanges(gitdrops.SizeField) {
 		log.Println("getDropletActions: droplet", activeDroplet.Name, "size has been updated in gitdrops.yaml")
-		dropletAction := action{
-			action: resize,
-			value:  gitdropsDroplet.Size,
+		dropletActions = append(dropletActions, dropletAction{
+			Action: resize,
+			Size:   gitdropsDroplet.Size,
+		})
+	}
+	if activeDroplet.Image != nil && activeDroplet.Image.Slug != gitdropsDroplet.Image && !gitdropsDroplet.IgnoresChanges(gitdrops.ImageField) {
+		if gitdropsDroplet.Protect {
+			log.Println("getDropletActions: droplet", activeDroplet.Name, "image has been updated in gitdrops.yaml, but the droplet is protected and will not be rebuilt")
+		} else {
+			log.Println("getDropletActions: droplet", activeDroplet.Name, "image has been updated in gitdrops.yaml")
+			dropletActions = append(dropletActions, dropletAction{
+				Action: rebuild,
+				Image:  gitdropsDroplet.Image,
+			})
+		}
+	}
+	if hasFeature(activeDroplet, backupsFeature) != gitdropsDroplet.Backups && !gitdropsDroplet.IgnoresChanges(gitdrops.BackupsField) {
+		log.Println("getDropletActions: droplet", activeDroplet.Name, "backups has been updated in gitdrops.yaml")
+		backupsAction := dropletAction{
+			Action: disableBackups,
+		}
+		if gitdropsDroplet.Backups {
+			backupsAction.Action = enableBackups
 		}
-		dropletActions = append(dropletActions, dropletAction)
+		dropletActions = append(dropletActions, backupsAction)
+	}
+	if !hasFeature(activeDroplet, ipv6Feature) && gitdropsDroplet.IPv6 && !gitdropsDroplet.IgnoresChanges(gitdrops.IPv6Field) {
+		log.Println("getDropletActions: droplet", activeDroplet.Name, "ipv6 has been updated in gitdrops.yaml")
+		dropletActions = append(dropletActions, dropletAction{
+			Action: enableIPv6,
+		})
 	}
-	if activeDroplet.Image != nil && activeDroplet.Image.Slug != gitdropsDroplet.Image {
-		log.Println("getDropletActions: droplet", activeDroplet.Name, "image has been updated in gitdrops.yaml")
-		dropletAction := action{
-			action: rebuild,
-			value:  gitdropsDroplet.Image,
+	if !gitdropsDroplet.IgnoresChanges(gitdrops.TagsField) {
+		addedTags, removedTags := tagChanges(activeDroplet.Tags, gitdropsDroplet.Tags)
+		for _, t := range addedTags {
+			dropletActions = append(dropletActions, dropletAction{Action: tag, Tag: t})
 		}
-		dropletActions = append(dropletActions, dropletAction)
+		for _, t := range removedTags {
+			dropletActions = append(dropletActions, dropletAction{Action: untag, Tag: t})
+		}
+	}
+	if activeDroplet.Status == dropletOff && gitdropsDroplet.PowerState == gitdrops.PowerOn && !gitdropsDroplet.IgnoresChanges(gitdrops.PowerStateField) {
+		log.Println("getDropletActions: droplet", activeDroplet.Name, "is to be powered on")
+		dropletActions = append(dropletActions, dropletAction{
+			Action: powerOn,
+		})
 	}
 
 	return dropletActions
 }
 
-// volumesToDetach returns a slice of actions{action: detach, value: <volume-id>}
-func (dr *dropletReconciler) volumesToDetach(activeDroplet godo.Droplet, gitdropsDroplet gitdrops.Droplet) []action {
-	actions := make([]action, 0)
-	for _, activeDropletVolumeID := range activeDroplet.VolumeIDs {
-		volumeFound := false
-		for _, gitdropsDropletVolume := range gitdropsDroplet.Volumes {
-			if dr.volumeNameToID[gitdropsDropletVolume] == activeDropletVolumeID {
-				volumeFound = true
-				continue
-			}
+// getReplacementFields returns the fields of gitdropsDroplet that differ from activeDroplet but
+// cannot be changed in place: the region, disabling IPv6, enabling or disabling monitoring (the monitoring
+// agent is installed when the droplet is created) and the SSH keys, which are only installed
+// when the droplet is created. The SSH keys of droplets not created by gitdrops are unknown.
+func getReplacementFields(gitdropsDroplet gitdrops.Droplet, activeDroplet godo.Droplet) []string {
+	fields := make([]string, 0)
+	if activeDroplet.Region != nil && activeDroplet.Region.Slug != gitdropsDroplet.Region && !gitdropsDroplet.IgnoresChanges(gitdrops.RegionField) {
+		fields = append(fields, gitdrops.RegionField)
+	}
+	if hasFeature(activeDroplet, monitoringFeature) != gitdropsDroplet.Monitoring && !gitdropsDroplet.IgnoresChanges(gitdrops.MonitoringField) {
+		fields = append(fields, gitdrops.MonitoringField)
+	}
+	if hasFeature(activeDroplet, ipv6Feature) && !gitdropsDroplet.IPv6 && !gitdropsDroplet.IgnoresChanges(gitdrops.IPv6Field) {
+		fields = append(fields, gitdrops.IPv6Field)
+	}
+	if activeSSHKeysTag, ok := findSSHKeysTag(activeDroplet.Tags); ok && activeSSHKeysTag != sshKeysTag(gitdropsDroplet.SSHKeyFingerprints) && !gitdropsDroplet.IgnoresChanges(gitdrops.SSHKeyFingerprintsField) {
+		fields = append(fields, gitdrops.SSHKeyFingerprintsField)
+	}
+	return fields
+}
+
+// replacementAction returns the replace or requiresReplacement action of a change to field
+// requiring the replacement of gitdropsDroplet. The new region is shown in the plan.
+func replacementAction(gitdropsDroplet gitdrops.Droplet, name, field string) dropletAction {
+	if field == gitdrops.RegionField {
+		return dropletAction{Action: name, Field: field, Value: gitdropsDroplet.Region}
+	}
+	return dropletAction{Action: name, Field: field}
+}
+
+// isReplaceable returns an error if activeDroplet must not be replaced. Replacing a droplet
+// deletes it, so like a deletion it is refused for droplets that are protected, in gitdrops.yaml
+// or by their tags, or that are not managed by gitdrops.
+func isReplaceable(gitdropsDroplet gitdrops.Droplet, activeDroplet godo.Droplet, ownershipTags []string) error {
+	if gitdropsDroplet.Protect || isProtected(activeDroplet.Tags) {
+		return fmt.Errorf("isReplaceable: droplet %q (%d) is protected and will not be replaced", activeDroplet.Name, activeDroplet.ID)
+	}
+	if !isManaged(activeDroplet.Tags, ownershipTags) {
+		return fmt.Errorf("isReplaceable: droplet %q (%d) is not managed by gitdrops and will not be replaced", activeDroplet.Name, activeDroplet.ID)
+	}
+	return nil
+}
+
+// tagChanges returns the tags to add to and remove from the user tags of an active object, ie
+// its tags other than those applied by gitdrops, to bring them in line with tags.
+func tagChanges(activeTags, tags []string) ([]string, []string) {
+	added := make([]string, 0)
+	removed := make([]string, 0)
+	activeUserTags := userTags(activeTags)
+	for _, t := range tags {
+		if !hasTag(activeUserTags, t) {
+			added = append(added, t)
 		}
-		if !volumeFound {
-			log.Println("dropletReconciler.volumesToDetach: volume", activeDropletVolumeID, "to be detached from droplet")
-			action := action{
-				action: detach,
-				value:  activeDropletVolumeID,
-			}
-			actions = append(actions, action)
+	}
+	for _, t := range activeUserTags {
+		if !hasTag(tags, t) {
+			removed = append(removed, t)
 		}
 	}
-	return actions
+	return added, removed
 }
 
-// volumesToAttach returns a slice of actions{action: attach, value: <volume-id>}
-func (dr *dropletReconciler) volumesToAttach(activeDroplet godo.Droplet, gitdropsDroplet gitdrops.Droplet) []action {
-	actions := make([]action, 0)
-	for _, gitdropsDropletVolume := range gitdropsDroplet.Volumes {
-		volumeFound := false
-		for _, activeDropletVolumeID := range activeDroplet.VolumeIDs {
-			if dr.volumeNameToID[gitdropsDropletVolume] == activeDropletVolumeID {
-				volumeFound = true
-				continue
-			}
-		}
-		if !volumeFound {
-			// create attach action for volume
-			log.Println("dropletReconciler.volumesToAttach: volume", gitdropsDropletVolume, "not attached, attach to droplet")
-			action := action{
-				action: attach,
-				value:  dr.volumeNameToID[gitdropsDropletVolume],
-			}
-			actions = append(actions, action)
+func hasFeature(activeDroplet godo.Droplet, feature string) bool {
+	for _, f := range activeDroplet.Features {
+		if f == feature {
+			return true
 		}
 	}
-	return actions
+	return false
 }
 
-func (dr *dropletReconciler) deleteObjects(ctx context.Context) error {
+// deleteOperations returns an operation per droplet to delete. Each operation waits for its
+// droplet to be deleted so that volumes are detached before operations depending on it run.
+func (dr *dropletReconciler) deleteOperations() []operation {
+	operations := make([]operation, 0)
 	for _, id := range dr.dropletsToDelete {
-		err := gitdrops.DeleteDroplet(ctx, dr.client, id)
+		id := id
+		operations = append(operations, operation{
+			key:   operationKey(droplet, remove, strconv.Itoa(id)),
+			locks: []string{lockKey(droplet, strconv.Itoa(id))},
+			run: func(ctx context.Context) error {
+				return dr.deleteObject(ctx, id)
+			},
+		})
+	}
+	return operations
+}
+
+func (dr *dropletReconciler) deleteObject(ctx context.Context, id int) error {
+	if dr.snapshotPolicy.beforeDelete {
+		err := dr.snapshotObject(ctx, id)
 		if err != nil {
-			return fmt.Errorf("dropletReconciler.deleteObjects: %v", err)
+			return fmt.Errorf("dropletReconciler.deleteObject: %v", err)
 		}
 	}
+	err := gitdrops.DeleteDroplet(ctx, dr.client, id)
+	if err != nil {
+		return fmt.Errorf("dropletReconciler.deleteObject: %v", err)
+	}
+	err = gitdrops.WaitForDropletDeleted(ctx, dr.client, id, dr.actionTimeout)
+	if err != nil {
+		return fmt.Errorf("dropletReconciler.deleteObject: %v", err)
+	}
 	return nil
 }
 
-func (dr *dropletReconciler) createObjects(ctx context.Context) error {
+// createOperations returns an operation per droplet to create, each depending on the creation
+// of the volumes to be attached to it, or on their detachment from another droplet.
+func (dr *dropletReconciler) createOperations() []operation {
+	operations := make([]operation, 0)
 	for _, dropletToCreate := range dr.dropletsToCreate {
-		dropletCreateRequest, err := dr.translateDropletCreateRequest(dropletToCreate)
-		if err != nil {
-			return fmt.Errorf("dropletReconciler.createObjects: %v", err)
+		dropletToCreate := dropletToCreate
+		dependsOn := make([]string, 0)
+		for _, volumeName := range dropletToCreate.Volumes {
+			dependsOn = append(dependsOn, operationKey(volume, create, volumeName))
+			if volumeID, ok := dr.volumeNameToID[volumeName]; ok {
+				dependsOn = append(dependsOn, operationKey(attachmentResource, detach, volumeID))
+				dependsOn = append(dependsOn, dr.undeclaredDropletDependencies(volumeID)...)
+			}
 		}
-		err = gitdrops.CreateDroplet(ctx, dr.client, dropletCreateRequest)
+		for _, sshKeyName := range dropletToCreate.SSHKeys {
+			dependsOn = append(dependsOn, operationKey(sshKeyResource, create, sshKeyName))
+		}
+		if dropletToCreate.VPC != "" {
+			dependsOn = append(dependsOn, operationKey(vpcResource, create, dropletToCreate.VPC))
+		}
+		operations = append(operations, operation{
+			key:       operationKey(droplet, create, dropletToCreate.Name),
+			dependsOn: dependsOn,
+			run: func(ctx context.Context) error {
+				return dr.createObject(ctx, dropletToCreate)
+			},
+		})
+	}
+	return operations
+}
+
+func (dr *dropletReconciler) createObject(ctx context.Context, dropletToCreate gitdrops.Droplet) error {
+	dropletCreateRequest, err := dr.lockedDropletCreateRequest(ctx, dropletToCreate)
+	if err != nil {
+		return fmt.Errorf("dropletReconciler.createObject: %v", err)
+	}
+	dropletCreateRequest.Tags = creationTags(dropletCreateRequest.Tags, dr.ownershipTags, dropletToCreate.Protect)
+	dropletCreateRequest.Tags = withTags(dropletCreateRequest.Tags, []string{sshKeysTag(dropletToCreate.SSHKeyFingerprints)})
+	createdDroplet, err := gitdrops.CreateDroplet(ctx, dr.client, dropletCreateRequest)
+	if err != nil {
+		return fmt.Errorf("dropletReconciler.createObject: %v", err)
+	}
+	_, err = gitdrops.WaitForDroplet(ctx, dr.client, createdDroplet.ID, dr.actionTimeout)
+	if err != nil {
+		return fmt.Errorf("dropletReconciler.createObject: %v", err)
+	}
+	if dropletToCreate.PowerState == gitdrops.PowerOff {
+		err = gitdrops.ShutdownDroplet(ctx, dr.client, createdDroplet.ID, dr.shutdownTimeout, dr.actionTimeout)
 		if err != nil {
-			return fmt.Errorf("dropletReconciler.createObjects: %v", err)
+			return fmt.Errorf("dropletReconciler.createObject: %v", err)
 		}
 	}
 	return nil
 }
 
-func (dr *dropletReconciler) updateObjects(ctx context.Context) error {
-	for id, dropletActions := range dr.dropletsToUpdate {
-		for _, dropletAction := range dropletActions {
-			err := gitdrops.UpdateDroplet(ctx, dr.client, id.(int), dropletAction.action, dropletAction.value.(string))
+// lockedDropletCreateRequest translates dropletToCreate while holding dr.mu. Volumes and VPCs
+// created since the droplet reconciler listed them are not yet known by ID, so volumeNameToID
+// and vpcNameToID are refreshed first if necessary.
+func (dr *dropletReconciler) lockedDropletCreateRequest(ctx context.Context, dropletToCreate gitdrops.Droplet) (*godo.DropletCreateRequest, error) {
+	dr.mu.Lock()
+	defer dr.mu.Unlock()
+	for _, volumeName := range dropletToCreate.Volumes {
+		if _, ok := dr.volumeNameToID[volumeName]; !ok {
+			err := dr.setVolumeNameToID(ctx)
 			if err != nil {
-				return fmt.Errorf("dropletReconciler.updateObjects: %v", err)
+				return nil, err
+			}
+			break
+		}
+	}
+	if _, ok := dr.vpcNameToID[dropletToCreate.VPC]; dropletToCreate.VPC != "" && dropletToCreate.VPCUUID == "" && !ok {
+		err := dr.setVPCNameToID(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return dr.translateDropletCreateRequest(dropletToCreate)
+}
+
+// updateOperations returns an operation per droplet to update, with the actions gitdrops has
+// the privileges for.
+func (dr *dropletReconciler) updateOperations() []operation {
+	ids := make([]int, 0, len(dr.dropletsToUpdate))
+	for id := range dr.dropletsToUpdate {
+		ids = append(ids, int(id))
+	}
+	sort.Ints(ids)
+
+	operations := make([]operation, 0)
+	for _, id := range ids {
+		id := id
+		dropletActions := make([]dropletAction, 0)
+		replaces := false
+		for _, dropletAction := range dr.dropletsToUpdate[dropletID(id)] {
+			switch dropletAction.Action {
+			case resize, rebuild, protect, enableBackups, disableBackups, enableIPv6, tag, untag, powerOn, powerOff:
+				if !dr.privileges.Allows(droplet, dropletAction.Action) {
+					log.Printf("gitdrops has discovered droplets to %s, but does not have %s privileges", dropletAction.Action, dropletAction.Action)
+					continue
+				}
+				dropletActions = append(dropletActions, dropletAction)
+			case replace:
+				// replacing a droplet creates and deletes a droplet
+				if !dr.privileges.Allows(droplet, replace) || !dr.privileges.Allows(droplet, create) || !dr.privileges.Allows(droplet, remove) {
+					log.Println("gitdrops has discovered droplets to replace, but does not have replace, create and delete privileges")
+					continue
+				}
+				err := dr.isReplaceable(id)
+				if err != nil {
+					log.Println("dropletReconciler.updateOperations:", err)
+					continue
+				}
+				replaces = true
+			}
+		}
+		if len(dropletActions) != 0 {
+			operations = append(operations, operation{
+				key:   operationKey(droplet, update, strconv.Itoa(id)),
+				locks: []string{lockKey(droplet, strconv.Itoa(id))},
+				run: func(ctx context.Context) error {
+					return dr.updateObject(ctx, id, dropletActions)
+				},
+			})
+		}
+		if replaces {
+			operations = append(operations, dr.replaceOperation(id))
+		}
+	}
+	return operations
+}
+
+// replaceOperation returns the operation replacing droplet id. It is keyed apart from the
+// droplet update, so that checkMassDelete counts the deletion of the droplet.
+func (dr *dropletReconciler) replaceOperation(id int) operation {
+	dependsOn := []string{operationKey(droplet, update, strconv.Itoa(id))}
+	if gitdropsDroplet, ok := dr.findGitdropsDroplet(dr.findDropletName(id)); ok {
+		for _, volumeName := range gitdropsDroplet.Volumes {
+			dependsOn = append(dependsOn, operationKey(volume, create, volumeName))
+			if volumeID, ok := dr.volumeNameToID[volumeName]; ok {
+				dependsOn = append(dependsOn, dr.undeclaredDropletDependencies(volumeID)...)
+			}
+		}
+		for _, sshKeyName := range gitdropsDroplet.SSHKeys {
+			dependsOn = append(dependsOn, operationKey(sshKeyResource, create, sshKeyName))
+		}
+		if gitdropsDroplet.VPC != "" {
+			dependsOn = append(dependsOn, operationKey(vpcResource, create, gitdropsDroplet.VPC))
+		}
+	}
+	return operation{
+		key:       operationKey(droplet, replace, strconv.Itoa(id)),
+		dependsOn: dependsOn,
+		locks:     []string{lockKey(droplet, strconv.Itoa(id))},
+		run: func(ctx context.Context) error {
+			return dr.replaceObject(ctx, id)
+		},
+	}
+}
+
+// updateObject waits for each droplet action to complete before the next one, as DO rejects
+// concurrent actions on the same droplet.
+func (dr *dropletReconciler) updateObject(ctx context.Context, id int, dropletActions []dropletAction) error {
+	for _, dropletAction := range dropletActions {
+		switch dropletAction.Action {
+		case protect:
+			resource := godo.Resource{ID: strconv.Itoa(id), Type: godo.DropletResourceType}
+			err := setProtected(ctx, dr.client, resource, dropletAction.Protected)
+			if err != nil {
+				return fmt.Errorf("dropletReconciler.updateObject: %v", err)
+			}
+		case powerOn:
+			err := gitdrops.PowerOnDroplet(ctx, dr.client, id, dr.actionTimeout)
+			if err != nil {
+				return fmt.Errorf("dropletReconciler.updateObject: %v", err)
+			}
+		case powerOff:
+			err := gitdrops.ShutdownDroplet(ctx, dr.client, id, dr.shutdownTimeout, dr.actionTimeout)
+			if err != nil {
+				return fmt.Errorf("dropletReconciler.updateObject: %v", err)
+			}
+		case tag, untag:
+			resources := []godo.Resource{{ID: strconv.Itoa(id), Type: godo.DropletResourceType}}
+			var err error
+			if dropletAction.Action == untag {
+				err = gitdrops.UntagResources(ctx, dr.client, dropletAction.Tag, resources)
+			} else {
+				err = gitdrops.TagResources(ctx, dr.client, dropletAction.Tag, resources)
+			}
+			if err != nil {
+				return fmt.Errorf("dropletReconciler.updateObject: %v", err)
+			}
+		default:
+			if dropletAction.Action == rebuild && dr.snapshotPolicy.beforeRebuild {
+				err := dr.snapshotObject(ctx, id)
+				if err != nil {
+					return fmt.Errorf("dropletReconciler.updateObject: %v", err)
+				}
+			}
+			// resize and rebuild are passed the new slug, other actions have no parameters
+			slug := dropletAction.Size
+			if dropletAction.Action == rebuild {
+				slug = dropletAction.Image
+			}
+			action, err := gitdrops.UpdateDroplet(ctx, dr.client, id, dropletAction.Action, slug)
+			if err != nil {
+				return fmt.Errorf("dropletReconciler.updateObject: %v", err)
+			}
+			err = gitdrops.WaitForAction(ctx, dr.client, action, dr.actionTimeout)
+			if err != nil {
+				return fmt.Errorf("dropletReconciler.updateObject: %v", err)
 			}
 		}
 	}
 	return nil
 }
 
+// replaceObject replaces droplet id with a new droplet created from its spec in gitdrops.yaml.
+// The existing droplet is deleted first, unless the spec sets createBeforeDestroy.
+func (dr *dropletReconciler) replaceObject(ctx context.Context, id int) error {
+	err := dr.isReplaceable(id)
+	if err != nil {
+		return fmt.Errorf("dropletReconciler.replaceObject: %v", err)
+	}
+	gitdropsDroplet, _ := dr.findGitdropsDroplet(dr.findDropletName(id))
+	if gitdropsDroplet.CreateBeforeDestroy {
+		err := dr.createObject(ctx, gitdropsDroplet)
+		if err != nil {
+			return fmt.Errorf("dropletReconciler.replaceObject: %v", err)
+		}
+		return dr.deleteObject(ctx, id)
+	}
+	err = dr.deleteObject(ctx, id)
+	if err != nil {
+		return fmt.Errorf("dropletReconciler.replaceObject: %v", err)
+	}
+	return dr.createObject(ctx, gitdropsDroplet)
+}
+
+// snapshotObject takes a snapshot of the droplet and waits for it to complete, see
+// snapshotPolicy.
+func (dr *dropletReconciler) snapshotObject(ctx context.Context, id int) error {
+	return dr.snapshotPolicy.snapshot(ctx, dr.client, godo.DropletResourceType, dr.findDropletName(id), func(snapshotName string) (string, error) {
+		return gitdrops.SnapshotDroplet(ctx, dr.client, id, snapshotName, dr.actionTimeout)
+	})
+}
+
+// findUndeclaredDroplets returns the active droplets not declared in gitdrops.yaml that volume
+// volumeID is attached to.
+func (dr *dropletReconciler) findUndeclaredDroplets(volumeID string) []godo.Droplet {
+	undeclaredDroplets := make([]godo.Droplet, 0)
+	if volumeID == "" {
+		return undeclaredDroplets
+	}
+	for _, activeDroplet := range dr.activeDroplets {
+		if !hasVolume(activeDroplet.VolumeIDs, volumeID) {
+			continue
+		}
+		if _, ok := dr.findGitdropsDroplet(activeDroplet.Name); !ok {
+			undeclaredDroplets = append(undeclaredDroplets, activeDroplet)
+		}
+	}
+	return undeclaredDroplets
+}
+
+// undeclaredDropletDependencies returns the keys of the operations deleting the droplets not
+// declared in gitdrops.yaml that volume volumeID is attached to. DO detaches the volume when
+// such a droplet is deleted, after which it can be attached to or created with another droplet.
+func (dr *dropletReconciler) undeclaredDropletDependencies(volumeID string) []string {
+	dependsOn := make([]string, 0)
+	for _, undeclaredDroplet := range dr.findUndeclaredDroplets(volumeID) {
+		if !hasDropletID(dr.dropletsToDelete, undeclaredDroplet.ID) {
+			log.Println("dropletReconciler.undeclaredDropletDependencies: volume", volumeID, "is attached to droplet", undeclaredDroplet.Name, "which is not declared in gitdrops.yaml but is not deleted, it cannot be attached to another droplet")
+		}
+		dependsOn = append(dependsOn, operationKey(droplet, remove, strconv.Itoa(undeclaredDroplet.ID)))
+	}
+	return dependsOn
+}
+
+func (dr *dropletReconciler) findGitdropsDroplet(name string) (gitdrops.Droplet, bool) {
+	for _, gitdropsDroplet := range dr.gitdropsDroplets {
+		if gitdropsDroplet.Name == name {
+			return gitdropsDroplet, true
+		}
+	}
+	return gitdrops.Droplet{}, false
+}
+
+// isReplaceable returns an error if droplet id is not declared in gitdrops.yaml or must not be
+// replaced, see isReplaceable.
+func (dr *dropletReconciler) isReplaceable(id int) error {
+	name := dr.findDropletName(id)
+	gitdropsDroplet, ok := dr.findGitdropsDroplet(name)
+	if !ok {
+		return fmt.Errorf("dropletReconciler.isReplaceable: droplet %q (%d) is not declared in gitdrops.yaml", name, id)
+	}
+	for _, activeDroplet := range dr.activeDroplets {
+		if activeDroplet.ID == id {
+			err := isReplaceable(gitdropsDroplet, activeDroplet, dr.ownershipTags)
+			if err != nil {
+				return fmt.Errorf("dropletReconciler.isReplaceable: %v", err)
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("dropletReconciler.isReplaceable: droplet %q (%d) is not active", name, id)
+}
+
+// replaces returns true if droplet id is replaced, its volumes are then attached to the new
+// droplet when it is created.
+func (dr *dropletReconciler) replaces(id int) bool {
+	return hasDropletAction(dr.dropletsToUpdate[dropletID(id)], replace)
+}
+
+func hasDropletAction(dropletActions []dropletAction, name string) bool {
+	for _, a := range dropletActions {
+		if a.Action == name {
+			return true
+		}
+	}
+	return false
+}
+
 func (dr *dropletReconciler) translateDropletCreateRequest(gitdropsDroplet gitdrops.Droplet) (*godo.DropletCreateRequest, error) {
 	createRequest := &godo.DropletCreateRequest{}
 	if gitdropsDroplet.Name == "" {
@@ -297,6 +840,9 @@ func (dr *dropletReconciler) translateDropletCreateRequest(gitdropsDroplet gitdr
 	dropletImage := godo.DropletCreateImage{}
 	dropletImage.Slug = gitdropsDroplet.Image
 	createRequest.Image = dropletImage
+	createRequest.Backups = gitdropsDroplet.Backups
+	createRequest.IPv6 = gitdropsDroplet.IPv6
+	createRequest.Monitoring = gitdropsDroplet.Monitoring
 
 	if gitdropsDroplet.SSHKeyFingerprints != nil {
 		dropletCreateSSHKeys := make([]godo.DropletCreateSSHKey, 0)
@@ -320,6 +866,12 @@ func (dr *dropletReconciler) translateDropletCreateRequest(gitdropsDroplet gitdr
 	}
 	if gitdropsDroplet.VPCUUID != "" {
 		createRequest.VPCUUID = gitdropsDroplet.VPCUUID
+	} else if gitdropsDroplet.VPC != "" {
+		vpcID, ok := dr.vpcNameToID[gitdropsDroplet.VPC]
+		if !ok {
+			return createRequest, fmt.Errorf("dropletReconciler.translateDropletCreateRequest: vpc %q not found", gitdropsDroplet.VPC)
+		}
+		createRequest.VPCUUID = vpcID
 	}
 	if gitdropsDroplet.UserData.Data != "" {
 		createRequest.UserData = gitdropsDroplet.UserData.Data
@@ -10,6 +10,9 @@ import (
 	"github.com/digitalocean/godo"
 )
 
+// testOwnershipTags mark the active objects of tests as managed by gitdrops
+var testOwnershipTags = ownershipTags("")
+
 func newTestDropletReconciler(privileges gitdrops.Privileges, client *godo.Client, activeDroplets []godo.Droplet, gitdropsDroplets []gitdrops.Droplet, volumeNameToID map[string]string) *dropletReconciler {
 	return &dropletReconciler{
 		privileges:       privileges,
@@ -17,6 +20,7 @@ func newTestDropletReconciler(privileges gitdrops.Privileges, client *godo.Clien
 		activeDroplets:   activeDroplets,
 		gitdropsDroplets: gitdropsDroplets,
 		volumeNameToID:   volumeNameToID,
+		ownershipTags:    ownershipTags(""),
 	}
 }
 
@@ -26,7 +30,7 @@ func TestSetDropletsToUpdateCreate(t *testing.T) {
 		activeDroplets   []godo.Droplet
 		gitdropsDroplets []gitdrops.Droplet
 		dropletsToCreate []gitdrops.Droplet
-		dropletsToUpdate actionsByID
+		dropletsToUpdate map[dropletID][]dropletAction
 		volumeNameToID   map[string]string
 	}{
 		{
@@ -56,7 +60,7 @@ func TestSetDropletsToUpdateCreate(t *testing.T) {
 					Name: "droplet-5",
 				},
 			},
-			dropletsToUpdate: make(actionsByID),
+			dropletsToUpdate: make(map[dropletID][]dropletAction),
 			dropletsToCreate: []gitdrops.Droplet{
 				{
 					Name: "droplet-4",
@@ -96,7 +100,7 @@ func TestSetDropletsToUpdateCreate(t *testing.T) {
 				},
 			},
 
-			dropletsToUpdate: make(actionsByID),
+			dropletsToUpdate: make(map[dropletID][]dropletAction),
 			dropletsToCreate: []gitdrops.Droplet{},
 			volumeNameToID:   make(map[string]string),
 		},
@@ -114,7 +118,7 @@ func TestSetDropletsToUpdateCreate(t *testing.T) {
 					Name: "droplet-3",
 				},
 			},
-			dropletsToUpdate: make(actionsByID),
+			dropletsToUpdate: make(map[dropletID][]dropletAction),
 			dropletsToCreate: []gitdrops.Droplet{
 				{
 					Name: "droplet-1",
@@ -167,21 +171,21 @@ func TestSetDropletsToUpdateCreate(t *testing.T) {
 					Name: "droplet-4",
 				},
 			},
-			dropletsToUpdate: actionsByID{
-				1: []action{
+			dropletsToUpdate: map[dropletID][]dropletAction{
+				dropletID(1): []dropletAction{
 					{
-						action: "rebuild",
-						value:  "ubuntu-16-04-x64",
+						Action: "rebuild",
+						Image:  "ubuntu-16-04-x64",
 					},
 				},
-				2: []action{
+				dropletID(2): []dropletAction{
 					{
-						action: "resize",
-						value:  "s-1vcpu-2gb",
+						Action: "resize",
+						Size:   "s-1vcpu-2gb",
 					},
 					{
-						action: "rebuild",
-						value:  "ubuntu-16-04-x64",
+						Action: "rebuild",
+						Image:  "ubuntu-16-04-x64",
 					},
 				},
 			},
@@ -193,7 +197,7 @@ func TestSetDropletsToUpdateCreate(t *testing.T) {
 			volumeNameToID: make(map[string]string),
 		},
 		{
-			name: "test case 5 - attach volume",
+			name: "test case 5 - volumes are attached by the attachment reconciler",
 			activeDroplets: []godo.Droplet{
 				{
 					ID:   2,
@@ -214,19 +218,15 @@ func TestSetDropletsToUpdateCreate(t *testing.T) {
 					Volumes: []string{"volume-1"},
 				},
 			},
-			dropletsToUpdate: actionsByID{
-				2: []action{
-					{
-						action: "resize",
-						value:  "s-1vcpu-2gb",
-					},
+			dropletsToUpdate: map[dropletID][]dropletAction{
+				dropletID(2): []dropletAction{
 					{
-						action: "rebuild",
-						value:  "ubuntu-16-04-x64",
+						Action: "resize",
+						Size:   "s-1vcpu-2gb",
 					},
 					{
-						action: "attach",
-						value:  "abc",
+						Action: "rebuild",
+						Image:  "ubuntu-16-04-x64",
 					},
 				},
 			},
@@ -237,81 +237,7 @@ func TestSetDropletsToUpdateCreate(t *testing.T) {
 			},
 		},
 		{
-			name: "test case 6 - attach volume and detach volume",
-			activeDroplets: []godo.Droplet{
-				{
-					ID:   1,
-					Name: "droplet-1",
-					Image: &godo.Image{
-						Slug: "ubuntu-16-04-x64",
-					},
-					Size: &godo.Size{
-						Slug: "s-1vcpu-2gb",
-					},
-					VolumeIDs: []string{
-						"def",
-					},
-				},
-				{
-					ID:   2,
-					Name: "droplet-2",
-					Image: &godo.Image{
-						Slug: "centos-8-x64",
-					},
-					Size: &godo.Size{
-						Slug: "s-1vcpu-2gb",
-					},
-					VolumeIDs: []string{
-						"abc",
-					},
-				},
-			},
-			gitdropsDroplets: []gitdrops.Droplet{
-				{
-					Name:    "droplet-1",
-					Image:   "ubuntu-16-04-x64",
-					Size:    "s-1vcpu-2gb",
-					Volumes: []string{"volume-1"},
-				},
-				{
-					Name:    "droplet-2",
-					Image:   "centos-8-x64",
-					Size:    "s-1vcpu-2gb",
-					Volumes: []string{"volume-2"},
-				},
-			},
-			dropletsToUpdate: actionsByID{
-				1: []action{
-					{
-						action: "detach",
-						value:  "def",
-					},
-
-					{
-						action: "attach",
-						value:  "abc",
-					},
-				},
-
-				2: []action{
-					{
-						action: "detach",
-						value:  "abc",
-					},
-					{
-						action: "attach",
-						value:  "def",
-					},
-				},
-			},
-			dropletsToCreate: []gitdrops.Droplet{},
-			volumeNameToID: map[string]string{
-				"volume-1": "abc",
-				"volume-2": "def",
-			},
-		},
-		{
-			name: "test case 7 - no action on attach/detach",
+			name: "test case 6 - no action on attached volume",
 			activeDroplets: []godo.Droplet{
 				{
 					ID:   2,
@@ -335,7 +261,7 @@ func TestSetDropletsToUpdateCreate(t *testing.T) {
 					Volumes: []string{"volume-1"},
 				},
 			},
-			dropletsToUpdate: make(actionsByID),
+			dropletsToUpdate: make(map[dropletID][]dropletAction),
 			dropletsToCreate: []gitdrops.Droplet{},
 			volumeNameToID: map[string]string{
 				"volume-1": "abc",
@@ -368,14 +294,17 @@ func TestSetDropletsToDelete(t *testing.T) {
 				{
 					ID:   1,
 					Name: "droplet-1",
+					Tags: testOwnershipTags,
 				},
 				{
 					ID:   2,
 					Name: "droplet-2",
+					Tags: testOwnershipTags,
 				},
 				{
 					ID:   3,
 					Name: "droplet-3",
+					Tags: testOwnershipTags,
 				},
 			},
 
@@ -399,14 +328,17 @@ func TestSetDropletsToDelete(t *testing.T) {
 				{
 					ID:   1,
 					Name: "droplet-1",
+					Tags: testOwnershipTags,
 				},
 				{
 					ID:   2,
 					Name: "droplet-2",
+					Tags: testOwnershipTags,
 				},
 				{
 					ID:   3,
 					Name: "droplet-3",
+					Tags: testOwnershipTags,
 				},
 			},
 			gitdropsDroplets: []gitdrops.Droplet{
@@ -444,6 +376,7 @@ func TestSetDropletsToDelete(t *testing.T) {
 				{
 					ID:   1,
 					Name: "droplet-1",
+					Tags: testOwnershipTags,
 					Region: &godo.Region{
 						Name: "london",
 					},
@@ -451,10 +384,12 @@ func TestSetDropletsToDelete(t *testing.T) {
 				{
 					ID:   2,
 					Name: "droplet-2",
+					Tags: testOwnershipTags,
 				},
 				{
 					ID:   3,
 					Name: "droplet-3",
+					Tags: testOwnershipTags,
 				},
 			},
 			gitdropsDroplets: []gitdrops.Droplet{
@@ -472,6 +407,32 @@ func TestSetDropletsToDelete(t *testing.T) {
 			},
 			dropletsToDelete: []int{3},
 		},
+		{
+			name: "test case 5 - unmanaged droplets are not deleted",
+			activeDroplets: []godo.Droplet{
+				{
+					ID:   1,
+					Name: "droplet-1",
+				},
+				{
+					ID:   2,
+					Name: "droplet-2",
+					Tags: []string{managedTag, stackTagPrefix + "other"},
+				},
+				{
+					ID:   3,
+					Name: "droplet-3",
+					Tags: testOwnershipTags,
+				},
+				{
+					ID:   4,
+					Name: "droplet-4",
+					Tags: append([]string{protectedTag}, testOwnershipTags...),
+				},
+			},
+			gitdropsDroplets: []gitdrops.Droplet{},
+			dropletsToDelete: []int{3},
+		},
 	}
 	for _, tc := range tcases {
 		dr := newTestDropletReconciler(gitdrops.Privileges{}, nil, tc.activeDroplets, tc.gitdropsDroplets, nil)
@@ -483,6 +444,311 @@ func TestSetDropletsToDelete(t *testing.T) {
 	}
 }
 
+func TestGetDropletActions(t *testing.T) {
+	activeDroplet := godo.Droplet{
+		ID:   1,
+		Name: "droplet-1",
+		Tags: testOwnershipTags,
+		Region: &godo.Region{
+			Slug: "nyc3",
+		},
+		Size: &godo.Size{
+			Slug: "s-1vcpu-1gb",
+		},
+		Image: &godo.Image{
+			Slug: "centos-8-x64",
+		},
+	}
+	tcases := []struct {
+		name            string
+		gitdropsDroplet gitdrops.Droplet
+		activeDroplet   godo.Droplet
+		expActions      []dropletAction
+	}{
+		{
+			name: "test case 1 - resize and rebuild",
+			gitdropsDroplet: gitdrops.Droplet{
+				Name:   "droplet-1",
+				Region: "nyc3",
+				Size:   "s-1vcpu-2gb",
+				Image:  "ubuntu-20-04-x64",
+			},
+			activeDroplet: activeDroplet,
+			expActions: []dropletAction{
+				{Action: resize, Size: "s-1vcpu-2gb"},
+				{Action: rebuild, Image: "ubuntu-20-04-x64"},
+			},
+		},
+		{
+			name: "test case 2 - protected droplet is not rebuilt",
+			gitdropsDroplet: gitdrops.Droplet{
+				Name:    "droplet-1",
+				Region:  "nyc3",
+				Size:    "s-1vcpu-2gb",
+				Image:   "ubuntu-20-04-x64",
+				Protect: true,
+			},
+			activeDroplet: activeDroplet,
+			expActions: []dropletAction{
+				{Action: protect, Protected: true},
+				{Action: resize, Size: "s-1vcpu-2gb"},
+			},
+		},
+		{
+			name: "test case 3 - ignored changes",
+			gitdropsDroplet: gitdrops.Droplet{
+				Name:          "droplet-1",
+				Region:        "sfo3",
+				Size:          "s-1vcpu-2gb",
+				Image:         "ubuntu-20-04-x64",
+				IgnoreChanges: []string{"size", "image", "region"},
+			},
+			activeDroplet: activeDroplet,
+			expActions:    nil,
+		},
+		{
+			name: "test case 4 - region change requires replacement",
+			gitdropsDroplet: gitdrops.Droplet{
+				Name:   "droplet-1",
+				Region: "sfo3",
+				Size:   "s-1vcpu-2gb",
+				Image:  "centos-8-x64",
+			},
+			activeDroplet: activeDroplet,
+			expActions: []dropletAction{
+				{Action: requiresReplacement, Field: gitdrops.RegionField, Value: "sfo3"},
+				{Action: resize, Size: "s-1vcpu-2gb"},
+			},
+		},
+		{
+			name: "test case 5 - unprotect",
+			gitdropsDroplet: gitdrops.Droplet{
+				Name:   "droplet-1",
+				Region: "nyc3",
+				Size:   "s-1vcpu-1gb",
+				Image:  "centos-8-x64",
+			},
+			activeDroplet: godo.Droplet{
+				ID:   1,
+				Name: "droplet-1",
+				Tags: []string{protectedTag},
+			},
+			expActions: []dropletAction{
+				{Action: protect, Protected: false},
+			},
+		},
+		{
+			name: "test case 6 - backups, ipv6 and tags",
+			gitdropsDroplet: gitdrops.Droplet{
+				Name:    "droplet-1",
+				Region:  "nyc3",
+				Size:    "s-1vcpu-1gb",
+				Image:   "centos-8-x64",
+				Backups: false,
+				IPv6:    true,
+				Tags:    []string{"web", "prod"},
+			},
+			activeDroplet: godo.Droplet{
+				ID:       1,
+				Name:     "droplet-1",
+				Features: []string{"backups"},
+				Tags:     append([]string{"web", "dev"}, testOwnershipTags...),
+			},
+			expActions: []dropletAction{
+				{Action: disableBackups},
+				{Action: enableIPv6},
+				{Action: tag, Tag: "prod"},
+				{Action: untag, Tag: "dev"},
+			},
+		},
+		{
+			name: "test case 7 - requires replacement",
+			gitdropsDroplet: gitdrops.Droplet{
+				Name:               "droplet-1",
+				Region:             "nyc3",
+				Size:               "s-1vcpu-1gb",
+				Image:              "centos-8-x64",
+				Monitoring:         true,
+				SSHKeyFingerprints: []string{"ab:cd"},
+			},
+			activeDroplet: godo.Droplet{
+				ID:       1,
+				Name:     "droplet-1",
+				Features: []string{"ipv6"},
+				Tags:     []string{sshKeysTag(nil)},
+			},
+			expActions: []dropletAction{
+				{Action: requiresReplacement, Field: gitdrops.MonitoringField},
+				{Action: requiresReplacement, Field: gitdrops.IPv6Field},
+				{Action: requiresReplacement, Field: gitdrops.SSHKeyFingerprintsField},
+			},
+		},
+		{
+			name: "test case 8 - replace on changes",
+			gitdropsDroplet: gitdrops.Droplet{
+				Name:             "droplet-1",
+				Region:           "nyc3",
+				Size:             "s-1vcpu-2gb",
+				Image:            "centos-8-x64",
+				Monitoring:       true,
+				ReplaceOnChanges: []string{gitdrops.MonitoringField},
+			},
+			activeDroplet: activeDroplet,
+			expActions: []dropletAction{
+				{Action: replace, Field: gitdrops.MonitoringField},
+			},
+		},
+		{
+			name: "test case 9 - ignored changes and unknown SSH keys",
+			gitdropsDroplet: gitdrops.Droplet{
+				Name:               "droplet-1",
+				Region:             "nyc3",
+				Size:               "s-1vcpu-1gb",
+				Image:              "centos-8-x64",
+				Monitoring:         true,
+				Backups:            true,
+				Tags:               []string{"web"},
+				SSHKeyFingerprints: []string{"ab:cd"},
+				IgnoreChanges:      []string{"monitoring", "backups", "tags"},
+			},
+			activeDroplet: activeDroplet,
+			expActions:    nil,
+		},
+		{
+			name: "test case 10 - power off before resize",
+			gitdropsDroplet: gitdrops.Droplet{
+				Name:       "droplet-1",
+				Region:     "nyc3",
+				Size:       "s-1vcpu-2gb",
+				Image:      "centos-8-x64",
+				PowerState: "off",
+			},
+			activeDroplet: godo.Droplet{
+				ID:     1,
+				Name:   "droplet-1",
+				Status: "active",
+				Size: &godo.Size{
+					Slug: "s-1vcpu-1gb",
+				},
+			},
+			expActions: []dropletAction{
+				{Action: powerOff},
+				{Action: resize, Size: "s-1vcpu-2gb"},
+			},
+		},
+		{
+			name: "test case 11 - power on after resize",
+			gitdropsDroplet: gitdrops.Droplet{
+				Name:       "droplet-1",
+				Region:     "nyc3",
+				Size:       "s-1vcpu-2gb",
+				Image:      "centos-8-x64",
+				PowerState: "on",
+			},
+			activeDroplet: godo.Droplet{
+				ID:     1,
+				Name:   "droplet-1",
+				Status: "off",
+				Size: &godo.Size{
+					Slug: "s-1vcpu-1gb",
+				},
+			},
+			expActions: []dropletAction{
+				{Action: resize, Size: "s-1vcpu-2gb"},
+				{Action: powerOn},
+			},
+		},
+		{
+			name: "test case 12 - region change replace on changes",
+			gitdropsDroplet: gitdrops.Droplet{
+				Name:             "droplet-1",
+				Region:           "sfo3",
+				Size:             "s-1vcpu-2gb",
+				Image:            "centos-8-x64",
+				ReplaceOnChanges: []string{gitdrops.RegionField},
+			},
+			activeDroplet: activeDroplet,
+			expActions: []dropletAction{
+				{Action: replace, Field: gitdrops.RegionField, Value: "sfo3"},
+			},
+		},
+		{
+			name: "test case 13 - droplet protected by tag is not replaced",
+			gitdropsDroplet: gitdrops.Droplet{
+				Name:             "droplet-1",
+				Region:           "sfo3",
+				Size:             "s-1vcpu-1gb",
+				Image:            "centos-8-x64",
+				ReplaceOnChanges: []string{gitdrops.RegionField},
+			},
+			activeDroplet: godo.Droplet{
+				ID:     1,
+				Name:   "droplet-1",
+				Tags:   append([]string{protectedTag}, testOwnershipTags...),
+				Region: &godo.Region{Slug: "nyc3"},
+			},
+			expActions: []dropletAction{
+				{Action: protect, Protected: false},
+				{Action: requiresReplacement, Field: gitdrops.RegionField, Value: "sfo3"},
+			},
+		},
+		{
+			name: "test case 14 - unmanaged droplet is not replaced",
+			gitdropsDroplet: gitdrops.Droplet{
+				Name:             "droplet-1",
+				Region:           "sfo3",
+				Size:             "s-1vcpu-1gb",
+				Image:            "centos-8-x64",
+				Monitoring:       true,
+				ReplaceOnChanges: []string{gitdrops.RegionField, gitdrops.MonitoringField},
+			},
+			activeDroplet: godo.Droplet{
+				ID:     1,
+				Name:   "droplet-1",
+				Region: &godo.Region{Slug: "nyc3"},
+			},
+			expActions: []dropletAction{
+				{Action: requiresReplacement, Field: gitdrops.RegionField, Value: "sfo3"},
+				{Action: requiresReplacement, Field: gitdrops.MonitoringField},
+			},
+		},
+	}
+	for _, tc := range tcases {
+		dropletActions := getDropletActions(tc.gitdropsDroplet, tc.activeDroplet, testOwnershipTags)
+		if !reflect.DeepEqual(dropletActions, tc.expActions) {
+			t.Errorf("Failed %v, expected: %v, got %v", tc.name, tc.expActions, dropletActions)
+		}
+	}
+}
+
+func TestDropletUpdateOperations(t *testing.T) {
+	activeDroplets := []godo.Droplet{
+		{ID: 1, Name: "droplet-1", Tags: testOwnershipTags},
+		{ID: 2, Name: "droplet-2"},
+		{ID: 3, Name: "droplet-3", Tags: append([]string{protectedTag}, testOwnershipTags...)},
+	}
+	gitdropsDroplets := []gitdrops.Droplet{
+		{Name: "droplet-1", Region: "sfo3"},
+		{Name: "droplet-2", Region: "sfo3"},
+		{Name: "droplet-3", Region: "sfo3"},
+	}
+	dr := newTestDropletReconciler(gitdrops.Privileges{Create: true, Update: true, Delete: true}, nil, activeDroplets, gitdropsDroplets, nil)
+	// a saved plan may replace droplets that have since been protected or are not managed
+	dr.dropletsToUpdate = map[dropletID][]dropletAction{
+		dropletID(1): []dropletAction{{Action: replace, Field: gitdrops.RegionField, Value: "sfo3"}},
+		dropletID(2): []dropletAction{{Action: replace, Field: gitdrops.RegionField, Value: "sfo3"}},
+		dropletID(3): []dropletAction{{Action: replace, Field: gitdrops.RegionField, Value: "sfo3"}},
+	}
+	keys := make([]string, 0)
+	for _, op := range dr.updateOperations() {
+		keys = append(keys, op.key)
+	}
+	expKeys := []string{operationKey(droplet, replace, "1")}
+	if !reflect.DeepEqual(keys, expKeys) {
+		t.Errorf("Failed, expected: %v, got %v", expKeys, keys)
+	}
+}
+
 func TestTranslateDropletCreateRequest(t *testing.T) {
 	tcases := []struct {
 		name                    string
@@ -569,6 +835,30 @@ func TestTranslateDropletCreateRequest(t *testing.T) {
 			},
 			expError: nil,
 		},
+		{
+			name: "test case 4 - backups, ipv6 and monitoring",
+			gitdropsDroplet: gitdrops.Droplet{
+				Name:       "droplet-1",
+				Region:     "nyc3",
+				Image:      "ubuntu",
+				Size:       "1gb",
+				Backups:    true,
+				IPv6:       true,
+				Monitoring: true,
+			},
+			expDropletCreateRequest: &godo.DropletCreateRequest{
+				Region: "nyc3",
+				Name:   "droplet-1",
+				Image: godo.DropletCreateImage{
+					Slug: "ubuntu",
+				},
+				Size:       "1gb",
+				Backups:    true,
+				IPv6:       true,
+				Monitoring: true,
+			},
+			expError: nil,
+		},
 	}
 	for _, tc := range tcases {
 		dr := newTestDropletReconciler(gitdrops.Privileges{}, nil, nil, nil, tc.volumeNameToID)
@@ -583,3 +873,68 @@ func TestTranslateDropletCreateRequest(t *testing.T) {
 		}
 	}
 }
+
+// TestCreatedDropletActions checks that a droplet created from its create request, as DO creates
+// it, is in line with gitdrops.yaml.
+func TestCreatedDropletActions(t *testing.T) {
+	tcases := []struct {
+		name            string
+		gitdropsDroplet gitdrops.Droplet
+	}{
+		{
+			name: "test case 1 - defaults",
+			gitdropsDroplet: gitdrops.Droplet{
+				Name:   "droplet-1",
+				Region: "nyc3",
+				Size:   "s-1vcpu-1gb",
+				Image:  "ubuntu-20-04-x64",
+			},
+		},
+		{
+			name: "test case 2 - backups, ipv6, monitoring, ssh keys and tags",
+			gitdropsDroplet: gitdrops.Droplet{
+				Name:               "droplet-1",
+				Region:             "nyc3",
+				Size:               "s-1vcpu-1gb",
+				Image:              "ubuntu-20-04-x64",
+				Backups:            true,
+				IPv6:               true,
+				Monitoring:         true,
+				SSHKeyFingerprints: []string{"abc"},
+				Tags:               []string{"web"},
+				Protect:            true,
+				ReplaceOnChanges:   []string{gitdrops.MonitoringField, gitdrops.IPv6Field},
+			},
+		},
+	}
+	for _, tc := range tcases {
+		dr := newTestDropletReconciler(gitdrops.Privileges{}, nil, nil, nil, nil)
+		createRequest, err := dr.translateDropletCreateRequest(tc.gitdropsDroplet)
+		if err != nil {
+			t.Errorf("Failed %v, unexpected error %v", tc.name, err)
+			continue
+		}
+		createdDroplet := godo.Droplet{
+			Name:     createRequest.Name,
+			Region:   &godo.Region{Slug: createRequest.Region},
+			Size:     &godo.Size{Slug: createRequest.Size},
+			Image:    &godo.Image{Slug: createRequest.Image.Slug},
+			Status:   dropletActive,
+			Features: []string{},
+			Tags:     withTags(creationTags(createRequest.Tags, testOwnershipTags, tc.gitdropsDroplet.Protect), []string{sshKeysTag(tc.gitdropsDroplet.SSHKeyFingerprints)}),
+		}
+		if createRequest.Backups {
+			createdDroplet.Features = append(createdDroplet.Features, backupsFeature)
+		}
+		if createRequest.IPv6 {
+			createdDroplet.Features = append(createdDroplet.Features, ipv6Feature)
+		}
+		if createRequest.Monitoring {
+			createdDroplet.Features = append(createdDroplet.Features, monitoringFeature)
+		}
+		dropletActions := getDropletActions(tc.gitdropsDroplet, createdDroplet, testOwnershipTags)
+		if len(dropletActions) != 0 {
+			t.Errorf("Failed %v, expected no actions, got %v", tc.name, dropletActions)
+		}
+	}
+}
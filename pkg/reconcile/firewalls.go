@@ -0,0 +1,572 @@
+package reconcile
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nolancon/gitdrops/pkg/gitdrops"
+
+	"github.com/digitalocean/godo"
+)
+
+const (
+	inbound  = "inbound"
+	outbound = "outbound"
+	// allPorts is the ports of a rule applying to all ports. DO reports such rules with ports 0.
+	allPorts = "all"
+)
+
+// firewallReconciler reconciles the firewalls declared in gitdrops.yaml: their rules, the
+// droplets they apply to and the tags of the droplets they apply to. Tagging a DO firewall
+// applies it to the droplets with the tag, so like SSH keys the firewalls created by gitdrops
+// are named with namePrefix followed by the name of the firewall in gitdrops.yaml. A firewall
+// not created by gitdrops that has the name of a declared firewall is only added to, never
+// removed from. Firewalls are never deleted.
+type firewallReconciler struct {
+	privileges    gitdrops.Privileges
+	client        *godo.Client
+	actionTimeout time.Duration
+	namePrefix    string
+	// droplets is the droplet reconciler, which must be planned first. Droplet names are
+	// resolved to the IDs of its active droplets.
+	droplets          *dropletReconciler
+	gitdropsFirewalls []gitdrops.Firewall
+	activeFirewalls   []godo.Firewall
+	firewallsToCreate []gitdrops.Firewall
+	firewallsToUpdate map[firewallID][]firewallAction
+	// mu guards appliedDroplets, the droplets listed when applying to find droplets created
+	// after planning
+	mu              sync.Mutex
+	appliedDroplets []godo.Droplet
+}
+
+var _ objectReconciler = &firewallReconciler{}
+
+// firewallSteps is the serializable form of the firewalls to create and update.
+type firewallSteps struct {
+	Create []gitdrops.Firewall             `json:"create"`
+	Update map[firewallID][]firewallAction `json:"update"`
+}
+
+// firewallNamePrefix returns the prefix of the names of the firewalls created by stack, which is
+// that of the SSH keys it uploads.
+func firewallNamePrefix(stack string) string {
+	return sshKeyNamePrefix(stack)
+}
+
+func (fr *firewallReconciler) getResourceType() string {
+	return firewall
+}
+
+func (fr *firewallReconciler) setActiveObjects(ctx context.Context) error {
+	activeFirewalls, err := gitdrops.ListFirewalls(ctx, fr.client)
+	if err != nil {
+		return fmt.Errorf("firewallReconciler.setActiveObjects: %v", err)
+	}
+	fr.activeFirewalls = activeFirewalls
+	log.Println("firewallReconciler.setActiveObjects: active firewalls", len(fr.activeFirewalls))
+	return nil
+}
+
+// setObjectsToUpdateAndCreate populates firewallReconciler with the firewalls declared in
+// gitdrops.yaml that are not active on DO, and with the actions that bring the active firewalls
+// in line with gitdrops.yaml.
+func (fr *firewallReconciler) setObjectsToUpdateAndCreate() error {
+	firewallsToCreate := make([]gitdrops.Firewall, 0)
+	firewallActionsByID := make(map[firewallID][]firewallAction)
+	for _, gitdropsFirewall := range fr.gitdropsFirewalls {
+		activeFirewall, ok := fr.findActiveFirewall(gitdropsFirewall.Name)
+		if !ok {
+			firewallsToCreate = append(firewallsToCreate, gitdropsFirewall)
+			continue
+		}
+		firewallActions := fr.getFirewallActions(gitdropsFirewall, activeFirewall)
+		if len(firewallActions) != 0 {
+			firewallActionsByID[firewallID(activeFirewall.ID)] = firewallActions
+		}
+	}
+	fr.firewallsToCreate = firewallsToCreate
+	fr.firewallsToUpdate = firewallActionsByID
+	log.Println("firewallReconciler.setObjectsToUpdateAndCreate: firewalls to create", fr.firewallsToCreate)
+	log.Println("firewallReconciler.setObjectsToUpdateAndCreate: firewalls to update", fr.firewallsToUpdate)
+	return nil
+}
+
+// setObjectsToDelete only logs the active firewalls created by gitdrops that are not declared in
+// gitdrops.yaml, firewalls are never deleted.
+func (fr *firewallReconciler) setObjectsToDelete() {
+	for _, activeFirewall := range fr.activeFirewalls {
+		name, ok := trimNamePrefix(activeFirewall.Name, fr.namePrefix)
+		if !ok {
+			continue
+		}
+		if _, ok := fr.findGitdropsFirewall(name); !ok {
+			log.Println("firewallReconciler.setObjectsToDelete: firewall", activeFirewall.Name, "is not declared in gitdrops.yaml, gitdrops does not delete firewalls")
+		}
+	}
+}
+
+func (fr *firewallReconciler) getChanges() []Change {
+	changes := make([]Change, 0)
+	for _, firewallToCreate := range fr.firewallsToCreate {
+		changes = append(changes, Change{Resource: firewall, Action: create, Name: firewallToCreate.Name})
+	}
+	for _, activeFirewall := range fr.activeFirewalls {
+		for _, firewallAction := range fr.firewallsToUpdate[firewallID(activeFirewall.ID)] {
+			changes = append(changes, Change{
+				Resource: firewall,
+				Action:   firewallAction.Action,
+				Name:     activeFirewall.Name,
+				ID:       activeFirewall.ID,
+				Value:    firewallAction.value(),
+			})
+		}
+	}
+	return changes
+}
+
+func (fr *firewallReconciler) getSteps() interface{} {
+	return firewallSteps{
+		Create: fr.firewallsToCreate,
+		Update: fr.firewallsToUpdate,
+	}
+}
+
+func (fr *firewallReconciler) setSteps(stepsJSON json.RawMessage) error {
+	steps := firewallSteps{}
+	err := unmarshalSteps(stepsJSON, &steps)
+	if err != nil {
+		return fmt.Errorf("firewallReconciler.setSteps: %v", err)
+	}
+	if steps.Update == nil {
+		steps.Update = make(map[firewallID][]firewallAction)
+	}
+	fr.firewallsToCreate = steps.Create
+	fr.firewallsToUpdate = steps.Update
+	return nil
+}
+
+// getObservedObjects returns the firewall fields that the firewall reconciler compares or acts
+// on.
+func (fr *firewallReconciler) getObservedObjects() []observedObject {
+	observedObjects := make([]observedObject, 0)
+	for _, activeFirewall := range fr.activeFirewalls {
+		observed := struct {
+			Name          string              `json:"name"`
+			InboundRules  []godo.InboundRule  `json:"inboundRules"`
+			OutboundRules []godo.OutboundRule `json:"outboundRules"`
+			DropletIDs    []int               `json:"dropletIDs"`
+			Tags          []string            `json:"tags"`
+		}{
+			Name:          activeFirewall.Name,
+			InboundRules:  activeFirewall.InboundRules,
+			OutboundRules: activeFirewall.OutboundRules,
+			DropletIDs:    activeFirewall.DropletIDs,
+			Tags:          activeFirewall.Tags,
+		}
+		observedObjects = append(observedObjects, observedObject{
+			id:       activeFirewall.ID,
+			name:     activeFirewall.Name,
+			observed: observed,
+		})
+	}
+	return observedObjects
+}
+
+// countManagedObjects returns 0, firewalls are never deleted.
+func (fr *firewallReconciler) countManagedObjects() int {
+	return 0
+}
+
+// getFirewallActions returns the actions that bring activeFirewall in line with
+// gitdropsFirewall. Droplets that are yet to be created or are replaced are added by name, as
+// their IDs are not known until the droplet is created. Replaced droplets are removed from the
+// firewall by DO when they are deleted. Rules, droplets and tags are only removed from firewalls
+// created by gitdrops.
+func (fr *firewallReconciler) getFirewallActions(gitdropsFirewall gitdrops.Firewall, activeFirewall godo.Firewall) []firewallAction {
+	firewallActions := make([]firewallAction, 0)
+	name, ok := trimNamePrefix(activeFirewall.Name, fr.namePrefix)
+	owned := ok && name == gitdropsFirewall.Name
+	if !owned {
+		log.Println("firewallReconciler.getFirewallActions: firewall", activeFirewall.Name, "was not created by gitdrops, rules, droplets and tags will be added to it but not removed")
+	}
+
+	gitdropsRules := translateFirewallRules(gitdropsFirewall)
+	activeRules := translateActiveFirewallRules(activeFirewall)
+	for _, rule := range gitdropsRules {
+		if !hasRule(activeRules, rule) {
+			firewallActions = append(firewallActions, firewallAction{Action: addRule, Rule: rule})
+		}
+	}
+	for _, rule := range activeRules {
+		if owned && !hasRule(gitdropsRules, rule) {
+			firewallActions = append(firewallActions, firewallAction{Action: removeRule, Rule: rule})
+		}
+	}
+
+	for _, dropletName := range gitdropsFirewall.Droplets {
+		activeDroplet, ok := fr.findActiveDroplet(dropletName)
+		if !ok || fr.droplets.replaces(activeDroplet.ID) {
+			firewallActions = append(firewallActions, firewallAction{Action: addDroplet, Droplet: dropletName})
+			continue
+		}
+		if !hasDropletID(activeFirewall.DropletIDs, activeDroplet.ID) {
+			firewallActions = append(firewallActions, firewallAction{Action: addDroplet, Droplet: dropletName, DropletID: activeDroplet.ID})
+		}
+	}
+	for _, id := range activeFirewall.DropletIDs {
+		dropletName := fr.findDropletName(id)
+		if !owned || fr.droplets.replaces(id) || (dropletName != "" && hasName(gitdropsFirewall.Droplets, dropletName)) {
+			continue
+		}
+		firewallActions = append(firewallActions, firewallAction{Action: removeDroplet, Droplet: dropletName, DropletID: id})
+	}
+
+	for _, tagName := range gitdropsFirewall.Tags {
+		if !hasName(activeFirewall.Tags, tagName) {
+			firewallActions = append(firewallActions, firewallAction{Action: addTag, Tag: tagName})
+		}
+	}
+	for _, tagName := range activeFirewall.Tags {
+		if owned && !hasName(gitdropsFirewall.Tags, tagName) {
+			firewallActions = append(firewallActions, firewallAction{Action: removeTag, Tag: tagName})
+		}
+	}
+	return firewallActions
+}
+
+func (fr *firewallReconciler) reconcileObjectsToCreate() []operation {
+	operations := make([]operation, 0)
+	if len(fr.firewallsToCreate) != 0 {
+		if fr.privileges.Allows(firewall, create) {
+			log.Println("firewallReconciler.reconcileObjectsToCreate: create firewalls", fr.firewallsToCreate)
+			operations = fr.createOperations()
+		} else {
+			log.Println("gitdrops discovered firewalls to create, but does not have create privileges")
+		}
+	}
+	return operations
+}
+
+func (fr *firewallReconciler) reconcileObjectsToUpdate() []operation {
+	operations := make([]operation, 0)
+	if len(fr.firewallsToUpdate) != 0 {
+		log.Println("firewallReconciler.reconcileObjectsToUpdate: update firewalls", fr.firewallsToUpdate)
+		operations = fr.updateOperations()
+	}
+	return operations
+}
+
+// reconcileObjectsToDelete returns no operations, firewalls are never deleted.
+func (fr *firewallReconciler) reconcileObjectsToDelete() []operation {
+	return []operation{}
+}
+
+// createOperations returns an operation per firewall to create, each depending on the creation
+// or replacement of the droplets it applies to.
+func (fr *firewallReconciler) createOperations() []operation {
+	operations := make([]operation, 0)
+	for _, firewallToCreate := range fr.firewallsToCreate {
+		firewallToCreate := firewallToCreate
+		dependsOn := make([]string, 0)
+		for _, dropletName := range firewallToCreate.Droplets {
+			dependsOn = append(dependsOn, fr.dropletDependencies(dropletName)...)
+		}
+		operations = append(operations, operation{
+			key:       operationKey(firewall, create, firewallToCreate.Name),
+			dependsOn: dependsOn,
+			run: func(ctx context.Context) error {
+				return fr.createObject(ctx, firewallToCreate)
+			},
+		})
+	}
+	return operations
+}
+
+func (fr *firewallReconciler) createObject(ctx context.Context, firewallToCreate gitdrops.Firewall) error {
+	dropletIDs := make([]int, 0, len(firewallToCreate.Droplets))
+	for _, dropletName := range firewallToCreate.Droplets {
+		id, err := fr.lockedFindDropletID(ctx, dropletName)
+		if err != nil {
+			return fmt.Errorf("firewallReconciler.createObject: %v", err)
+		}
+		dropletIDs = append(dropletIDs, id)
+	}
+	firewallRulesRequest := translateFirewallRulesRequest(translateFirewallRules(firewallToCreate))
+	_, err := gitdrops.CreateFirewall(ctx, fr.client, &godo.FirewallRequest{
+		Name:          fr.namePrefix + firewallToCreate.Name,
+		InboundRules:  firewallRulesRequest.InboundRules,
+		OutboundRules: firewallRulesRequest.OutboundRules,
+		DropletIDs:    dropletIDs,
+		Tags:          firewallToCreate.Tags,
+	})
+	if err != nil {
+		return fmt.Errorf("firewallReconciler.createObject: %v", err)
+	}
+	return nil
+}
+
+// updateOperations returns an operation per firewall to update, with the actions gitdrops has
+// the privileges for. Droplets added by name are added once they are created or replaced.
+func (fr *firewallReconciler) updateOperations() []operation {
+	ids := make([]string, 0, len(fr.firewallsToUpdate))
+	for id := range fr.firewallsToUpdate {
+		ids = append(ids, string(id))
+	}
+	sort.Strings(ids)
+
+	operations := make([]operation, 0)
+	for _, id := range ids {
+		id := id
+		firewallActions := make([]firewallAction, 0)
+		dependsOn := make([]string, 0)
+		for _, firewallAction := range fr.firewallsToUpdate[firewallID(id)] {
+			if !fr.privileges.Allows(firewall, firewallAction.Action) {
+				log.Printf("gitdrops discovered firewalls to %s, but does not have %s privileges", firewallAction.Action, firewallAction.Action)
+				continue
+			}
+			firewallActions = append(firewallActions, firewallAction)
+			if firewallAction.Action == addDroplet && firewallAction.DropletID == 0 {
+				dependsOn = append(dependsOn, fr.dropletDependencies(firewallAction.Droplet)...)
+			}
+		}
+		if len(firewallActions) == 0 {
+			continue
+		}
+		operations = append(operations, operation{
+			key:       operationKey(firewall, update, id),
+			dependsOn: dependsOn,
+			locks:     []string{lockKey(firewall, id)},
+			run: func(ctx context.Context) error {
+				return fr.updateObject(ctx, id, firewallActions)
+			},
+		})
+	}
+	return operations
+}
+
+// dropletDependencies returns the keys of the operations creating or replacing the droplet
+// named dropletName.
+func (fr *firewallReconciler) dropletDependencies(dropletName string) []string {
+	dependsOn := []string{operationKey(droplet, create, dropletName)}
+	if activeDroplet, ok := fr.findActiveDroplet(dropletName); ok && fr.droplets.replaces(activeDroplet.ID) {
+		dependsOn = append(dependsOn, operationKey(droplet, replace, strconv.Itoa(activeDroplet.ID)))
+	}
+	return dependsOn
+}
+
+func (fr *firewallReconciler) updateObject(ctx context.Context, id string, firewallActions []firewallAction) error {
+	for _, firewallAction := range firewallActions {
+		var err error
+		switch firewallAction.Action {
+		case addRule:
+			err = gitdrops.AddFirewallRules(ctx, fr.client, id, translateFirewallRulesRequest([]firewallRule{firewallAction.Rule}))
+		case removeRule:
+			err = gitdrops.RemoveFirewallRules(ctx, fr.client, id, translateFirewallRulesRequest([]firewallRule{firewallAction.Rule}))
+		case addDroplet:
+			addedDropletID := firewallAction.DropletID
+			if addedDropletID == 0 {
+				// the droplet was created or replaced after planning
+				addedDropletID, err = fr.lockedFindDropletID(ctx, firewallAction.Droplet)
+				if err != nil {
+					break
+				}
+			}
+			err = gitdrops.AddFirewallDroplets(ctx, fr.client, id, addedDropletID)
+		case removeDroplet:
+			err = gitdrops.RemoveFirewallDroplets(ctx, fr.client, id, firewallAction.DropletID)
+		case addTag:
+			err = gitdrops.AddFirewallTags(ctx, fr.client, id, firewallAction.Tag)
+		case removeTag:
+			err = gitdrops.RemoveFirewallTags(ctx, fr.client, id, firewallAction.Tag)
+		default:
+			err = fmt.Errorf("unknown action %q", firewallAction.Action)
+		}
+		if err != nil {
+			return fmt.Errorf("firewallReconciler.updateObject: %v", err)
+		}
+	}
+	return nil
+}
+
+// lockedFindDropletID returns the ID of the droplet named name while holding fr.mu, listing the
+// droplets on DO again if it is not yet known. Droplets replaced by the droplet reconciler are
+// ignored, so that the ID is that of the new droplet.
+func (fr *firewallReconciler) lockedFindDropletID(ctx context.Context, name string) (int, error) {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+	if id, ok := fr.findAppliedDropletID(name); ok {
+		return id, nil
+	}
+	appliedDroplets, err := gitdrops.ListDroplets(ctx, fr.client)
+	if err != nil {
+		return 0, err
+	}
+	fr.appliedDroplets = appliedDroplets
+	if id, ok := fr.findAppliedDropletID(name); ok {
+		return id, nil
+	}
+	return 0, fmt.Errorf("droplet %q not found", name)
+}
+
+func (fr *firewallReconciler) findAppliedDropletID(name string) (int, bool) {
+	for _, appliedDroplet := range fr.appliedDroplets {
+		if appliedDroplet.Name == name && !fr.droplets.replaces(appliedDroplet.ID) {
+			return appliedDroplet.ID, true
+		}
+	}
+	return 0, false
+}
+
+// findActiveFirewall returns the firewall created by gitdrops for the firewall named name in
+// gitdrops.yaml or, if there is none, a firewall not created by gitdrops named name.
+func (fr *firewallReconciler) findActiveFirewall(name string) (godo.Firewall, bool) {
+	for _, activeFirewall := range fr.activeFirewalls {
+		if activeFirewall.Name == fr.namePrefix+name {
+			return activeFirewall, true
+		}
+	}
+	for _, activeFirewall := range fr.activeFirewalls {
+		if activeFirewall.Name == name {
+			return activeFirewall, true
+		}
+	}
+	return godo.Firewall{}, false
+}
+
+func (fr *firewallReconciler) findGitdropsFirewall(name string) (gitdrops.Firewall, bool) {
+	for _, gitdropsFirewall := range fr.gitdropsFirewalls {
+		if gitdropsFirewall.Name == name {
+			return gitdropsFirewall, true
+		}
+	}
+	return gitdrops.Firewall{}, false
+}
+
+func (fr *firewallReconciler) findActiveDroplet(name string) (godo.Droplet, bool) {
+	for _, activeDroplet := range fr.droplets.activeDroplets {
+		if activeDroplet.Name == name {
+			return activeDroplet, true
+		}
+	}
+	return godo.Droplet{}, false
+}
+
+func (fr *firewallReconciler) findDropletName(id int) string {
+	for _, activeDroplet := range fr.droplets.activeDroplets {
+		if activeDroplet.ID == id {
+			return activeDroplet.Name
+		}
+	}
+	return ""
+}
+
+// translateFirewallRules returns the inbound and outbound rules of gitdropsFirewall in the form
+// they are compared with the rules of an active firewall.
+func translateFirewallRules(gitdropsFirewall gitdrops.Firewall) []firewallRule {
+	rules := make([]firewallRule, 0, len(gitdropsFirewall.InboundRules)+len(gitdropsFirewall.OutboundRules))
+	for _, rule := range gitdropsFirewall.InboundRules {
+		rules = append(rules, normalizeFirewallRule(inbound, rule))
+	}
+	for _, rule := range gitdropsFirewall.OutboundRules {
+		rules = append(rules, normalizeFirewallRule(outbound, rule))
+	}
+	return rules
+}
+
+// translateActiveFirewallRules is the reverse of translateFirewallRulesRequest. Droplet and load
+// balancer sources and destinations cannot be declared in gitdrops.yaml and are ignored.
+func translateActiveFirewallRules(activeFirewall godo.Firewall) []firewallRule {
+	rules := make([]firewallRule, 0, len(activeFirewall.InboundRules)+len(activeFirewall.OutboundRules))
+	for _, inboundRule := range activeFirewall.InboundRules {
+		rule := gitdrops.FirewallRule{Protocol: inboundRule.Protocol, Ports: inboundRule.PortRange}
+		if inboundRule.Sources != nil {
+			rule.Addresses = inboundRule.Sources.Addresses
+			rule.Tags = inboundRule.Sources.Tags
+		}
+		rules = append(rules, normalizeFirewallRule(inbound, rule))
+	}
+	for _, outboundRule := range activeFirewall.OutboundRules {
+		rule := gitdrops.FirewallRule{Protocol: outboundRule.Protocol, Ports: outboundRule.PortRange}
+		if outboundRule.Destinations != nil {
+			rule.Addresses = outboundRule.Destinations.Addresses
+			rule.Tags = outboundRule.Destinations.Tags
+		}
+		rules = append(rules, normalizeFirewallRule(outbound, rule))
+	}
+	return rules
+}
+
+// normalizeFirewallRule returns rule in the form DO reports it: addresses and tags are sorted,
+// icmp rules have no ports and other rules applying to all ports have ports all.
+func normalizeFirewallRule(direction string, rule gitdrops.FirewallRule) firewallRule {
+	normalized := gitdrops.FirewallRule{Protocol: rule.Protocol, Ports: rule.Ports}
+	if normalized.Protocol == "icmp" {
+		normalized.Ports = ""
+	} else if normalized.Ports == "0" || normalized.Ports == "" {
+		normalized.Ports = allPorts
+	}
+	if len(rule.Addresses) != 0 {
+		normalized.Addresses = append([]string{}, rule.Addresses...)
+		sort.Strings(normalized.Addresses)
+	}
+	if len(rule.Tags) != 0 {
+		normalized.Tags = append([]string{}, rule.Tags...)
+		sort.Strings(normalized.Tags)
+	}
+	return firewallRule{Direction: direction, Rule: normalized}
+}
+
+func translateFirewallRulesRequest(rules []firewallRule) *godo.FirewallRulesRequest {
+	firewallRulesRequest := &godo.FirewallRulesRequest{}
+	for _, rule := range rules {
+		switch rule.Direction {
+		case inbound:
+			firewallRulesRequest.InboundRules = append(firewallRulesRequest.InboundRules, godo.InboundRule{
+				Protocol:  rule.Rule.Protocol,
+				PortRange: rule.Rule.Ports,
+				Sources:   &godo.Sources{Addresses: rule.Rule.Addresses, Tags: rule.Rule.Tags},
+			})
+		case outbound:
+			firewallRulesRequest.OutboundRules = append(firewallRulesRequest.OutboundRules, godo.OutboundRule{
+				Protocol:     rule.Rule.Protocol,
+				PortRange:    rule.Rule.Ports,
+				Destinations: &godo.Destinations{Addresses: rule.Rule.Addresses, Tags: rule.Rule.Tags},
+			})
+		}
+	}
+	return firewallRulesRequest
+}
+
+func hasRule(rules []firewallRule, rule firewallRule) bool {
+	for _, r := range rules {
+		if r.String() == rule.String() {
+			return true
+		}
+	}
+	return false
+}
+
+func hasDropletID(ids []int, id int) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
+
+func hasName(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
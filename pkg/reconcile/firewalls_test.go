@@ -0,0 +1,531 @@
+package reconcile
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/nolancon/gitdrops/pkg/gitdrops"
+
+	"github.com/digitalocean/godo"
+)
+
+func newTestFirewallReconciler(privileges gitdrops.Privileges, droplets *dropletReconciler, activeFirewalls []godo.Firewall, gitdropsFirewalls []gitdrops.Firewall) *firewallReconciler {
+	return &firewallReconciler{
+		privileges:        privileges,
+		droplets:          droplets,
+		namePrefix:        firewallNamePrefix(""),
+		activeFirewalls:   activeFirewalls,
+		gitdropsFirewalls: gitdropsFirewalls,
+	}
+}
+
+func TestSetFirewallsToUpdateCreate(t *testing.T) {
+	sshRule := gitdrops.FirewallRule{
+		Protocol:  "tcp",
+		Ports:     "22",
+		Addresses: []string{"0.0.0.0/0", "::/0"},
+	}
+	tcases := []struct {
+		name              string
+		activeDroplets    []godo.Droplet
+		gitdropsDroplets  []gitdrops.Droplet
+		activeFirewalls   []godo.Firewall
+		gitdropsFirewalls []gitdrops.Firewall
+		firewallsToCreate []gitdrops.Firewall
+		firewallsToUpdate map[firewallID][]firewallAction
+	}{
+		{
+			name: "test case 1 - create firewall",
+			gitdropsFirewalls: []gitdrops.Firewall{
+				{
+					Name:         "firewall-1",
+					InboundRules: []gitdrops.FirewallRule{sshRule},
+				},
+			},
+			firewallsToCreate: []gitdrops.Firewall{
+				{
+					Name:         "firewall-1",
+					InboundRules: []gitdrops.FirewallRule{sshRule},
+				},
+			},
+			firewallsToUpdate: map[firewallID][]firewallAction{},
+		},
+		{
+			name: "test case 2 - no change",
+			activeDroplets: []godo.Droplet{
+				{
+					ID:   1,
+					Name: "droplet-1",
+				},
+			},
+			gitdropsDroplets: []gitdrops.Droplet{
+				{
+					Name: "droplet-1",
+				},
+			},
+			activeFirewalls: []godo.Firewall{
+				{
+					ID:   "abc",
+					Name: "firewall-1",
+					InboundRules: []godo.InboundRule{
+						{
+							Protocol:  "tcp",
+							PortRange: "22",
+							Sources:   &godo.Sources{Addresses: []string{"::/0", "0.0.0.0/0"}},
+						},
+					},
+					OutboundRules: []godo.OutboundRule{
+						{
+							Protocol:     "icmp",
+							PortRange:    "0",
+							Destinations: &godo.Destinations{Addresses: []string{"0.0.0.0/0"}},
+						},
+					},
+					DropletIDs: []int{1},
+					Tags:       []string{"web"},
+				},
+			},
+			gitdropsFirewalls: []gitdrops.Firewall{
+				{
+					Name:         "firewall-1",
+					InboundRules: []gitdrops.FirewallRule{sshRule},
+					OutboundRules: []gitdrops.FirewallRule{
+						{
+							Protocol:  "icmp",
+							Addresses: []string{"0.0.0.0/0"},
+						},
+					},
+					Droplets: []string{"droplet-1"},
+					Tags:     []string{"web"},
+				},
+			},
+			firewallsToCreate: []gitdrops.Firewall{},
+			firewallsToUpdate: map[firewallID][]firewallAction{},
+		},
+		{
+			name: "test case 3 - update rules, droplets and tags",
+			activeDroplets: []godo.Droplet{
+				{
+					ID:   1,
+					Name: "droplet-1",
+				},
+				{
+					ID:   2,
+					Name: "droplet-2",
+				},
+			},
+			gitdropsDroplets: []gitdrops.Droplet{
+				{
+					Name: "droplet-1",
+				},
+				{
+					Name: "droplet-2",
+				},
+				{
+					Name: "droplet-3",
+				},
+			},
+			activeFirewalls: []godo.Firewall{
+				{
+					ID:   "abc",
+					Name: "gitdrops-default-firewall-1",
+					InboundRules: []godo.InboundRule{
+						{
+							Protocol:  "tcp",
+							PortRange: "80",
+							Sources:   &godo.Sources{Addresses: []string{"0.0.0.0/0"}},
+						},
+					},
+					DropletIDs: []int{1},
+					Tags:       []string{"web"},
+				},
+			},
+			gitdropsFirewalls: []gitdrops.Firewall{
+				{
+					Name:         "firewall-1",
+					InboundRules: []gitdrops.FirewallRule{sshRule},
+					OutboundRules: []gitdrops.FirewallRule{
+						{
+							Protocol: "udp",
+							Ports:    "all",
+							Tags:     []string{"db"},
+						},
+					},
+					Droplets: []string{"droplet-2", "droplet-3"},
+					Tags:     []string{"app"},
+				},
+			},
+			firewallsToCreate: []gitdrops.Firewall{},
+			firewallsToUpdate: map[firewallID][]firewallAction{
+				firewallID("abc"): []firewallAction{
+					{
+						Action: addRule,
+						Rule:   firewallRule{Direction: "inbound", Rule: sshRule},
+					},
+					{
+						Action: addRule,
+						Rule: firewallRule{Direction: "outbound", Rule: gitdrops.FirewallRule{
+							Protocol: "udp",
+							Ports:    "all",
+							Tags:     []string{"db"},
+						}},
+					},
+					{
+						Action: removeRule,
+						Rule: firewallRule{Direction: "inbound", Rule: gitdrops.FirewallRule{
+							Protocol:  "tcp",
+							Ports:     "80",
+							Addresses: []string{"0.0.0.0/0"},
+						}},
+					},
+					{
+						Action:    addDroplet,
+						Droplet:   "droplet-2",
+						DropletID: 2,
+					},
+					{
+						Action:  addDroplet,
+						Droplet: "droplet-3",
+					},
+					{
+						Action:    removeDroplet,
+						Droplet:   "droplet-1",
+						DropletID: 1,
+					},
+					{
+						Action: addTag,
+						Tag:    "app",
+					},
+					{
+						Action: removeTag,
+						Tag:    "web",
+					},
+				},
+			},
+		},
+		{
+			name: "test case 4 - replaced droplet is added by name",
+			activeDroplets: []godo.Droplet{
+				{
+					ID:     1,
+					Name:   "droplet-1",
+					Region: &godo.Region{Slug: "nyc3"},
+					Tags:   testOwnershipTags,
+				},
+			},
+			gitdropsDroplets: []gitdrops.Droplet{
+				{
+					Name:             "droplet-1",
+					Region:           "sfo3",
+					ReplaceOnChanges: []string{gitdrops.RegionField},
+				},
+			},
+			activeFirewalls: []godo.Firewall{
+				{
+					ID:   "abc",
+					Name: "firewall-1",
+					InboundRules: []godo.InboundRule{
+						{
+							Protocol:  "tcp",
+							PortRange: "22",
+							Sources:   &godo.Sources{Addresses: []string{"0.0.0.0/0", "::/0"}},
+						},
+					},
+					DropletIDs: []int{1},
+				},
+			},
+			gitdropsFirewalls: []gitdrops.Firewall{
+				{
+					Name:         "firewall-1",
+					InboundRules: []gitdrops.FirewallRule{sshRule},
+					Droplets:     []string{"droplet-1"},
+				},
+			},
+			firewallsToCreate: []gitdrops.Firewall{},
+			firewallsToUpdate: map[firewallID][]firewallAction{
+				firewallID("abc"): []firewallAction{
+					{
+						Action:  addDroplet,
+						Droplet: "droplet-1",
+					},
+				},
+			},
+		},
+		{
+			name: "test case 5 - firewall not created by gitdrops is only added to",
+			activeDroplets: []godo.Droplet{
+				{
+					ID:   1,
+					Name: "droplet-1",
+				},
+				{
+					ID:   2,
+					Name: "droplet-2",
+				},
+			},
+			gitdropsDroplets: []gitdrops.Droplet{
+				{
+					Name: "droplet-1",
+				},
+				{
+					Name: "droplet-2",
+				},
+				{
+					Name: "droplet-3",
+				},
+			},
+			activeFirewalls: []godo.Firewall{
+				{
+					ID:   "abc",
+					Name: "firewall-1",
+					InboundRules: []godo.InboundRule{
+						{
+							Protocol:  "tcp",
+							PortRange: "80",
+							Sources:   &godo.Sources{Addresses: []string{"0.0.0.0/0"}},
+						},
+					},
+					DropletIDs: []int{1},
+					Tags:       []string{"web"},
+				},
+			},
+			gitdropsFirewalls: []gitdrops.Firewall{
+				{
+					Name:         "firewall-1",
+					InboundRules: []gitdrops.FirewallRule{sshRule},
+					OutboundRules: []gitdrops.FirewallRule{
+						{
+							Protocol: "udp",
+							Ports:    "all",
+							Tags:     []string{"db"},
+						},
+					},
+					Droplets: []string{"droplet-2", "droplet-3"},
+					Tags:     []string{"app"},
+				},
+			},
+			firewallsToCreate: []gitdrops.Firewall{},
+			firewallsToUpdate: map[firewallID][]firewallAction{
+				firewallID("abc"): []firewallAction{
+					{
+						Action: addRule,
+						Rule:   firewallRule{Direction: "inbound", Rule: sshRule},
+					},
+					{
+						Action: addRule,
+						Rule: firewallRule{Direction: "outbound", Rule: gitdrops.FirewallRule{
+							Protocol: "udp",
+							Ports:    "all",
+							Tags:     []string{"db"},
+						}},
+					},
+					{
+						Action:    addDroplet,
+						Droplet:   "droplet-2",
+						DropletID: 2,
+					},
+					{
+						Action:  addDroplet,
+						Droplet: "droplet-3",
+					},
+					{
+						Action: addTag,
+						Tag:    "app",
+					},
+				},
+			},
+		},
+	}
+	for _, tc := range tcases {
+		dr := newTestDropletReconciler(gitdrops.Privileges{}, nil, tc.activeDroplets, tc.gitdropsDroplets, nil)
+		dr.setObjectsToUpdateAndCreate()
+		fr := newTestFirewallReconciler(gitdrops.Privileges{}, dr, tc.activeFirewalls, tc.gitdropsFirewalls)
+		fr.setObjectsToUpdateAndCreate()
+		if !reflect.DeepEqual(fr.firewallsToCreate, tc.firewallsToCreate) {
+			t.Errorf("FirewallsToCreate - Failed %v, expected: %v, got %v", tc.name, tc.firewallsToCreate, fr.firewallsToCreate)
+		}
+		if !reflect.DeepEqual(fr.firewallsToUpdate, tc.firewallsToUpdate) {
+			t.Errorf("FirewallsToUpdate - Failed %v, expected: %v, got %v", tc.name, tc.firewallsToUpdate, fr.firewallsToUpdate)
+		}
+	}
+}
+
+func TestFirewallStacks(t *testing.T) {
+	sshRule := gitdrops.FirewallRule{
+		Protocol:  "tcp",
+		Ports:     "22",
+		Addresses: []string{"0.0.0.0/0"},
+	}
+	httpRule := gitdrops.FirewallRule{
+		Protocol:  "tcp",
+		Ports:     "80",
+		Addresses: []string{"0.0.0.0/0"},
+	}
+	httpInboundRule := godo.InboundRule{
+		Protocol:  "tcp",
+		PortRange: "80",
+		Sources:   &godo.Sources{Addresses: []string{"0.0.0.0/0"}},
+	}
+	activeFirewalls := []godo.Firewall{
+		{
+			ID:           "prod",
+			Name:         "gitdrops-prod-web",
+			InboundRules: []godo.InboundRule{httpInboundRule},
+		},
+		{
+			ID:           "prod_eu",
+			Name:         "gitdrops-prod_eu-web",
+			InboundRules: []godo.InboundRule{httpInboundRule},
+		},
+	}
+	tcases := []struct {
+		name              string
+		stack             string
+		gitdropsFirewalls []gitdrops.Firewall
+		firewallsToUpdate map[firewallID][]firewallAction
+	}{
+		{
+			name:  "test case 1 - stack prod",
+			stack: "prod",
+			gitdropsFirewalls: []gitdrops.Firewall{
+				{
+					Name:         "web",
+					InboundRules: []gitdrops.FirewallRule{sshRule},
+				},
+			},
+			firewallsToUpdate: map[firewallID][]firewallAction{
+				firewallID("prod"): []firewallAction{
+					{
+						Action: addRule,
+						Rule:   firewallRule{Direction: "inbound", Rule: sshRule},
+					},
+					{
+						Action: removeRule,
+						Rule:   firewallRule{Direction: "inbound", Rule: httpRule},
+					},
+				},
+			},
+		},
+		{
+			name:  "test case 2 - stack prod_eu",
+			stack: "prod_eu",
+			gitdropsFirewalls: []gitdrops.Firewall{
+				{
+					Name:         "web",
+					InboundRules: []gitdrops.FirewallRule{sshRule},
+				},
+			},
+			firewallsToUpdate: map[firewallID][]firewallAction{
+				firewallID("prod_eu"): []firewallAction{
+					{
+						Action: addRule,
+						Rule:   firewallRule{Direction: "inbound", Rule: sshRule},
+					},
+					{
+						Action: removeRule,
+						Rule:   firewallRule{Direction: "inbound", Rule: httpRule},
+					},
+				},
+			},
+		},
+		{
+			name:  "test case 3 - firewall of another stack is only added to",
+			stack: "prod",
+			gitdropsFirewalls: []gitdrops.Firewall{
+				{
+					Name:         "gitdrops-prod_eu-web",
+					InboundRules: []gitdrops.FirewallRule{sshRule},
+				},
+			},
+			firewallsToUpdate: map[firewallID][]firewallAction{
+				firewallID("prod_eu"): []firewallAction{
+					{
+						Action: addRule,
+						Rule:   firewallRule{Direction: "inbound", Rule: sshRule},
+					},
+				},
+			},
+		},
+		{
+			name:  "test case 4 - firewall named like one of the stack is only added to",
+			stack: "prod",
+			gitdropsFirewalls: []gitdrops.Firewall{
+				{
+					Name:         "gitdrops-prod-web",
+					InboundRules: []gitdrops.FirewallRule{sshRule},
+				},
+			},
+			firewallsToUpdate: map[firewallID][]firewallAction{
+				firewallID("prod"): []firewallAction{
+					{
+						Action: addRule,
+						Rule:   firewallRule{Direction: "inbound", Rule: sshRule},
+					},
+				},
+			},
+		},
+	}
+	for _, tc := range tcases {
+		dr := newTestDropletReconciler(gitdrops.Privileges{}, nil, nil, nil, nil)
+		dr.setObjectsToUpdateAndCreate()
+		fr := newTestFirewallReconciler(gitdrops.Privileges{}, dr, activeFirewalls, tc.gitdropsFirewalls)
+		fr.namePrefix = firewallNamePrefix(tc.stack)
+		fr.setObjectsToUpdateAndCreate()
+		if !reflect.DeepEqual(fr.firewallsToUpdate, tc.firewallsToUpdate) {
+			t.Errorf("FirewallsToUpdate - Failed %v, expected: %v, got %v", tc.name, tc.firewallsToUpdate, fr.firewallsToUpdate)
+		}
+	}
+}
+
+func TestFirewallSteps(t *testing.T) {
+	dr := newTestDropletReconciler(gitdrops.Privileges{}, nil, nil, nil, nil)
+	fr := newTestFirewallReconciler(gitdrops.Privileges{}, dr, nil, nil)
+	fr.firewallsToCreate = []gitdrops.Firewall{
+		{
+			Name: "firewall-2",
+			InboundRules: []gitdrops.FirewallRule{
+				{
+					Protocol:  "tcp",
+					Ports:     "443",
+					Addresses: []string{"0.0.0.0/0"},
+				},
+			},
+			Droplets: []string{"droplet-1"},
+		},
+	}
+	fr.firewallsToUpdate = map[firewallID][]firewallAction{
+		firewallID("abc"): []firewallAction{
+			{
+				Action: removeRule,
+				Rule: firewallRule{Direction: "outbound", Rule: gitdrops.FirewallRule{
+					Protocol: "icmp",
+					Tags:     []string{"web"},
+				}},
+			},
+			{
+				Action:  addDroplet,
+				Droplet: "droplet-1",
+			},
+			{
+				Action: removeTag,
+				Tag:    "web",
+			},
+		},
+	}
+
+	steps, err := marshalSteps(fr)
+	if err != nil {
+		t.Fatalf("Failed, unexpected error %v", err)
+	}
+	loaded := newTestFirewallReconciler(gitdrops.Privileges{}, dr, nil, nil)
+	err = loaded.setSteps(steps)
+	if err != nil {
+		t.Fatalf("Failed, unexpected error %v", err)
+	}
+	if !reflect.DeepEqual(loaded.firewallsToCreate, fr.firewallsToCreate) {
+		t.Errorf("FirewallsToCreate - Failed, expected: %v, got %v", fr.firewallsToCreate, loaded.firewallsToCreate)
+	}
+	if !reflect.DeepEqual(loaded.firewallsToUpdate, fr.firewallsToUpdate) {
+		t.Errorf("FirewallsToUpdate - Failed, expected: %v, got %v", fr.firewallsToUpdate, loaded.firewallsToUpdate)
+	}
+}
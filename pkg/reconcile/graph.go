@@ -0,0 +1,237 @@
+package reconcile
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/nolancon/gitdrops/pkg/gitdrops"
+)
+
+// operation is a single step of Apply, eg creating a volume or resizing a droplet.
+type operation struct {
+	// key identifies the operation so that other operations can depend on it, see operationKey.
+	key string
+	// dependsOn are the keys of operations that must complete before this operation runs.
+	// Keys of operations that are not part of the graph are ignored, which allows a reconciler
+	// to declare dependencies without knowing which changes other reconcilers have planned.
+	dependsOn []string
+	// locks are the objects the operation acts on, see lockKey. Operations sharing a lock are
+	// never run concurrently, eg DO rejects concurrent actions on the same droplet.
+	locks []string
+	run   func(context.Context) error
+}
+
+// operationResult is the outcome of an executed operation.
+type operationResult struct {
+	key string
+	err error
+	// attempts is the number of DO requests made by the operation, including retries
+	attempts int
+	// snapshots are the IDs of the snapshots taken by the operation, see snapshotPolicy
+	snapshots []string
+}
+
+// operationKey returns the key of the operation performing action on the object of type resource
+// identified by id. id is the name of objects that are yet to be created.
+func operationKey(resource, action, id string) string {
+	return resource + "/" + action + "/" + id
+}
+
+// splitOperationKey returns the resource, action and id of an operation key.
+func splitOperationKey(key string) (string, string, string) {
+	parts := strings.SplitN(key, "/", 3)
+	if len(parts) != 3 {
+		return "", "", key
+	}
+	return parts[0], parts[1], parts[2]
+}
+
+// lockKey returns the lock of the object of type resource identified by id.
+func lockKey(resource, id string) string {
+	return resource + "/" + id
+}
+
+// graph is a directed acyclic graph of operations, executed in topological order.
+type graph struct {
+	operations []operation
+}
+
+func (g *graph) add(operations ...operation) {
+	g.operations = append(g.operations, operations...)
+}
+
+// order returns the operations in topological order. Operations that are not ordered by a
+// dependency keep the order in which they were added, so that execution is deterministic.
+func (g *graph) order() ([]operation, error) {
+	// pending counts the operations not yet ordered per key, an operation is ready once
+	// pending is 0 for all of its dependencies.
+	pending := make(map[string]int)
+	for _, op := range g.operations {
+		pending[op.key]++
+	}
+	ready := func(op operation) bool {
+		for _, dependency := range op.dependsOn {
+			if dependency != op.key && pending[dependency] != 0 {
+				return false
+			}
+		}
+		return true
+	}
+
+	ordered := make([]operation, 0, len(g.operations))
+	remaining := g.operations
+	for len(remaining) != 0 {
+		next := make([]operation, 0, len(remaining))
+		for _, op := range remaining {
+			if ready(op) {
+				ordered = append(ordered, op)
+				pending[op.key]--
+			} else {
+				next = append(next, op)
+			}
+		}
+		if len(next) == len(remaining) {
+			keys := make([]string, 0, len(next))
+			for _, op := range next {
+				keys = append(keys, op.key)
+			}
+			return nil, fmt.Errorf("graph.order: dependency cycle between %s", strings.Join(keys, ", "))
+		}
+		remaining = next
+	}
+	return ordered, nil
+}
+
+// execute runs up to concurrency operations at a time. An operation is started once all of its
+// dependencies have completed and none of its locks are held by a running operation. Ready
+// operations are started in topological order, so a concurrency of 1 executes the graph
+// sequentially in that order. After the first error no further operations are started, but
+// running operations are allowed to finish. If continueOnError is true, only operations that
+// depend on a failed operation are skipped, every other operation is attempted and the error
+// returned is that of the first failure. The results of all executed and skipped operations are
+// returned.
+func (g *graph) execute(ctx context.Context, concurrency int, continueOnError bool) ([]operationResult, error) {
+	ordered, err := g.order()
+	if err != nil {
+		return nil, fmt.Errorf("graph.execute: %v", err)
+	}
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	pending := make(map[string]int)
+	for _, op := range ordered {
+		pending[op.key]++
+	}
+	locked := make(map[string]bool)
+	failed := make(map[string]bool)
+	failedDependency := func(op operation) string {
+		for _, dependency := range op.dependsOn {
+			if dependency != op.key && failed[dependency] {
+				return dependency
+			}
+		}
+		return ""
+	}
+	canStart := func(op operation) bool {
+		for _, dependency := range op.dependsOn {
+			if dependency != op.key && pending[dependency] != 0 {
+				return false
+			}
+		}
+		for _, lock := range op.locks {
+			if locked[lock] {
+				return false
+			}
+		}
+		return true
+	}
+
+	type finished struct {
+		index     int
+		err       error
+		attempts  int
+		snapshots []string
+	}
+	done := make(chan finished)
+	started := make([]bool, len(ordered))
+	results := make([]operationResult, 0, len(ordered))
+	running := 0
+	var firstErr error
+	for {
+		for i, op := range ordered {
+			if (firstErr != nil && !continueOnError) || running == concurrency {
+				break
+			}
+			if started[i] || !canStart(op) {
+				continue
+			}
+			started[i] = true
+			if dependency := failedDependency(op); dependency != "" {
+				log.Println("graph.execute: skipping", op.key, "as", dependency, "failed")
+				failed[op.key] = true
+				pending[op.key]--
+				results = append(results, operationResult{key: op.key, err: fmt.Errorf("skipped as %s failed", dependency)})
+				continue
+			}
+			running++
+			for _, lock := range op.locks {
+				locked[lock] = true
+			}
+			log.Println("graph.execute: running", op.key)
+			go func(i int, op operation) {
+				opCtx, attempts := gitdrops.CountAttempts(ctx)
+				opCtx, snapshots := recordSnapshots(opCtx)
+				err := op.run(opCtx)
+				done <- finished{index: i, err: err, attempts: attempts(), snapshots: snapshots()}
+			}(i, op)
+		}
+		if running == 0 {
+			break
+		}
+
+		result := <-done
+		running--
+		op := ordered[result.index]
+		for _, lock := range op.locks {
+			delete(locked, lock)
+		}
+		pending[op.key]--
+		results = append(results, operationResult{key: op.key, err: result.err, attempts: result.attempts, snapshots: result.snapshots})
+		if result.err != nil {
+			failed[op.key] = true
+			if firstErr == nil {
+				firstErr = fmt.Errorf("graph.execute: %s: %v", op.key, result.err)
+			}
+		}
+	}
+	return results, firstErr
+}
+
+// summarize logs the results of executed operations grouped by the object they acted on.
+func summarize(results []operationResult) {
+	objects := make([]string, 0)
+	resultsByObject := make(map[string][]string)
+	for _, result := range results {
+		resource, action, id := splitOperationKey(result.key)
+		object := strings.TrimSpace(resource + " " + id)
+		outcome := strings.TrimSpace(action + " succeeded")
+		if result.err != nil {
+			outcome = strings.Replace(outcome, "succeeded", "failed: "+result.err.Error(), 1)
+		}
+		if len(result.snapshots) != 0 {
+			outcome += " (snapshot " + strings.Join(result.snapshots, ", ") + ")"
+		}
+		if _, ok := resultsByObject[object]; !ok {
+			objects = append(objects, object)
+		}
+		resultsByObject[object] = append(resultsByObject[object], outcome)
+	}
+	sort.Strings(objects)
+	for _, object := range objects {
+		log.Printf("summarize: %s: %s", object, strings.Join(resultsByObject[object], ", "))
+	}
+}
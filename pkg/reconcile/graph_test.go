@@ -0,0 +1,574 @@
+package reconcile
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nolancon/gitdrops/pkg/gitdrops"
+
+	"github.com/digitalocean/godo"
+)
+
+func newTestOperation(key string, dependsOn ...string) operation {
+	return operation{
+		key:       key,
+		dependsOn: dependsOn,
+		run: func(context.Context) error {
+			return nil
+		},
+	}
+}
+
+func operationKeys(operations []operation) []string {
+	keys := make([]string, 0, len(operations))
+	for _, op := range operations {
+		keys = append(keys, op.key)
+	}
+	return keys
+}
+
+func TestGraphOrder(t *testing.T) {
+	tcases := []struct {
+		name       string
+		operations []operation
+		expKeys    []string
+		expError   bool
+	}{
+		{
+			name: "test case 1 - no dependencies keeps insertion order",
+			operations: []operation{
+				newTestOperation("a"),
+				newTestOperation("b"),
+				newTestOperation("c"),
+			},
+			expKeys: []string{"a", "b", "c"},
+		},
+		{
+			name: "test case 2 - dependencies",
+			operations: []operation{
+				newTestOperation("a", "c"),
+				newTestOperation("b"),
+				newTestOperation("c", "b"),
+			},
+			expKeys: []string{"b", "c", "a"},
+		},
+		{
+			name: "test case 3 - missing dependencies are ignored",
+			operations: []operation{
+				newTestOperation("a", "x"),
+				newTestOperation("b", "a", "y"),
+			},
+			expKeys: []string{"a", "b"},
+		},
+		{
+			name: "test case 4 - cycle",
+			operations: []operation{
+				newTestOperation("a", "b"),
+				newTestOperation("b", "a"),
+				newTestOperation("c"),
+			},
+			expError: true,
+		},
+	}
+	for _, tc := range tcases {
+		g := graph{}
+		g.add(tc.operations...)
+		ordered, err := g.order()
+		if (err != nil) != tc.expError {
+			t.Errorf("Failed %v, expected error: %v, got error %v", tc.name, tc.expError, err)
+			continue
+		}
+		if err == nil && !reflect.DeepEqual(operationKeys(ordered), tc.expKeys) {
+			t.Errorf("Failed %v, expected: %v, got %v", tc.name, tc.expKeys, operationKeys(ordered))
+		}
+	}
+}
+
+// executionTracker records the operations and locks in use while a graph is executed.
+type executionTracker struct {
+	mu            sync.Mutex
+	running       int
+	maxRunning    int
+	locked        map[string]bool
+	overlaps      []string
+	completed     []string
+	dependencyErr []string
+}
+
+// newTrackedOperation returns an operation recording its execution in tracker. The operation
+// fails if failErr is not nil.
+func (tracker *executionTracker) newTrackedOperation(key string, locks []string, failErr error, dependsOn ...string) operation {
+	return operation{
+		key:       key,
+		dependsOn: dependsOn,
+		locks:     locks,
+		run: func(context.Context) error {
+			tracker.mu.Lock()
+			for _, dependency := range dependsOn {
+				if !containsString(tracker.completed, dependency) {
+					tracker.dependencyErr = append(tracker.dependencyErr, key)
+				}
+			}
+			for _, lock := range locks {
+				if tracker.locked[lock] {
+					tracker.overlaps = append(tracker.overlaps, key)
+				}
+				tracker.locked[lock] = true
+			}
+			tracker.running++
+			if tracker.running > tracker.maxRunning {
+				tracker.maxRunning = tracker.running
+			}
+			tracker.mu.Unlock()
+
+			time.Sleep(10 * time.Millisecond)
+
+			tracker.mu.Lock()
+			for _, lock := range locks {
+				delete(tracker.locked, lock)
+			}
+			tracker.running--
+			tracker.completed = append(tracker.completed, key)
+			tracker.mu.Unlock()
+			return failErr
+		},
+	}
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func TestGraphExecute(t *testing.T) {
+	failErr := errors.New("operation failed")
+	tcases := []struct {
+		name            string
+		concurrency     int
+		continueOnError bool
+		operations      func(tracker *executionTracker) []operation
+		expMaxRunning   int
+		expCompleted    []string
+		expFailed       []string
+		expError        bool
+	}{
+		{
+			name:        "test case 1 - sequential",
+			concurrency: 1,
+			operations: func(tracker *executionTracker) []operation {
+				return []operation{
+					tracker.newTrackedOperation("op-a", nil, nil),
+					tracker.newTrackedOperation("op-b", nil, nil),
+					tracker.newTrackedOperation("op-c", nil, nil),
+				}
+			},
+			expMaxRunning: 1,
+			expCompleted:  []string{"op-a", "op-b", "op-c"},
+		},
+		{
+			name:        "test case 2 - bounded concurrency",
+			concurrency: 2,
+			operations: func(tracker *executionTracker) []operation {
+				return []operation{
+					tracker.newTrackedOperation("op-a", nil, nil),
+					tracker.newTrackedOperation("op-b", nil, nil),
+					tracker.newTrackedOperation("op-c", nil, nil),
+					tracker.newTrackedOperation("op-d", nil, nil),
+				}
+			},
+			expMaxRunning: 2,
+			expCompleted:  []string{"op-a", "op-b", "op-c", "op-d"},
+		},
+		{
+			name:        "test case 3 - locks and dependencies",
+			concurrency: 4,
+			operations: func(tracker *executionTracker) []operation {
+				return []operation{
+					tracker.newTrackedOperation("op-a", []string{"droplet/1"}, nil),
+					tracker.newTrackedOperation("op-b", []string{"droplet/1", "volume/abc"}, nil),
+					tracker.newTrackedOperation("op-c", []string{"volume/abc"}, nil),
+					tracker.newTrackedOperation("op-d", nil, nil, "op-c"),
+				}
+			},
+			expMaxRunning: 2,
+			expCompleted:  []string{"op-a", "op-b", "op-c", "op-d"},
+		},
+		{
+			name:        "test case 4 - error stops further operations",
+			concurrency: 1,
+			operations: func(tracker *executionTracker) []operation {
+				return []operation{
+					tracker.newTrackedOperation("op-a", nil, failErr),
+					tracker.newTrackedOperation("op-b", nil, nil),
+				}
+			},
+			expMaxRunning: 1,
+			expCompleted:  []string{"op-a"},
+			expFailed:     []string{"op-a"},
+			expError:      true,
+		},
+		{
+			name:            "test case 5 - continue on error skips dependent operations",
+			concurrency:     1,
+			continueOnError: true,
+			operations: func(tracker *executionTracker) []operation {
+				return []operation{
+					tracker.newTrackedOperation("op-a", nil, failErr),
+					tracker.newTrackedOperation("op-b", nil, nil, "op-a"),
+					tracker.newTrackedOperation("op-c", nil, nil, "op-b"),
+					tracker.newTrackedOperation("op-d", nil, nil),
+				}
+			},
+			expMaxRunning: 1,
+			expCompleted:  []string{"op-a", "op-b", "op-c", "op-d"},
+			expFailed:     []string{"op-a", "op-b", "op-c"},
+			expError:      true,
+		},
+	}
+	for _, tc := range tcases {
+		tracker := &executionTracker{locked: make(map[string]bool)}
+		g := graph{}
+		g.add(tc.operations(tracker)...)
+		results, err := g.execute(context.Background(), tc.concurrency, tc.continueOnError)
+		if (err != nil) != tc.expError {
+			t.Errorf("Failed %v, expected error: %v, got error %v", tc.name, tc.expError, err)
+		}
+		if tracker.maxRunning != tc.expMaxRunning {
+			t.Errorf("Failed %v, expected max running: %v, got %v", tc.name, tc.expMaxRunning, tracker.maxRunning)
+		}
+		if len(tracker.overlaps) != 0 {
+			t.Errorf("Failed %v, expected no concurrent operations on the same lock, got %v", tc.name, tracker.overlaps)
+		}
+		if len(tracker.dependencyErr) != 0 {
+			t.Errorf("Failed %v, expected dependencies to complete first, got %v", tc.name, tracker.dependencyErr)
+		}
+		completed := make([]string, 0, len(results))
+		failed := make([]string, 0)
+		for _, result := range results {
+			completed = append(completed, result.key)
+			if result.err != nil {
+				failed = append(failed, result.key)
+			}
+		}
+		sort.Strings(completed)
+		if !reflect.DeepEqual(completed, tc.expCompleted) {
+			t.Errorf("Failed %v, expected: %v, got %v", tc.name, tc.expCompleted, completed)
+		}
+		sort.Strings(failed)
+		if tc.expFailed == nil {
+			tc.expFailed = []string{}
+		}
+		if !reflect.DeepEqual(failed, tc.expFailed) {
+			t.Errorf("Failed %v, expected failed: %v, got %v", tc.name, tc.expFailed, failed)
+		}
+	}
+}
+
+func TestApplyOrder(t *testing.T) {
+	privileges := gitdrops.Privileges{Create: true, Update: true, Delete: true}
+	vr := newTestVolumeReconciler(privileges, nil,
+		[]godo.Volume{
+			{
+				ID:         "abc",
+				Name:       "volume-1",
+				Tags:       testOwnershipTags,
+				DropletIDs: []int{1},
+			},
+			{
+				ID:         "def",
+				Name:       "volume-2",
+				Tags:       testOwnershipTags,
+				DropletIDs: []int{2},
+			},
+		},
+		[]gitdrops.Volume{
+			{
+				Name: "volume-2",
+			},
+			{
+				Name: "volume-3",
+			},
+		},
+	)
+	dr := newTestDropletReconciler(privileges, nil,
+		[]godo.Droplet{
+			{
+				ID:        1,
+				Name:      "droplet-1",
+				Tags:      testOwnershipTags,
+				VolumeIDs: []string{"abc"},
+			},
+			{
+				ID:        2,
+				Name:      "droplet-2",
+				Tags:      testOwnershipTags,
+				VolumeIDs: []string{"def"},
+			},
+		},
+		[]gitdrops.Droplet{
+			{
+				Name:    "droplet-2",
+				Volumes: []string{"volume-3"},
+			},
+			{
+				Name:    "droplet-3",
+				Volumes: []string{"volume-2"},
+			},
+		},
+		map[string]string{
+			"volume-1": "abc",
+			"volume-2": "def",
+		},
+	)
+	ar := newTestAttachmentReconciler(privileges, dr, vr.activeVolumes)
+	r := Reconciler{reconcilers: []objectReconciler{vr, dr, ar}}
+	for _, reconciler := range r.reconcilers {
+		reconciler.setObjectsToUpdateAndCreate()
+		reconciler.setObjectsToDelete()
+	}
+
+	operations := r.getOperations()
+	ordered, err := operations.order()
+	if err != nil {
+		t.Fatalf("Failed, unexpected error %v", err)
+	}
+	expKeys := []string{
+		"volume/create/volume-3",
+		"droplet/delete/1",
+		"attachment/detach/def",
+		"droplet/create/droplet-3",
+		"attachment/attach/volume-3",
+		"volume/delete/abc",
+	}
+	if !reflect.DeepEqual(operationKeys(ordered), expKeys) {
+		t.Errorf("Failed, expected: %v, got %v", expKeys, operationKeys(ordered))
+	}
+}
+
+func TestOperationPrivileges(t *testing.T) {
+	yes, no := true, false
+	tcases := []struct {
+		name       string
+		privileges gitdrops.Privileges
+		expKeys    []string
+	}{
+		{
+			name:       "test case 1 - top level privileges",
+			privileges: gitdrops.Privileges{Create: true, Update: true, Delete: true},
+			expKeys: []string{
+				"droplet/update/1",
+				"volume/create/volume-2",
+				"attachment/attach/volume-2",
+				"volume/delete/abc",
+				"volume/resize/def",
+			},
+		},
+		{
+			name: "test case 2 - per resource kind and action privileges",
+			privileges: gitdrops.Privileges{
+				Create: true,
+				Update: true,
+				Delete: true,
+				Droplets: &gitdrops.ResourcePrivileges{
+					Actions: map[string]bool{"rebuild": false},
+				},
+				Volumes: &gitdrops.ResourcePrivileges{
+					Update:  &no,
+					Delete:  &no,
+					Actions: map[string]bool{"attach": true},
+				},
+			},
+			expKeys: []string{
+				"droplet/update/1",
+				"volume/create/volume-2",
+				"attachment/attach/volume-2",
+			},
+		},
+		{
+			name: "test case 3 - no droplet update privileges",
+			privileges: gitdrops.Privileges{
+				Create: false,
+				Update: true,
+				Delete: false,
+				Droplets: &gitdrops.ResourcePrivileges{
+					Update: &no,
+				},
+				Volumes: &gitdrops.ResourcePrivileges{
+					Create: &yes,
+				},
+			},
+			expKeys: []string{
+				"volume/create/volume-2",
+				"attachment/attach/volume-2",
+				"volume/resize/def",
+			},
+		},
+	}
+	for _, tc := range tcases {
+		vr := newTestVolumeReconciler(tc.privileges, nil,
+			[]godo.Volume{
+				{
+					ID:   "abc",
+					Name: "volume-1",
+					Tags: testOwnershipTags,
+				},
+				{
+					ID:            "def",
+					Name:          "volume-3",
+					SizeGigaBytes: 100,
+					Tags:          testOwnershipTags,
+				},
+			},
+			[]gitdrops.Volume{
+				{
+					Name: "volume-2",
+				},
+				{
+					Name:          "volume-3",
+					SizeGigaBytes: 200,
+				},
+			},
+		)
+		dr := newTestDropletReconciler(tc.privileges, nil,
+			[]godo.Droplet{
+				{
+					ID:   1,
+					Name: "droplet-1",
+					Tags: testOwnershipTags,
+					Size: &godo.Size{Slug: "s-1vcpu-1gb"},
+					Image: &godo.Image{
+						Slug: "centos-8-x64",
+					},
+					Region: &godo.Region{Slug: "nyc3"},
+				},
+			},
+			[]gitdrops.Droplet{
+				{
+					Name:    "droplet-1",
+					Region:  "nyc3",
+					Size:    "s-2vcpu-2gb",
+					Image:   "ubuntu-20-04-x64",
+					Volumes: []string{"volume-2"},
+				},
+			},
+			map[string]string{
+				"volume-1": "abc",
+				"volume-3": "def",
+			},
+		)
+		ar := newTestAttachmentReconciler(tc.privileges, dr, vr.activeVolumes)
+		r := Reconciler{reconcilers: []objectReconciler{dr, vr, ar}}
+		for _, reconciler := range r.reconcilers {
+			reconciler.setObjectsToUpdateAndCreate()
+			reconciler.setObjectsToDelete()
+		}
+
+		operations := r.getOperations()
+		ordered, err := operations.order()
+		if err != nil {
+			t.Fatalf("Failed %v, unexpected error %v", tc.name, err)
+		}
+		keys := operationKeys(ordered)
+		sort.Strings(keys)
+		sort.Strings(tc.expKeys)
+		if !reflect.DeepEqual(keys, tc.expKeys) {
+			t.Errorf("Failed %v, expected: %v, got %v", tc.name, tc.expKeys, keys)
+		}
+	}
+}
+
+// TestApplyOrderVolumeSwap checks that a droplet created or replaced with a volume attached to a
+// droplet removed from gitdrops.yaml is created once that droplet is deleted, as DO detaches the
+// volume when the droplet is deleted.
+func TestApplyOrderVolumeSwap(t *testing.T) {
+	privileges := gitdrops.Privileges{Create: true, Update: true, Delete: true}
+	tcases := []struct {
+		name             string
+		activeDroplets   []godo.Droplet
+		gitdropsDroplets []gitdrops.Droplet
+		expKeys          []string
+	}{
+		{
+			name: "test case 1 - new droplet takes the volume of a deleted droplet",
+			activeDroplets: []godo.Droplet{
+				{
+					ID:        1,
+					Name:      "droplet-old",
+					Tags:      testOwnershipTags,
+					VolumeIDs: []string{"abc"},
+				},
+			},
+			gitdropsDroplets: []gitdrops.Droplet{
+				{
+					Name:    "droplet-new",
+					Volumes: []string{"volume-1"},
+				},
+			},
+			expKeys: []string{
+				"droplet/delete/1",
+				"droplet/create/droplet-new",
+			},
+		},
+		{
+			name: "test case 2 - replaced droplet takes the volume of a deleted droplet",
+			activeDroplets: []godo.Droplet{
+				{
+					ID:        1,
+					Name:      "droplet-old",
+					Tags:      testOwnershipTags,
+					VolumeIDs: []string{"abc"},
+				},
+				{
+					ID:     2,
+					Name:   "droplet-2",
+					Region: &godo.Region{Slug: "nyc3"},
+					Tags:   testOwnershipTags,
+				},
+			},
+			gitdropsDroplets: []gitdrops.Droplet{
+				{
+					Name:             "droplet-2",
+					Region:           "sfo3",
+					Volumes:          []string{"volume-1"},
+					ReplaceOnChanges: []string{gitdrops.RegionField},
+				},
+			},
+			expKeys: []string{
+				"droplet/delete/1",
+				"droplet/replace/2",
+			},
+		},
+	}
+	for _, tc := range tcases {
+		dr := newTestDropletReconciler(privileges, nil, tc.activeDroplets, tc.gitdropsDroplets, map[string]string{"volume-1": "abc"})
+		r := Reconciler{reconcilers: []objectReconciler{dr}}
+		for _, reconciler := range r.reconcilers {
+			reconciler.setObjectsToUpdateAndCreate()
+			reconciler.setObjectsToDelete()
+		}
+
+		operations := r.getOperations()
+		ordered, err := operations.order()
+		if err != nil {
+			t.Errorf("Failed %v, unexpected error %v", tc.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(operationKeys(ordered), tc.expKeys) {
+			t.Errorf("Failed %v, expected: %v, got %v", tc.name, tc.expKeys, operationKeys(ordered))
+			continue
+		}
+		// the order must not rely on the insertion order, which concurrent execution ignores
+		if !hasName(ordered[1].dependsOn, tc.expKeys[0]) {
+			t.Errorf("DependsOn - Failed %v, expected: %v, got %v", tc.name, tc.expKeys[0], ordered[1].dependsOn)
+		}
+	}
+}
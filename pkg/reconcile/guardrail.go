@@ -0,0 +1,57 @@
+package reconcile
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// massDeleteLimits are the limits above which Apply refuses to delete objects, see Options.
+type massDeleteLimits struct {
+	maxDeletes       int
+	maxDeletePercent int
+	allowMassDelete  bool
+	confirmToken     string
+}
+
+// deleteToken returns a token identifying the set of objects deleted by deleteKeys. The token
+// confirms a mass deletion of exactly these objects, so that a token copied from an earlier
+// run does not confirm the deletion of different objects.
+func deleteToken(deleteKeys []string) string {
+	keys := append([]string{}, deleteKeys...)
+	sort.Strings(keys)
+	hash := sha256.Sum256([]byte(strings.Join(keys, "\n")))
+	return fmt.Sprintf("%x", hash[:6])
+}
+
+// check returns an error if deleteKeys, the keys of the delete and replace operations of a plan,
+// delete more than maxDeletes objects, or more than maxDeletePercent of the managed objects. A
+// single deletion never exceeds the percentage, so that the last managed object can be deleted.
+// A limit of 0 is no limit. The deletion is allowed regardless if allowMassDelete is set or
+// confirmToken matches the deleteToken of deleteKeys. The error lists deleteKeys, so that the
+// objects confirmed by the token can be reviewed.
+func (l massDeleteLimits) check(deleteKeys []string, managed int) error {
+	deletes := len(deleteKeys)
+	if deletes == 0 || l.allowMassDelete {
+		return nil
+	}
+	exceeded := make([]string, 0)
+	if l.maxDeletes > 0 && deletes > l.maxDeletes {
+		exceeded = append(exceeded, fmt.Sprintf("more than %d objects", l.maxDeletes))
+	}
+	if l.maxDeletePercent > 0 && deletes > 1 && managed > 0 && deletes*100 > l.maxDeletePercent*managed {
+		exceeded = append(exceeded, fmt.Sprintf("more than %d%% of %d managed objects", l.maxDeletePercent, managed))
+	}
+	if len(exceeded) == 0 {
+		return nil
+	}
+	token := deleteToken(deleteKeys)
+	if l.confirmToken == token {
+		return nil
+	}
+	keys := append([]string{}, deleteKeys...)
+	sort.Strings(keys)
+	return fmt.Errorf("massDeleteLimits.check: refusing to delete or replace %d objects (%s), %s. Review the plan and re-run with -confirm-delete %s or -allow-mass-delete",
+		deletes, strings.Join(keys, ", "), strings.Join(exceeded, " and "), token)
+}
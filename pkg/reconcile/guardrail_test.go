@@ -0,0 +1,113 @@
+package reconcile
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMassDeleteLimits(t *testing.T) {
+	deleteKeys := []string{"droplet/delete/1", "droplet/delete/2", "volume/delete/abc"}
+	tcases := []struct {
+		name       string
+		limits     massDeleteLimits
+		deleteKeys []string
+		managed    int
+		expError   bool
+	}{
+		{
+			name:       "test case 1 - within limits",
+			limits:     massDeleteLimits{maxDeletes: 5, maxDeletePercent: 50},
+			deleteKeys: deleteKeys,
+			managed:    10,
+			expError:   false,
+		},
+		{
+			name:       "test case 2 - too many objects",
+			limits:     massDeleteLimits{maxDeletes: 2, maxDeletePercent: 50},
+			deleteKeys: deleteKeys,
+			managed:    10,
+			expError:   true,
+		},
+		{
+			name:       "test case 3 - too large a percentage",
+			limits:     massDeleteLimits{maxDeletes: 5, maxDeletePercent: 50},
+			deleteKeys: deleteKeys,
+			managed:    4,
+			expError:   true,
+		},
+		{
+			name:       "test case 4 - single deletion",
+			limits:     massDeleteLimits{maxDeletes: 5, maxDeletePercent: 50},
+			deleteKeys: deleteKeys[:1],
+			managed:    1,
+			expError:   false,
+		},
+		{
+			name:       "test case 5 - allowed",
+			limits:     massDeleteLimits{maxDeletes: 2, maxDeletePercent: 50, allowMassDelete: true},
+			deleteKeys: deleteKeys,
+			managed:    3,
+			expError:   false,
+		},
+		{
+			name:       "test case 6 - confirmed",
+			limits:     massDeleteLimits{maxDeletes: 2, confirmToken: deleteToken([]string{"volume/delete/abc", "droplet/delete/2", "droplet/delete/1"})},
+			deleteKeys: deleteKeys,
+			managed:    3,
+			expError:   false,
+		},
+		{
+			name:       "test case 7 - token of other deletions",
+			limits:     massDeleteLimits{maxDeletes: 2, confirmToken: deleteToken(deleteKeys[:2])},
+			deleteKeys: deleteKeys,
+			managed:    3,
+			expError:   true,
+		},
+		{
+			name:       "test case 8 - no limits",
+			limits:     massDeleteLimits{},
+			deleteKeys: deleteKeys,
+			managed:    3,
+			expError:   false,
+		},
+	}
+	for _, tc := range tcases {
+		err := tc.limits.check(tc.deleteKeys, tc.managed)
+		if (err != nil) != tc.expError {
+			t.Errorf("Failed %v, expected error: %v, got error %v", tc.name, tc.expError, err)
+		}
+	}
+}
+
+func TestCheckMassDelete(t *testing.T) {
+	operations := graph{}
+	for _, key := range []string{"droplet/delete/1", "droplet/replace/2", "volume/replace/abc", "domain/deleteRecord/example.com/4", "droplet/update/3", "volume/resize/def"} {
+		operations.add(operation{key: key})
+	}
+	tcases := []struct {
+		name       string
+		maxDeletes int
+		expError   bool
+	}{
+		{
+			name:       "test case 1 - replacements and record deletions within limits",
+			maxDeletes: 4,
+			expError:   false,
+		},
+		{
+			name:       "test case 2 - replacements and record deletions count as deletions",
+			maxDeletes: 3,
+			expError:   true,
+		},
+	}
+	for _, tc := range tcases {
+		r := Reconciler{massDelete: massDeleteLimits{maxDeletes: tc.maxDeletes}}
+		err := r.checkMassDelete(operations)
+		if (err != nil) != tc.expError {
+			t.Errorf("Failed %v, expected error: %v, got error %v", tc.name, tc.expError, err)
+		}
+		if err != nil && !strings.Contains(err.Error(), "domain/deleteRecord/example.com/4, droplet/delete/1, droplet/replace/2, volume/replace/abc") {
+			t.Errorf("Failed %v, expected the replaced objects to be listed, got error %v", tc.name, err)
+		}
+	}
+}
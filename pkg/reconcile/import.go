@@ -0,0 +1,98 @@
+package reconcile
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/nolancon/gitdrops/pkg/gitdrops"
+
+	"github.com/digitalocean/godo"
+)
+
+const (
+	backupsFeature    = "backups"
+	ipv6Feature       = "ipv6"
+	monitoringFeature = "monitoring"
+)
+
+// Import returns a GitDrops spec describing the droplets and volumes currently active on the DO
+// account. Privileges are left disabled so that the imported spec is safe to reconcile as is.
+func Import(ctx context.Context, client *godo.Client) (gitdrops.GitDrops, error) {
+	gitDrops := gitdrops.GitDrops{}
+	activeVolumes, err := gitdrops.ListVolumes(ctx, client)
+	if err != nil {
+		return gitDrops, fmt.Errorf("Import: %v", err)
+	}
+	activeDroplets, err := gitdrops.ListDroplets(ctx, client)
+	if err != nil {
+		return gitDrops, fmt.Errorf("Import: %v", err)
+	}
+
+	volumeIDToName := make(map[string]string)
+	for _, activeVolume := range activeVolumes {
+		volumeIDToName[activeVolume.ID] = activeVolume.Name
+		gitDrops.Volumes = append(gitDrops.Volumes, translateActiveVolume(activeVolume))
+	}
+	for _, activeDroplet := range activeDroplets {
+		gitDrops.Droplets = append(gitDrops.Droplets, translateActiveDroplet(activeDroplet, volumeIDToName))
+	}
+	log.Println("Import: imported", len(gitDrops.Droplets), "droplet(s) and", len(gitDrops.Volumes), "volume(s)")
+	return gitDrops, nil
+}
+
+// translateActiveDroplet is the reverse of translateDropletCreateRequest. SSH keys and user data
+// cannot be read back from DO and are therefore not set.
+func translateActiveDroplet(activeDroplet godo.Droplet, volumeIDToName map[string]string) gitdrops.Droplet {
+	gitdropsDroplet := gitdrops.Droplet{
+		Name:    activeDroplet.Name,
+		Size:    activeDroplet.SizeSlug,
+		Tags:    userTags(activeDroplet.Tags),
+		VPCUUID: activeDroplet.VPCUUID,
+		Protect: isProtected(activeDroplet.Tags),
+	}
+	if activeDroplet.Region != nil {
+		gitdropsDroplet.Region = activeDroplet.Region.Slug
+	}
+	if activeDroplet.Size != nil {
+		gitdropsDroplet.Size = activeDroplet.Size.Slug
+	}
+	if activeDroplet.Image != nil {
+		gitdropsDroplet.Image = activeDroplet.Image.Slug
+	}
+	for _, feature := range activeDroplet.Features {
+		switch feature {
+		case backupsFeature:
+			gitdropsDroplet.Backups = true
+		case ipv6Feature:
+			gitdropsDroplet.IPv6 = true
+		case monitoringFeature:
+			gitdropsDroplet.Monitoring = true
+		}
+	}
+	for _, volumeID := range activeDroplet.VolumeIDs {
+		if volumeName, ok := volumeIDToName[volumeID]; ok {
+			gitdropsDroplet.Volumes = append(gitdropsDroplet.Volumes, volumeName)
+		}
+	}
+	return gitdropsDroplet
+}
+
+// translateActiveVolume is the reverse of translateVolumeCreateRequest.
+func translateActiveVolume(activeVolume godo.Volume) gitdrops.Volume {
+	gitdropsVolume := gitdrops.Volume{
+		Name:            activeVolume.Name,
+		SizeGigaBytes:   activeVolume.SizeGigaBytes,
+		FilesystemType:  activeVolume.FilesystemType,
+		FilesystemLabel: activeVolume.FilesystemLabel,
+		Tags:            userTags(activeVolume.Tags),
+		Protect:         isProtected(activeVolume.Tags),
+	}
+	if activeVolume.Region != nil {
+		gitdropsVolume.Region = activeVolume.Region.Slug
+	}
+	if snapshotID, ok := findSnapshotID(activeVolume.Tags); ok {
+		gitdropsVolume.SnapshotID = snapshotID
+	}
+	return gitdropsVolume
+}
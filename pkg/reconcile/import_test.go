@@ -0,0 +1,95 @@
+package reconcile
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/nolancon/gitdrops/pkg/gitdrops"
+
+	"github.com/digitalocean/godo"
+)
+
+func TestTranslateActiveDroplet(t *testing.T) {
+	tcases := []struct {
+		name           string
+		activeDroplet  godo.Droplet
+		volumeIDToName map[string]string
+		expDroplet     gitdrops.Droplet
+	}{
+		{
+			name: "test case 1 - name only",
+			activeDroplet: godo.Droplet{
+				ID:   1,
+				Name: "droplet-1",
+			},
+			expDroplet: gitdrops.Droplet{
+				Name: "droplet-1",
+			},
+		},
+		{
+			name: "test case 2 - all fields",
+			activeDroplet: godo.Droplet{
+				ID:   1,
+				Name: "droplet-1",
+				Region: &godo.Region{
+					Slug: "nyc3",
+				},
+				Size: &godo.Size{
+					Slug: "s-1vcpu-1gb",
+				},
+				Image: &godo.Image{
+					Slug: "centos-8-x64",
+				},
+				Features:  []string{"backups", "ipv6", "private_networking"},
+				Tags:      []string{"tag-1", managedTag, protectedTag},
+				VolumeIDs: []string{"abc", "xyz"},
+				VPCUUID:   "vpc-1",
+			},
+			volumeIDToName: map[string]string{
+				"abc": "volume-1",
+			},
+			expDroplet: gitdrops.Droplet{
+				Name:    "droplet-1",
+				Region:  "nyc3",
+				Size:    "s-1vcpu-1gb",
+				Image:   "centos-8-x64",
+				Backups: true,
+				IPv6:    true,
+				Tags:    []string{"tag-1"},
+				Volumes: []string{"volume-1"},
+				VPCUUID: "vpc-1",
+				Protect: true,
+			},
+		},
+	}
+	for _, tc := range tcases {
+		gitdropsDroplet := translateActiveDroplet(tc.activeDroplet, tc.volumeIDToName)
+		if !reflect.DeepEqual(gitdropsDroplet, tc.expDroplet) {
+			t.Errorf("Failed %v, expected: %v, got %v", tc.name, tc.expDroplet, gitdropsDroplet)
+		}
+	}
+}
+
+func TestTranslateActiveVolume(t *testing.T) {
+	activeVolume := godo.Volume{
+		ID:   "abc",
+		Name: "volume-1",
+		Region: &godo.Region{
+			Slug: "nyc3",
+		},
+		SizeGigaBytes:  100,
+		FilesystemType: "ext4",
+		Tags:           []string{"tag-1"},
+	}
+	expVolume := gitdrops.Volume{
+		Name:           "volume-1",
+		Region:         "nyc3",
+		SizeGigaBytes:  100,
+		FilesystemType: "ext4",
+		Tags:           []string{"tag-1"},
+	}
+	gitdropsVolume := translateActiveVolume(activeVolume)
+	if !reflect.DeepEqual(gitdropsVolume, expVolume) {
+		t.Errorf("Failed, expected: %v, got %v", expVolume, gitdropsVolume)
+	}
+}
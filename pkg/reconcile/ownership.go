@@ -0,0 +1,219 @@
+package reconcile
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/nolancon/gitdrops/pkg/gitdrops"
+
+	"github.com/digitalocean/godo"
+)
+
+const (
+	// managedTag is applied to every droplet and volume created by gitdrops
+	managedTag = "gitdrops:managed"
+	// stackTagPrefix followed by the stack ID is applied to every droplet and volume created
+	// by gitdrops, see gitdrops.GitDrops.Stack
+	stackTagPrefix = "gitdrops:stack:"
+	defaultStack   = "default"
+	// gitdropsTagPrefix is the prefix of all tags applied by gitdrops itself
+	gitdropsTagPrefix = "gitdrops:"
+	// protectedTag is applied to droplets and volumes with protect set, they are never deleted
+	protectedTag = "gitdrops:protected"
+	// sshKeysTagPrefix followed by a hash of the SSH key fingerprints of a droplet is applied
+	// to droplets created by gitdrops, as DO does not report the SSH keys of a droplet
+	sshKeysTagPrefix = "gitdrops:sshkeys:"
+	// snapshotTagPrefix followed by the ID of the snapshot a volume was created from is applied
+	// to volumes created by gitdrops, as DO does not report the snapshot of a volume
+	snapshotTagPrefix = "gitdrops:snapshot:"
+	adopt             = "adopt"
+	protect           = "protect"
+)
+
+// ownershipTags returns the tags that mark a droplet or volume as managed by stack. Only
+// objects with all of these tags are ever deleted.
+func ownershipTags(stack string) []string {
+	if stack == "" {
+		stack = defaultStack
+	}
+	return []string{managedTag, stackTagPrefix + stack}
+}
+
+// isManaged returns true if tags contain all ownershipTags.
+func isManaged(tags, ownershipTags []string) bool {
+	for _, ownershipTag := range ownershipTags {
+		if !hasTag(tags, ownershipTag) {
+			return false
+		}
+	}
+	return true
+}
+
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// withTags returns tags followed by the extraTags not already in tags.
+func withTags(tags, extraTags []string) []string {
+	allTags := make([]string, 0, len(tags)+len(extraTags))
+	allTags = append(allTags, tags...)
+	for _, extraTag := range extraTags {
+		if !hasTag(allTags, extraTag) {
+			allTags = append(allTags, extraTag)
+		}
+	}
+	return allTags
+}
+
+// creationTags returns the tags of a new object: tags, the ownershipTags and, if protected,
+// protectedTag.
+func creationTags(tags, ownershipTags []string, protected bool) []string {
+	tags = withTags(tags, ownershipTags)
+	if protected {
+		tags = withTags(tags, []string{protectedTag})
+	}
+	return tags
+}
+
+// userTags returns tags without the tags applied by gitdrops itself, ie the tags declared in
+// gitdrops.yaml.
+func userTags(tags []string) []string {
+	var filtered []string
+	for _, tag := range tags {
+		if !strings.HasPrefix(tag, gitdropsTagPrefix) {
+			filtered = append(filtered, tag)
+		}
+	}
+	return filtered
+}
+
+// sshKeysTag returns the tag recording sshKeyFingerprints.
+func sshKeysTag(sshKeyFingerprints []string) string {
+	if len(sshKeyFingerprints) == 0 {
+		return sshKeysTagPrefix + "none"
+	}
+	fingerprints := append([]string{}, sshKeyFingerprints...)
+	sort.Strings(fingerprints)
+	hash := sha256.Sum256([]byte(strings.Join(fingerprints, "\n")))
+	return fmt.Sprintf("%s%x", sshKeysTagPrefix, hash[:6])
+}
+
+// findSSHKeysTag returns the sshKeysTag in tags, false if the droplet was not created with it.
+func findSSHKeysTag(tags []string) (string, bool) {
+	for _, t := range tags {
+		if strings.HasPrefix(t, sshKeysTagPrefix) {
+			return t, true
+		}
+	}
+	return "", false
+}
+
+// snapshotTag returns the tag recording the snapshot a volume is created from.
+func snapshotTag(snapshotID string) string {
+	if snapshotID == "" {
+		return snapshotTagPrefix + "none"
+	}
+	return snapshotTagPrefix + snapshotID
+}
+
+// findSnapshotID returns the snapshot ID recorded by snapshotTag in tags, false if the volume
+// was not created with it.
+func findSnapshotID(tags []string) (string, bool) {
+	for _, t := range tags {
+		if strings.HasPrefix(t, snapshotTagPrefix) {
+			if t == snapshotTag("") {
+				return "", true
+			}
+			return strings.TrimPrefix(t, snapshotTagPrefix), true
+		}
+	}
+	return "", false
+}
+
+// isProtected returns true if tags contain protectedTag.
+func isProtected(tags []string) bool {
+	return hasTag(tags, protectedTag)
+}
+
+// setProtected applies or removes protectedTag to resource.
+func setProtected(ctx context.Context, client *godo.Client, resource godo.Resource, protected bool) error {
+	if protected {
+		return gitdrops.TagResources(ctx, client, protectedTag, []godo.Resource{resource})
+	}
+	return gitdrops.UntagResources(ctx, client, protectedTag, []godo.Resource{resource})
+}
+
+// Adopt applies the ownership tags of gitDrops.Stack to the droplets and volumes declared in
+// gitDrops that already exist on the DO account but were not created by gitdrops, so that they
+// are deleted once removed from gitdrops.yaml. If names is not empty, only the objects with
+// these names are adopted. The adopted objects are returned as changes.
+func Adopt(ctx context.Context, gitDrops gitdrops.GitDrops, client *godo.Client, names []string) ([]Change, error) {
+	tags := ownershipTags(gitDrops.Stack)
+	selected := func(name string) bool {
+		if len(names) == 0 {
+			return true
+		}
+		for _, n := range names {
+			if n == name {
+				return true
+			}
+		}
+		return false
+	}
+
+	activeDroplets, err := gitdrops.ListDroplets(ctx, client)
+	if err != nil {
+		return nil, fmt.Errorf("Adopt: %v", err)
+	}
+	activeVolumes, err := gitdrops.ListVolumes(ctx, client)
+	if err != nil {
+		return nil, fmt.Errorf("Adopt: %v", err)
+	}
+
+	changes := make([]Change, 0)
+	resources := make([]godo.Resource, 0)
+	for _, activeVolume := range activeVolumes {
+		if isManaged(activeVolume.Tags, tags) || !selected(activeVolume.Name) {
+			continue
+		}
+		for _, gitdropsVolume := range gitDrops.Volumes {
+			if gitdropsVolume.Name == activeVolume.Name {
+				resources = append(resources, godo.Resource{ID: activeVolume.ID, Type: godo.VolumeResourceType})
+				changes = append(changes, Change{Resource: volume, Action: adopt, Name: activeVolume.Name, ID: activeVolume.ID})
+			}
+		}
+	}
+	for _, activeDroplet := range activeDroplets {
+		if isManaged(activeDroplet.Tags, tags) || !selected(activeDroplet.Name) {
+			continue
+		}
+		for _, gitdropsDroplet := range gitDrops.Droplets {
+			if gitdropsDroplet.Name == activeDroplet.Name {
+				id := strconv.Itoa(activeDroplet.ID)
+				resources = append(resources, godo.Resource{ID: id, Type: godo.DropletResourceType})
+				changes = append(changes, Change{Resource: droplet, Action: adopt, Name: activeDroplet.Name, ID: id})
+			}
+		}
+	}
+	if len(resources) == 0 {
+		log.Println("Adopt: no unmanaged droplets or volumes declared in gitdrops.yaml")
+		return changes, nil
+	}
+	for _, tag := range tags {
+		err = gitdrops.TagResources(ctx, client, tag, resources)
+		if err != nil {
+			return nil, fmt.Errorf("Adopt: %v", err)
+		}
+	}
+	return changes, nil
+}
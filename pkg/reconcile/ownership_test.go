@@ -0,0 +1,65 @@
+package reconcile
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestOwnershipTags(t *testing.T) {
+	tcases := []struct {
+		name       string
+		stack      string
+		tags       []string
+		expTags    []string
+		expManaged bool
+	}{
+		{
+			name:       "test case 1 - default stack",
+			stack:      "",
+			tags:       []string{"tag-1"},
+			expTags:    []string{"tag-1", "gitdrops:managed", "gitdrops:stack:default"},
+			expManaged: false,
+		},
+		{
+			name:       "test case 2 - already managed",
+			stack:      "web",
+			tags:       []string{"gitdrops:stack:web", "gitdrops:managed"},
+			expTags:    []string{"gitdrops:stack:web", "gitdrops:managed"},
+			expManaged: true,
+		},
+		{
+			name:       "test case 3 - managed by another stack",
+			stack:      "web",
+			tags:       []string{"gitdrops:managed", "gitdrops:stack:db"},
+			expTags:    []string{"gitdrops:managed", "gitdrops:stack:db", "gitdrops:stack:web"},
+			expManaged: false,
+		},
+	}
+	for _, tc := range tcases {
+		tags := withTags(tc.tags, ownershipTags(tc.stack))
+		if !reflect.DeepEqual(tags, tc.expTags) {
+			t.Errorf("Failed %v, expected: %v, got %v", tc.name, tc.expTags, tags)
+		}
+		managed := isManaged(tc.tags, ownershipTags(tc.stack))
+		if managed != tc.expManaged {
+			t.Errorf("Failed %v, expected managed: %v, got %v", tc.name, tc.expManaged, managed)
+		}
+	}
+}
+
+func TestSSHKeysTag(t *testing.T) {
+	tag := sshKeysTag([]string{"ab:cd", "ef:01"})
+	if tag != sshKeysTag([]string{"ef:01", "ab:cd"}) {
+		t.Errorf("Failed, expected the tag to not depend on the order of fingerprints")
+	}
+	if tag == sshKeysTag([]string{"ab:cd"}) || sshKeysTag(nil) != "gitdrops:sshkeys:none" {
+		t.Errorf("Failed, expected distinct tags for distinct fingerprints, got %v", tag)
+	}
+	found, ok := findSSHKeysTag([]string{"web", managedTag, tag})
+	if !ok || found != tag {
+		t.Errorf("Failed, expected: %v, got %v", tag, found)
+	}
+	if _, ok := findSSHKeysTag([]string{"web"}); ok {
+		t.Errorf("Failed, expected no SSH keys tag")
+	}
+}
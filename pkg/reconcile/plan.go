@@ -0,0 +1,253 @@
+package reconcile
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/nolancon/gitdrops/pkg/gitdrops"
+)
+
+// Plan is the set of changes required to bring the DO account in line with gitdrops.yaml.
+// It is computed by Reconciler.Plan without modifying any objects on the DO account.
+type Plan struct {
+	Changes []Change `json:"changes"`
+	// Steps holds the objects to create, update and delete of each reconciler, keyed by resource
+	// type. Steps are what Apply executes, Changes are a readable summary of them.
+	Steps map[string]json.RawMessage `json:"steps"`
+	// Observed holds fingerprints of the objects that were active on DO when the plan was made.
+	Observed []Fingerprint `json:"observed"`
+	// GitDrops is a hash of the gitdrops.yaml the plan was made from. Apply reads the privileges
+	// and the droplets to create or replace from gitdrops.yaml, so a saved plan is only applied
+	// with the same gitdrops.yaml.
+	GitDrops string `json:"gitdrops"`
+}
+
+// Fingerprint identifies the observed state of an active object on DO. If the fingerprint of an
+// object changes between plan and apply, the account has drifted and the plan is stale.
+type Fingerprint struct {
+	Resource string `json:"resource"`
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Hash     string `json:"hash"`
+}
+
+// Change is a single create, update or delete operation on an object.
+type Change struct {
+	// Resource is the type of object to be changed eg droplet, volume
+	Resource string `json:"resource"`
+	// Action is the operation to be performed eg create, resize, rebuild, attach, detach, delete.
+	// requiresReplacement reports a change to the field Value that cannot be applied in place.
+	Action string `json:"action"`
+	// Name is the name of the object as it appears in gitdrops.yaml or on DO
+	Name string `json:"name,omitempty"`
+	// ID is the DO ID of the object. It is empty for objects that are yet to be created.
+	ID string `json:"id,omitempty"`
+	// Value is eg 's-1vcpu-2gb' for resize, 'ubuntu-x-x' for rebuild or the volume for attach
+	Value string `json:"value,omitempty"`
+}
+
+// HasChanges returns true if the plan contains at least one change that Apply acts on. Changes
+// requiring replacement are reported but never applied, so they are not counted.
+func (p Plan) HasChanges() bool {
+	return len(p.Changes)-p.count(requiresReplacement) != 0
+}
+
+// String returns a human-readable summary of the plan, one change per line.
+func (p Plan) String() string {
+	if len(p.Changes) == 0 {
+		return "No changes. DO account is in sync with gitdrops.yaml.\n"
+	}
+	var b strings.Builder
+	for _, change := range p.Changes {
+		b.WriteString(change.String())
+		b.WriteString("\n")
+	}
+	fmt.Fprintf(&b, "Plan: %d to create, %d to update, %d to delete.\n", p.count(create), p.countUpdates(), p.count(remove))
+	if replacements := p.count(requiresReplacement); replacements != 0 {
+		fmt.Fprintf(&b, "%d change(s) cannot be applied in place and require replacement.\n", replacements)
+	}
+	return b.String()
+}
+
+// ReadPlan reads a plan previously saved with WritePlan.
+func ReadPlan(path string) (Plan, error) {
+	plan := Plan{}
+	planJSON, err := ioutil.ReadFile(path)
+	if err != nil {
+		return plan, fmt.Errorf("ReadPlan: %v", err)
+	}
+	err = json.Unmarshal(planJSON, &plan)
+	if err != nil {
+		return plan, fmt.Errorf("ReadPlan: %v", err)
+	}
+	return plan, nil
+}
+
+// WritePlan saves the plan to path so that it can later be applied verbatim.
+func WritePlan(path string, plan Plan) error {
+	planJSON, err := plan.JSON()
+	if err != nil {
+		return fmt.Errorf("WritePlan: %v", err)
+	}
+	err = ioutil.WriteFile(path, planJSON, 0600)
+	if err != nil {
+		return fmt.Errorf("WritePlan: %v", err)
+	}
+	return nil
+}
+
+// JSON returns the machine-readable representation of the plan.
+func (p Plan) JSON() ([]byte, error) {
+	if p.Changes == nil {
+		p.Changes = []Change{}
+	}
+	return json.MarshalIndent(p, "", "  ")
+}
+
+func (p Plan) count(action string) int {
+	count := 0
+	for _, change := range p.Changes {
+		if change.Action == action {
+			count++
+		}
+	}
+	return count
+}
+
+func (p Plan) countUpdates() int {
+	return len(p.Changes) - p.count(create) - p.count(remove) - p.count(requiresReplacement)
+}
+
+func (c Change) String() string {
+	if c.Action == requiresReplacement {
+		return fmt.Sprintf("! %s %s (%s): %s requires replacement", c.Resource, c.Name, c.ID, c.Value)
+	}
+	symbol := "~"
+	switch c.Action {
+	case create:
+		symbol = "+"
+	case remove:
+		symbol = "-"
+	}
+	s := fmt.Sprintf("%s %s %s %s", symbol, c.Action, c.Resource, c.Name)
+	if c.ID != "" {
+		s += fmt.Sprintf(" (%s)", c.ID)
+	}
+	if c.Value != "" {
+		s += ": " + c.Value
+	}
+	return s
+}
+
+// hashGitDrops hashes gitDrops, including the public keys read from the files it references.
+func hashGitDrops(gitDrops gitdrops.GitDrops) (string, error) {
+	gitDropsJSON, err := json.Marshal(gitDrops)
+	if err != nil {
+		return "", fmt.Errorf("hashGitDrops: %v", err)
+	}
+	hash := sha256.Sum256(gitDropsJSON)
+	return hex.EncodeToString(hash[:]), nil
+}
+
+// checkGitDrops returns an error if a saved plan was made from a different gitdrops.yaml.
+func checkGitDrops(planned, current string) error {
+	if planned != current {
+		return fmt.Errorf("checkGitDrops: gitdrops.yaml has changed since the plan was made, plan again")
+	}
+	return nil
+}
+
+// marshalSteps serializes the steps of reconciler, see objectReconciler.getSteps.
+func marshalSteps(reconciler objectReconciler) (json.RawMessage, error) {
+	steps, err := json.Marshal(reconciler.getSteps())
+	if err != nil {
+		return nil, fmt.Errorf("marshalSteps: %s: %v", reconciler.getResourceType(), err)
+	}
+	return steps, nil
+}
+
+// unmarshalSteps deserializes stepsJSON into steps. steps is left as it is if a saved plan has no
+// steps for the reconciler.
+func unmarshalSteps(stepsJSON json.RawMessage, steps interface{}) error {
+	if len(stepsJSON) == 0 {
+		return nil
+	}
+	err := json.Unmarshal(stepsJSON, steps)
+	if err != nil {
+		return fmt.Errorf("unmarshalSteps: %v", err)
+	}
+	return nil
+}
+
+// observedObject is an active object and the fields of it that a reconciler compares or acts
+// on, see objectReconciler.getObservedObjects.
+type observedObject struct {
+	id       string
+	name     string
+	observed interface{}
+}
+
+// getFingerprints fingerprints the observed objects of reconciler.
+func getFingerprints(reconciler objectReconciler) ([]Fingerprint, error) {
+	fingerprints := make([]Fingerprint, 0)
+	for _, object := range reconciler.getObservedObjects() {
+		fingerprint, err := newFingerprint(reconciler.getResourceType(), object.id, object.name, object.observed)
+		if err != nil {
+			return nil, fmt.Errorf("getFingerprints: %v", err)
+		}
+		fingerprints = append(fingerprints, fingerprint)
+	}
+	return fingerprints, nil
+}
+
+// newFingerprint hashes the observed fields of an active object.
+func newFingerprint(resource, id, name string, observed interface{}) (Fingerprint, error) {
+	observedJSON, err := json.Marshal(observed)
+	if err != nil {
+		return Fingerprint{}, fmt.Errorf("newFingerprint: %s %s: %v", resource, id, err)
+	}
+	hash := sha256.Sum256(observedJSON)
+	return Fingerprint{
+		Resource: resource,
+		ID:       id,
+		Name:     name,
+		Hash:     hex.EncodeToString(hash[:]),
+	}, nil
+}
+
+// checkDrift compares the fingerprints observed at plan time with those currently observed and
+// returns an error describing every object that was created, changed or deleted in between.
+func checkDrift(planned, current []Fingerprint) error {
+	key := func(f Fingerprint) string {
+		return f.Resource + "/" + f.ID
+	}
+	currentByKey := make(map[string]Fingerprint)
+	for _, fingerprint := range current {
+		currentByKey[key(fingerprint)] = fingerprint
+	}
+	drifted := make([]string, 0)
+	for _, plannedFingerprint := range planned {
+		currentFingerprint, ok := currentByKey[key(plannedFingerprint)]
+		if !ok {
+			drifted = append(drifted, fmt.Sprintf("%s %s (%s) no longer exists", plannedFingerprint.Resource, plannedFingerprint.Name, plannedFingerprint.ID))
+			continue
+		}
+		if currentFingerprint.Hash != plannedFingerprint.Hash {
+			drifted = append(drifted, fmt.Sprintf("%s %s (%s) has changed", plannedFingerprint.Resource, plannedFingerprint.Name, plannedFingerprint.ID))
+		}
+		delete(currentByKey, key(plannedFingerprint))
+	}
+	for _, fingerprint := range current {
+		if _, ok := currentByKey[key(fingerprint)]; ok {
+			drifted = append(drifted, fmt.Sprintf("%s %s (%s) was created", fingerprint.Resource, fingerprint.Name, fingerprint.ID))
+		}
+	}
+	if len(drifted) != 0 {
+		return fmt.Errorf("checkDrift: DO account has drifted since the plan was made: %s", strings.Join(drifted, ", "))
+	}
+	return nil
+}
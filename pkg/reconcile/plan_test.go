@@ -0,0 +1,391 @@
+package reconcile
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/nolancon/gitdrops/pkg/gitdrops"
+
+	"github.com/digitalocean/godo"
+)
+
+func TestGetChanges(t *testing.T) {
+	tcases := []struct {
+		name             string
+		activeDroplets   []godo.Droplet
+		gitdropsDroplets []gitdrops.Droplet
+		activeVolumes    []godo.Volume
+		gitdropsVolumes  []gitdrops.Volume
+		volumeNameToID   map[string]string
+		expChanges       []Change
+	}{
+		{
+			name: "test case 1 - no changes",
+			activeDroplets: []godo.Droplet{
+				{
+					ID:   1,
+					Name: "droplet-1",
+					Tags: testOwnershipTags,
+				},
+			},
+			gitdropsDroplets: []gitdrops.Droplet{
+				{
+					Name: "droplet-1",
+				},
+			},
+			volumeNameToID: make(map[string]string),
+			expChanges:     []Change{},
+		},
+		{
+			name: "test case 2 - create, update and delete",
+			activeDroplets: []godo.Droplet{
+				{
+					ID:   1,
+					Name: "droplet-1",
+					Tags: testOwnershipTags,
+					Size: &godo.Size{
+						Slug: "s-1vcpu-1gb",
+					},
+				},
+				{
+					ID:   2,
+					Name: "droplet-2",
+					Tags: testOwnershipTags,
+				},
+			},
+			gitdropsDroplets: []gitdrops.Droplet{
+				{
+					Name:    "droplet-1",
+					Size:    "s-1vcpu-2gb",
+					Volumes: []string{"volume-2"},
+				},
+				{
+					Name: "droplet-3",
+				},
+			},
+			activeVolumes: []godo.Volume{
+				{
+					ID:            "abc",
+					Name:          "volume-1",
+					Tags:          testOwnershipTags,
+					SizeGigaBytes: 100,
+				},
+			},
+			gitdropsVolumes: []gitdrops.Volume{
+				{
+					Name:          "volume-2",
+					SizeGigaBytes: 100,
+				},
+			},
+			volumeNameToID: map[string]string{
+				"volume-1": "abc",
+			},
+			expChanges: []Change{
+				{
+					Resource: "volume",
+					Action:   "create",
+					Name:     "volume-2",
+				},
+				{
+					Resource: "volume",
+					Action:   "delete",
+					Name:     "volume-1",
+					ID:       "abc",
+				},
+				{
+					Resource: "droplet",
+					Action:   "create",
+					Name:     "droplet-3",
+				},
+				{
+					Resource: "droplet",
+					Action:   "resize",
+					Name:     "droplet-1",
+					ID:       "1",
+					Value:    "s-1vcpu-2gb",
+				},
+				{
+					Resource: "droplet",
+					Action:   "delete",
+					Name:     "droplet-2",
+					ID:       "2",
+				},
+				{
+					Resource: "attachment",
+					Action:   "attach",
+					Name:     "droplet-1",
+					ID:       "1",
+					Value:    "volume-2",
+				},
+			},
+		},
+	}
+	for _, tc := range tcases {
+		vr := newTestVolumeReconciler(gitdrops.Privileges{}, nil, tc.activeVolumes, tc.gitdropsVolumes)
+		vr.setObjectsToUpdateAndCreate()
+		vr.setObjectsToDelete()
+		dr := newTestDropletReconciler(gitdrops.Privileges{}, nil, tc.activeDroplets, tc.gitdropsDroplets, tc.volumeNameToID)
+		dr.setObjectsToUpdateAndCreate()
+		dr.setObjectsToDelete()
+		ar := newTestAttachmentReconciler(gitdrops.Privileges{}, dr, tc.activeVolumes)
+		ar.setObjectsToUpdateAndCreate()
+		ar.setObjectsToDelete()
+
+		changes := append(vr.getChanges(), dr.getChanges()...)
+		changes = append(changes, ar.getChanges()...)
+		if !reflect.DeepEqual(changes, tc.expChanges) {
+			t.Errorf("Failed %v, expected: %v, got %v", tc.name, tc.expChanges, changes)
+		}
+	}
+}
+
+func TestPlanString(t *testing.T) {
+	tcases := []struct {
+		name          string
+		plan          Plan
+		expString     string
+		expHasChanges bool
+	}{
+		{
+			name:      "test case 1 - no changes",
+			plan:      Plan{},
+			expString: "No changes. DO account is in sync with gitdrops.yaml.\n",
+		},
+		{
+			name: "test case 2 - changes",
+			plan: Plan{
+				Changes: []Change{
+					{
+						Resource: "volume",
+						Action:   "create",
+						Name:     "volume-1",
+					},
+					{
+						Resource: "droplet",
+						Action:   "rebuild",
+						Name:     "droplet-1",
+						ID:       "1",
+						Value:    "centos-8-x64",
+					},
+					{
+						Resource: "droplet",
+						Action:   "delete",
+						Name:     "droplet-2",
+						ID:       "2",
+					},
+				},
+			},
+			expString: "+ create volume volume-1\n" +
+				"~ rebuild droplet droplet-1 (1): centos-8-x64\n" +
+				"- delete droplet droplet-2 (2)\n" +
+				"Plan: 1 to create, 1 to update, 1 to delete.\n",
+			expHasChanges: true,
+		},
+		{
+			name: "test case 3 - requires replacement",
+			plan: Plan{
+				Changes: []Change{
+					{
+						Resource: "droplet",
+						Action:   "requiresReplacement",
+						Name:     "droplet-1",
+						ID:       "1",
+						Value:    "monitoring",
+					},
+					{
+						Resource: "droplet",
+						Action:   "enableBackups",
+						Name:     "droplet-1",
+						ID:       "1",
+					},
+				},
+			},
+			expString: "! droplet droplet-1 (1): monitoring requires replacement\n" +
+				"~ enableBackups droplet droplet-1 (1)\n" +
+				"Plan: 0 to create, 1 to update, 0 to delete.\n" +
+				"1 change(s) cannot be applied in place and require replacement.\n",
+			expHasChanges: true,
+		},
+		{
+			name: "test case 4 - only requires replacement",
+			plan: Plan{
+				Changes: []Change{
+					{
+						Resource: "volume",
+						Action:   "requiresReplacement",
+						Name:     "volume-1",
+						ID:       "abc",
+						Value:    "region",
+					},
+				},
+			},
+			expString: "! volume volume-1 (abc): region requires replacement\n" +
+				"Plan: 0 to create, 0 to update, 0 to delete.\n" +
+				"1 change(s) cannot be applied in place and require replacement.\n",
+			expHasChanges: false,
+		},
+	}
+	for _, tc := range tcases {
+		if tc.plan.String() != tc.expString {
+			t.Errorf("Failed %v, expected: %v, got %v", tc.name, tc.expString, tc.plan.String())
+		}
+		if tc.plan.HasChanges() != tc.expHasChanges {
+			t.Errorf("HasChanges - Failed %v, expected: %v, got %v", tc.name, tc.expHasChanges, tc.plan.HasChanges())
+		}
+	}
+}
+
+// testFingerprint returns the fingerprint of a string, which always marshals.
+func testFingerprint(resource, id, name, observed string) Fingerprint {
+	fingerprint, _ := newFingerprint(resource, id, name, observed)
+	return fingerprint
+}
+
+func TestNewFingerprintError(t *testing.T) {
+	_, err := newFingerprint("droplet", "1", "droplet-1", make(chan int))
+	if err == nil {
+		t.Errorf("Failed, expected error, got %v", err)
+	}
+}
+
+func TestCheckGitDrops(t *testing.T) {
+	gitDrops := gitdrops.GitDrops{
+		Privileges: gitdrops.Privileges{Create: true},
+		Droplets: []gitdrops.Droplet{
+			{Name: "droplet-1", Region: "nyc3", Size: "s-1vcpu-1gb", Image: "ubuntu-20-04-x64"},
+		},
+	}
+	privileges := gitDrops
+	privileges.Privileges = gitdrops.Privileges{Create: true, Delete: true}
+	size := gitDrops
+	size.Droplets = []gitdrops.Droplet{
+		{Name: "droplet-1", Region: "nyc3", Size: "s-2vcpu-2gb", Image: "ubuntu-20-04-x64"},
+	}
+	tcases := []struct {
+		name       string
+		current    gitdrops.GitDrops
+		expChanged bool
+	}{
+		{
+			name:       "test case 1 - unchanged",
+			current:    gitDrops,
+			expChanged: false,
+		},
+		{
+			name:       "test case 2 - privileges changed",
+			current:    privileges,
+			expChanged: true,
+		},
+		{
+			name:       "test case 3 - droplet changed",
+			current:    size,
+			expChanged: true,
+		},
+	}
+	planned, err := hashGitDrops(gitDrops)
+	if err != nil {
+		t.Fatalf("Failed, unexpected error %v", err)
+	}
+	for _, tc := range tcases {
+		current, err := hashGitDrops(tc.current)
+		if err != nil {
+			t.Fatalf("Failed %v, unexpected error %v", tc.name, err)
+		}
+		err = checkGitDrops(planned, current)
+		if (err != nil) != tc.expChanged {
+			t.Errorf("Failed %v, expected changed: %v, got error %v", tc.name, tc.expChanged, err)
+		}
+	}
+}
+
+func TestCheckDrift(t *testing.T) {
+	tcases := []struct {
+		name     string
+		planned  []Fingerprint
+		current  []Fingerprint
+		expDrift bool
+	}{
+		{
+			name: "test case 1 - no drift",
+			planned: []Fingerprint{
+				testFingerprint("droplet", "1", "droplet-1", "a"),
+				testFingerprint("volume", "abc", "volume-1", "b"),
+			},
+			current: []Fingerprint{
+				testFingerprint("volume", "abc", "volume-1", "b"),
+				testFingerprint("droplet", "1", "droplet-1", "a"),
+			},
+			expDrift: false,
+		},
+		{
+			name: "test case 2 - changed",
+			planned: []Fingerprint{
+				testFingerprint("droplet", "1", "droplet-1", "a"),
+			},
+			current: []Fingerprint{
+				testFingerprint("droplet", "1", "droplet-1", "b"),
+			},
+			expDrift: true,
+		},
+		{
+			name: "test case 3 - deleted",
+			planned: []Fingerprint{
+				testFingerprint("droplet", "1", "droplet-1", "a"),
+			},
+			current:  []Fingerprint{},
+			expDrift: true,
+		},
+		{
+			name:    "test case 4 - created",
+			planned: []Fingerprint{},
+			current: []Fingerprint{
+				testFingerprint("volume", "abc", "volume-1", "b"),
+			},
+			expDrift: true,
+		},
+	}
+	for _, tc := range tcases {
+		err := checkDrift(tc.planned, tc.current)
+		if (err != nil) != tc.expDrift {
+			t.Errorf("Failed %v, expected drift: %v, got error %v", tc.name, tc.expDrift, err)
+		}
+	}
+}
+
+func TestDropletSteps(t *testing.T) {
+	dr := newTestDropletReconciler(gitdrops.Privileges{}, nil, nil, nil, nil)
+	dr.dropletsToCreate = []gitdrops.Droplet{
+		{
+			Name:    "droplet-1",
+			Volumes: []string{"volume-1"},
+		},
+	}
+	dr.dropletsToUpdate = map[dropletID][]dropletAction{
+		dropletID(2): []dropletAction{
+			{
+				Action: "rebuild",
+				Image:  "centos-8-x64",
+			},
+		},
+	}
+	dr.dropletsToDelete = []int{3}
+
+	steps, err := marshalSteps(dr)
+	if err != nil {
+		t.Fatalf("Failed, unexpected error %v", err)
+	}
+	loaded := newTestDropletReconciler(gitdrops.Privileges{}, nil, nil, nil, nil)
+	err = loaded.setSteps(steps)
+	if err != nil {
+		t.Fatalf("Failed, unexpected error %v", err)
+	}
+	if !reflect.DeepEqual(loaded.dropletsToCreate, dr.dropletsToCreate) {
+		t.Errorf("DropletsToCreate - Failed, expected: %v, got %v", dr.dropletsToCreate, loaded.dropletsToCreate)
+	}
+	if !reflect.DeepEqual(loaded.dropletsToUpdate, dr.dropletsToUpdate) {
+		t.Errorf("DropletsToUpdate - Failed, expected: %v, got %v", dr.dropletsToUpdate, loaded.dropletsToUpdate)
+	}
+	if !reflect.DeepEqual(loaded.dropletsToDelete, dr.dropletsToDelete) {
+		t.Errorf("DropletsToDelete - Failed, expected: %v, got %v", dr.dropletsToDelete, loaded.dropletsToDelete)
+	}
+}
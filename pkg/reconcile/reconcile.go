@@ -2,9 +2,8 @@ package reconcile
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
-	"log"
-	"os"
 	"time"
 
 	"github.com/nolancon/gitdrops/pkg/gitdrops"
@@ -13,129 +12,325 @@ import (
 )
 
 const (
-	resize            = "resize"
-	rebuild           = "rebuild"
-	attach            = "attach"
-	detach            = "detach"
-	digitaloceanToken = "DIGITALOCEAN_TOKEN"
+	create   = "create"
+	update   = "update"
+	remove   = "delete"
+	resize   = "resize"
+	rebuild  = "rebuild"
+	replace  = "replace"
+	attach   = "attach"
+	detach   = "detach"
+	droplet  = "droplet"
+	volume   = "volume"
+	firewall = "firewall"
+	domain   = "domain"
+	// requiresReplacement reports a change that cannot be applied in place, its Field is the
+	// changed field. It is reported in the plan but never applied.
+	requiresReplacement = "requiresReplacement"
+	enableBackups       = "enableBackups"
+	disableBackups      = "disableBackups"
+	enableIPv6          = "enableIPv6"
+	tag                 = "tag"
+	untag               = "untag"
+	powerOn             = "powerOn"
+	powerOff            = "powerOff"
+	addRule             = "addRule"
+	removeRule          = "removeRule"
+	addDroplet          = "addDroplet"
+	removeDroplet       = "removeDroplet"
+	addTag              = "addTag"
+	removeTag           = "removeTag"
+	createRecord        = "createRecord"
+	editRecord          = "editRecord"
+	deleteRecord        = "deleteRecord"
+	assign              = "assign"
+	unassign            = "unassign"
+	updateDescription   = "updateDescription"
+	// dropletOff is the status of a powered off droplet. godo does not define droplet statuses.
+	dropletOff    = "off"
+	dropletActive = "active"
 )
 
 type objectReconciler interface {
+	// getResourceType returns the type of object reconciled eg droplet, volume
+	getResourceType() string
 	setActiveObjects(context.Context) error
-	setObjectsToUpdateAndCreate()
+	// setObjectsToUpdateAndCreate returns an error if gitdrops.yaml requests a change that can
+	// never be applied
+	setObjectsToUpdateAndCreate() error
 	setObjectsToDelete()
-	getActiveObjects() interface{}
-	getObjectsToCreate() interface{}
-	getObjectsToUpdate() actionsByID
-	getObjectsToDelete() interface{}
-	reconcileObjectsToCreate(context.Context) error
-	// reconcileObjectsToUpdate can optionally be passed actions from another reconciler
-	// eg attach/detach from dropletReconciler to volumeReconciler
-	reconcileObjectsToUpdate(context.Context, actionsByID) error
-	reconcileObjectsToDelete(context.Context) error
+	// getChanges returns the objects to create, update and delete as a list of plan changes.
+	// Updates are listed in the order of the active or declared objects rather than that of
+	// the map of actions, so that the order of changes is stable between plans.
+	getChanges() []Change
+	// getSteps returns the objects to create, update and delete in a serializable form, see
+	// marshalSteps, and setSteps populates the reconciler from them, see unmarshalSteps, so
+	// that a saved plan can be applied verbatim
+	getSteps() interface{}
+	setSteps(json.RawMessage) error
+	// getObservedObjects returns the fields of the active objects that the reconciler compares
+	// or acts on, which are fingerprinted for drift detection, see getFingerprints
+	getObservedObjects() []observedObject
+	// countManagedObjects returns the number of active objects managed by gitdrops, see
+	// ownershipTags
+	countManagedObjects() int
+	// reconcileObjectsToCreate, reconcileObjectsToUpdate and reconcileObjectsToDelete return
+	// the operations that reconcile the objects, provided the reconciler has the privileges to
+	// do so. Each operation declares its dependencies on operations of any reconciler.
+	reconcileObjectsToCreate() []operation
+	reconcileObjectsToUpdate() []operation
+	reconcileObjectsToDelete() []operation
 }
 
-type Reconciler struct {
-	volumeReconciler  objectReconciler
-	dropletReconciler objectReconciler
+// Options configures how a Reconciler applies changes.
+type Options struct {
+	// ActionTimeout is the maximum time to wait for a single droplet or volume action, or for
+	// a new droplet to become active. 0 means wait until the context is done.
+	ActionTimeout time.Duration
+	// ShutdownTimeout is the maximum time to wait for a droplet to shut down gracefully before
+	// it is powered off. 0 means wait until the context is done.
+	ShutdownTimeout time.Duration
+	// Concurrency is the maximum number of operations applied at the same time. Values below 1
+	// are treated as 1, ie operations are applied one at a time.
+	Concurrency int
+	// ContinueOnError attempts every operation that does not depend on a failed operation,
+	// rather than stopping at the first failure. Apply then returns an *ApplyError reporting
+	// all failures.
+	ContinueOnError bool
+	// MaxDeletes and MaxDeletePercent limit the number of objects Apply deletes or replaces, in
+	// total and as a percentage of the objects managed by gitdrops. 0 is no limit. A mass deletion
+	// exceeding either limit is only applied if AllowMassDelete is set or ConfirmDelete is the
+	// token reported by the error of the refused Apply.
+	MaxDeletes       int
+	MaxDeletePercent int
+	AllowMassDelete  bool
+	ConfirmDelete    string
 }
 
-// actionsByID is a slice of actions to be taken on the object. The ID is that of the object
-// itself, we use an interface as the type (string/int) varies per object.
-type actionsByID map[interface{}][]action
-
-type action struct {
-	// action is the name of the action to be taken eg resize, rebuild, attach, detach etc
-	action string
-	// value is eg '10GB' for resize or 'ubuntu-x-x' for rebuild etc
-	value interface{}
+type Reconciler struct {
+	// reconcilers are planned in order. The order in which changes are applied is determined by
+	// the dependencies between their operations.
+	reconcilers []objectReconciler
+	// gitDrops is hashed into a Plan, so that a saved plan is not applied with a different
+	// gitdrops.yaml
+	gitDrops        gitdrops.GitDrops
+	concurrency     int
+	continueOnError bool
+	massDelete      massDeleteLimits
 }
 
-func NewReconciler(ctx context.Context) (Reconciler, error) {
-	gitDrops, err := gitdrops.ReadGitDrops()
-	if err != nil {
-		log.Println(err)
-		return Reconciler{}, fmt.Errorf("NewReconciler: %v", err)
+// NewReconciler returns a Reconciler for the objects defined in gitDrops. client is used to list
+// and modify objects on the DO account.
+func NewReconciler(gitDrops gitdrops.GitDrops, client *godo.Client, opts Options) Reconciler {
+	volumeReconciler := &volumeReconciler{
+		privileges:      gitDrops.Privileges,
+		client:          client,
+		actionTimeout:   opts.ActionTimeout,
+		gitdropsVolumes: gitDrops.Volumes,
+		ownershipTags:   ownershipTags(gitDrops.Stack),
+		snapshotPolicy:  newSnapshotPolicy(gitDrops),
 	}
 
-	client := godo.NewFromToken(os.Getenv(digitaloceanToken))
+	vpcReconciler := &vpcReconciler{
+		privileges:   gitDrops.Privileges,
+		client:       client,
+		gitdropsVPCs: gitDrops.VPCs,
+	}
 
-	volumeReconciler := &volumeReconciler{
+	sshKeyReconciler := &sshKeyReconciler{
 		privileges:      gitDrops.Privileges,
 		client:          client,
-		gitdropsVolumes: gitDrops.Volumes,
+		namePrefix:      sshKeyNamePrefix(gitDrops.Stack),
+		gitdropsSSHKeys: gitDrops.SSHKeys,
 	}
 
+	// droplets reference keys in gitdrops.yaml by name, see withSSHKeyFingerprints
 	dropletReconciler := &dropletReconciler{
 		privileges:       gitDrops.Privileges,
 		client:           client,
+		actionTimeout:    opts.ActionTimeout,
+		shutdownTimeout:  opts.ShutdownTimeout,
+		gitdropsDroplets: withSSHKeyFingerprints(gitDrops.Droplets, gitDrops.SSHKeys),
+		ownershipTags:    ownershipTags(gitDrops.Stack),
+		snapshotPolicy:   newSnapshotPolicy(gitDrops),
+	}
+	// the attachment reconciler is planned after the droplet reconciler, see droplets
+	attachmentReconciler := &attachmentReconciler{
+		privileges:       gitDrops.Privileges,
+		client:           client,
+		actionTimeout:    opts.ActionTimeout,
+		droplets:         dropletReconciler,
 		gitdropsDroplets: gitDrops.Droplets,
 	}
+	// the firewall reconciler is planned after the droplet reconciler, see firewalls
+	firewallReconciler := &firewallReconciler{
+		privileges:        gitDrops.Privileges,
+		client:            client,
+		actionTimeout:     opts.ActionTimeout,
+		namePrefix:        firewallNamePrefix(gitDrops.Stack),
+		droplets:          dropletReconciler,
+		gitdropsFirewalls: gitDrops.Firewalls,
+	}
+	// the domain reconciler is planned after the droplet reconciler, see domains
+	domainReconciler := &domainReconciler{
+		privileges:      gitDrops.Privileges,
+		client:          client,
+		droplets:        dropletReconciler,
+		gitdropsDomains: gitDrops.Domains,
+	}
+	// the reserved IP reconciler is planned after the droplet reconciler, see reservedIPs
+	reservedIPReconciler := &reservedIPReconciler{
+		privileges:          gitDrops.Privileges,
+		client:              client,
+		actionTimeout:       opts.ActionTimeout,
+		droplets:            dropletReconciler,
+		gitdropsReservedIPs: gitDrops.ReservedIPs,
+	}
 	return Reconciler{
-		volumeReconciler:  volumeReconciler,
-		dropletReconciler: dropletReconciler,
-	}, nil
-}
-
-func (r *Reconciler) Reconcile(ctx context.Context) error {
-	err := r.volumeReconciler.setActiveObjects(ctx)
-	if err != nil {
-		return fmt.Errorf("Reconcile: %v", err)
+		reconcilers:     []objectReconciler{vpcReconciler, sshKeyReconciler, volumeReconciler, dropletReconciler, attachmentReconciler, firewallReconciler, domainReconciler, reservedIPReconciler},
+		gitDrops:        gitDrops,
+		concurrency:     opts.Concurrency,
+		continueOnError: opts.ContinueOnError,
+		massDelete: massDeleteLimits{
+			maxDeletes:       opts.MaxDeletes,
+			maxDeletePercent: opts.MaxDeletePercent,
+			allowMassDelete:  opts.AllowMassDelete,
+			confirmToken:     opts.ConfirmDelete,
+		},
 	}
-	r.volumeReconciler.setObjectsToUpdateAndCreate()
+}
 
-	err = r.volumeReconciler.reconcileObjectsToCreate(ctx)
-	if err != nil {
-		return fmt.Errorf("Reconcile: %v", err)
+// Plan populates the reconcilers with the objects to create, update and delete and returns them
+// as a Plan. Plan only lists objects on the DO account, it never modifies them.
+func (r *Reconciler) Plan(ctx context.Context) (Plan, error) {
+	plan := Plan{
+		Changes:  make([]Change, 0),
+		Steps:    make(map[string]json.RawMessage),
+		Observed: make([]Fingerprint, 0),
 	}
-	err = r.volumeReconciler.reconcileObjectsToUpdate(ctx, nil)
+	gitDropsHash, err := hashGitDrops(r.gitDrops)
 	if err != nil {
-		return fmt.Errorf("Reconcile: %v", err)
+		return Plan{}, fmt.Errorf("Plan: %v", err)
 	}
+	plan.GitDrops = gitDropsHash
+	for _, reconciler := range r.reconcilers {
+		err := reconciler.setActiveObjects(ctx)
+		if err != nil {
+			return Plan{}, fmt.Errorf("Plan: %v", err)
+		}
+		err = reconciler.setObjectsToUpdateAndCreate()
+		if err != nil {
+			return Plan{}, fmt.Errorf("Plan: %v", err)
+		}
+		reconciler.setObjectsToDelete()
 
-	// wait 10 seconds to allow any volume creation before droplet creation,
-	// also allow time for volumes to attach detach
-	log.Println("Reconcile: waiting for volumes to update before reconciling droplets (10s)...")
-	time.Sleep(10 * time.Second)
+		plan.Changes = append(plan.Changes, reconciler.getChanges()...)
+		plan.Steps[reconciler.getResourceType()], err = marshalSteps(reconciler)
+		if err != nil {
+			return Plan{}, fmt.Errorf("Plan: %v", err)
+		}
+		fingerprints, err := getFingerprints(reconciler)
+		if err != nil {
+			return Plan{}, fmt.Errorf("Plan: %v", err)
+		}
+		plan.Observed = append(plan.Observed, fingerprints...)
+	}
+	return plan, nil
+}
 
-	err = r.dropletReconciler.setActiveObjects(ctx)
+// LoadPlan populates the reconcilers with the steps of a saved plan so that Apply executes
+// exactly those steps. LoadPlan returns an error if gitdrops.yaml has changed or any object on
+// the DO account has been created, changed or deleted since the plan was made.
+func (r *Reconciler) LoadPlan(ctx context.Context, plan Plan) error {
+	gitDropsHash, err := hashGitDrops(r.gitDrops)
 	if err != nil {
-		return fmt.Errorf("Reconcile: %v", err)
+		return fmt.Errorf("LoadPlan: %v", err)
 	}
-	r.dropletReconciler.setObjectsToUpdateAndCreate()
-	err = r.dropletReconciler.reconcileObjectsToCreate(ctx)
+	err = checkGitDrops(plan.GitDrops, gitDropsHash)
 	if err != nil {
-		return fmt.Errorf("Reconcile: %v", err)
+		return fmt.Errorf("LoadPlan: %v", err)
 	}
-	err = r.dropletReconciler.reconcileObjectsToUpdate(ctx, nil)
+
+	observed := make([]Fingerprint, 0)
+	for _, reconciler := range r.reconcilers {
+		err := reconciler.setActiveObjects(ctx)
+		if err != nil {
+			return fmt.Errorf("LoadPlan: %v", err)
+		}
+		fingerprints, err := getFingerprints(reconciler)
+		if err != nil {
+			return fmt.Errorf("LoadPlan: %v", err)
+		}
+		observed = append(observed, fingerprints...)
+	}
+	err = checkDrift(plan.Observed, observed)
 	if err != nil {
-		return fmt.Errorf("Reconcile: %v", err)
+		return fmt.Errorf("LoadPlan: %v", err)
 	}
 
-	r.dropletReconciler.setObjectsToDelete()
-	err = r.dropletReconciler.reconcileObjectsToDelete(ctx)
-	if err != nil {
-		return fmt.Errorf("Reconcile: %v", err)
+	for _, reconciler := range r.reconcilers {
+		err = reconciler.setSteps(plan.Steps[reconciler.getResourceType()])
+		if err != nil {
+			return fmt.Errorf("LoadPlan: %v", err)
+		}
 	}
+	return nil
+}
 
-	// reset active objects in the volume reconciler as new volumes may have been created during
-	// initial volume reconciliation and we need to search again for volume attach/detach actions.
-	err = r.volumeReconciler.setActiveObjects(ctx)
+// Apply executes the changes computed by the previous call to Plan or loaded by LoadPlan. The
+// operations of all reconcilers are executed in the order required by their dependencies, eg a
+// volume is created before a droplet it is attached to and deleted after it is detached. Each
+// operation waits for its droplets to become active and its actions to complete. Independent
+// operations are applied concurrently, up to Options.Concurrency at a time, but never two
+// operations acting on the same droplet or volume. The outcome of each operation is logged per
+// droplet and volume. Apply refuses to start if the plan deletes more objects than allowed by
+// Options.MaxDeletes or Options.MaxDeletePercent.
+func (r *Reconciler) Apply(ctx context.Context) error {
+	operations := r.getOperations()
+	err := r.checkMassDelete(operations)
 	if err != nil {
-		return fmt.Errorf("Reconcile: %v", err)
+		return fmt.Errorf("Apply: %v", err)
 	}
-	objectsToUpdate := r.dropletReconciler.getObjectsToUpdate()
-	// pass objects to update from droplet reconciler to volume reconciler as they now contain
-	// actions for volumes to attach/detach from droplets based on droplet reconciliation
-	err = r.volumeReconciler.reconcileObjectsToUpdate(ctx, objectsToUpdate)
-	if err != nil {
-		return fmt.Errorf("Reconcile: %v", err)
+	results, err := operations.execute(ctx, r.concurrency, r.continueOnError)
+	summarize(results)
+	if r.continueOnError {
+		if applyError := newApplyError(results); applyError != nil {
+			return applyError
+		}
 	}
-	r.volumeReconciler.setObjectsToDelete()
-	err = r.volumeReconciler.reconcileObjectsToDelete(ctx)
 	if err != nil {
-		return fmt.Errorf("Reconcile: %v", err)
+		return fmt.Errorf("Apply: %v", err)
 	}
-
 	return nil
 }
+
+// checkMassDelete returns an error if operations delete more objects than allowed. Replacing an
+// object deletes it, so replacements count as deletions, as do domain record deletions.
+func (r *Reconciler) checkMassDelete(operations graph) error {
+	deleteKeys := make([]string, 0)
+	for _, op := range operations.operations {
+		if _, action, _ := splitOperationKey(op.key); action == remove || action == replace || action == deleteRecord {
+			deleteKeys = append(deleteKeys, op.key)
+		}
+	}
+	managed := 0
+	for _, reconciler := range r.reconcilers {
+		managed += reconciler.countManagedObjects()
+	}
+	return r.massDelete.check(deleteKeys, managed)
+}
+
+// getOperations returns the graph of operations of all reconcilers.
+func (r *Reconciler) getOperations() graph {
+	operations := graph{}
+	for _, reconciler := range r.reconcilers {
+		operations.add(reconciler.reconcileObjectsToCreate()...)
+	}
+	for _, reconciler := range r.reconcilers {
+		operations.add(reconciler.reconcileObjectsToUpdate()...)
+	}
+	for _, reconciler := range r.reconcilers {
+		operations.add(reconciler.reconcileObjectsToDelete()...)
+	}
+	return operations
+}
@@ -0,0 +1,72 @@
+package reconcile
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Failure is an operation of Apply that failed, or was skipped because an operation it depends
+// on failed.
+type Failure struct {
+	Resource  string `json:"resource"`
+	Operation string `json:"operation"`
+	// ID is the ID of the object, or its name if it was to be created
+	ID    string `json:"id"`
+	Error string `json:"error"`
+	// Attempts is the number of DO requests made by the operation, including retries. It is 0
+	// for skipped operations.
+	Attempts int `json:"attempts"`
+	// Snapshots are the IDs of the snapshots taken by the operation before it failed
+	Snapshots []string `json:"snapshots,omitempty"`
+}
+
+func (f Failure) String() string {
+	failure := fmt.Sprintf("%s %s %s: %s (%d attempt(s))", f.Operation, f.Resource, f.ID, f.Error, f.Attempts)
+	if len(f.Snapshots) != 0 {
+		failure += fmt.Sprintf(" (snapshot %s)", strings.Join(f.Snapshots, ", "))
+	}
+	return failure
+}
+
+// ApplyError is returned by Apply in continue-on-error mode, see Options.ContinueOnError. It
+// reports every operation that failed or was skipped.
+type ApplyError struct {
+	Failures []Failure `json:"failures"`
+	// Operations is the number of operations attempted or skipped
+	Operations int `json:"operations"`
+}
+
+func (e *ApplyError) Error() string {
+	lines := make([]string, 0, len(e.Failures)+1)
+	lines = append(lines, fmt.Sprintf("%d of %d operation(s) failed:", len(e.Failures), e.Operations))
+	for _, failure := range e.Failures {
+		lines = append(lines, "  "+failure.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// newApplyError returns an ApplyError for the failed results, or nil if no result failed.
+func newApplyError(results []operationResult) *ApplyError {
+	applyError := &ApplyError{
+		Failures:   make([]Failure, 0),
+		Operations: len(results),
+	}
+	for _, result := range results {
+		if result.err == nil {
+			continue
+		}
+		resource, action, id := splitOperationKey(result.key)
+		applyError.Failures = append(applyError.Failures, Failure{
+			Resource:  resource,
+			Operation: action,
+			ID:        id,
+			Error:     result.err.Error(),
+			Attempts:  result.attempts,
+			Snapshots: result.snapshots,
+		})
+	}
+	if len(applyError.Failures) == 0 {
+		return nil
+	}
+	return applyError
+}
@@ -0,0 +1,46 @@
+package reconcile
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestNewApplyError(t *testing.T) {
+	tcases := []struct {
+		name          string
+		results       []operationResult
+		expApplyError *ApplyError
+	}{
+		{
+			name: "test case 1 - no failures",
+			results: []operationResult{
+				{key: "droplet/create/droplet-1", attempts: 1},
+			},
+			expApplyError: nil,
+		},
+		{
+			name: "test case 2 - failed and skipped",
+			results: []operationResult{
+				{key: "volume/create/volume-1", err: errors.New("invalid size"), attempts: 1},
+				{key: "droplet/create/droplet-1", err: errors.New("skipped as volume/create/volume-1 failed")},
+				{key: "droplet/delete/2", attempts: 3},
+				{key: "volume/delete/abc", err: errors.New("volume is attached"), attempts: 4, snapshots: []string{"snap-1"}},
+			},
+			expApplyError: &ApplyError{
+				Failures: []Failure{
+					{Resource: "volume", Operation: "create", ID: "volume-1", Error: "invalid size", Attempts: 1},
+					{Resource: "droplet", Operation: "create", ID: "droplet-1", Error: "skipped as volume/create/volume-1 failed"},
+					{Resource: "volume", Operation: "delete", ID: "abc", Error: "volume is attached", Attempts: 4, Snapshots: []string{"snap-1"}},
+				},
+				Operations: 4,
+			},
+		},
+	}
+	for _, tc := range tcases {
+		applyError := newApplyError(tc.results)
+		if !reflect.DeepEqual(applyError, tc.expApplyError) {
+			t.Errorf("Failed %v, expected: %v, got %v", tc.name, tc.expApplyError, applyError)
+		}
+	}
+}
@@ -0,0 +1,397 @@
+package reconcile
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nolancon/gitdrops/pkg/gitdrops"
+
+	"github.com/digitalocean/godo"
+)
+
+const reservedIPResource = "reservedIP"
+
+// reservedIPReconciler reserves the IPs declared in gitdrops.yaml and assigns them to their
+// droplets. DO reserved IPs have no name and cannot be tagged: a reserved IP is found by its
+// address, or by the droplet it is assigned to if no address is declared. gitdrops cannot tell
+// which reserved IPs it created and never deletes a reserved IP.
+type reservedIPReconciler struct {
+	privileges    gitdrops.Privileges
+	client        *godo.Client
+	actionTimeout time.Duration
+	// droplets is the droplet reconciler, which must be planned first. Droplet names are
+	// resolved to the IDs of its active droplets.
+	droplets            *dropletReconciler
+	gitdropsReservedIPs []gitdrops.ReservedIP
+	activeReservedIPs   []godo.FloatingIP
+	reservedIPsToCreate []gitdrops.ReservedIP
+	reservedIPsToUpdate map[reservedIPAddress][]reservedIPAction
+	// mu guards appliedDroplets, the droplets listed when applying to find droplets created
+	// after planning
+	mu              sync.Mutex
+	appliedDroplets []godo.Droplet
+}
+
+var _ objectReconciler = &reservedIPReconciler{}
+
+// reservedIPSteps is the serializable form of the reserved IPs to create and update.
+type reservedIPSteps struct {
+	Create []gitdrops.ReservedIP                    `json:"create"`
+	Update map[reservedIPAddress][]reservedIPAction `json:"update"`
+}
+
+func (rr *reservedIPReconciler) getResourceType() string {
+	return reservedIPResource
+}
+
+func (rr *reservedIPReconciler) setActiveObjects(ctx context.Context) error {
+	activeReservedIPs, err := gitdrops.ListReservedIPs(ctx, rr.client)
+	if err != nil {
+		return fmt.Errorf("reservedIPReconciler.setActiveObjects: %v", err)
+	}
+	rr.activeReservedIPs = activeReservedIPs
+	log.Println("reservedIPReconciler.setActiveObjects: active reserved IPs", len(rr.activeReservedIPs))
+	return nil
+}
+
+// setObjectsToUpdateAndCreate populates reservedIPReconciler with the reserved IPs declared in
+// gitdrops.yaml without an address whose droplet has no reserved IP, and with the actions that
+// assign the active reserved IPs to their droplets. A declared address that is not reserved on
+// DO cannot be reserved again and is only logged.
+func (rr *reservedIPReconciler) setObjectsToUpdateAndCreate() error {
+	reservedIPsToCreate := make([]gitdrops.ReservedIP, 0)
+	reservedIPActionsByID := make(map[reservedIPAddress][]reservedIPAction)
+	for _, gitdropsReservedIP := range rr.gitdropsReservedIPs {
+		activeReservedIP, ok := rr.findActiveReservedIP(gitdropsReservedIP)
+		if !ok {
+			if gitdropsReservedIP.IP != "" {
+				log.Println("reservedIPReconciler.setObjectsToUpdateAndCreate: reserved IP", gitdropsReservedIP.IP, "not found, gitdrops cannot reserve a given address")
+				continue
+			}
+			reservedIPsToCreate = append(reservedIPsToCreate, gitdropsReservedIP)
+			continue
+		}
+		reservedIPActions := rr.getReservedIPActions(gitdropsReservedIP, activeReservedIP)
+		if len(reservedIPActions) != 0 {
+			reservedIPActionsByID[reservedIPAddress(activeReservedIP.IP)] = reservedIPActions
+		}
+	}
+	rr.reservedIPsToCreate = reservedIPsToCreate
+	rr.reservedIPsToUpdate = reservedIPActionsByID
+	log.Println("reservedIPReconciler.setObjectsToUpdateAndCreate: reserved IPs to create", rr.reservedIPsToCreate)
+	log.Println("reservedIPReconciler.setObjectsToUpdateAndCreate: reserved IPs to update", rr.reservedIPsToUpdate)
+	return nil
+}
+
+// setObjectsToDelete only logs the active reserved IPs not declared in gitdrops.yaml, reserved
+// IPs are never deleted.
+func (rr *reservedIPReconciler) setObjectsToDelete() {
+	for _, activeReservedIP := range rr.activeReservedIPs {
+		if !rr.isDeclared(activeReservedIP) {
+			log.Println("reservedIPReconciler.setObjectsToDelete: reserved IP", activeReservedIP.IP, "is not declared in gitdrops.yaml, gitdrops does not delete reserved IPs")
+		}
+	}
+}
+
+func (rr *reservedIPReconciler) getChanges() []Change {
+	changes := make([]Change, 0)
+	for _, reservedIPToCreate := range rr.reservedIPsToCreate {
+		changes = append(changes, Change{Resource: reservedIPResource, Action: create, Name: reservedIPToCreate.Droplet, Value: reservedIPToCreate.Region})
+	}
+	for _, activeReservedIP := range rr.activeReservedIPs {
+		for _, reservedIPAction := range rr.reservedIPsToUpdate[reservedIPAddress(activeReservedIP.IP)] {
+			changes = append(changes, Change{
+				Resource: reservedIPResource,
+				Action:   reservedIPAction.Action,
+				Name:     activeReservedIP.IP,
+				Value:    reservedIPAction.value(),
+			})
+		}
+	}
+	return changes
+}
+
+func (rr *reservedIPReconciler) getSteps() interface{} {
+	return reservedIPSteps{
+		Create: rr.reservedIPsToCreate,
+		Update: rr.reservedIPsToUpdate,
+	}
+}
+
+func (rr *reservedIPReconciler) setSteps(stepsJSON json.RawMessage) error {
+	steps := reservedIPSteps{}
+	err := unmarshalSteps(stepsJSON, &steps)
+	if err != nil {
+		return fmt.Errorf("reservedIPReconciler.setSteps: %v", err)
+	}
+	if steps.Update == nil {
+		steps.Update = make(map[reservedIPAddress][]reservedIPAction)
+	}
+	rr.reservedIPsToCreate = steps.Create
+	rr.reservedIPsToUpdate = steps.Update
+	return nil
+}
+
+// getObservedObjects returns the region and droplet of every reserved IP.
+func (rr *reservedIPReconciler) getObservedObjects() []observedObject {
+	observedObjects := make([]observedObject, 0)
+	for _, activeReservedIP := range rr.activeReservedIPs {
+		observed := struct {
+			Region    string `json:"region"`
+			DropletID int    `json:"dropletID"`
+		}{}
+		if activeReservedIP.Region != nil {
+			observed.Region = activeReservedIP.Region.Slug
+		}
+		if activeReservedIP.Droplet != nil {
+			observed.DropletID = activeReservedIP.Droplet.ID
+		}
+		observedObjects = append(observedObjects, observedObject{
+			id:       activeReservedIP.IP,
+			name:     activeReservedIP.IP,
+			observed: observed,
+		})
+	}
+	return observedObjects
+}
+
+// countManagedObjects returns 0, reserved IPs are never deleted.
+func (rr *reservedIPReconciler) countManagedObjects() int {
+	return 0
+}
+
+// getReservedIPActions returns the actions that assign activeReservedIP to the droplet of
+// gitdropsReservedIP, or unassign it if it has no droplet. Droplets that are yet to be created
+// or are replaced are assigned by name, as their IDs are not known until the droplet is created.
+// DO unassigns the reserved IP of a replaced droplet when it is deleted.
+func (rr *reservedIPReconciler) getReservedIPActions(gitdropsReservedIP gitdrops.ReservedIP, activeReservedIP godo.FloatingIP) []reservedIPAction {
+	if gitdropsReservedIP.Droplet == "" {
+		if activeReservedIP.Droplet != nil {
+			return []reservedIPAction{{Action: unassign, Droplet: activeReservedIP.Droplet.Name, DropletID: activeReservedIP.Droplet.ID}}
+		}
+		return nil
+	}
+	activeDroplet, ok := rr.findActiveDroplet(gitdropsReservedIP.Droplet)
+	if !ok || rr.droplets.replaces(activeDroplet.ID) {
+		return []reservedIPAction{{Action: assign, Droplet: gitdropsReservedIP.Droplet}}
+	}
+	if activeReservedIP.Droplet == nil || activeReservedIP.Droplet.ID != activeDroplet.ID {
+		return []reservedIPAction{{Action: assign, Droplet: gitdropsReservedIP.Droplet, DropletID: activeDroplet.ID}}
+	}
+	return nil
+}
+
+func (rr *reservedIPReconciler) reconcileObjectsToCreate() []operation {
+	operations := make([]operation, 0)
+	if len(rr.reservedIPsToCreate) != 0 {
+		if rr.privileges.Allows(reservedIPResource, create) {
+			log.Println("reservedIPReconciler.reconcileObjectsToCreate: create reserved IPs", rr.reservedIPsToCreate)
+			operations = rr.createOperations()
+		} else {
+			log.Println("gitdrops discovered reserved IPs to create, but does not have create privileges")
+		}
+	}
+	return operations
+}
+
+func (rr *reservedIPReconciler) reconcileObjectsToUpdate() []operation {
+	operations := make([]operation, 0)
+	if len(rr.reservedIPsToUpdate) != 0 {
+		log.Println("reservedIPReconciler.reconcileObjectsToUpdate: update reserved IPs", rr.reservedIPsToUpdate)
+		operations = rr.updateOperations()
+	}
+	return operations
+}
+
+// reconcileObjectsToDelete returns no operations, reserved IPs are never deleted.
+func (rr *reservedIPReconciler) reconcileObjectsToDelete() []operation {
+	return []operation{}
+}
+
+// createOperations returns an operation per reserved IP to create, each depending on the
+// creation or replacement of its droplet. The IP is reserved by assigning it to the droplet.
+func (rr *reservedIPReconciler) createOperations() []operation {
+	operations := make([]operation, 0)
+	for _, reservedIPToCreate := range rr.reservedIPsToCreate {
+		reservedIPToCreate := reservedIPToCreate
+		operations = append(operations, operation{
+			key:       operationKey(reservedIPResource, create, reservedIPToCreate.Droplet),
+			dependsOn: rr.dropletDependencies(reservedIPToCreate.Droplet),
+			run: func(ctx context.Context) error {
+				return rr.createObject(ctx, reservedIPToCreate)
+			},
+		})
+	}
+	return operations
+}
+
+func (rr *reservedIPReconciler) createObject(ctx context.Context, reservedIPToCreate gitdrops.ReservedIP) error {
+	id, err := rr.lockedFindDropletID(ctx, reservedIPToCreate.Droplet)
+	if err != nil {
+		return fmt.Errorf("reservedIPReconciler.createObject: %v", err)
+	}
+	reservedIP, err := gitdrops.CreateReservedIP(ctx, rr.client, &godo.FloatingIPCreateRequest{
+		DropletID: id,
+	})
+	if err != nil {
+		return fmt.Errorf("reservedIPReconciler.createObject: %v", err)
+	}
+	log.Println("reservedIPReconciler.createObject: reserved IP", reservedIP.IP, "for droplet", reservedIPToCreate.Droplet)
+	return nil
+}
+
+// updateOperations returns an operation per reserved IP to update, with the actions gitdrops
+// has the privileges for. Droplets assigned by name are assigned once they are created or
+// replaced.
+func (rr *reservedIPReconciler) updateOperations() []operation {
+	ips := make([]string, 0, len(rr.reservedIPsToUpdate))
+	for ip := range rr.reservedIPsToUpdate {
+		ips = append(ips, string(ip))
+	}
+	sort.Strings(ips)
+
+	operations := make([]operation, 0)
+	for _, ip := range ips {
+		ip := ip
+		reservedIPActions := make([]reservedIPAction, 0)
+		dependsOn := make([]string, 0)
+		// DO rejects concurrent actions on the same droplet
+		locks := []string{lockKey(reservedIPResource, ip)}
+		for _, reservedIPAction := range rr.reservedIPsToUpdate[reservedIPAddress(ip)] {
+			if !rr.privileges.Allows(reservedIPResource, reservedIPAction.Action) {
+				log.Printf("gitdrops discovered reserved IPs to %s, but does not have %s privileges", reservedIPAction.Action, reservedIPAction.Action)
+				continue
+			}
+			reservedIPActions = append(reservedIPActions, reservedIPAction)
+			if reservedIPAction.DropletID == 0 {
+				dependsOn = append(dependsOn, rr.dropletDependencies(reservedIPAction.Droplet)...)
+			} else {
+				dependsOn = append(dependsOn, operationKey(droplet, update, strconv.Itoa(reservedIPAction.DropletID)))
+				locks = append(locks, lockKey(droplet, strconv.Itoa(reservedIPAction.DropletID)))
+			}
+		}
+		if len(reservedIPActions) == 0 {
+			continue
+		}
+		operations = append(operations, operation{
+			key:       operationKey(reservedIPResource, update, ip),
+			dependsOn: dependsOn,
+			locks:     locks,
+			run: func(ctx context.Context) error {
+				return rr.updateObject(ctx, ip, reservedIPActions)
+			},
+		})
+	}
+	return operations
+}
+
+// dropletDependencies returns the keys of the operations creating or replacing the droplet
+// named dropletName.
+func (rr *reservedIPReconciler) dropletDependencies(dropletName string) []string {
+	dependsOn := []string{operationKey(droplet, create, dropletName)}
+	if activeDroplet, ok := rr.findActiveDroplet(dropletName); ok && rr.droplets.replaces(activeDroplet.ID) {
+		dependsOn = append(dependsOn, operationKey(droplet, replace, strconv.Itoa(activeDroplet.ID)))
+	}
+	return dependsOn
+}
+
+func (rr *reservedIPReconciler) updateObject(ctx context.Context, ip string, reservedIPActions []reservedIPAction) error {
+	for _, reservedIPAction := range reservedIPActions {
+		var ipAction *godo.Action
+		var err error
+		switch reservedIPAction.Action {
+		case assign:
+			assignedDropletID := reservedIPAction.DropletID
+			if assignedDropletID == 0 {
+				// the droplet was created or replaced after planning
+				assignedDropletID, err = rr.lockedFindDropletID(ctx, reservedIPAction.Droplet)
+				if err != nil {
+					break
+				}
+			}
+			ipAction, err = gitdrops.AssignReservedIP(ctx, rr.client, ip, assignedDropletID)
+		case unassign:
+			ipAction, err = gitdrops.UnassignReservedIP(ctx, rr.client, ip)
+		default:
+			err = fmt.Errorf("unknown action %q", reservedIPAction.Action)
+		}
+		if err == nil {
+			err = gitdrops.WaitForAction(ctx, rr.client, ipAction, rr.actionTimeout)
+		}
+		if err != nil {
+			return fmt.Errorf("reservedIPReconciler.updateObject: %v", err)
+		}
+	}
+	return nil
+}
+
+// lockedFindDropletID returns the ID of the droplet named name while holding rr.mu, listing the
+// droplets on DO again if it is not yet known. Droplets replaced by the droplet reconciler are
+// ignored, so that the ID is that of the new droplet.
+func (rr *reservedIPReconciler) lockedFindDropletID(ctx context.Context, name string) (int, error) {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+	if id, ok := rr.findAppliedDropletID(name); ok {
+		return id, nil
+	}
+	appliedDroplets, err := gitdrops.ListDroplets(ctx, rr.client)
+	if err != nil {
+		return 0, err
+	}
+	rr.appliedDroplets = appliedDroplets
+	if id, ok := rr.findAppliedDropletID(name); ok {
+		return id, nil
+	}
+	return 0, fmt.Errorf("droplet %q not found", name)
+}
+
+func (rr *reservedIPReconciler) findAppliedDropletID(name string) (int, bool) {
+	for _, appliedDroplet := range rr.appliedDroplets {
+		if appliedDroplet.Name == name && !rr.droplets.replaces(appliedDroplet.ID) {
+			return appliedDroplet.ID, true
+		}
+	}
+	return 0, false
+}
+
+// findActiveReservedIP returns the active reserved IP with the address of gitdropsReservedIP,
+// or if it has no address, the active reserved IP in its region assigned to its droplet.
+func (rr *reservedIPReconciler) findActiveReservedIP(gitdropsReservedIP gitdrops.ReservedIP) (godo.FloatingIP, bool) {
+	for _, activeReservedIP := range rr.activeReservedIPs {
+		if gitdropsReservedIP.IP != "" {
+			if activeReservedIP.IP == gitdropsReservedIP.IP {
+				return activeReservedIP, true
+			}
+			continue
+		}
+		if activeReservedIP.Droplet != nil && activeReservedIP.Droplet.Name == gitdropsReservedIP.Droplet &&
+			activeReservedIP.Region != nil && activeReservedIP.Region.Slug == gitdropsReservedIP.Region {
+			return activeReservedIP, true
+		}
+	}
+	return godo.FloatingIP{}, false
+}
+
+func (rr *reservedIPReconciler) isDeclared(activeReservedIP godo.FloatingIP) bool {
+	for _, gitdropsReservedIP := range rr.gitdropsReservedIPs {
+		if found, ok := rr.findActiveReservedIP(gitdropsReservedIP); ok && found.IP == activeReservedIP.IP {
+			return true
+		}
+	}
+	return false
+}
+
+func (rr *reservedIPReconciler) findActiveDroplet(name string) (godo.Droplet, bool) {
+	for _, activeDroplet := range rr.droplets.activeDroplets {
+		if activeDroplet.Name == name {
+			return activeDroplet, true
+		}
+	}
+	return godo.Droplet{}, false
+}
@@ -0,0 +1,233 @@
+package reconcile
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/nolancon/gitdrops/pkg/gitdrops"
+
+	"github.com/digitalocean/godo"
+)
+
+func newTestReservedIPReconciler(privileges gitdrops.Privileges, droplets *dropletReconciler, activeReservedIPs []godo.FloatingIP, gitdropsReservedIPs []gitdrops.ReservedIP) *reservedIPReconciler {
+	return &reservedIPReconciler{
+		privileges:          privileges,
+		droplets:            droplets,
+		activeReservedIPs:   activeReservedIPs,
+		gitdropsReservedIPs: gitdropsReservedIPs,
+	}
+}
+
+func TestSetReservedIPsToUpdateCreate(t *testing.T) {
+	nyc3 := &godo.Region{Slug: "nyc3"}
+	tcases := []struct {
+		name                string
+		activeDroplets      []godo.Droplet
+		gitdropsDroplets    []gitdrops.Droplet
+		activeReservedIPs   []godo.FloatingIP
+		gitdropsReservedIPs []gitdrops.ReservedIP
+		reservedIPsToCreate []gitdrops.ReservedIP
+		reservedIPsToUpdate map[reservedIPAddress][]reservedIPAction
+	}{
+		{
+			name: "test case 1 - create reserved ip",
+			gitdropsDroplets: []gitdrops.Droplet{
+				{
+					Name:   "droplet-1",
+					Region: "nyc3",
+				},
+			},
+			activeReservedIPs: []godo.FloatingIP{
+				{IP: "203.0.113.1", Region: nyc3},
+			},
+			gitdropsReservedIPs: []gitdrops.ReservedIP{
+				{Region: "nyc3", Droplet: "droplet-1"},
+				{Region: "nyc3", IP: "203.0.113.2"},
+			},
+			reservedIPsToCreate: []gitdrops.ReservedIP{
+				{Region: "nyc3", Droplet: "droplet-1"},
+			},
+			reservedIPsToUpdate: map[reservedIPAddress][]reservedIPAction{},
+		},
+		{
+			name: "test case 2 - no change",
+			activeDroplets: []godo.Droplet{
+				{
+					ID:     1,
+					Name:   "droplet-1",
+					Region: nyc3,
+				},
+				{
+					ID:     2,
+					Name:   "droplet-2",
+					Region: nyc3,
+				},
+			},
+			gitdropsDroplets: []gitdrops.Droplet{
+				{
+					Name:   "droplet-1",
+					Region: "nyc3",
+				},
+				{
+					Name:   "droplet-2",
+					Region: "nyc3",
+				},
+			},
+			activeReservedIPs: []godo.FloatingIP{
+				{IP: "203.0.113.1", Region: nyc3, Droplet: &godo.Droplet{ID: 1, Name: "droplet-1"}},
+				{IP: "203.0.113.2", Region: nyc3, Droplet: &godo.Droplet{ID: 2, Name: "droplet-2"}},
+			},
+			gitdropsReservedIPs: []gitdrops.ReservedIP{
+				{Region: "nyc3", Droplet: "droplet-1"},
+				{Region: "nyc3", Droplet: "droplet-2", IP: "203.0.113.2"},
+			},
+			reservedIPsToCreate: []gitdrops.ReservedIP{},
+			reservedIPsToUpdate: map[reservedIPAddress][]reservedIPAction{},
+		},
+		{
+			name: "test case 3 - assign, reassign and unassign",
+			activeDroplets: []godo.Droplet{
+				{
+					ID:     1,
+					Name:   "droplet-1",
+					Region: nyc3,
+				},
+				{
+					ID:     2,
+					Name:   "droplet-2",
+					Region: nyc3,
+				},
+			},
+			gitdropsDroplets: []gitdrops.Droplet{
+				{
+					Name:   "droplet-1",
+					Region: "nyc3",
+				},
+				{
+					Name:   "droplet-2",
+					Region: "nyc3",
+				},
+			},
+			activeReservedIPs: []godo.FloatingIP{
+				{IP: "203.0.113.1", Region: nyc3},
+				{IP: "203.0.113.2", Region: nyc3, Droplet: &godo.Droplet{ID: 1, Name: "droplet-1"}},
+				{IP: "203.0.113.3", Region: nyc3, Droplet: &godo.Droplet{ID: 3, Name: "droplet-3"}},
+			},
+			gitdropsReservedIPs: []gitdrops.ReservedIP{
+				{Region: "nyc3", Droplet: "droplet-1", IP: "203.0.113.1"},
+				{Region: "nyc3", Droplet: "droplet-2", IP: "203.0.113.2"},
+				{Region: "nyc3", IP: "203.0.113.3"},
+			},
+			reservedIPsToCreate: []gitdrops.ReservedIP{},
+			reservedIPsToUpdate: map[reservedIPAddress][]reservedIPAction{
+				reservedIPAddress("203.0.113.1"): []reservedIPAction{
+					{
+						Action:    assign,
+						Droplet:   "droplet-1",
+						DropletID: 1,
+					},
+				},
+				reservedIPAddress("203.0.113.2"): []reservedIPAction{
+					{
+						Action:    assign,
+						Droplet:   "droplet-2",
+						DropletID: 2,
+					},
+				},
+				reservedIPAddress("203.0.113.3"): []reservedIPAction{
+					{
+						Action:    unassign,
+						Droplet:   "droplet-3",
+						DropletID: 3,
+					},
+				},
+			},
+		},
+		{
+			name: "test case 4 - replaced droplet is assigned by name",
+			activeDroplets: []godo.Droplet{
+				{
+					ID:     1,
+					Name:   "droplet-1",
+					Region: nyc3,
+					Image:  &godo.Image{Slug: "centos-8-x64"},
+					Tags:   testOwnershipTags,
+				},
+			},
+			gitdropsDroplets: []gitdrops.Droplet{
+				{
+					Name:             "droplet-1",
+					Region:           "nyc3",
+					Monitoring:       true,
+					ReplaceOnChanges: []string{gitdrops.MonitoringField},
+				},
+			},
+			activeReservedIPs: []godo.FloatingIP{
+				{IP: "203.0.113.1", Region: nyc3, Droplet: &godo.Droplet{ID: 1, Name: "droplet-1"}},
+			},
+			gitdropsReservedIPs: []gitdrops.ReservedIP{
+				{Region: "nyc3", Droplet: "droplet-1"},
+			},
+			reservedIPsToCreate: []gitdrops.ReservedIP{},
+			reservedIPsToUpdate: map[reservedIPAddress][]reservedIPAction{
+				reservedIPAddress("203.0.113.1"): []reservedIPAction{
+					{
+						Action:  assign,
+						Droplet: "droplet-1",
+					},
+				},
+			},
+		},
+	}
+	for _, tc := range tcases {
+		dr := newTestDropletReconciler(gitdrops.Privileges{}, nil, tc.activeDroplets, tc.gitdropsDroplets, nil)
+		dr.setObjectsToUpdateAndCreate()
+		rr := newTestReservedIPReconciler(gitdrops.Privileges{}, dr, tc.activeReservedIPs, tc.gitdropsReservedIPs)
+		rr.setObjectsToUpdateAndCreate()
+		if !reflect.DeepEqual(rr.reservedIPsToCreate, tc.reservedIPsToCreate) {
+			t.Errorf("ReservedIPsToCreate - Failed %v, expected: %v, got %v", tc.name, tc.reservedIPsToCreate, rr.reservedIPsToCreate)
+		}
+		if !reflect.DeepEqual(rr.reservedIPsToUpdate, tc.reservedIPsToUpdate) {
+			t.Errorf("ReservedIPsToUpdate - Failed %v, expected: %v, got %v", tc.name, tc.reservedIPsToUpdate, rr.reservedIPsToUpdate)
+		}
+	}
+}
+
+func TestReservedIPSteps(t *testing.T) {
+	dr := newTestDropletReconciler(gitdrops.Privileges{}, nil, nil, nil, nil)
+	rr := newTestReservedIPReconciler(gitdrops.Privileges{}, dr, nil, nil)
+	rr.reservedIPsToCreate = []gitdrops.ReservedIP{
+		{Region: "nyc3", Droplet: "droplet-2"},
+	}
+	rr.reservedIPsToUpdate = map[reservedIPAddress][]reservedIPAction{
+		reservedIPAddress("203.0.113.1"): []reservedIPAction{
+			{
+				Action:  assign,
+				Droplet: "droplet-1",
+			},
+		},
+		reservedIPAddress("203.0.113.3"): []reservedIPAction{
+			{
+				Action:    unassign,
+				Droplet:   "droplet-3",
+				DropletID: 3,
+			},
+		},
+	}
+
+	steps, err := marshalSteps(rr)
+	if err != nil {
+		t.Fatalf("Failed, unexpected error %v", err)
+	}
+	loaded := newTestReservedIPReconciler(gitdrops.Privileges{}, dr, nil, nil)
+	err = loaded.setSteps(steps)
+	if err != nil {
+		t.Fatalf("Failed, unexpected error %v", err)
+	}
+	if !reflect.DeepEqual(loaded.reservedIPsToCreate, rr.reservedIPsToCreate) {
+		t.Errorf("ReservedIPsToCreate - Failed, expected: %v, got %v", rr.reservedIPsToCreate, loaded.reservedIPsToCreate)
+	}
+	if !reflect.DeepEqual(loaded.reservedIPsToUpdate, rr.reservedIPsToUpdate) {
+		t.Errorf("ReservedIPsToUpdate - Failed, expected: %v, got %v", rr.reservedIPsToUpdate, loaded.reservedIPsToUpdate)
+	}
+}
@@ -0,0 +1,167 @@
+package reconcile
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nolancon/gitdrops/pkg/gitdrops"
+
+	"github.com/digitalocean/godo"
+)
+
+// snapshotPolicy determines whether droplets and volumes are snapshotted before they are
+// deleted or rebuilt, and how many of the snapshots taken by gitdrops are kept. See
+// gitdrops.GitDrops.SnapshotBeforeDelete.
+type snapshotPolicy struct {
+	stack         string
+	beforeDelete  bool
+	beforeRebuild bool
+	// retention is the number of snapshots kept per object, 0 keeps all snapshots
+	retention int
+}
+
+func newSnapshotPolicy(gitDrops gitdrops.GitDrops) snapshotPolicy {
+	stack := gitDrops.Stack
+	if stack == "" {
+		stack = defaultStack
+	}
+	return snapshotPolicy{
+		stack:         stack,
+		beforeDelete:  gitDrops.SnapshotBeforeDelete,
+		beforeRebuild: gitDrops.SnapshotBeforeRebuild,
+		retention:     gitDrops.SnapshotRetention,
+	}
+}
+
+// snapshotPrefix is the prefix of the names of the snapshots taken by gitdrops of the object
+// named name. The name is followed by the unix time the snapshot was taken.
+func (p snapshotPolicy) snapshotPrefix(name string) string {
+	return "gitdrops-" + p.stack + "-" + name + "-"
+}
+
+func (p snapshotPolicy) snapshotName(name string, now time.Time) string {
+	return p.snapshotPrefix(name) + strconv.FormatInt(now.Unix(), 10)
+}
+
+// snapshotTime returns the unix time a snapshot named snapshotName was taken, if it was taken
+// by gitdrops of the object named name. The unix time follows the last '-' of snapshotName and
+// stacks cannot contain '-', so the stack and the name are compared exactly, eg the snapshots of
+// droplet-1-2 are never taken as those of droplet-1.
+func (p snapshotPolicy) snapshotTime(snapshotName, name string) (int64, bool) {
+	i := strings.LastIndex(snapshotName, "-")
+	if i == -1 || snapshotName[:i+1] != p.snapshotPrefix(name) {
+		return 0, false
+	}
+	unix, err := strconv.ParseUint(snapshotName[i+1:], 10, 63)
+	if err != nil {
+		return 0, false
+	}
+	return int64(unix), true
+}
+
+// snapshotResourceType returns the type of the snapshots of objects of resourceType.
+func snapshotResourceType(resourceType godo.ResourceType) godo.ResourceType {
+	if resourceType == godo.VolumeResourceType {
+		return godo.VolumeSnapshotResourceType
+	}
+	return godo.ImageResourceType
+}
+
+// snapshotsToPrune returns the snapshots taken by gitdrops of the object named name, except
+// for the newest retention snapshots. Only snapshots tagged with the ownershipTags of the stack
+// are pruned, so that the snapshots of other stacks, and those taken before gitdrops tagged its
+// snapshots, are never deleted.
+func (p snapshotPolicy) snapshotsToPrune(snapshots []godo.Snapshot, name string) []godo.Snapshot {
+	if p.retention == 0 {
+		return nil
+	}
+	type takenSnapshot struct {
+		snapshot godo.Snapshot
+		unix     int64
+	}
+	taken := make([]takenSnapshot, 0)
+	tags := ownershipTags(p.stack)
+	for _, snapshot := range snapshots {
+		if !isManaged(snapshot.Tags, tags) {
+			continue
+		}
+		if unix, ok := p.snapshotTime(snapshot.Name, name); ok {
+			taken = append(taken, takenSnapshot{snapshot: snapshot, unix: unix})
+		}
+	}
+	sort.SliceStable(taken, func(i, j int) bool {
+		return taken[i].unix > taken[j].unix
+	})
+	prune := make([]godo.Snapshot, 0)
+	for i := p.retention; i < len(taken); i++ {
+		prune = append(prune, taken[i].snapshot)
+	}
+	return prune
+}
+
+// snapshot calls take to take a snapshot of the object named name and records its ID for the
+// operation report before returning, so that the ID is reported even if the destructive
+// action that follows fails. The snapshot is tagged with the ownershipTags of the stack and
+// older snapshots of the object are then pruned, see retention. A failure to tag or prune is
+// logged but does not fail the operation.
+func (p snapshotPolicy) snapshot(ctx context.Context, client *godo.Client, resourceType godo.ResourceType, name string, take func(snapshotName string) (string, error)) error {
+	snapshotName := p.snapshotName(name, time.Now())
+	id, err := take(snapshotName)
+	if err != nil {
+		return fmt.Errorf("snapshotPolicy.snapshot: %v", err)
+	}
+	recordSnapshot(ctx, id)
+	log.Printf("snapshotPolicy.snapshot: took snapshot %s (%s) of %s %s", snapshotName, id, resourceType, name)
+
+	for _, tag := range ownershipTags(p.stack) {
+		err = gitdrops.TagResources(ctx, client, tag, []godo.Resource{{ID: id, Type: snapshotResourceType(resourceType)}})
+		if err != nil {
+			log.Println("snapshotPolicy.snapshot: cannot tag snapshot", snapshotName, "it will not be pruned:", err)
+			return nil
+		}
+	}
+	snapshots, err := gitdrops.ListSnapshots(ctx, client, resourceType)
+	if err != nil {
+		log.Println("snapshotPolicy.snapshot: cannot prune snapshots:", err)
+		return nil
+	}
+	for _, snapshot := range p.snapshotsToPrune(snapshots, name) {
+		err = gitdrops.DeleteSnapshot(ctx, client, snapshot.ID)
+		if err != nil {
+			log.Println("snapshotPolicy.snapshot: cannot prune snapshot", snapshot.Name, err)
+		}
+	}
+	return nil
+}
+
+type snapshotsKey struct{}
+
+type snapshotRecorder struct {
+	mu  sync.Mutex
+	ids []string
+}
+
+// recordSnapshots returns a copy of ctx that records the snapshots taken with it, see
+// recordSnapshot, and a function returning their IDs.
+func recordSnapshots(ctx context.Context) (context.Context, func() []string) {
+	recorder := &snapshotRecorder{}
+	return context.WithValue(ctx, snapshotsKey{}, recorder), func() []string {
+		recorder.mu.Lock()
+		defer recorder.mu.Unlock()
+		return append([]string(nil), recorder.ids...)
+	}
+}
+
+func recordSnapshot(ctx context.Context, id string) {
+	if recorder, ok := ctx.Value(snapshotsKey{}).(*snapshotRecorder); ok {
+		recorder.mu.Lock()
+		recorder.ids = append(recorder.ids, id)
+		recorder.mu.Unlock()
+	}
+}
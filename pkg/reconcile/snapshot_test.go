@@ -0,0 +1,112 @@
+package reconcile
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/digitalocean/godo"
+)
+
+func TestSnapshotName(t *testing.T) {
+	policy := snapshotPolicy{stack: "prod"}
+	snapshotName := policy.snapshotName("droplet-1", time.Unix(1600000000, 0))
+	if snapshotName != "gitdrops-prod-droplet-1-1600000000" {
+		t.Errorf("Failed, expected: %v, got %v", "gitdrops-prod-droplet-1-1600000000", snapshotName)
+	}
+	tcases := []struct {
+		name         string
+		snapshotName string
+		expUnix      int64
+		expOk        bool
+	}{
+		{
+			name:         "test case 1 - taken by gitdrops",
+			snapshotName: "gitdrops-prod-droplet-1-1600000000",
+			expUnix:      1600000000,
+			expOk:        true,
+		},
+		{
+			name:         "test case 2 - taken of droplet-1-2",
+			snapshotName: "gitdrops-prod-droplet-1-2-1600000000",
+		},
+		{
+			name:         "test case 3 - taken by another stack",
+			snapshotName: "gitdrops-dev-droplet-1-1600000000",
+		},
+		{
+			name:         "test case 4 - not taken by gitdrops",
+			snapshotName: "droplet-1-backup",
+		},
+		{
+			name:         "test case 5 - signed unix time",
+			snapshotName: "gitdrops-prod-droplet-1-+1600000000",
+		},
+		{
+			name:         "test case 6 - taken by a stack with the same prefix",
+			snapshotName: "gitdrops-prod_eu-droplet-1-1600000000",
+		},
+	}
+	for _, tc := range tcases {
+		unix, ok := policy.snapshotTime(tc.snapshotName, "droplet-1")
+		if unix != tc.expUnix || ok != tc.expOk {
+			t.Errorf("Failed %v, expected: %v %v, got %v %v", tc.name, tc.expUnix, tc.expOk, unix, ok)
+		}
+	}
+}
+
+func TestSnapshotsToPrune(t *testing.T) {
+	snapshots := []godo.Snapshot{
+		{ID: "1", Name: "gitdrops-default-volume-1-100", Tags: testOwnershipTags},
+		{ID: "2", Name: "gitdrops-default-volume-1-300", Tags: testOwnershipTags},
+		{ID: "3", Name: "gitdrops-default-volume-2-50", Tags: testOwnershipTags},
+		{ID: "4", Name: "gitdrops-default-volume-1-200", Tags: testOwnershipTags},
+		{ID: "5", Name: "volume-1-manual"},
+		{ID: "6", Name: "gitdrops-default-volume-1-150"},
+		{ID: "7", Name: "gitdrops-default-volume-1-120", Tags: ownershipTags("prod")},
+	}
+	tcases := []struct {
+		name      string
+		retention int
+		expPrune  []godo.Snapshot
+	}{
+		{
+			name:      "test case 1 - keep all",
+			retention: 0,
+			expPrune:  nil,
+		},
+		{
+			name:      "test case 2 - keep newest",
+			retention: 1,
+			expPrune: []godo.Snapshot{
+				{ID: "4", Name: "gitdrops-default-volume-1-200", Tags: testOwnershipTags},
+				{ID: "1", Name: "gitdrops-default-volume-1-100", Tags: testOwnershipTags},
+			},
+		},
+		{
+			name:      "test case 3 - retention above snapshots",
+			retention: 5,
+			expPrune:  []godo.Snapshot{},
+		},
+	}
+	for _, tc := range tcases {
+		policy := snapshotPolicy{stack: defaultStack, retention: tc.retention}
+		prune := policy.snapshotsToPrune(snapshots, "volume-1")
+		if !reflect.DeepEqual(prune, tc.expPrune) {
+			t.Errorf("Failed %v, expected: %v, got %v", tc.name, tc.expPrune, prune)
+		}
+	}
+}
+
+func TestRecordSnapshots(t *testing.T) {
+	ctx, snapshots := recordSnapshots(context.Background())
+	recordSnapshot(ctx, "1")
+	recordSnapshot(ctx, "2")
+	// recording without a recorder is a no-op
+	recordSnapshot(context.Background(), "3")
+	expSnapshots := []string{"1", "2"}
+	if !reflect.DeepEqual(snapshots(), expSnapshots) {
+		t.Errorf("Failed, expected: %v, got %v", expSnapshots, snapshots())
+	}
+}
@@ -0,0 +1,294 @@
+package reconcile
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/nolancon/gitdrops/pkg/gitdrops"
+
+	"github.com/digitalocean/godo"
+)
+
+const sshKeyResource = "sshKey"
+
+// sshKeyReconciler uploads the SSH keys declared in gitdrops.yaml. DO keys cannot be tagged, so
+// the keys uploaded by gitdrops are named with namePrefix followed by the name of the key in
+// gitdrops.yaml. Only keys named with namePrefix are ever deleted.
+type sshKeyReconciler struct {
+	privileges      gitdrops.Privileges
+	client          *godo.Client
+	namePrefix      string
+	gitdropsSSHKeys []gitdrops.SSHKey
+	activeKeys      []godo.Key
+	keysToCreate    []gitdrops.SSHKey
+	keysToDelete    []int
+}
+
+var _ objectReconciler = &sshKeyReconciler{}
+
+// sshKeySteps is the serializable form of the SSH keys to create and delete. The keys to create
+// include their public keys, so that a saved plan uploads the keys read when it was made.
+type sshKeySteps struct {
+	Create []gitdrops.SSHKey `json:"create"`
+	Delete []int             `json:"delete"`
+}
+
+// sshKeyNamePrefix returns the prefix of the names of the keys uploaded by stack.
+func sshKeyNamePrefix(stack string) string {
+	if stack == "" {
+		stack = defaultStack
+	}
+	return "gitdrops-" + stack + "-"
+}
+
+// trimNamePrefix returns name without namePrefix, see sshKeyNamePrefix, and false if name was not
+// given by the stack of namePrefix. Stacks cannot contain '-', so the stack is the part of name
+// between its first two '-' and is compared exactly, eg stack prod never matches the names of
+// stack prod_eu.
+func trimNamePrefix(name, namePrefix string) (string, bool) {
+	parts := strings.SplitN(name, "-", 3)
+	if len(parts) != 3 || parts[0]+"-"+parts[1]+"-" != namePrefix {
+		return "", false
+	}
+	return parts[2], true
+}
+
+// withSSHKeyFingerprints returns gitdropsDroplets with the fingerprints of the keys named in
+// droplets.sshKeys added to their sshKeyFingerprints, so that the keys are compared and added
+// to new droplets like any other fingerprint.
+func withSSHKeyFingerprints(gitdropsDroplets []gitdrops.Droplet, gitdropsSSHKeys []gitdrops.SSHKey) []gitdrops.Droplet {
+	fingerprints := make(map[string]string)
+	for _, gitdropsSSHKey := range gitdropsSSHKeys {
+		fingerprints[gitdropsSSHKey.Name] = gitdropsSSHKey.Fingerprint
+	}
+	droplets := make([]gitdrops.Droplet, 0, len(gitdropsDroplets))
+	for _, gitdropsDroplet := range gitdropsDroplets {
+		if len(gitdropsDroplet.SSHKeys) != 0 {
+			sshKeyFingerprints := append([]string{}, gitdropsDroplet.SSHKeyFingerprints...)
+			for _, sshKeyName := range gitdropsDroplet.SSHKeys {
+				if fingerprint, ok := fingerprints[sshKeyName]; ok && !hasName(sshKeyFingerprints, fingerprint) {
+					sshKeyFingerprints = append(sshKeyFingerprints, fingerprint)
+				}
+			}
+			gitdropsDroplet.SSHKeyFingerprints = sshKeyFingerprints
+		}
+		droplets = append(droplets, gitdropsDroplet)
+	}
+	return droplets
+}
+
+func (kr *sshKeyReconciler) getResourceType() string {
+	return sshKeyResource
+}
+
+func (kr *sshKeyReconciler) setActiveObjects(ctx context.Context) error {
+	activeKeys, err := gitdrops.ListKeys(ctx, kr.client)
+	if err != nil {
+		return fmt.Errorf("sshKeyReconciler.setActiveObjects: %v", err)
+	}
+	kr.activeKeys = activeKeys
+	log.Println("sshKeyReconciler.setActiveObjects: active keys", len(kr.activeKeys))
+	return nil
+}
+
+// setObjectsToUpdateAndCreate populates sshKeyReconciler with the keys declared in gitdrops.yaml
+// that are not on DO. A key is on DO if any key has its fingerprint, whatever its name, as DO
+// rejects uploading the same key twice.
+func (kr *sshKeyReconciler) setObjectsToUpdateAndCreate() error {
+	keysToCreate := make([]gitdrops.SSHKey, 0)
+	for _, gitdropsSSHKey := range kr.gitdropsSSHKeys {
+		if _, ok := kr.findActiveKey(gitdropsSSHKey.Fingerprint); ok {
+			continue
+		}
+		keysToCreate = append(keysToCreate, gitdropsSSHKey)
+	}
+	kr.keysToCreate = keysToCreate
+	log.Println("sshKeyReconciler.setObjectsToUpdateAndCreate: keys to create", len(kr.keysToCreate))
+	return nil
+}
+
+// setObjectsToDelete populates sshKeyReconciler with the IDs of the keys uploaded by gitdrops
+// whose fingerprints are not declared in gitdrops.yaml, ie keys that were removed or whose
+// public key changed.
+func (kr *sshKeyReconciler) setObjectsToDelete() {
+	keysToDelete := make([]int, 0)
+	for _, activeKey := range kr.activeKeys {
+		if kr.isDeclared(activeKey.Fingerprint) {
+			continue
+		}
+		if _, ok := trimNamePrefix(activeKey.Name, kr.namePrefix); !ok {
+			log.Println("sshKeyReconciler.setObjectsToDelete: key", activeKey.Name, "was not uploaded by gitdrops, it will not be deleted")
+			continue
+		}
+		keysToDelete = append(keysToDelete, activeKey.ID)
+	}
+	kr.keysToDelete = keysToDelete
+	log.Println("sshKeyReconciler.setObjectsToDelete: keys to delete", kr.keysToDelete)
+}
+
+func (kr *sshKeyReconciler) getChanges() []Change {
+	changes := make([]Change, 0)
+	for _, keyToCreate := range kr.keysToCreate {
+		changes = append(changes, Change{Resource: sshKeyResource, Action: create, Name: keyToCreate.Name, Value: keyToCreate.Fingerprint})
+	}
+	for _, id := range kr.keysToDelete {
+		changes = append(changes, Change{Resource: sshKeyResource, Action: remove, Name: kr.findKeyName(id), ID: strconv.Itoa(id)})
+	}
+	return changes
+}
+
+func (kr *sshKeyReconciler) getSteps() interface{} {
+	return sshKeySteps{
+		Create: kr.keysToCreate,
+		Delete: kr.keysToDelete,
+	}
+}
+
+func (kr *sshKeyReconciler) setSteps(stepsJSON json.RawMessage) error {
+	steps := sshKeySteps{}
+	err := unmarshalSteps(stepsJSON, &steps)
+	if err != nil {
+		return fmt.Errorf("sshKeyReconciler.setSteps: %v", err)
+	}
+	kr.keysToCreate = steps.Create
+	kr.keysToDelete = steps.Delete
+	return nil
+}
+
+// getObservedObjects returns the name and SSH key fingerprint of every key.
+func (kr *sshKeyReconciler) getObservedObjects() []observedObject {
+	observedObjects := make([]observedObject, 0)
+	for _, activeKey := range kr.activeKeys {
+		observed := struct {
+			Name        string `json:"name"`
+			Fingerprint string `json:"fingerprint"`
+		}{
+			Name:        activeKey.Name,
+			Fingerprint: activeKey.Fingerprint,
+		}
+		observedObjects = append(observedObjects, observedObject{
+			id:       strconv.Itoa(activeKey.ID),
+			name:     activeKey.Name,
+			observed: observed,
+		})
+	}
+	return observedObjects
+}
+
+// countManagedObjects returns the number of keys uploaded by gitdrops.
+func (kr *sshKeyReconciler) countManagedObjects() int {
+	managed := 0
+	for _, activeKey := range kr.activeKeys {
+		if _, ok := trimNamePrefix(activeKey.Name, kr.namePrefix); ok {
+			managed++
+		}
+	}
+	return managed
+}
+
+func (kr *sshKeyReconciler) reconcileObjectsToCreate() []operation {
+	operations := make([]operation, 0)
+	if len(kr.keysToCreate) != 0 {
+		if kr.privileges.Allows(sshKeyResource, create) {
+			log.Println("sshKeyReconciler.reconcileObjectsToCreate: create keys", len(kr.keysToCreate))
+			operations = kr.createOperations()
+		} else {
+			log.Println("gitdrops discovered SSH keys to create, but does not have create privileges")
+		}
+	}
+	return operations
+}
+
+// reconcileObjectsToUpdate returns no operations, a key is either created or deleted.
+func (kr *sshKeyReconciler) reconcileObjectsToUpdate() []operation {
+	return []operation{}
+}
+
+func (kr *sshKeyReconciler) reconcileObjectsToDelete() []operation {
+	operations := make([]operation, 0)
+	if len(kr.keysToDelete) != 0 {
+		if kr.privileges.Allows(sshKeyResource, remove) {
+			log.Println("sshKeyReconciler.reconcileObjectsToDelete: delete keys", kr.keysToDelete)
+			operations = kr.deleteOperations()
+		} else {
+			log.Println("gitdrops discovered SSH keys to delete, but does not have delete privileges")
+		}
+	}
+	return operations
+}
+
+// createOperations returns an operation per key to create. Droplets using the key depend on
+// its creation, see dropletReconciler.createOperations.
+func (kr *sshKeyReconciler) createOperations() []operation {
+	operations := make([]operation, 0)
+	for _, keyToCreate := range kr.keysToCreate {
+		keyToCreate := keyToCreate
+		operations = append(operations, operation{
+			key: operationKey(sshKeyResource, create, keyToCreate.Name),
+			run: func(ctx context.Context) error {
+				return kr.createObject(ctx, keyToCreate)
+			},
+		})
+	}
+	return operations
+}
+
+func (kr *sshKeyReconciler) createObject(ctx context.Context, keyToCreate gitdrops.SSHKey) error {
+	_, err := gitdrops.CreateKey(ctx, kr.client, &godo.KeyCreateRequest{
+		Name:      kr.namePrefix + keyToCreate.Name,
+		PublicKey: keyToCreate.PublicKey,
+	})
+	if err != nil {
+		return fmt.Errorf("sshKeyReconciler.createObject: %v", err)
+	}
+	return nil
+}
+
+func (kr *sshKeyReconciler) deleteOperations() []operation {
+	operations := make([]operation, 0)
+	for _, id := range kr.keysToDelete {
+		id := id
+		operations = append(operations, operation{
+			key: operationKey(sshKeyResource, remove, strconv.Itoa(id)),
+			run: func(ctx context.Context) error {
+				err := gitdrops.DeleteKey(ctx, kr.client, id)
+				if err != nil {
+					return fmt.Errorf("sshKeyReconciler.deleteObject: %v", err)
+				}
+				return nil
+			},
+		})
+	}
+	return operations
+}
+
+func (kr *sshKeyReconciler) findActiveKey(fingerprint string) (godo.Key, bool) {
+	for _, activeKey := range kr.activeKeys {
+		if activeKey.Fingerprint == fingerprint {
+			return activeKey, true
+		}
+	}
+	return godo.Key{}, false
+}
+
+func (kr *sshKeyReconciler) isDeclared(fingerprint string) bool {
+	for _, gitdropsSSHKey := range kr.gitdropsSSHKeys {
+		if gitdropsSSHKey.Fingerprint == fingerprint {
+			return true
+		}
+	}
+	return false
+}
+
+func (kr *sshKeyReconciler) findKeyName(id int) string {
+	for _, activeKey := range kr.activeKeys {
+		if activeKey.ID == id {
+			return activeKey.Name
+		}
+	}
+	return ""
+}
@@ -0,0 +1,214 @@
+package reconcile
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/nolancon/gitdrops/pkg/gitdrops"
+
+	"github.com/digitalocean/godo"
+)
+
+func newTestSSHKeyReconciler(privileges gitdrops.Privileges, activeKeys []godo.Key, gitdropsSSHKeys []gitdrops.SSHKey) *sshKeyReconciler {
+	return &sshKeyReconciler{
+		privileges:      privileges,
+		namePrefix:      sshKeyNamePrefix(""),
+		activeKeys:      activeKeys,
+		gitdropsSSHKeys: gitdropsSSHKeys,
+	}
+}
+
+func TestSetSSHKeysToCreateDelete(t *testing.T) {
+	tcases := []struct {
+		name            string
+		activeKeys      []godo.Key
+		gitdropsSSHKeys []gitdrops.SSHKey
+		keysToCreate    []gitdrops.SSHKey
+		keysToDelete    []int
+	}{
+		{
+			name: "test case 1 - create key",
+			gitdropsSSHKeys: []gitdrops.SSHKey{
+				{Name: "deploy", PublicKey: "ssh-ed25519 AAAA deploy", Fingerprint: "aa:aa"},
+			},
+			keysToCreate: []gitdrops.SSHKey{
+				{Name: "deploy", PublicKey: "ssh-ed25519 AAAA deploy", Fingerprint: "aa:aa"},
+			},
+			keysToDelete: []int{},
+		},
+		{
+			name: "test case 2 - key already uploaded under another name",
+			activeKeys: []godo.Key{
+				{ID: 1, Name: "laptop", Fingerprint: "aa:aa"},
+			},
+			gitdropsSSHKeys: []gitdrops.SSHKey{
+				{Name: "deploy", PublicKey: "ssh-ed25519 AAAA deploy", Fingerprint: "aa:aa"},
+			},
+			keysToCreate: []gitdrops.SSHKey{},
+			keysToDelete: []int{},
+		},
+		{
+			name: "test case 3 - changed key is replaced, unmanaged key is kept",
+			activeKeys: []godo.Key{
+				{ID: 1, Name: "laptop", Fingerprint: "cc:cc"},
+				{ID: 2, Name: "gitdrops-default-deploy", Fingerprint: "aa:aa"},
+				{ID: 3, Name: "gitdrops-other-deploy", Fingerprint: "dd:dd"},
+			},
+			gitdropsSSHKeys: []gitdrops.SSHKey{
+				{Name: "deploy", PublicKey: "ssh-ed25519 BBBB deploy", Fingerprint: "bb:bb"},
+			},
+			keysToCreate: []gitdrops.SSHKey{
+				{Name: "deploy", PublicKey: "ssh-ed25519 BBBB deploy", Fingerprint: "bb:bb"},
+			},
+			keysToDelete: []int{2},
+		},
+	}
+	for _, tc := range tcases {
+		kr := newTestSSHKeyReconciler(gitdrops.Privileges{}, tc.activeKeys, tc.gitdropsSSHKeys)
+		kr.setObjectsToUpdateAndCreate()
+		kr.setObjectsToDelete()
+		if !reflect.DeepEqual(kr.keysToCreate, tc.keysToCreate) {
+			t.Errorf("KeysToCreate - Failed %v, expected: %v, got %v", tc.name, tc.keysToCreate, kr.keysToCreate)
+		}
+		if !reflect.DeepEqual(kr.keysToDelete, tc.keysToDelete) {
+			t.Errorf("KeysToDelete - Failed %v, expected: %v, got %v", tc.name, tc.keysToDelete, kr.keysToDelete)
+		}
+	}
+}
+
+func TestSSHKeyStacks(t *testing.T) {
+	activeKeys := []godo.Key{
+		{ID: 1, Name: "gitdrops-prod-deploy", Fingerprint: "aa:aa"},
+		{ID: 2, Name: "gitdrops-prod_eu-deploy", Fingerprint: "bb:bb"},
+		{ID: 3, Name: "gitdrops-prod-eu-deploy", Fingerprint: "cc:cc"},
+	}
+	tcases := []struct {
+		name         string
+		stack        string
+		keysToDelete []int
+		managed      int
+	}{
+		{
+			name:         "test case 1 - stack prod",
+			stack:        "prod",
+			keysToDelete: []int{1, 3},
+			managed:      2,
+		},
+		{
+			name:         "test case 2 - stack prod_eu",
+			stack:        "prod_eu",
+			keysToDelete: []int{2},
+			managed:      1,
+		},
+		{
+			name:         "test case 3 - stack eu",
+			stack:        "eu",
+			keysToDelete: []int{},
+			managed:      0,
+		},
+	}
+	for _, tc := range tcases {
+		kr := newTestSSHKeyReconciler(gitdrops.Privileges{}, activeKeys, nil)
+		kr.namePrefix = sshKeyNamePrefix(tc.stack)
+		kr.setObjectsToDelete()
+		if !reflect.DeepEqual(kr.keysToDelete, tc.keysToDelete) {
+			t.Errorf("KeysToDelete - Failed %v, expected: %v, got %v", tc.name, tc.keysToDelete, kr.keysToDelete)
+		}
+		if kr.countManagedObjects() != tc.managed {
+			t.Errorf("Managed - Failed %v, expected: %v, got %v", tc.name, tc.managed, kr.countManagedObjects())
+		}
+	}
+}
+
+func TestTrimNamePrefix(t *testing.T) {
+	tcases := []struct {
+		name       string
+		objectName string
+		namePrefix string
+		expName    string
+		expOK      bool
+	}{
+		{
+			name:       "test case 1 - name of stack",
+			objectName: "gitdrops-prod-eu-deploy",
+			namePrefix: sshKeyNamePrefix("prod"),
+			expName:    "eu-deploy",
+			expOK:      true,
+		},
+		{
+			name:       "test case 2 - name of a stack with the same prefix",
+			objectName: "gitdrops-prod_eu-deploy",
+			namePrefix: sshKeyNamePrefix("prod"),
+			expName:    "",
+			expOK:      false,
+		},
+		{
+			name:       "test case 3 - name of default stack",
+			objectName: "gitdrops-default-deploy",
+			namePrefix: sshKeyNamePrefix(""),
+			expName:    "deploy",
+			expOK:      true,
+		},
+		{
+			name:       "test case 4 - not named by gitdrops",
+			objectName: "laptop",
+			namePrefix: sshKeyNamePrefix(""),
+			expName:    "",
+			expOK:      false,
+		},
+	}
+	for _, tc := range tcases {
+		name, ok := trimNamePrefix(tc.objectName, tc.namePrefix)
+		if name != tc.expName || ok != tc.expOK {
+			t.Errorf("Failed %v, expected: %v %v, got %v %v", tc.name, tc.expName, tc.expOK, name, ok)
+		}
+	}
+}
+
+func TestWithSSHKeyFingerprints(t *testing.T) {
+	gitdropsSSHKeys := []gitdrops.SSHKey{
+		{Name: "deploy", Fingerprint: "aa:aa"},
+		{Name: "admin", Fingerprint: "bb:bb"},
+	}
+	gitdropsDroplets := []gitdrops.Droplet{
+		{Name: "droplet-1"},
+		{Name: "droplet-2", SSHKeys: []string{"deploy"}},
+		{Name: "droplet-3", SSHKeys: []string{"deploy", "admin"}, SSHKeyFingerprints: []string{"aa:aa", "cc:cc"}},
+	}
+	expected := []gitdrops.Droplet{
+		{Name: "droplet-1"},
+		{Name: "droplet-2", SSHKeys: []string{"deploy"}, SSHKeyFingerprints: []string{"aa:aa"}},
+		{Name: "droplet-3", SSHKeys: []string{"deploy", "admin"}, SSHKeyFingerprints: []string{"aa:aa", "cc:cc", "bb:bb"}},
+	}
+	got := withSSHKeyFingerprints(gitdropsDroplets, gitdropsSSHKeys)
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Failed, expected: %v, got %v", expected, got)
+	}
+	if gitdropsDroplets[2].SSHKeyFingerprints[1] != "cc:cc" || len(gitdropsDroplets[2].SSHKeyFingerprints) != 2 {
+		t.Errorf("Failed, gitdropsDroplets modified: %v", gitdropsDroplets)
+	}
+}
+
+func TestSSHKeySteps(t *testing.T) {
+	kr := newTestSSHKeyReconciler(gitdrops.Privileges{}, nil, nil)
+	kr.keysToCreate = []gitdrops.SSHKey{
+		{Name: "deploy", PublicKey: "ssh-ed25519 AAAA deploy", Fingerprint: "aa:aa"},
+	}
+	kr.keysToDelete = []int{2}
+
+	steps, err := marshalSteps(kr)
+	if err != nil {
+		t.Fatalf("Failed, unexpected error %v", err)
+	}
+	loaded := newTestSSHKeyReconciler(gitdrops.Privileges{}, nil, nil)
+	err = loaded.setSteps(steps)
+	if err != nil {
+		t.Fatalf("Failed, unexpected error %v", err)
+	}
+	if !reflect.DeepEqual(loaded.keysToCreate, kr.keysToCreate) {
+		t.Errorf("KeysToCreate - Failed, expected: %v, got %v", kr.keysToCreate, loaded.keysToCreate)
+	}
+	if !reflect.DeepEqual(loaded.keysToDelete, kr.keysToDelete) {
+		t.Errorf("KeysToDelete - Failed, expected: %v, got %v", kr.keysToDelete, loaded.keysToDelete)
+	}
+}
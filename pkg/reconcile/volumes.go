@@ -2,9 +2,14 @@ package reconcile
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/nolancon/gitdrops/pkg/gitdrops"
 
@@ -18,63 +23,67 @@ const (
 )
 
 type volumeReconciler struct {
-	privileges      gitdrops.Privileges
-	client          *godo.Client
+	privileges    gitdrops.Privileges
+	client        *godo.Client
+	actionTimeout time.Duration
+	// mu guards activeVolumes, which createObject appends to while other operations run
+	mu              sync.Mutex
 	activeVolumes   []godo.Volume
 	gitdropsVolumes []gitdrops.Volume
 	volumesToCreate []gitdrops.Volume
-	volumesToUpdate actionsByID
+	volumesToUpdate map[volumeID][]volumeAction
 	volumesToDelete []string
+	// ownershipTags are applied to created volumes, only volumes with these tags are deleted
+	ownershipTags  []string
+	snapshotPolicy snapshotPolicy
 }
 
 var _ objectReconciler = &volumeReconciler{}
 
-func (vr *volumeReconciler) reconcileObjectsToCreate(ctx context.Context) error {
+// volumeSteps is the serializable form of the volumes to create, update and delete.
+type volumeSteps struct {
+	Create []gitdrops.Volume           `json:"create"`
+	Update map[volumeID][]volumeAction `json:"update"`
+	Delete []string                    `json:"delete"`
+}
+
+func (vr *volumeReconciler) getResourceType() string {
+	return volume
+}
+
+func (vr *volumeReconciler) reconcileObjectsToCreate() []operation {
+	operations := make([]operation, 0)
 	if len(vr.volumesToCreate) != 0 {
-		if vr.privileges.Create {
+		if vr.privileges.Allows(volume, create) {
 			log.Println("volumeReconciler.reconcileObjectsToCreate: create volumes", vr.volumesToCreate)
-			err := vr.createObjects(ctx)
-			if err != nil {
-				return fmt.Errorf("volumeReconciler.reconcile: %v", err)
-			}
+			operations = vr.createOperations()
 		} else {
 			log.Println("gitdrops discovered volumes to create, but does not have create privileges")
 		}
 	}
-	return nil
+	return operations
 }
 
-func (vr *volumeReconciler) reconcileObjectsToUpdate(ctx context.Context, outsideActions actionsByID) error {
+func (vr *volumeReconciler) reconcileObjectsToUpdate() []operation {
+	operations := make([]operation, 0)
 	if len(vr.volumesToUpdate) != 0 {
-		if len(outsideActions) != 0 {
-			vr.volumesToUpdate = outsideActions
-		}
-		if vr.privileges.Update {
-			log.Println("volumeReconciler.reconcileObjectsToUpdate: update volumes", vr.volumesToUpdate)
-			err := vr.updateObjects(ctx)
-			if err != nil {
-				return fmt.Errorf("volumeReconciler.reconcile: %v", err)
-			}
-		} else {
-			log.Println("gitdrops discovered volumes to update, but does not have update privileges")
-		}
+		log.Println("volumeReconciler.reconcileObjectsToUpdate: update volumes", vr.volumesToUpdate)
+		operations = vr.updateOperations()
 	}
-	return nil
+	return operations
 }
 
-func (vr *volumeReconciler) reconcileObjectsToDelete(ctx context.Context) error {
+func (vr *volumeReconciler) reconcileObjectsToDelete() []operation {
+	operations := make([]operation, 0)
 	if len(vr.volumesToDelete) != 0 {
-		if vr.privileges.Delete {
+		if vr.privileges.Allows(volume, remove) {
 			log.Println("volumeReconciler.reconcileObjectsToDelete: delete volumes", vr.volumesToDelete)
-			err := vr.deleteObjects(ctx)
-			if err != nil {
-				return fmt.Errorf("volumeReconciler.secondaryReconcile: %v", err)
-			}
+			operations = vr.deleteOperations()
 		} else {
 			log.Println("gitdrops discovered volumes to delete, but does not have delete privileges")
 		}
 	}
-	return nil
+	return operations
 }
 
 func (vr *volumeReconciler) setActiveObjects(ctx context.Context) error {
@@ -88,21 +97,24 @@ func (vr *volumeReconciler) setActiveObjects(ctx context.Context) error {
 }
 
 // SetObjectsToUpdateCreate populates VolumeReconciler with two lists:
-// * volumesToUpdate: volumeActionsByID of volumes that are active on DO and are defined in
+// * volumesToUpdate: the actions to take on volumes that are active on DO and are defined in
 // gitdrops.yaml, but the active volumes are no longer in sync with the local gitdrops version.
 // * volumesToCreate: Volumes of volumes defined in gitdrops.yaml that are NOT
 // active on DO and therefore should be created.
-func (vr *volumeReconciler) setObjectsToUpdateAndCreate() {
+func (vr *volumeReconciler) setObjectsToUpdateAndCreate() error {
 	volumesToCreate := make([]gitdrops.Volume, 0)
-	volumeActionsByID := make(actionsByID)
+	volumeActionsByID := make(map[volumeID][]volumeAction)
 	for _, gitdropsVolume := range vr.gitdropsVolumes {
 		volumeIsActive := false
 		for _, activeVolume := range vr.activeVolumes {
 			if gitdropsVolume.Name == activeVolume.Name {
 				//volume already exists, check for change in request
-				volumeActions := getVolumeActions(gitdropsVolume, activeVolume)
+				volumeActions, err := getVolumeActions(gitdropsVolume, activeVolume, vr.ownershipTags)
+				if err != nil {
+					return fmt.Errorf("volumeReconciler.setObjectsToUpdateAndCreate: %v", err)
+				}
 				if len(volumeActions) != 0 {
-					volumeActionsByID[activeVolume.ID] = volumeActions
+					volumeActionsByID[volumeID(activeVolume.ID)] = volumeActions
 				}
 				volumeIsActive = true
 				continue
@@ -117,11 +129,13 @@ func (vr *volumeReconciler) setObjectsToUpdateAndCreate() {
 	vr.volumesToCreate = volumesToCreate
 	log.Println("volumeReconciler.setObjectsToUpdateAndCreate: volumes to create", vr.volumesToCreate)
 	log.Println("volumeReconciler.setObjectsToUpdateAndCreate: volumes to update", vr.volumesToUpdate)
+	return nil
 }
 
 // SetObjectToDelete populates VolumeReconciler with  a list of IDs for volumes that need
 // to be deleted upon reconciliation of gitdrops.yaml (ie these volumes are active but not present
-// in the spec)
+// in the spec). Volumes without the ownership tags were not created by gitdrops and are never
+// deleted.
 func (vr *volumeReconciler) setObjectsToDelete() {
 	volumesToDelete := make([]string, 0)
 
@@ -133,100 +147,483 @@ func (vr *volumeReconciler) setObjectsToDelete() {
 				continue
 			}
 		}
-		if !activeVolumeInSpec {
-			//create volume from local request
-			volumesToDelete = append(volumesToDelete, activeVolume.ID)
+		if activeVolumeInSpec {
+			continue
+		}
+		if !isManaged(activeVolume.Tags, vr.ownershipTags) {
+			log.Println("volumeReconciler.setObjectsToDelete: volume", activeVolume.Name, "is not managed by gitdrops, it will not be deleted")
+			continue
 		}
+		if isProtected(activeVolume.Tags) {
+			log.Println("volumeReconciler.setObjectsToDelete: volume", activeVolume.Name, "is protected, it will not be deleted")
+			continue
+		}
+		volumesToDelete = append(volumesToDelete, activeVolume.ID)
 	}
 	vr.volumesToDelete = volumesToDelete
 	log.Println("volumeReconciler.setObjectsToDelete: objects to delete", vr.volumesToDelete)
 }
 
-func (vr *volumeReconciler) getActiveObjects() interface{} {
-	return vr.activeVolumes
+func (vr *volumeReconciler) getChanges() []Change {
+	changes := make([]Change, 0)
+	for _, volumeToCreate := range vr.volumesToCreate {
+		changes = append(changes, Change{Resource: volume, Action: create, Name: volumeToCreate.Name})
+	}
+	for _, activeVolume := range vr.activeVolumes {
+		for _, volumeAction := range vr.volumesToUpdate[volumeID(activeVolume.ID)] {
+			changes = append(changes, Change{
+				Resource: volume,
+				Action:   volumeAction.Action,
+				Name:     activeVolume.Name,
+				ID:       activeVolume.ID,
+				Value:    volumeAction.value(),
+			})
+		}
+	}
+	for _, id := range vr.volumesToDelete {
+		changes = append(changes, Change{Resource: volume, Action: remove, Name: vr.findVolumeName(id), ID: id})
+	}
+	return changes
 }
 
-func (vr *volumeReconciler) getObjectsToCreate() interface{} {
-	return vr.volumesToCreate
+func (vr *volumeReconciler) getSteps() interface{} {
+	return volumeSteps{
+		Create: vr.volumesToCreate,
+		Update: vr.volumesToUpdate,
+		Delete: vr.volumesToDelete,
+	}
 }
 
-func (vr *volumeReconciler) getObjectsToUpdate() actionsByID {
-	return vr.volumesToUpdate
+func (vr *volumeReconciler) setSteps(stepsJSON json.RawMessage) error {
+	steps := volumeSteps{}
+	err := unmarshalSteps(stepsJSON, &steps)
+	if err != nil {
+		return fmt.Errorf("volumeReconciler.setSteps: %v", err)
+	}
+	if steps.Update == nil {
+		steps.Update = make(map[volumeID][]volumeAction)
+	}
+	vr.volumesToCreate = steps.Create
+	vr.volumesToUpdate = steps.Update
+	vr.volumesToDelete = steps.Delete
+	return nil
 }
 
-func (vr *volumeReconciler) getObjectsToDelete() interface{} {
-	return vr.volumesToDelete
+// getObservedObjects returns the volume fields that the volume reconciler compares or acts on.
+func (vr *volumeReconciler) getObservedObjects() []observedObject {
+	observedObjects := make([]observedObject, 0)
+	for _, activeVolume := range vr.activeVolumes {
+		observed := struct {
+			Name          string   `json:"name"`
+			Region        string   `json:"region"`
+			SizeGigaBytes int64    `json:"sizeGigaBytes"`
+			DropletIDs    []int    `json:"dropletIDs"`
+			Tags          []string `json:"tags"`
+		}{
+			Name:          activeVolume.Name,
+			SizeGigaBytes: activeVolume.SizeGigaBytes,
+			DropletIDs:    activeVolume.DropletIDs,
+			Tags:          activeVolume.Tags,
+		}
+		if activeVolume.Region != nil {
+			observed.Region = activeVolume.Region.Slug
+		}
+		observedObjects = append(observedObjects, observedObject{
+			id:       activeVolume.ID,
+			name:     activeVolume.Name,
+			observed: observed,
+		})
+	}
+	return observedObjects
 }
 
-func getVolumeActions(gitdropsVolume gitdrops.Volume, activeVolume godo.Volume) []action {
-	var volumeActions []action
-	if activeVolume.SizeGigaBytes != 0 && activeVolume.SizeGigaBytes != gitdropsVolume.SizeGigaBytes {
+// getVolumeActions returns the actions that bring activeVolume in line with gitdropsVolume.
+// Changes to fields in gitdropsVolume.IgnoreChanges are ignored. Changes to fields that cannot
+// be changed in place are reported as requiring replacement. A smaller size is an error, unless
+// gitdropsVolume.ReplaceOnShrink is set, in which case the volume is replaced unless it is
+// protected or not managed by gitdrops, see isVolumeReplaceable. The size of a volume that is
+// not replaced is reported as requiring replacement.
+func getVolumeActions(gitdropsVolume gitdrops.Volume, activeVolume godo.Volume, ownershipTags []string) ([]volumeAction, error) {
+	var volumeActions []volumeAction
+	if isProtected(activeVolume.Tags) != gitdropsVolume.Protect {
+		volumeActions = append(volumeActions, volumeAction{Action: protect, Protected: gitdropsVolume.Protect})
+	}
+	for _, field := range getVolumeReplacementFields(gitdropsVolume, activeVolume) {
+		log.Println("getVolumeActions: volume", activeVolume.Name, field, "has been updated in gitdrops.yaml, but cannot be changed in place and requires replacement")
+		volumeActions = append(volumeActions, volumeAction{
+			Action: requiresReplacement,
+			Field:  field,
+		})
+	}
+	if activeVolume.SizeGigaBytes > gitdropsVolume.SizeGigaBytes && !gitdropsVolume.IgnoresChanges(gitdrops.SizeField) {
+		if !gitdropsVolume.ReplaceOnShrink {
+			return nil, fmt.Errorf("getVolumeActions: volume %q: sizeGigaBytes %d is smaller than the current size %d, DO cannot shrink volumes. Restore sizeGigaBytes, or set replaceOnShrink to replace the volume with a new, empty volume",
+				gitdropsVolume.Name, gitdropsVolume.SizeGigaBytes, activeVolume.SizeGigaBytes)
+		}
+		if err := isVolumeReplaceable(gitdropsVolume, activeVolume, ownershipTags); err != nil {
+			log.Println("getVolumeActions: volume", activeVolume.Name, "size has been reduced in gitdrops.yaml, but", err)
+			volumeActions = append(volumeActions, volumeAction{
+				Action: requiresReplacement,
+				Field:  gitdrops.SizeField,
+			})
+		} else {
+			log.Println("getVolumeActions: volume", activeVolume.Name, "size has been reduced in gitdrops.yaml, the volume will be replaced")
+			// the new volume is created with the tags in gitdrops.yaml
+			return append(volumeActions, volumeAction{
+				Action:        replace,
+				SizeGigaBytes: gitdropsVolume.SizeGigaBytes,
+			}), nil
+		}
+	} else if activeVolume.SizeGigaBytes != 0 && activeVolume.SizeGigaBytes != gitdropsVolume.SizeGigaBytes && !gitdropsVolume.IgnoresChanges(gitdrops.SizeField) {
 		log.Println("getVolumeActions: volume", activeVolume.Name, "size has been updated in gitdrops.yaml")
-		volumeAction := action{
-			action: resize,
-			value:  gitdropsVolume.SizeGigaBytes,
+		volumeActions = append(volumeActions, volumeAction{
+			Action:        resize,
+			SizeGigaBytes: gitdropsVolume.SizeGigaBytes,
+		})
+	}
+	if !gitdropsVolume.IgnoresChanges(gitdrops.TagsField) {
+		addedTags, removedTags := tagChanges(activeVolume.Tags, gitdropsVolume.Tags)
+		for _, t := range addedTags {
+			volumeActions = append(volumeActions, volumeAction{Action: tag, Tag: t})
+		}
+		for _, t := range removedTags {
+			volumeActions = append(volumeActions, volumeAction{Action: untag, Tag: t})
+		}
+	}
+	return volumeActions, nil
+}
+
+// isVolumeReplaceable returns an error if activeVolume must not be replaced. Replacing a volume
+// deletes it, so like a deletion it is refused for volumes that are protected, in gitdrops.yaml
+// or by their tags, or that are not managed by gitdrops.
+func isVolumeReplaceable(gitdropsVolume gitdrops.Volume, activeVolume godo.Volume, ownershipTags []string) error {
+	if gitdropsVolume.Protect || isProtected(activeVolume.Tags) {
+		return fmt.Errorf("isVolumeReplaceable: volume %q (%s) is protected and will not be replaced", activeVolume.Name, activeVolume.ID)
+	}
+	if !isManaged(activeVolume.Tags, ownershipTags) {
+		return fmt.Errorf("isVolumeReplaceable: volume %q (%s) is not managed by gitdrops and will not be replaced", activeVolume.Name, activeVolume.ID)
+	}
+	return nil
+}
+
+// getVolumeReplacementFields returns the fields of gitdropsVolume that differ from activeVolume
+// but cannot be changed in place. The filesystem type and label are only compared if set in
+// gitdrops.yaml, and the snapshot of volumes not created by gitdrops is unknown.
+func getVolumeReplacementFields(gitdropsVolume gitdrops.Volume, activeVolume godo.Volume) []string {
+	fields := make([]string, 0)
+	if activeVolume.Region != nil && activeVolume.Region.Slug != gitdropsVolume.Region && !gitdropsVolume.IgnoresChanges(gitdrops.RegionField) {
+		fields = append(fields, gitdrops.RegionField)
+	}
+	if gitdropsVolume.FilesystemType != "" && activeVolume.FilesystemType != gitdropsVolume.FilesystemType && !gitdropsVolume.IgnoresChanges(gitdrops.FilesystemTypeField) {
+		fields = append(fields, gitdrops.FilesystemTypeField)
+	}
+	if gitdropsVolume.FilesystemLabel != "" && activeVolume.FilesystemLabel != gitdropsVolume.FilesystemLabel && !gitdropsVolume.IgnoresChanges(gitdrops.FilesystemLabelField) {
+		fields = append(fields, gitdrops.FilesystemLabelField)
+	}
+	if snapshotID, ok := findSnapshotID(activeVolume.Tags); ok && snapshotID != gitdropsVolume.SnapshotID && !gitdropsVolume.IgnoresChanges(gitdrops.SnapshotIDField) {
+		fields = append(fields, gitdrops.SnapshotIDField)
+	}
+	return fields
+}
+
+func (vr *volumeReconciler) countManagedObjects() int {
+	managed := 0
+	for _, activeVolume := range vr.activeVolumes {
+		if isManaged(activeVolume.Tags, vr.ownershipTags) {
+			managed++
 		}
-		volumeActions = append(volumeActions, volumeAction)
 	}
-	return volumeActions
+	return managed
 }
 
-func (vr *volumeReconciler) deleteObjects(ctx context.Context) error {
+// deleteOperations returns an operation per volume to delete, each depending on the volume
+// being detached and on the deletion of the droplets it is attached to.
+func (vr *volumeReconciler) deleteOperations() []operation {
+	operations := make([]operation, 0)
 	for _, id := range vr.volumesToDelete {
-		err := gitdrops.DeleteVolume(ctx, vr.client, id)
+		id := id
+		dependsOn := []string{operationKey(attachmentResource, detach, id)}
+		for _, activeVolume := range vr.activeVolumes {
+			if activeVolume.ID != id {
+				continue
+			}
+			for _, dropletID := range activeVolume.DropletIDs {
+				dependsOn = append(dependsOn, operationKey(droplet, remove, strconv.Itoa(dropletID)))
+			}
+		}
+		operations = append(operations, operation{
+			key:       operationKey(volume, remove, id),
+			dependsOn: dependsOn,
+			locks:     []string{lockKey(volume, id)},
+			run: func(ctx context.Context) error {
+				return vr.deleteObject(ctx, id)
+			},
+		})
+	}
+	return operations
+}
+
+func (vr *volumeReconciler) deleteObject(ctx context.Context, id string) error {
+	if vr.snapshotPolicy.beforeDelete {
+		err := vr.snapshotPolicy.snapshot(ctx, vr.client, godo.VolumeResourceType, vr.findVolumeName(id), func(snapshotName string) (string, error) {
+			return gitdrops.SnapshotVolume(ctx, vr.client, id, snapshotName)
+		})
 		if err != nil {
-			return fmt.Errorf("volumeReconciler.deleteObjects: %v", err)
+			return fmt.Errorf("volumeReconciler.deleteObject: %v", err)
 		}
 	}
+	err := gitdrops.DeleteVolume(ctx, vr.client, id)
+	if err != nil {
+		return fmt.Errorf("volumeReconciler.deleteObject: %v", err)
+	}
 	return nil
 }
 
-func (vr *volumeReconciler) createObjects(ctx context.Context) error {
+func (vr *volumeReconciler) createOperations() []operation {
+	operations := make([]operation, 0)
 	for _, volumeToCreate := range vr.volumesToCreate {
-		volumeCreateRequest, err := translateVolumeCreateRequest(volumeToCreate)
-		if err != nil {
-			return fmt.Errorf("volumeReconciler.createObjects: %v", err)
-		}
-		err = gitdrops.CreateVolume(ctx, vr.client, volumeCreateRequest)
-		if err != nil {
-			return fmt.Errorf("volumeReconciler.createObjects: %v", err)
-		}
+		volumeToCreate := volumeToCreate
+		operations = append(operations, operation{
+			key: operationKey(volume, create, volumeToCreate.Name),
+			run: func(ctx context.Context) error {
+				return vr.createObject(ctx, volumeToCreate)
+			},
+		})
+	}
+	return operations
+}
+
+func (vr *volumeReconciler) createObject(ctx context.Context, volumeToCreate gitdrops.Volume) error {
+	volumeCreateRequest, err := translateVolumeCreateRequest(volumeToCreate)
+	if err != nil {
+		return fmt.Errorf("volumeReconciler.createObject: %v", err)
+	}
+	volumeCreateRequest.Tags = creationTags(volumeCreateRequest.Tags, vr.ownershipTags, volumeToCreate.Protect)
+	volumeCreateRequest.Tags = withTags(volumeCreateRequest.Tags, []string{snapshotTag(volumeToCreate.SnapshotID)})
+	createdVolume, err := gitdrops.CreateVolume(ctx, vr.client, volumeCreateRequest)
+	if err != nil {
+		return fmt.Errorf("volumeReconciler.createObject: %v", err)
 	}
+	// the created volume is now active, this allows attach actions planned before the volume
+	// existed to find it by name
+	vr.mu.Lock()
+	vr.activeVolumes = append(vr.activeVolumes, *createdVolume)
+	vr.mu.Unlock()
 	return nil
 }
 
-func (vr *volumeReconciler) updateObjects(ctx context.Context) error {
-	for id, volumeActions := range vr.volumesToUpdate {
-		for _, volumeAction := range volumeActions {
-			switch volumeAction.action {
-			case resize:
-				err := gitdrops.ResizeVolume(ctx, vr.client, id.(string), vr.findVolumeRegion(id.(string)), volumeAction.value)
-				if err != nil {
-					return fmt.Errorf("volumeReconciler.updateObjects (resize): %v", err)
-				}
-			case attach:
-				// in this case, 'id' is that of the droplet and 'value' is the volume
-				// name. This is because this action was detected and created by the
-				// droplet reconciler.
-				err := gitdrops.AttachVolume(ctx, vr.client, volumeAction.value.(string), id.(int))
-				if err != nil {
-					return fmt.Errorf("volumeReconciler.updateObjects (attach): %v", err)
+func (vr *volumeReconciler) updateOperations() []operation {
+	ids := make([]string, 0, len(vr.volumesToUpdate))
+	for id := range vr.volumesToUpdate {
+		ids = append(ids, string(id))
+	}
+	sort.Strings(ids)
+
+	operations := make([]operation, 0)
+	for _, id := range ids {
+		id := id
+		// the tag and untag actions of a volume are applied by a single operation
+		tagActions := make([]volumeAction, 0)
+		for _, volumeAction := range vr.volumesToUpdate[volumeID(id)] {
+			volumeAction := volumeAction
+			if volumeAction.Action == requiresReplacement {
+				continue
+			}
+			if volumeAction.Action == replace {
+				// replacing a volume creates and deletes a volume
+				if !vr.privileges.Allows(volume, replace) || !vr.privileges.Allows(volume, create) || !vr.privileges.Allows(volume, remove) {
+					log.Println("gitdrops discovered volumes to replace, but does not have replace, create and delete privileges")
+					continue
 				}
-			case detach:
-				// in this case, 'id' is that of the droplet and 'value' is the volume
-				// id. This is because this action was detected and created by the
-				// droplet reconciler.
-				err := gitdrops.DetachVolume(ctx, vr.client, volumeAction.value.(string), id.(int))
+				err := vr.isReplaceable(id)
 				if err != nil {
-					return fmt.Errorf("volumeReconciler.updateObjects (detach): %v", err)
+					log.Println("volumeReconciler.updateOperations:", err)
+					continue
 				}
+				operations = append(operations, vr.replaceOperation(id))
+				continue
+			}
+			if !vr.privileges.Allows(volume, volumeAction.Action) {
+				log.Printf("gitdrops discovered volumes to %s, but does not have %s privileges", volumeAction.Action, volumeAction.Action)
+				continue
 			}
+			if volumeAction.Action == tag || volumeAction.Action == untag {
+				tagActions = append(tagActions, volumeAction)
+				continue
+			}
+			operations = append(operations, operation{
+				key:   operationKey(volume, volumeAction.Action, id),
+				locks: []string{lockKey(volume, id)},
+				run: func(ctx context.Context) error {
+					return vr.updateObject(ctx, id, volumeAction)
+				},
+			})
+		}
+		if len(tagActions) != 0 {
+			operations = append(operations, operation{
+				key:   operationKey(volume, tag, id),
+				locks: []string{lockKey(volume, id)},
+				run: func(ctx context.Context) error {
+					for _, tagAction := range tagActions {
+						err := vr.updateObject(ctx, id, tagAction)
+						if err != nil {
+							return err
+						}
+					}
+					return nil
+				},
+			})
+		}
+	}
+	return operations
+}
+
+// replaceOperation returns the operation replacing volume id, which acts on the droplets the
+// volume is attached to. Its key has the replace action, so that checkMassDelete counts the
+// deletion of the volume.
+func (vr *volumeReconciler) replaceOperation(id string) operation {
+	dependsOn := []string{operationKey(attachmentResource, detach, id)}
+	locks := []string{lockKey(volume, id)}
+	for _, activeVolume := range vr.activeVolumes {
+		if activeVolume.ID != id {
+			continue
+		}
+		for _, dropletID := range activeVolume.DropletIDs {
+			dependsOn = append(dependsOn, operationKey(droplet, update, strconv.Itoa(dropletID)), operationKey(droplet, replace, strconv.Itoa(dropletID)))
+			locks = append(locks, lockKey(droplet, strconv.Itoa(dropletID)))
+		}
+	}
+	return operation{
+		key:       operationKey(volume, replace, id),
+		dependsOn: dependsOn,
+		locks:     locks,
+		run: func(ctx context.Context) error {
+			return vr.replaceObject(ctx, id)
+		},
+	}
+}
+
+// replaceObject detaches volume id from its droplets, deletes it and creates a new, empty volume
+// as declared in gitdrops.yaml, which is attached to the same droplets.
+func (vr *volumeReconciler) replaceObject(ctx context.Context, id string) error {
+	name := vr.findVolumeName(id)
+	gitdropsVolume, ok := vr.findGitdropsVolume(name)
+	if !ok {
+		return fmt.Errorf("volumeReconciler.replaceObject: volume %q (%s) is not declared in gitdrops.yaml", name, id)
+	}
+	// the volume may have been detached, attached or tagged since it was listed
+	activeVolume, err := gitdrops.GetVolume(ctx, vr.client, id)
+	if err != nil {
+		return fmt.Errorf("volumeReconciler.replaceObject: %v", err)
+	}
+	err = isVolumeReplaceable(gitdropsVolume, *activeVolume, vr.ownershipTags)
+	if err != nil {
+		return fmt.Errorf("volumeReconciler.replaceObject: %v", err)
+	}
+	for _, dropletID := range activeVolume.DropletIDs {
+		action, err := gitdrops.DetachVolume(ctx, vr.client, id, dropletID)
+		if err != nil {
+			return fmt.Errorf("volumeReconciler.replaceObject: %v", err)
+		}
+		err = gitdrops.WaitForAction(ctx, vr.client, action, vr.actionTimeout)
+		if err != nil {
+			return fmt.Errorf("volumeReconciler.replaceObject: %v", err)
+		}
+	}
+	err = vr.deleteObject(ctx, id)
+	if err != nil {
+		return fmt.Errorf("volumeReconciler.replaceObject: %v", err)
+	}
+	// the new volume has the same name, see findVolumeID
+	vr.mu.Lock()
+	for i, v := range vr.activeVolumes {
+		if v.ID == id {
+			vr.activeVolumes = append(vr.activeVolumes[:i], vr.activeVolumes[i+1:]...)
+			break
+		}
+	}
+	vr.mu.Unlock()
+	err = vr.createObject(ctx, gitdropsVolume)
+	if err != nil {
+		return fmt.Errorf("volumeReconciler.replaceObject: %v", err)
+	}
+	newID := vr.findVolumeID(name)
+	for _, dropletID := range activeVolume.DropletIDs {
+		action, err := gitdrops.AttachVolume(ctx, vr.client, newID, dropletID)
+		if err != nil {
+			return fmt.Errorf("volumeReconciler.replaceObject: %v", err)
+		}
+		err = gitdrops.WaitForAction(ctx, vr.client, action, vr.actionTimeout)
+		if err != nil {
+			return fmt.Errorf("volumeReconciler.replaceObject: %v", err)
+		}
+	}
+	return nil
+}
+
+func (vr *volumeReconciler) updateObject(ctx context.Context, id string, updateAction volumeAction) error {
+	switch updateAction.Action {
+	case protect:
+		resource := godo.Resource{ID: id, Type: godo.VolumeResourceType}
+		err := setProtected(ctx, vr.client, resource, updateAction.Protected)
+		if err != nil {
+			return fmt.Errorf("volumeReconciler.updateObject (protect): %v", err)
+		}
+	case resize:
+		action, err := gitdrops.ResizeVolume(ctx, vr.client, id, vr.findVolumeRegion(id), updateAction.SizeGigaBytes)
+		if err != nil {
+			return fmt.Errorf("volumeReconciler.updateObject (resize): %v", err)
+		}
+		return gitdrops.WaitForAction(ctx, vr.client, action, vr.actionTimeout)
+	case tag, untag:
+		resources := []godo.Resource{{ID: id, Type: godo.VolumeResourceType}}
+		var err error
+		if updateAction.Action == untag {
+			err = gitdrops.UntagResources(ctx, vr.client, updateAction.Tag, resources)
+		} else {
+			err = gitdrops.TagResources(ctx, vr.client, updateAction.Tag, resources)
+		}
+		if err != nil {
+			return fmt.Errorf("volumeReconciler.updateObject (%s): %v", updateAction.Action, err)
 		}
+	default:
+		return fmt.Errorf("volumeReconciler.updateObject: unknown action %q", updateAction.Action)
 	}
 	return nil
 }
 
+// isReplaceable returns an error if volume id is not declared in gitdrops.yaml or must not be
+// replaced, see isVolumeReplaceable.
+func (vr *volumeReconciler) isReplaceable(id string) error {
+	name := vr.findVolumeName(id)
+	gitdropsVolume, ok := vr.findGitdropsVolume(name)
+	if !ok {
+		return fmt.Errorf("volumeReconciler.isReplaceable: volume %q (%s) is not declared in gitdrops.yaml", name, id)
+	}
+	for _, activeVolume := range vr.activeVolumes {
+		if activeVolume.ID == id {
+			err := isVolumeReplaceable(gitdropsVolume, activeVolume, vr.ownershipTags)
+			if err != nil {
+				return fmt.Errorf("volumeReconciler.isReplaceable: %v", err)
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("volumeReconciler.isReplaceable: volume %q (%s) is not active", name, id)
+}
+
+func (vr *volumeReconciler) findGitdropsVolume(name string) (gitdrops.Volume, bool) {
+	for _, gitdropsVolume := range vr.gitdropsVolumes {
+		if gitdropsVolume.Name == name {
+			return gitdropsVolume, true
+		}
+	}
+	return gitdrops.Volume{}, false
+}
+
 func (vr *volumeReconciler) findVolumeRegion(volID string) string {
+	vr.mu.Lock()
+	defer vr.mu.Unlock()
 	for _, vol := range vr.activeVolumes {
 		if vol.ID == volID {
 			return vol.Region.Slug
@@ -235,6 +632,30 @@ func (vr *volumeReconciler) findVolumeRegion(volID string) string {
 	return ""
 }
 
+func (vr *volumeReconciler) findVolumeName(volID string) string {
+	vr.mu.Lock()
+	defer vr.mu.Unlock()
+	for _, vol := range vr.activeVolumes {
+		if vol.ID == volID {
+			return vol.Name
+		}
+	}
+	return ""
+}
+
+// findVolumeID returns the ID of the active volume with the given ID or name. Attach actions
+// planned before a volume was created refer to the volume by name.
+func (vr *volumeReconciler) findVolumeID(volIDOrName string) string {
+	vr.mu.Lock()
+	defer vr.mu.Unlock()
+	for _, vol := range vr.activeVolumes {
+		if vol.ID == volIDOrName || vol.Name == volIDOrName {
+			return vol.ID
+		}
+	}
+	return volIDOrName
+}
+
 func translateVolumeCreateRequest(gitdropsVolume gitdrops.Volume) (*godo.VolumeCreateRequest, error) {
 	createRequest := &godo.VolumeCreateRequest{}
 	if gitdropsVolume.Name == "" {
@@ -2,6 +2,7 @@ package reconcile
 
 import (
 	"errors"
+	"fmt"
 	"reflect"
 	"testing"
 
@@ -16,6 +17,7 @@ func newTestVolumeReconciler(privileges gitdrops.Privileges, client *godo.Client
 		client:          client,
 		activeVolumes:   activeVolumes,
 		gitdropsVolumes: gitdropsVolumes,
+		ownershipTags:   ownershipTags(""),
 	}
 }
 
@@ -25,7 +27,7 @@ func TestSetVolumesToUpdateCreate(t *testing.T) {
 		activeVolumes   []godo.Volume
 		gitdropsVolumes []gitdrops.Volume
 		volumesToCreate []gitdrops.Volume
-		volumesToUpdate actionsByID
+		volumesToUpdate map[volumeID][]volumeAction
 		volumeNameToID  map[string]string
 	}{
 		{
@@ -55,7 +57,7 @@ func TestSetVolumesToUpdateCreate(t *testing.T) {
 					Name: "volume-5",
 				},
 			},
-			volumesToUpdate: make(actionsByID),
+			volumesToUpdate: make(map[volumeID][]volumeAction),
 			volumesToCreate: []gitdrops.Volume{
 				{
 					Name: "volume-4",
@@ -95,7 +97,7 @@ func TestSetVolumesToUpdateCreate(t *testing.T) {
 				},
 			},
 
-			volumesToUpdate: make(actionsByID),
+			volumesToUpdate: make(map[volumeID][]volumeAction),
 			volumesToCreate: []gitdrops.Volume{},
 			volumeNameToID:  make(map[string]string),
 		},
@@ -113,7 +115,7 @@ func TestSetVolumesToUpdateCreate(t *testing.T) {
 					Name: "volume-3",
 				},
 			},
-			volumesToUpdate: make(actionsByID),
+			volumesToUpdate: make(map[volumeID][]volumeAction),
 			volumesToCreate: []gitdrops.Volume{
 				{
 					Name: "volume-1",
@@ -156,11 +158,11 @@ func TestSetVolumesToUpdateCreate(t *testing.T) {
 					Name: "volume-4",
 				},
 			},
-			volumesToUpdate: actionsByID{
-				string("def"): []action{
+			volumesToUpdate: map[volumeID][]volumeAction{
+				volumeID("def"): []volumeAction{
 					{
-						action: "resize",
-						value:  int64(200),
+						Action:        "resize",
+						SizeGigaBytes: 200,
 					},
 				},
 			},
@@ -199,14 +201,17 @@ func TestSetVolumesToDelete(t *testing.T) {
 				{
 					ID:   "abc",
 					Name: "volume-1",
+					Tags: testOwnershipTags,
 				},
 				{
 					ID:   "def",
 					Name: "volume-2",
+					Tags: testOwnershipTags,
 				},
 				{
 					ID:   "ghi",
 					Name: "volume-3",
+					Tags: testOwnershipTags,
 				},
 			},
 
@@ -230,14 +235,17 @@ func TestSetVolumesToDelete(t *testing.T) {
 				{
 					ID:   "abc",
 					Name: "volume-1",
+					Tags: testOwnershipTags,
 				},
 				{
 					ID:   "def",
 					Name: "volume-2",
+					Tags: testOwnershipTags,
 				},
 				{
 					ID:   "ghi",
 					Name: "volume-3",
+					Tags: testOwnershipTags,
 				},
 			},
 			gitdropsVolumes: []gitdrops.Volume{
@@ -275,6 +283,7 @@ func TestSetVolumesToDelete(t *testing.T) {
 				{
 					ID:   "abc",
 					Name: "volume-1",
+					Tags: testOwnershipTags,
 					Region: &godo.Region{
 						Name: "london",
 					},
@@ -282,10 +291,12 @@ func TestSetVolumesToDelete(t *testing.T) {
 				{
 					ID:   "def",
 					Name: "volume-2",
+					Tags: testOwnershipTags,
 				},
 				{
 					ID:   "ghi",
 					Name: "volume-3",
+					Tags: testOwnershipTags,
 				},
 			},
 			gitdropsVolumes: []gitdrops.Volume{
@@ -303,6 +314,27 @@ func TestSetVolumesToDelete(t *testing.T) {
 			},
 			volumesToDelete: []string{"ghi"},
 		},
+		{
+			name: "test case 5 - unmanaged volumes are not deleted",
+			activeVolumes: []godo.Volume{
+				{
+					ID:   "abc",
+					Name: "volume-1",
+				},
+				{
+					ID:   "def",
+					Name: "volume-2",
+					Tags: testOwnershipTags,
+				},
+				{
+					ID:   "ghi",
+					Name: "volume-3",
+					Tags: append([]string{protectedTag}, testOwnershipTags...),
+				},
+			},
+			gitdropsVolumes: []gitdrops.Volume{},
+			volumesToDelete: []string{"def"},
+		},
 	}
 	for _, tc := range tcases {
 		vr := newTestVolumeReconciler(gitdrops.Privileges{}, nil, tc.activeVolumes, tc.gitdropsVolumes)
@@ -314,6 +346,203 @@ func TestSetVolumesToDelete(t *testing.T) {
 	}
 }
 
+func TestGetVolumeActions(t *testing.T) {
+	activeVolume := godo.Volume{
+		ID:            "abc",
+		Name:          "volume-1",
+		SizeGigaBytes: 100,
+		Tags:          testOwnershipTags,
+	}
+	tcases := []struct {
+		name           string
+		gitdropsVolume gitdrops.Volume
+		activeVolume   godo.Volume
+		expActions     []volumeAction
+		expError       error
+	}{
+		{
+			name: "test case 1 - resize",
+			gitdropsVolume: gitdrops.Volume{
+				Name:          "volume-1",
+				SizeGigaBytes: 200,
+			},
+			activeVolume: activeVolume,
+			expActions: []volumeAction{
+				{Action: resize, SizeGigaBytes: 200},
+			},
+		},
+		{
+			name: "test case 2 - ignored size and protect",
+			gitdropsVolume: gitdrops.Volume{
+				Name:          "volume-1",
+				SizeGigaBytes: 200,
+				Protect:       true,
+				IgnoreChanges: []string{"size"},
+			},
+			activeVolume: activeVolume,
+			expActions: []volumeAction{
+				{Action: protect, Protected: true},
+			},
+		},
+		{
+			name: "test case 3 - tags and requires replacement",
+			gitdropsVolume: gitdrops.Volume{
+				Name:            "volume-1",
+				Region:          "sfo3",
+				SizeGigaBytes:   100,
+				SnapshotID:      "snapshot-2",
+				FilesystemType:  "xfs",
+				FilesystemLabel: "data",
+				Tags:            []string{"db"},
+			},
+			activeVolume: godo.Volume{
+				ID:              "abc",
+				Name:            "volume-1",
+				Region:          &godo.Region{Slug: "nyc3"},
+				SizeGigaBytes:   100,
+				FilesystemType:  "ext4",
+				FilesystemLabel: "data",
+				Tags:            append([]string{"web", snapshotTag("snapshot-1")}, testOwnershipTags...),
+			},
+			expActions: []volumeAction{
+				{Action: requiresReplacement, Field: "region"},
+				{Action: requiresReplacement, Field: "filesystemType"},
+				{Action: requiresReplacement, Field: "snapShotID"},
+				{Action: tag, Tag: "db"},
+				{Action: untag, Tag: "web"},
+			},
+		},
+		{
+			name: "test case 4 - ignored changes and unknown snapshot",
+			gitdropsVolume: gitdrops.Volume{
+				Name:           "volume-1",
+				Region:         "sfo3",
+				SizeGigaBytes:  100,
+				SnapshotID:     "snapshot-2",
+				FilesystemType: "xfs",
+				Tags:           []string{"db"},
+				IgnoreChanges:  []string{"region", "filesystemType", "tags"},
+			},
+			activeVolume: godo.Volume{
+				ID:             "abc",
+				Name:           "volume-1",
+				Region:         &godo.Region{Slug: "nyc3"},
+				SizeGigaBytes:  100,
+				FilesystemType: "ext4",
+			},
+			expActions: nil,
+		},
+		{
+			name: "test case 5 - shrink",
+			gitdropsVolume: gitdrops.Volume{
+				Name:          "volume-1",
+				SizeGigaBytes: 50,
+			},
+			activeVolume: activeVolume,
+			expActions:   nil,
+			expError:     errors.New(`getVolumeActions: volume "volume-1": sizeGigaBytes 50 is smaller than the current size 100, DO cannot shrink volumes. Restore sizeGigaBytes, or set replaceOnShrink to replace the volume with a new, empty volume`),
+		},
+		{
+			name: "test case 6 - replace on shrink",
+			gitdropsVolume: gitdrops.Volume{
+				Name:            "volume-1",
+				SizeGigaBytes:   50,
+				Tags:            []string{"db"},
+				ReplaceOnShrink: true,
+			},
+			activeVolume: activeVolume,
+			expActions: []volumeAction{
+				{Action: replace, SizeGigaBytes: 50},
+			},
+		},
+		{
+			name: "test case 7 - protected volume is not replaced",
+			gitdropsVolume: gitdrops.Volume{
+				Name:            "volume-1",
+				SizeGigaBytes:   50,
+				Protect:         true,
+				ReplaceOnShrink: true,
+			},
+			activeVolume: activeVolume,
+			expActions: []volumeAction{
+				{Action: protect, Protected: true},
+				{Action: requiresReplacement, Field: gitdrops.SizeField},
+			},
+		},
+		{
+			name: "test case 8 - volume protected by tag is not replaced",
+			gitdropsVolume: gitdrops.Volume{
+				Name:            "volume-1",
+				SizeGigaBytes:   50,
+				ReplaceOnShrink: true,
+			},
+			activeVolume: godo.Volume{
+				ID:            "abc",
+				Name:          "volume-1",
+				SizeGigaBytes: 100,
+				Tags:          append([]string{protectedTag}, testOwnershipTags...),
+			},
+			expActions: []volumeAction{
+				{Action: protect, Protected: false},
+				{Action: requiresReplacement, Field: gitdrops.SizeField},
+			},
+		},
+		{
+			name: "test case 9 - unmanaged volume is not replaced",
+			gitdropsVolume: gitdrops.Volume{
+				Name:            "volume-1",
+				SizeGigaBytes:   50,
+				ReplaceOnShrink: true,
+			},
+			activeVolume: godo.Volume{
+				ID:            "abc",
+				Name:          "volume-1",
+				SizeGigaBytes: 100,
+			},
+			expActions: []volumeAction{
+				{Action: requiresReplacement, Field: gitdrops.SizeField},
+			},
+		},
+	}
+	for _, tc := range tcases {
+		volumeActions, err := getVolumeActions(tc.gitdropsVolume, tc.activeVolume, testOwnershipTags)
+		if !reflect.DeepEqual(volumeActions, tc.expActions) {
+			t.Errorf("Failed %v, expected: %v, got %v", tc.name, tc.expActions, volumeActions)
+		}
+		if fmt.Sprint(err) != fmt.Sprint(tc.expError) {
+			t.Errorf("Failed %v, expected error: %v, got error %v", tc.name, tc.expError, err)
+		}
+	}
+}
+
+func TestVolumeUpdateOperations(t *testing.T) {
+	activeVolumes := []godo.Volume{
+		{ID: "abc", Name: "volume-1", Tags: testOwnershipTags},
+		{ID: "def", Name: "volume-2"},
+		{ID: "ghi", Name: "volume-3", Tags: append([]string{protectedTag}, testOwnershipTags...)},
+	}
+	gitdropsVolumes := []gitdrops.Volume{
+		{Name: "volume-1", SizeGigaBytes: 50, ReplaceOnShrink: true},
+		{Name: "volume-2", SizeGigaBytes: 50, ReplaceOnShrink: true},
+		{Name: "volume-3", SizeGigaBytes: 50, ReplaceOnShrink: true},
+	}
+	vr := newTestVolumeReconciler(gitdrops.Privileges{Create: true, Update: true, Delete: true}, nil, activeVolumes, gitdropsVolumes)
+	// a saved plan may replace volumes that have since been protected or are not managed
+	vr.volumesToUpdate = map[volumeID][]volumeAction{
+		volumeID("abc"): []volumeAction{{Action: replace, SizeGigaBytes: 50}},
+		volumeID("def"): []volumeAction{{Action: replace, SizeGigaBytes: 50}},
+		volumeID("ghi"): []volumeAction{{Action: replace, SizeGigaBytes: 50}},
+	}
+	keys := make([]string, 0)
+	for _, op := range vr.updateOperations() {
+		keys = append(keys, op.key)
+	}
+	expKeys := []string{operationKey(volume, replace, "abc")}
+	if !reflect.DeepEqual(keys, expKeys) {
+		t.Errorf("Failed, expected: %v, got %v", expKeys, keys)
+	}
+}
+
 func TestTranslateVolumesCreateRequest(t *testing.T) {
 	tcases := []struct {
 		name                   string
@@ -0,0 +1,300 @@
+package reconcile
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+
+	"github.com/nolancon/gitdrops/pkg/gitdrops"
+
+	"github.com/digitalocean/godo"
+)
+
+const vpcResource = "vpc"
+
+// vpcReconciler creates the VPCs declared in gitdrops.yaml and keeps their descriptions in line
+// with it. DO VPCs cannot be tagged, so gitdrops cannot tell which VPCs it created and never
+// deletes a VPC. Droplets reference VPCs by name, see dropletReconciler.vpcNameToID.
+type vpcReconciler struct {
+	privileges   gitdrops.Privileges
+	client       *godo.Client
+	gitdropsVPCs []gitdrops.VPC
+	activeVPCs   []godo.VPC
+	vpcsToCreate []gitdrops.VPC
+	vpcsToUpdate map[vpcID][]vpcAction
+}
+
+var _ objectReconciler = &vpcReconciler{}
+
+// vpcSteps is the serializable form of the VPCs to create and update.
+type vpcSteps struct {
+	Create []gitdrops.VPC        `json:"create"`
+	Update map[vpcID][]vpcAction `json:"update"`
+}
+
+func (vr *vpcReconciler) getResourceType() string {
+	return vpcResource
+}
+
+func (vr *vpcReconciler) setActiveObjects(ctx context.Context) error {
+	activeVPCs, err := gitdrops.ListVPCs(ctx, vr.client)
+	if err != nil {
+		return fmt.Errorf("vpcReconciler.setActiveObjects: %v", err)
+	}
+	vr.activeVPCs = activeVPCs
+	log.Println("vpcReconciler.setActiveObjects: active VPCs", len(vr.activeVPCs))
+	return nil
+}
+
+// setObjectsToUpdateAndCreate populates vpcReconciler with the VPCs declared in gitdrops.yaml
+// that are not active on DO, and with the actions that bring the active VPCs in line with
+// gitdrops.yaml. Changes to the region or IP range of a VPC are reported as requiring
+// replacement.
+func (vr *vpcReconciler) setObjectsToUpdateAndCreate() error {
+	vpcsToCreate := make([]gitdrops.VPC, 0)
+	vpcActionsByID := make(map[vpcID][]vpcAction)
+	for _, gitdropsVPC := range vr.gitdropsVPCs {
+		activeVPC, ok := vr.findActiveVPC(gitdropsVPC.Name)
+		if !ok {
+			vpcsToCreate = append(vpcsToCreate, gitdropsVPC)
+			continue
+		}
+		vpcActions := getVPCActions(gitdropsVPC, activeVPC)
+		if len(vpcActions) != 0 {
+			vpcActionsByID[vpcID(activeVPC.ID)] = vpcActions
+		}
+	}
+	vr.vpcsToCreate = vpcsToCreate
+	vr.vpcsToUpdate = vpcActionsByID
+	log.Println("vpcReconciler.setObjectsToUpdateAndCreate: VPCs to create", vr.vpcsToCreate)
+	log.Println("vpcReconciler.setObjectsToUpdateAndCreate: VPCs to update", vr.vpcsToUpdate)
+	return nil
+}
+
+// setObjectsToDelete only logs the active VPCs not declared in gitdrops.yaml, VPCs are never
+// deleted.
+func (vr *vpcReconciler) setObjectsToDelete() {
+	for _, activeVPC := range vr.activeVPCs {
+		if _, ok := vr.findGitdropsVPC(activeVPC.Name); !ok && !activeVPC.Default {
+			log.Println("vpcReconciler.setObjectsToDelete: VPC", activeVPC.Name, "is not declared in gitdrops.yaml, gitdrops does not delete VPCs")
+		}
+	}
+}
+
+func (vr *vpcReconciler) getChanges() []Change {
+	changes := make([]Change, 0)
+	for _, vpcToCreate := range vr.vpcsToCreate {
+		changes = append(changes, Change{Resource: vpcResource, Action: create, Name: vpcToCreate.Name, Value: vpcToCreate.Region})
+	}
+	for _, activeVPC := range vr.activeVPCs {
+		for _, vpcAction := range vr.vpcsToUpdate[vpcID(activeVPC.ID)] {
+			changes = append(changes, Change{
+				Resource: vpcResource,
+				Action:   vpcAction.Action,
+				Name:     activeVPC.Name,
+				ID:       activeVPC.ID,
+				Value:    vpcAction.value(),
+			})
+		}
+	}
+	return changes
+}
+
+func (vr *vpcReconciler) getSteps() interface{} {
+	return vpcSteps{
+		Create: vr.vpcsToCreate,
+		Update: vr.vpcsToUpdate,
+	}
+}
+
+func (vr *vpcReconciler) setSteps(stepsJSON json.RawMessage) error {
+	steps := vpcSteps{}
+	err := unmarshalSteps(stepsJSON, &steps)
+	if err != nil {
+		return fmt.Errorf("vpcReconciler.setSteps: %v", err)
+	}
+	if steps.Update == nil {
+		steps.Update = make(map[vpcID][]vpcAction)
+	}
+	vr.vpcsToCreate = steps.Create
+	vr.vpcsToUpdate = steps.Update
+	return nil
+}
+
+// getObservedObjects returns the VPC fields that the VPC reconciler compares.
+func (vr *vpcReconciler) getObservedObjects() []observedObject {
+	observedObjects := make([]observedObject, 0)
+	for _, activeVPC := range vr.activeVPCs {
+		observed := struct {
+			Name        string `json:"name"`
+			Region      string `json:"region"`
+			IPRange     string `json:"ipRange"`
+			Description string `json:"description"`
+		}{
+			Name:        activeVPC.Name,
+			Region:      activeVPC.RegionSlug,
+			IPRange:     activeVPC.IPRange,
+			Description: activeVPC.Description,
+		}
+		observedObjects = append(observedObjects, observedObject{
+			id:       activeVPC.ID,
+			name:     activeVPC.Name,
+			observed: observed,
+		})
+	}
+	return observedObjects
+}
+
+// countManagedObjects returns 0, VPCs are never deleted.
+func (vr *vpcReconciler) countManagedObjects() int {
+	return 0
+}
+
+// getVPCActions returns the actions that bring activeVPC in line with gitdropsVPC. The region
+// and IP range of a VPC cannot be changed, such changes are reported as requiring replacement
+// but are never applied. An empty ipRange is picked by DO and never differs.
+func getVPCActions(gitdropsVPC gitdrops.VPC, activeVPC godo.VPC) []vpcAction {
+	vpcActions := make([]vpcAction, 0)
+	if gitdropsVPC.Region != activeVPC.RegionSlug {
+		vpcActions = append(vpcActions, vpcAction{Action: requiresReplacement, Field: gitdrops.RegionField})
+	}
+	if gitdropsVPC.IPRange != "" && gitdropsVPC.IPRange != activeVPC.IPRange {
+		vpcActions = append(vpcActions, vpcAction{Action: requiresReplacement, Field: "ipRange"})
+	}
+	for _, vpcAction := range vpcActions {
+		log.Println("getVPCActions: VPC", activeVPC.Name, vpcAction.value(), "has been updated in gitdrops.yaml, but cannot be changed in place and requires replacement")
+	}
+	if gitdropsVPC.Description != activeVPC.Description {
+		vpcActions = append(vpcActions, vpcAction{Action: updateDescription, Description: gitdropsVPC.Description})
+	}
+	return vpcActions
+}
+
+func (vr *vpcReconciler) reconcileObjectsToCreate() []operation {
+	operations := make([]operation, 0)
+	if len(vr.vpcsToCreate) != 0 {
+		if vr.privileges.Allows(vpcResource, create) {
+			log.Println("vpcReconciler.reconcileObjectsToCreate: create VPCs", vr.vpcsToCreate)
+			operations = vr.createOperations()
+		} else {
+			log.Println("gitdrops discovered VPCs to create, but does not have create privileges")
+		}
+	}
+	return operations
+}
+
+func (vr *vpcReconciler) reconcileObjectsToUpdate() []operation {
+	operations := make([]operation, 0)
+	if len(vr.vpcsToUpdate) != 0 {
+		log.Println("vpcReconciler.reconcileObjectsToUpdate: update VPCs", vr.vpcsToUpdate)
+		operations = vr.updateOperations()
+	}
+	return operations
+}
+
+// reconcileObjectsToDelete returns no operations, VPCs are never deleted.
+func (vr *vpcReconciler) reconcileObjectsToDelete() []operation {
+	return []operation{}
+}
+
+// createOperations returns an operation per VPC to create. Droplets in the VPC depend on its
+// creation, see dropletReconciler.createOperations.
+func (vr *vpcReconciler) createOperations() []operation {
+	operations := make([]operation, 0)
+	for _, vpcToCreate := range vr.vpcsToCreate {
+		vpcToCreate := vpcToCreate
+		operations = append(operations, operation{
+			key: operationKey(vpcResource, create, vpcToCreate.Name),
+			run: func(ctx context.Context) error {
+				return vr.createObject(ctx, vpcToCreate)
+			},
+		})
+	}
+	return operations
+}
+
+func (vr *vpcReconciler) createObject(ctx context.Context, vpcToCreate gitdrops.VPC) error {
+	_, err := gitdrops.CreateVPC(ctx, vr.client, &godo.VPCCreateRequest{
+		Name:        vpcToCreate.Name,
+		RegionSlug:  vpcToCreate.Region,
+		Description: vpcToCreate.Description,
+		IPRange:     vpcToCreate.IPRange,
+	})
+	if err != nil {
+		return fmt.Errorf("vpcReconciler.createObject: %v", err)
+	}
+	return nil
+}
+
+// updateOperations returns an operation per VPC to update, with the actions gitdrops has the
+// privileges for. Changes requiring replacement are never applied.
+func (vr *vpcReconciler) updateOperations() []operation {
+	ids := make([]string, 0, len(vr.vpcsToUpdate))
+	for id := range vr.vpcsToUpdate {
+		ids = append(ids, string(id))
+	}
+	sort.Strings(ids)
+
+	operations := make([]operation, 0)
+	for _, id := range ids {
+		id := id
+		vpcActions := make([]vpcAction, 0)
+		for _, vpcAction := range vr.vpcsToUpdate[vpcID(id)] {
+			if vpcAction.Action == requiresReplacement {
+				continue
+			}
+			if !vr.privileges.Allows(vpcResource, vpcAction.Action) {
+				log.Printf("gitdrops discovered VPCs to %s, but does not have %s privileges", vpcAction.Action, vpcAction.Action)
+				continue
+			}
+			vpcActions = append(vpcActions, vpcAction)
+		}
+		if len(vpcActions) == 0 {
+			continue
+		}
+		operations = append(operations, operation{
+			key:   operationKey(vpcResource, update, id),
+			locks: []string{lockKey(vpcResource, id)},
+			run: func(ctx context.Context) error {
+				return vr.updateObject(ctx, id, vpcActions)
+			},
+		})
+	}
+	return operations
+}
+
+func (vr *vpcReconciler) updateObject(ctx context.Context, id string, vpcActions []vpcAction) error {
+	for _, vpcAction := range vpcActions {
+		var err error
+		switch vpcAction.Action {
+		case updateDescription:
+			err = gitdrops.SetVPCDescription(ctx, vr.client, id, vpcAction.Description)
+		default:
+			err = fmt.Errorf("unknown action %q", vpcAction.Action)
+		}
+		if err != nil {
+			return fmt.Errorf("vpcReconciler.updateObject: %v", err)
+		}
+	}
+	return nil
+}
+
+func (vr *vpcReconciler) findActiveVPC(name string) (godo.VPC, bool) {
+	for _, activeVPC := range vr.activeVPCs {
+		if activeVPC.Name == name {
+			return activeVPC, true
+		}
+	}
+	return godo.VPC{}, false
+}
+
+func (vr *vpcReconciler) findGitdropsVPC(name string) (gitdrops.VPC, bool) {
+	for _, gitdropsVPC := range vr.gitdropsVPCs {
+		if gitdropsVPC.Name == name {
+			return gitdropsVPC, true
+		}
+	}
+	return gitdrops.VPC{}, false
+}
@@ -0,0 +1,160 @@
+package reconcile
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/nolancon/gitdrops/pkg/gitdrops"
+
+	"github.com/digitalocean/godo"
+)
+
+func newTestVPCReconciler(privileges gitdrops.Privileges, activeVPCs []godo.VPC, gitdropsVPCs []gitdrops.VPC) *vpcReconciler {
+	return &vpcReconciler{
+		privileges:   privileges,
+		activeVPCs:   activeVPCs,
+		gitdropsVPCs: gitdropsVPCs,
+	}
+}
+
+func TestSetVPCsToUpdateCreate(t *testing.T) {
+	tcases := []struct {
+		name         string
+		activeVPCs   []godo.VPC
+		gitdropsVPCs []gitdrops.VPC
+		vpcsToCreate []gitdrops.VPC
+		vpcsToUpdate map[vpcID][]vpcAction
+	}{
+		{
+			name: "test case 1 - create vpc",
+			activeVPCs: []godo.VPC{
+				{ID: "default-nyc3", Name: "default-nyc3", RegionSlug: "nyc3", IPRange: "10.108.0.0/20", Default: true},
+			},
+			gitdropsVPCs: []gitdrops.VPC{
+				{Name: "vpc-1", Region: "nyc3", IPRange: "10.10.10.0/24"},
+			},
+			vpcsToCreate: []gitdrops.VPC{
+				{Name: "vpc-1", Region: "nyc3", IPRange: "10.10.10.0/24"},
+			},
+			vpcsToUpdate: map[vpcID][]vpcAction{},
+		},
+		{
+			name: "test case 2 - no change",
+			activeVPCs: []godo.VPC{
+				{ID: "abc", Name: "vpc-1", RegionSlug: "nyc3", IPRange: "10.10.10.0/24", Description: "staging"},
+				{ID: "def", Name: "vpc-2", RegionSlug: "sfo3", IPRange: "10.10.20.0/24"},
+			},
+			gitdropsVPCs: []gitdrops.VPC{
+				{Name: "vpc-1", Region: "nyc3", IPRange: "10.10.10.0/24", Description: "staging"},
+				{Name: "vpc-2", Region: "sfo3"},
+			},
+			vpcsToCreate: []gitdrops.VPC{},
+			vpcsToUpdate: map[vpcID][]vpcAction{},
+		},
+		{
+			name: "test case 3 - update description, region and ip range require replacement",
+			activeVPCs: []godo.VPC{
+				{ID: "abc", Name: "vpc-1", RegionSlug: "nyc3", IPRange: "10.10.10.0/24", Description: "staging"},
+			},
+			gitdropsVPCs: []gitdrops.VPC{
+				{Name: "vpc-1", Region: "sfo3", IPRange: "10.10.30.0/24", Description: "production"},
+			},
+			vpcsToCreate: []gitdrops.VPC{},
+			vpcsToUpdate: map[vpcID][]vpcAction{
+				vpcID("abc"): []vpcAction{
+					{
+						Action: requiresReplacement,
+						Field:  gitdrops.RegionField,
+					},
+					{
+						Action: requiresReplacement,
+						Field:  "ipRange",
+					},
+					{
+						Action:      updateDescription,
+						Description: "production",
+					},
+				},
+			},
+		},
+	}
+	for _, tc := range tcases {
+		vr := newTestVPCReconciler(gitdrops.Privileges{}, tc.activeVPCs, tc.gitdropsVPCs)
+		vr.setObjectsToUpdateAndCreate()
+		if !reflect.DeepEqual(vr.vpcsToCreate, tc.vpcsToCreate) {
+			t.Errorf("VPCsToCreate - Failed %v, expected: %v, got %v", tc.name, tc.vpcsToCreate, vr.vpcsToCreate)
+		}
+		if !reflect.DeepEqual(vr.vpcsToUpdate, tc.vpcsToUpdate) {
+			t.Errorf("VPCsToUpdate - Failed %v, expected: %v, got %v", tc.name, tc.vpcsToUpdate, vr.vpcsToUpdate)
+		}
+	}
+}
+
+func TestVPCSteps(t *testing.T) {
+	vr := newTestVPCReconciler(gitdrops.Privileges{}, nil, nil)
+	vr.vpcsToCreate = []gitdrops.VPC{
+		{Name: "vpc-2", Region: "sfo3", Description: "production"},
+	}
+	vr.vpcsToUpdate = map[vpcID][]vpcAction{
+		vpcID("abc"): []vpcAction{
+			{
+				Action: requiresReplacement,
+				Field:  gitdrops.RegionField,
+			},
+			{
+				Action:      updateDescription,
+				Description: "staging",
+			},
+		},
+	}
+
+	steps, err := marshalSteps(vr)
+	if err != nil {
+		t.Fatalf("Failed, unexpected error %v", err)
+	}
+	loaded := newTestVPCReconciler(gitdrops.Privileges{}, nil, nil)
+	err = loaded.setSteps(steps)
+	if err != nil {
+		t.Fatalf("Failed, unexpected error %v", err)
+	}
+	if !reflect.DeepEqual(loaded.vpcsToCreate, vr.vpcsToCreate) {
+		t.Errorf("VPCsToCreate - Failed, expected: %v, got %v", vr.vpcsToCreate, loaded.vpcsToCreate)
+	}
+	if !reflect.DeepEqual(loaded.vpcsToUpdate, vr.vpcsToUpdate) {
+		t.Errorf("VPCsToUpdate - Failed, expected: %v, got %v", vr.vpcsToUpdate, loaded.vpcsToUpdate)
+	}
+}
+
+func TestDropletVPCs(t *testing.T) {
+	gitdropsDroplets := []gitdrops.Droplet{
+		{Name: "droplet-1", Region: "nyc3", Size: "1gb", Image: "ubuntu", VPC: "vpc-1"},
+		{Name: "droplet-2", Region: "nyc3", Size: "1gb", Image: "ubuntu", VPC: "vpc-2"},
+	}
+	dr := newTestDropletReconciler(gitdrops.Privileges{}, nil, nil, gitdropsDroplets, nil)
+	dr.vpcNameToID = map[string]string{"vpc-1": "vpc-1-id"}
+	dr.setObjectsToUpdateAndCreate()
+
+	// vpc-1 exists and is resolved when planning, vpc-2 is created by the plan
+	expDropletsToCreate := []gitdrops.Droplet{
+		{Name: "droplet-1", Region: "nyc3", Size: "1gb", Image: "ubuntu", VPC: "vpc-1", VPCUUID: "vpc-1-id"},
+		{Name: "droplet-2", Region: "nyc3", Size: "1gb", Image: "ubuntu", VPC: "vpc-2"},
+	}
+	if !reflect.DeepEqual(dr.dropletsToCreate, expDropletsToCreate) {
+		t.Errorf("DropletsToCreate - Failed, expected: %v, got %v", expDropletsToCreate, dr.dropletsToCreate)
+	}
+
+	dr.vpcNameToID["vpc-2"] = "vpc-2-id"
+	dropletCreateRequest, err := dr.translateDropletCreateRequest(dr.dropletsToCreate[1])
+	if err != nil {
+		t.Fatalf("Failed, unexpected error %v", err)
+	}
+	if dropletCreateRequest.VPCUUID != "vpc-2-id" {
+		t.Errorf("VPCUUID - Failed, expected: %v, got %v", "vpc-2-id", dropletCreateRequest.VPCUUID)
+	}
+
+	operations := dr.createOperations()
+	expDependsOn := operationKey(vpcResource, create, "vpc-2")
+	if len(operations) != 2 || !hasName(operations[1].dependsOn, expDependsOn) {
+		t.Errorf("DependsOn - Failed, expected: %v, got %v", expDependsOn, operations)
+	}
+}